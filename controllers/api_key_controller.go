@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// apiKeyPrefix identifica a simple vista las claves de este sistema
+const apiKeyPrefix = "lab_"
+
+// CreateAPIKey godoc
+// @Summary      Crear API key
+// @Description  Emite una clave para un cliente de máquina con scopes recurso:accion. La clave solo se muestra en esta respuesta
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.CreateAPIKeyRequest true "Datos de la API key"
+// @Success      201 {object} utils.Response{data=dtos.CreateAPIKeyResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string} "SCOPE_NOT_ALLOWED: scopes que el rol del usuario no permite"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /api-keys [post]
+func CreateAPIKey(c *gin.Context) {
+	var input dtos.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	scopes, err := models.ParseScopes(input.Scopes)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "Scopes inválidos", err.Error())
+		return
+	}
+	// Una API key no puede conceder más de lo que permite el rol de quien la emite
+	userID, _ := c.Get("userID")
+	var creator models.User
+	if err := config.GetDB().Preload("Role").First(&creator, userID).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener el usuario", err.Error())
+		return
+	}
+	granted := creator.Role.Permissions
+	if !creator.Role.IsActive {
+		granted = models.Permissions{}
+	}
+	if denied := scopes.ScopesNotAllowedBy(granted); len(denied) > 0 {
+		utils.Error(c, http.StatusForbidden, "No puede asignar scopes que su rol no permite", gin.H{"code": "SCOPE_NOT_ALLOWED", "scopes": denied})
+		return
+	}
+	allowedIPs, err := models.NormalizeAllowedIPs(input.AllowedIPs)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "Restricción de IP inválida", err.Error())
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		utils.Error(c, http.StatusBadRequest, "La fecha de expiración debe ser futura", nil)
+		return
+	}
+
+	secret, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := models.APIKey{
+		Name:       input.Name,
+		Prefix:     rawKey[:len(apiKeyPrefix)+8],
+		KeyHash:    utils.HashToken(rawKey),
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		ExpiresAt:  input.ExpiresAt,
+		CreatedBy:  userID.(uint),
+	}
+	if err := config.GetDB().Create(&key).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al crear la API key", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusCreated, "API key creada. Guárdela ahora: no se volverá a mostrar", dtos.CreateAPIKeyResponse{
+		APIKeyResponse: key.ToResponse(),
+		Key:            rawKey,
+	})
+}
+
+// GetAPIKeys godoc
+// @Summary      Listar API keys
+// @Description  Lista las API keys con sus scopes y último uso. Use active=true para omitir las revocadas o expiradas
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        active query bool false "Solo claves vigentes"
+// @Success      200 {object} utils.Response{data=[]models.APIKeyResponse}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /api-keys [get]
+func GetAPIKeys(c *gin.Context) {
+	var keys []models.APIKey
+	query := config.GetDB().Order("created_at DESC")
+	if c.Query("active") == "true" {
+		query = query.Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+	}
+
+	if err := query.Find(&keys).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener las API keys", err.Error())
+		return
+	}
+
+	response := make([]models.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, key.ToResponse())
+	}
+	utils.Success(c, http.StatusOK, "API keys obtenidas exitosamente", response)
+}
+
+// GetAPIKeyByID godoc
+// @Summary      Obtener API key por ID
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID de la API key"
+// @Success      200 {object} utils.Response{data=models.APIKeyResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /api-keys/{id} [get]
+func GetAPIKeyByID(c *gin.Context) {
+	var key models.APIKey
+	if err := config.GetDB().First(&key, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "API key no encontrada", nil)
+		return
+	}
+	utils.Success(c, http.StatusOK, "API key obtenida exitosamente", key.ToResponse())
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revocar API key
+// @Description  Invalida la clave de inmediato. El registro se conserva para auditoría
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID de la API key"
+// @Success      200 {object} utils.Response{data=models.APIKeyResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /api-keys/{id}/revoke [post]
+func RevokeAPIKey(c *gin.Context) {
+	var key models.APIKey
+	db := config.GetDB()
+	if err := db.First(&key, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "API key no encontrada", nil)
+		return
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		if err := db.Model(&key).Update("revoked_at", &now).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al revocar la API key", err.Error())
+			return
+		}
+		key.RevokedAt = &now
+	}
+
+	utils.Success(c, http.StatusOK, "API key revocada exitosamente", key.ToResponse())
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// recordAudit registra una operación en la bitácora de auditoría, atribuida al
+// usuario autenticado (o al creador de la API key) y con la IP y el user agent
+func recordAudit(tx *gorm.DB, c *gin.Context, table string, recordID uint, action string, oldValues, newValues map[string]interface{}) error {
+	var userID *uint
+	if id, ok := c.Get("userID"); ok {
+		uid := id.(uint)
+		userID = &uid
+	}
+	entry := models.CreateAuditLog(table, recordID, action, oldValues, newValues, userID, c.ClientIP(), c.Request.UserAgent())
+	return tx.Create(entry).Error
+}
+
+// diffFields retorna los valores anteriores y nuevos de los campos que cambiaron
+func diffFields(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	oldValues := map[string]interface{}{}
+	newValues := map[string]interface{}{}
+	for field, value := range after {
+		if before[field] != value {
+			oldValues[field] = before[field]
+			newValues[field] = value
+		}
+	}
+	return oldValues, newValues
+}
@@ -1,27 +1,33 @@
 package controllers
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/cesarbmathec/medical-exams-backend/config"
 	"github.com/cesarbmathec/medical-exams-backend/dtos"
 	"github.com/cesarbmathec/medical-exams-backend/models"
 	"github.com/cesarbmathec/medical-exams-backend/utils"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	_ "github.com/cesarbmathec/medical-exams-backend/docs"
 )
 
 // Login godoc
 // @Summary      Iniciar sesión
-// @Description  Autentica al usuario y devuelve un token JWT
+// @Description  Autentica al usuario y devuelve un token JWT de corta duración y un refresh token. Con mode=cookie los tokens se entregan en cookies HttpOnly y se retorna el token CSRF
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        request body dtos.LoginRequest true "Credenciales de usuario"
-// @Success      200 {object} utils.Response{data=dtos.LoginResponse}
+// @Success      200 {object} utils.Response{data=dtos.LoginResponse} "Sin doble factor; con doble factor activo retorna dtos.TwoFactorChallengeResponse"
 // @Failure      400 {object} utils.Response{errors=string}
-// @Failure      401 {object} utils.Response{errors=string}
+// @Failure      401 {object} utils.Response{errors=string} "INVALID_CREDENTIALS"
+// @Failure      403 {object} utils.Response{errors=string} "USER_INACTIVE"
+// @Failure      423 {object} utils.Response{errors=string} "ACCOUNT_LOCKED"
 // @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
 // @Router       /login [post]
 func Login(c *gin.Context) {
@@ -36,41 +42,300 @@ func Login(c *gin.Context) {
 
 	// Buscar usuario e incluir el rol
 	if err := db.Preload("Role").Where("username = ?", input.Username).First(&user).Error; err != nil {
-		utils.Error(c, http.StatusUnauthorized, "Usuario o contraseña incorrectos", nil)
+		recordLoginAttempt(db, c, nil, input.Username, false, models.LoginReasonUnknownUser)
+		utils.Error(c, http.StatusUnauthorized, "Usuario o contraseña incorrectos", gin.H{"code": "INVALID_CREDENTIALS"})
+		return
+	}
+
+	// Una cuenta bloqueada no evalúa la contraseña para frenar la fuerza bruta
+	if user.IsLocked() {
+		recordLoginAttempt(db, c, &user, input.Username, false, models.LoginReasonAccountLocked)
+		utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+			"code":         "ACCOUNT_LOCKED",
+			"locked_until": user.LockedUntil,
+		})
 		return
 	}
 
 	// Verificar password usando el método que definiste en user.go
 	if !user.CheckPassword(input.Password) {
-		utils.Error(c, http.StatusUnauthorized, "error", "Usuario o contraseña incorrectos")
+		if err := registerFailedLogin(db, &user); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+			return
+		}
+		recordLoginAttempt(db, c, &user, input.Username, false, models.LoginReasonInvalidPassword)
+
+		if user.IsLocked() {
+			utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+				"code":         "ACCOUNT_LOCKED",
+				"locked_until": user.LockedUntil,
+			})
+			return
+		}
+		utils.Error(c, http.StatusUnauthorized, "Usuario o contraseña incorrectos", gin.H{"code": "INVALID_CREDENTIALS"})
+		return
+	}
+
+	// Con la contraseña verificada migramos su hash al algoritmo y costo actuales
+	rehashPassword(db, &user, input.Password)
+
+	if !user.IsActive {
+		recordLoginAttempt(db, c, &user, input.Username, false, models.LoginReasonUserInactive)
+		utils.Error(c, http.StatusForbidden, "Usuario inactivo", gin.H{"code": "USER_INACTIVE"})
+		return
+	}
+
+	// Con doble factor activo la contraseña solo habilita el segundo paso
+	if user.TwoFactorEnabled {
+		respondTwoFactorChallenge(c, user)
 		return
 	}
 
-	// Generar Token
-	token, err := utils.GenerateToken(user.ID, user.Username, user.RoleID)
+	completeLogin(c, db, user, false, input.Mode, models.LoginReasonPassword)
+}
+
+// respondTwoFactorChallenge emite el token de desafío para completar el login en /auth/2fa/verify
+func respondTwoFactorChallenge(c *gin.Context, user models.User) {
+	challenge, ttl, err := utils.IssueChallengeToken(user.ID, user.Username, user.RoleID)
 	if err != nil {
 		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
 		return
 	}
+	utils.Success(c, http.StatusOK, "Se requiere el código de verificación", dtos.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int64(ttl.Seconds()),
+	})
+}
+
+// completeLogin reinicia el contador de fallos, registra el último acceso y el intento
+// exitoso (reason indica el método), y emite los tokens de una nueva sesión en el modo
+// solicitado (Bearer o cookie)
+func completeLogin(c *gin.Context, db *gorm.DB, user models.User, mfa bool, mode, reason string) {
+	now := time.Now()
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	user.LastLogin = &now
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+		"last_login":            &now,
+	}).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+
+	// Generar Token de acceso y refresh token (nueva familia)
+	tokens, _, err := issueTokens(db, c, user, "", mfa)
+	if err == nil {
+		err = applyAuthMode(c, &tokens, mode)
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+
+	recordLoginAttempt(db, c, &user, user.Username, true, reason)
 
 	utils.Success(c, http.StatusOK, "Inicio de sesión exitoso", dtos.LoginResponse{
-		User:  user.ToResponse(),
-		Token: token,
+		User:                   user.ToResponse(),
+		TokenResponse:          tokens,
+		TwoFactorSetupRequired: user.Role.RequireTwoFactor && !user.TwoFactorEnabled,
+	})
+}
+
+// RefreshToken godoc
+// @Summary      Renovar token de acceso
+// @Description  Canjea un refresh token por un nuevo par de tokens. El refresh token usado queda invalidado; si se reutiliza se revoca toda la sesión. En modo cookie se toma de la cookie y requiere X-CSRF-Token
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body dtos.RefreshRequest false "Refresh token (omitir en modo cookie)"
+// @Param        X-CSRF-Token header string false "Token CSRF (modo cookie)"
+// @Success      200 {object} utils.Response{data=dtos.TokenResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      401 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string} "CSRF_TOKEN_INVALID"
+// @Failure      423 {object} utils.Response{errors=string} "ACCOUNT_LOCKED"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /auth/refresh [post]
+func RefreshToken(c *gin.Context) {
+	var input dtos.RefreshRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+			return
+		}
+	}
+
+	// Sin refresh token en el cuerpo se usa la cookie de sesión, protegida con CSRF
+	mode := utils.AuthModeBearer
+	if input.RefreshToken == "" {
+		cookie, err := c.Cookie(utils.RefreshCookieName)
+		if err != nil || cookie == "" {
+			utils.Error(c, http.StatusBadRequest, "Error de validación", "refresh_token es requerido")
+			return
+		}
+		if !utils.ValidCSRF(c.Request) {
+			utils.Error(c, http.StatusForbidden, "Token CSRF inválido", gin.H{"code": "CSRF_TOKEN_INVALID"})
+			return
+		}
+		input.RefreshToken = cookie
+		mode = utils.AuthModeCookie
+	}
+
+	db := config.GetDB()
+
+	var current models.RefreshToken
+	if err := db.Where("token_hash = ?", utils.HashToken(input.RefreshToken)).First(&current).Error; err != nil {
+		utils.Error(c, http.StatusUnauthorized, "Refresh token inválido", nil)
+		return
+	}
+
+	// Un token ya canjeado o revocado que vuelve a llegar indica robo: se revoca toda la familia
+	if current.UsedAt != nil || current.RevokedAt != nil {
+		if err := revokeTokenFamily(db, current.FamilyID, "reuse_detected"); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al revocar la sesión", err.Error())
+			return
+		}
+		utils.Error(c, http.StatusUnauthorized, "Refresh token reutilizado, la sesión fue revocada", nil)
+		return
+	}
+
+	if current.IsExpired() {
+		utils.Error(c, http.StatusUnauthorized, "Refresh token expirado", nil)
+		return
+	}
+
+	var user models.User
+	if err := db.Preload("Role").First(&user, current.UserID).Error; err != nil || !user.IsActive {
+		utils.Error(c, http.StatusUnauthorized, "Usuario no disponible", nil)
+		return
+	}
+	// Una cuenta bloqueada tampoco renueva sus tokens
+	if user.IsLocked() {
+		utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+			"code":         "ACCOUNT_LOCKED",
+			"locked_until": user.LockedUntil,
+		})
+		return
+	}
+
+	var tokens dtos.TokenResponse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Marcamos el token como usado solo si nadie lo canjeó antes (evita carreras)
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", current.ID).
+			Update("used_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		issued, next, err := issueTokens(tx, c, user, current.FamilyID, current.MFA)
+		if err != nil {
+			return err
+		}
+		tokens = issued
+
+		return tx.Model(&models.RefreshToken{}).Where("id = ?", current.ID).Update("replaced_by", next.ID).Error
 	})
+
+	if err == errRefreshTokenReused {
+		if err := revokeTokenFamily(db, current.FamilyID, "reuse_detected"); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al revocar la sesión", err.Error())
+			return
+		}
+		utils.Error(c, http.StatusUnauthorized, "Refresh token reutilizado, la sesión fue revocada", nil)
+		return
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al renovar el token", err.Error())
+		return
+	}
+	if err := applyAuthMode(c, &tokens, mode); err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al renovar el token", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Token renovado exitosamente", tokens)
+}
+
+// Logout godoc
+// @Summary      Cerrar sesión
+// @Description  Revoca el token de acceso actual y la sesión de refresh tokens asociada
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.LogoutRequest false "Refresh token a revocar (opcional)"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /auth/logout [post]
+func Logout(c *gin.Context) {
+	var input dtos.LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	jti := c.GetString("jti")
+	expiresAt := c.GetTime("tokenExpiresAt")
+	db := config.GetDB()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := revokeAccessToken(tx, jti, userID.(uint), expiresAt, "logout"); err != nil {
+			return err
+		}
+
+		// Buscamos la familia por el refresh token enviado o por el token de acceso actual
+		var refresh models.RefreshToken
+		query := tx.Where("user_id = ?", userID.(uint))
+		if input.RefreshToken != "" {
+			query = query.Where("token_hash = ?", utils.HashToken(input.RefreshToken))
+		} else {
+			query = query.Where("access_jti = ?", jti)
+		}
+		if err := query.First(&refresh).Error; err == nil {
+			if err := revokeTokenFamily(tx, refresh.FamilyID, "logout"); err != nil {
+				return err
+			}
+		}
+
+		return purgeExpiredRevocations(tx)
+	})
+
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al cerrar sesión", err.Error())
+		return
+	}
+	if c.GetString("authMode") == utils.AuthModeCookie {
+		utils.ClearAuthCookies(c.Writer)
+	}
+
+	utils.Success(c, http.StatusOK, "Sesión cerrada exitosamente", nil)
 }
 
 // Register godoc
 // @Summary      Registrar nuevo usuario
-// @Description  Crea un nuevo usuario en el sistema
-// @Tags         auth
+// @Description  Crea un nuevo usuario en el sistema (requiere permiso users:write). El usuario deberá cambiar su contraseña en el primer inicio de sesión
+// @Tags         users
 // @Accept       json
 // @Produce      json
+// @Security     BearerAuth
 // @Param        request body dtos.RegisterRequest true "Datos del nuevo usuario"
 // @Success      201 {object} utils.Response{data=dtos.RegisterResponse}
-// @Success      200 {object} utils.Response{data=dtos.LoginResponse}
 // @Failure      400 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string} "PERMISSION_NOT_ALLOWED: el rol concede permisos que el usuario actual no tiene"
 // @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
-// @Router       /register [post]
+// @Router       /users [post]
 func Register(c *gin.Context) {
 	var input dtos.RegisterRequest
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -80,14 +345,27 @@ func Register(c *gin.Context) {
 
 	db := config.GetDB()
 
+	role, err := findActiveRole(db, input.RoleID)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "Rol inválido o inactivo", nil)
+		return
+	}
+	if !ensureGrantablePermissions(c, db, role.Permissions) {
+		return
+	}
+	if !validateNewPassword(c, db, models.User{}, input.Password) {
+		return
+	}
+
 	// Creamos la instancia del modelo
 	user := models.User{
-		Username: input.Username,
-		Email:    input.Email,
-		Password: input.Password, // El hook BeforeCreate en user.go hará el Hash
-		FullName: input.FullName,
-		RoleID:   input.RoleID,
-		IsActive: true,
+		Username:           input.Username,
+		Email:              input.Email,
+		Password:           input.Password, // El hook BeforeCreate en user.go hará el Hash
+		FullName:           input.FullName,
+		RoleID:             role.ID,
+		IsActive:           true,
+		MustChangePassword: true,
 	}
 
 	// Guardamos en DB
@@ -95,17 +373,62 @@ func Register(c *gin.Context) {
 		utils.Error(c, http.StatusInternalServerError, "error", "No se pudo crear el usuario (posible duplicado)")
 		return
 	}
-
-	token, err := utils.GenerateToken(user.ID, user.Username, user.RoleID)
-	if err != nil {
-		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
-		return
-	}
+	user.Role = role
 
 	utils.Success(c, http.StatusCreated,
 		"Usuario registrado exitosamente",
 		dtos.RegisterResponse{
-			User:  user.ToResponse(),
-			Token: token,
+			User: user.ToResponse(),
 		})
 }
+
+// rehashPassword regenera el hash si usa un algoritmo o parámetros anteriores. Un error
+// no impide el login: se reintenta en el siguiente.
+func rehashPassword(db *gorm.DB, user *models.User, password string) {
+	if !user.PasswordNeedsRehash() {
+		return
+	}
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("No se pudo regenerar el hash de la contraseña del usuario %d: %v", user.ID, err)
+		return
+	}
+	// UpdateColumn evita el hook BeforeUpdate y no modifica updated_at
+	if err := db.Model(user).UpdateColumn("password_hash", hashed).Error; err != nil {
+		log.Printf("No se pudo regenerar el hash de la contraseña del usuario %d: %v", user.ID, err)
+		return
+	}
+	user.PasswordHash = hashed
+}
+
+// registerFailedLogin suma un intento fallido al usuario y lo bloquea si alcanza el
+// umbral. El incremento se hace en SQL y el bloqueo se calcula con el valor retornado,
+// para que intentos en paralelo no escriban todos el mismo contador.
+func registerFailedLogin(db *gorm.DB, user *models.User) error {
+	var counter models.User
+	err := db.Model(&counter).Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_login_attempts"}}}).
+		Where("id = ?", user.ID).
+		UpdateColumn("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error
+	if err != nil {
+		return err
+	}
+	user.FailedLoginAttempts = counter.FailedLoginAttempts
+
+	threshold, baseLock, maxLock := lockoutPolicy()
+	if !user.ApplyLockout(threshold, baseLock, maxLock) {
+		return nil
+	}
+	return db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("locked_until", user.LockedUntil).Error
+}
+
+// lockoutPolicy lee la política de bloqueo desde el entorno:
+// LOGIN_MAX_FAILED_ATTEMPTS, LOGIN_LOCKOUT_MINUTES y LOGIN_LOCKOUT_MAX_MINUTES
+func lockoutPolicy() (int, time.Duration, time.Duration) {
+	threshold := utils.EnvInt("LOGIN_MAX_FAILED_ATTEMPTS", 5)
+	baseLock := time.Duration(utils.EnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute
+	maxLock := time.Duration(utils.EnvInt("LOGIN_LOCKOUT_MAX_MINUTES", 1440)) * time.Minute
+	if maxLock < baseLock {
+		maxLock = baseLock
+	}
+	return threshold, baseLock, maxLock
+}
@@ -0,0 +1,489 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// consentVersionAttempts limita los reintentos al publicar una versión que otra petición
+// tomó al mismo tiempo
+const consentVersionAttempts = 3
+
+// GetConsentTemplates godoc
+// @Summary      Listar plantillas de consentimiento
+// @Description  Retorna la versión vigente de cada consentimiento o, con code, todas las versiones de ese consentimiento (de la más reciente a la más antigua)
+// @Tags         consents
+// @Produce      json
+// @Param        code query string false "Código del consentimiento"
+// @Success      200 {object} utils.Response{data=[]models.ConsentTemplate}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /consents/templates [get]
+// @Security BearerAuth
+func GetConsentTemplates(c *gin.Context) {
+	db := config.GetDB()
+	if code := c.Query("code"); code != "" {
+		var templates []models.ConsentTemplate
+		if err := db.Where("code = ?", code).Order("version DESC").Find(&templates).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al obtener plantillas", err.Error())
+			return
+		}
+		utils.Success(c, http.StatusOK, "Plantillas obtenidas exitosamente", templates)
+		return
+	}
+
+	versions, err := loadConsentVersions(db)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener plantillas", err.Error())
+		return
+	}
+	templates := []models.ConsentTemplate{}
+	for _, code := range sortedConsentCodes(versions) {
+		templates = append(templates, versions[code].current)
+	}
+	utils.Success(c, http.StatusOK, "Plantillas obtenidas exitosamente", templates)
+}
+
+// GetConsentTemplateByID godoc
+// @Summary      Obtener plantilla de consentimiento
+// @Tags         consents
+// @Produce      json
+// @Param        id path int true "ID de la plantilla"
+// @Success      200 {object} utils.Response{data=models.ConsentTemplate}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /consents/templates/{id} [get]
+// @Security BearerAuth
+func GetConsentTemplateByID(c *gin.Context) {
+	var template models.ConsentTemplate
+	if err := config.GetDB().First(&template, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Plantilla no encontrada", nil)
+		return
+	}
+	utils.Success(c, http.StatusOK, "Plantilla obtenida exitosamente", template)
+}
+
+// CreateConsentTemplate godoc
+// @Summary      Publicar versión de consentimiento
+// @Description  Publica una nueva versión del consentimiento (la primera si el código no existe). Las versiones anteriores no se modifican; con requires_reconsent los pacientes deben aceptar de nuevo
+// @Tags         consents
+// @Accept       json
+// @Produce      json
+// @Param        request body dtos.CreateConsentTemplateRequest true "Texto del consentimiento"
+// @Success      201 {object} utils.Response{data=models.ConsentTemplate}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "CONSENT_VERSION_CONFLICT"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /consents/templates [post]
+// @Security BearerAuth
+func CreateConsentTemplate(c *gin.Context) {
+	var input dtos.CreateConsentTemplateRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	template := models.ConsentTemplate{
+		Code:              strings.ToLower(strings.TrimSpace(input.Code)),
+		Title:             input.Title,
+		Body:              input.Body,
+		RequiredForOrders: input.RequiredForOrders,
+		RequiresReconsent: input.RequiresReconsent,
+		CreatedBy:         userID.(uint),
+	}
+	if template.Code == "" {
+		utils.Error(c, http.StatusBadRequest, "El código es obligatorio", nil)
+		return
+	}
+
+	// Dos publicaciones simultáneas del mismo código pueden calcular la misma versión: el
+	// índice único rechaza la segunda y se reintenta con la siguiente
+	var err error
+	for attempt := 0; attempt < consentVersionAttempts; attempt++ {
+		err = config.GetDB().Transaction(func(tx *gorm.DB) error {
+			var last int
+			if err := tx.Model(&models.ConsentTemplate{}).Where("code = ?", template.Code).
+				Select("COALESCE(MAX(version), 0)").Scan(&last).Error; err != nil {
+				return err
+			}
+			template.ID = 0
+			template.Version = last + 1
+			if err := tx.Create(&template).Error; err != nil {
+				return err
+			}
+			return recordAudit(tx, c, template.TableName(), template.ID, models.AuditActionInsert, nil, map[string]interface{}{
+				"code":                template.Code,
+				"version":             template.Version,
+				"title":               template.Title,
+				"body":                template.Body,
+				"required_for_orders": template.RequiredForOrders,
+				"requires_reconsent":  template.RequiresReconsent,
+			})
+		})
+		if !utils.IsUniqueViolation(err) {
+			break
+		}
+	}
+	if utils.IsUniqueViolation(err) {
+		utils.Error(c, http.StatusConflict, "Se publicó otra versión del consentimiento al mismo tiempo, intente de nuevo", gin.H{"code": "CONSENT_VERSION_CONFLICT"})
+		return
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo publicar el consentimiento", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusCreated, "Consentimiento publicado exitosamente", template)
+}
+
+// GetPatientConsents godoc
+// @Summary      Consentimientos del paciente
+// @Description  Retorna el estado del paciente para la versión vigente de cada consentimiento (granted, revoked, outdated o missing) y el historial de aceptaciones y revocaciones
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Success      200 {object} utils.Response{data=dtos.PatientConsentsResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/consents [get]
+// @Security BearerAuth
+func GetPatientConsents(c *gin.Context) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	versions, err := loadConsentVersions(db)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener consentimientos", err.Error())
+		return
+	}
+	var history []models.PatientConsent
+	if err := db.Where("patient_id = ?", patient.ID).Order("granted_at DESC, id DESC").Find(&history).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener consentimientos", err.Error())
+		return
+	}
+
+	// El historial está en orden descendente: la primera aparición es la más reciente
+	latest := map[string]*models.PatientConsent{}
+	for i := range history {
+		if _, ok := latest[history[i].Code]; !ok {
+			latest[history[i].Code] = &history[i]
+		}
+	}
+
+	states := []dtos.ConsentState{}
+	for _, code := range sortedConsentCodes(versions) {
+		version := versions[code]
+		state := dtos.ConsentState{
+			Code:              code,
+			Title:             version.current.Title,
+			TemplateID:        version.current.ID,
+			CurrentVersion:    version.current.Version,
+			MinimumVersion:    version.minimum,
+			RequiredForOrders: version.current.RequiredForOrders,
+			Status:            models.ConsentStatusMissing,
+		}
+		if consent, ok := latest[code]; ok {
+			state.Status = consent.Status(version.minimum)
+			state.Consent = consent
+		}
+		states = append(states, state)
+	}
+
+	utils.Success(c, http.StatusOK, "Consentimientos obtenidos exitosamente", dtos.PatientConsentsResponse{
+		States:  states,
+		History: history,
+	})
+}
+
+// GrantPatientConsent godoc
+// @Summary      Registrar consentimiento
+// @Description  Registra que el paciente aceptó la versión vigente de un consentimiento, con la fecha y el usuario que lo capturó. Por un menor firma su representante (el indicado o el principal)
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        request body dtos.GrantConsentRequest true "Consentimiento aceptado"
+// @Success      201 {object} utils.Response{data=models.PatientConsent}
+// @Failure      400 {object} utils.Response{errors=string} "GUARDIAN_NOT_FOUND"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "CONSENT_TEMPLATE_OUTDATED, CONSENT_ALREADY_GRANTED, GUARDIAN_REQUIRED"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/consents [post]
+// @Security BearerAuth
+func GrantPatientConsent(c *gin.Context) {
+	var input dtos.GrantConsentRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+	var template models.ConsentTemplate
+	if err := db.First(&template, input.TemplateID).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Plantilla no encontrada", nil)
+		return
+	}
+
+	// Solo se acepta la versión vigente
+	versions, err := loadConsentVersions(db)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener consentimientos", err.Error())
+		return
+	}
+	current := versions[template.Code].current
+	if current.ID != template.ID {
+		utils.Error(c, http.StatusConflict, "La plantilla no es la versión vigente del consentimiento", gin.H{
+			"code":                "CONSENT_TEMPLATE_OUTDATED",
+			"current_template_id": current.ID,
+		})
+		return
+	}
+	var existing models.PatientConsent
+	err = db.Where("patient_id = ? AND template_id = ? AND revoked_at IS NULL", patient.ID, template.ID).First(&existing).Error
+	if err == nil {
+		utils.Error(c, http.StatusConflict, "El paciente ya aceptó esta versión del consentimiento", gin.H{
+			"code":       "CONSENT_ALREADY_GRANTED",
+			"consent_id": existing.ID,
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	consent := models.PatientConsent{
+		PatientID:  patient.ID,
+		TemplateID: template.ID,
+		Code:       template.Code,
+		Version:    template.Version,
+		GrantedAt:  time.Now(),
+		GrantedBy:  userID.(uint),
+		SignedBy:   patient.GetFullName(),
+		Notes:      input.Notes,
+	}
+	if input.GuardianID != nil || patient.IsMinor() {
+		guardian, ok := responsibleGuardian(c, db, &patient, input.GuardianID)
+		if !ok {
+			return
+		}
+		consent.GuardianID = &guardian.ID
+		consent.SignedBy = guardian.GuardianName()
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&consent).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, consent.TableName(), consent.ID, models.AuditActionInsert, nil, consentFields(&consent))
+	})
+	if utils.IsUniqueViolation(err) {
+		// Otra petición registró el mismo consentimiento entre la verificación y el alta
+		db.Where("patient_id = ? AND template_id = ? AND revoked_at IS NULL", patient.ID, template.ID).First(&existing)
+		utils.Error(c, http.StatusConflict, "El paciente ya aceptó esta versión del consentimiento", gin.H{
+			"code":       "CONSENT_ALREADY_GRANTED",
+			"consent_id": existing.ID,
+		})
+		return
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo registrar el consentimiento", err.Error())
+		return
+	}
+
+	consent.Template = &template
+	utils.Success(c, http.StatusCreated, "Consentimiento registrado exitosamente", consent)
+}
+
+// RevokePatientConsent godoc
+// @Summary      Revocar consentimiento
+// @Description  Revoca el consentimiento con su motivo. El registro se conserva en el historial con la fecha y el usuario que lo revocó
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        consentId path int true "ID del consentimiento"
+// @Param        request body dtos.RevokeConsentRequest true "Motivo"
+// @Success      200 {object} utils.Response{data=models.PatientConsent}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "CONSENT_REVOKED"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/consents/{consentId}/revoke [post]
+// @Security BearerAuth
+func RevokePatientConsent(c *gin.Context) {
+	var input dtos.RevokeConsentRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var consent models.PatientConsent
+	db := config.GetDB()
+	if err := db.Where("patient_id = ?", c.Param("id")).First(&consent, c.Param("consentId")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Consentimiento no encontrado", nil)
+		return
+	}
+	if consent.IsRevoked() {
+		utils.Error(c, http.StatusConflict, "El consentimiento ya fue revocado", gin.H{"code": "CONSENT_REVOKED"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	before := consentFields(&consent)
+	consent.Revoke(userID.(uint), input.Reason)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&consent).Updates(map[string]interface{}{
+			"revoked_at":        consent.RevokedAt,
+			"revoked_by":        consent.RevokedBy,
+			"revocation_reason": consent.RevocationReason,
+		}).Error; err != nil {
+			return err
+		}
+		oldValues, newValues := diffFields(before, consentFields(&consent))
+		return recordAudit(tx, c, consent.TableName(), consent.ID, models.AuditActionUpdate, oldValues, newValues)
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo revocar el consentimiento", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Consentimiento revocado exitosamente", consent)
+}
+
+// ensureOrderConsents verifica que el paciente tenga vigentes los consentimientos que
+// exigen los exámenes de la orden y los que exige toda orden. Si falta alguno responde
+// 409 CONSENT_REQUIRED con los códigos faltantes y retorna false.
+func ensureOrderConsents(c *gin.Context, db *gorm.DB, patientID uint, examTypeIDs []uint) bool {
+	versions, err := loadConsentVersions(db)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar consentimientos", err.Error())
+		return false
+	}
+
+	var examCodes []string
+	if err := db.Model(&models.ExamType{}).Where("id IN ? AND required_consent <> ''", examTypeIDs).
+		Distinct().Pluck("required_consent", &examCodes).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar consentimientos", err.Error())
+		return false
+	}
+	requiredSet := map[string]bool{}
+	for _, code := range examCodes {
+		requiredSet[code] = true
+	}
+	for code, version := range versions {
+		if version.current.RequiredForOrders {
+			requiredSet[code] = true
+		}
+	}
+	if len(requiredSet) == 0 {
+		return true
+	}
+	required := make([]string, 0, len(requiredSet))
+	for code := range requiredSet {
+		required = append(required, code)
+	}
+	sort.Strings(required)
+
+	var consents []models.PatientConsent
+	if err := db.Where("patient_id = ? AND code IN ?", patientID, required).
+		Order("granted_at, id").Find(&consents).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar consentimientos", err.Error())
+		return false
+	}
+	latest := map[string]models.PatientConsent{}
+	for _, consent := range consents {
+		latest[consent.Code] = consent
+	}
+
+	missing := []string{}
+	for _, code := range required {
+		consent, granted := latest[code]
+		version, published := versions[code]
+		if !granted || !published || consent.Status(version.minimum) != models.ConsentStatusGranted {
+			missing = append(missing, code)
+		}
+	}
+	if len(missing) > 0 {
+		utils.Error(c, http.StatusConflict, "El paciente no tiene vigentes los consentimientos que exige la orden", gin.H{
+			"code":    "CONSENT_REQUIRED",
+			"missing": missing,
+		})
+		return false
+	}
+	return true
+}
+
+// consentVersion es la versión vigente de un consentimiento y la versión mínima que
+// sigue siendo válida (la última publicada con requires_reconsent)
+type consentVersion struct {
+	current models.ConsentTemplate
+	minimum int
+}
+
+// loadConsentVersions carga las versiones vigentes de todos los consentimientos
+func loadConsentVersions(db *gorm.DB) (map[string]*consentVersion, error) {
+	var templates []models.ConsentTemplate
+	if err := db.Order("code, version").Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	versions := map[string]*consentVersion{}
+	for _, template := range templates {
+		version, ok := versions[template.Code]
+		if !ok {
+			version = &consentVersion{minimum: template.Version}
+			versions[template.Code] = version
+		}
+		version.current = template
+		if template.RequiresReconsent {
+			version.minimum = template.Version
+		}
+	}
+	return versions, nil
+}
+
+func sortedConsentCodes(versions map[string]*consentVersion) []string {
+	codes := make([]string, 0, len(versions))
+	for code := range versions {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// consentFields retorna los datos del consentimiento tal como se registran en la auditoría
+func consentFields(pc *models.PatientConsent) map[string]interface{} {
+	fields := map[string]interface{}{
+		"patient_id":        pc.PatientID,
+		"template_id":       pc.TemplateID,
+		"code":              pc.Code,
+		"version":           pc.Version,
+		"granted_at":        pc.GrantedAt.Format(time.RFC3339),
+		"signed_by":         pc.SignedBy,
+		"notes":             pc.Notes,
+		"revocation_reason": pc.RevocationReason,
+	}
+	if pc.GuardianID != nil {
+		fields["guardian_id"] = *pc.GuardianID
+	}
+	if pc.RevokedAt != nil {
+		fields["revoked_at"] = pc.RevokedAt.Format(time.RFC3339)
+	}
+	return fields
+}
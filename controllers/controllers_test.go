@@ -1,20 +1,32 @@
 package controllers
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cesarbmathec/medical-exams-backend/config"
 	"github.com/cesarbmathec/medical-exams-backend/dtos"
 	"github.com/cesarbmathec/medical-exams-backend/middleware"
+	"github.com/cesarbmathec/medical-exams-backend/migrations"
 	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -28,8 +40,13 @@ func setupTestDB(t *testing.T) *gorm.DB {
 
 	if err := db.AutoMigrate(
 		&models.Role{},
+		&models.RolePermissionGrant{},
 		&models.User{},
 		&models.Patient{},
+		&models.PatientGuardian{},
+		&models.ConsentTemplate{},
+		&models.PatientConsent{},
+		&models.PatientExport{},
 		&models.ExamCategory{},
 		&models.SampleType{},
 		&models.ExamType{},
@@ -37,11 +54,32 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		&models.Order{},
 		&models.OrderExam{},
 		&models.ExamResult{},
+		&models.Payment{},
+		&models.Invoice{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.RecoveryCode{},
+		&models.PasswordHistory{},
+		&models.PasswordResetToken{},
+		&models.APIKey{},
+		&models.ResultSignature{},
+		&models.Session{},
+		&models.LoginAttempt{},
+		&models.OIDCAuthRequest{},
+		&models.UserIdentity{},
+		&models.AuditLog{},
 	); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
+	if err := migrations.BackfillPatientDocuments(db); err != nil {
+		t.Fatalf("failed to create patient indexes: %v", err)
+	}
+	if err := migrations.DeduplicatePatientConsents(db); err != nil {
+		t.Fatalf("failed to create consent indexes: %v", err)
+	}
 
 	config.DB = db
+	middleware.InvalidateAllRolePermissions()
 	return db
 }
 
@@ -52,21 +90,93 @@ func setupRouter() *gin.Engine {
 
 	api := r.Group("/api/v1")
 	api.POST("/login", Login)
-	api.POST("/register", Register)
+	api.POST("/auth/refresh", RefreshToken)
+	api.POST("/auth/2fa/verify", VerifyTwoFactor)
+	api.POST("/auth/password/reset", ResetPassword)
+	api.GET("/auth/oidc/login", StartOIDCLogin)
+	api.POST("/auth/oidc/callback", OIDCCallback)
 
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware())
-	protected.POST("/patients", CreatePatient)
-	protected.GET("/patients", GetPatients)
-	protected.POST("/orders", CreateOrder)
-	protected.GET("/orders", GetOrders)
-	protected.GET("/lab/exams/catalog", GetExamCatalog)
 
+	account := protected.Group("/")
+	account.Use(middleware.RequireUserSession())
+	account.POST("/auth/logout", Logout)
+	account.GET("/me", GetMe)
+	account.POST("/me/password", ChangePassword)
+	account.GET("/me/sessions", GetMySessions)
+	account.DELETE("/me/sessions/:id", RevokeMySession)
+	account.GET("/me/login-history", GetMyLoginHistory)
+	account.GET("/me/sso/link", StartOIDCLink)
+	account.POST("/me/sso/link/callback", LinkOIDCCallback)
+	account.POST("/me/2fa/setup", SetupTwoFactor)
+	account.POST("/me/2fa/enable", EnableTwoFactor)
+	account.POST("/me/2fa/disable", DisableTwoFactor)
+
+	secured := protected.Group("/")
+	secured.Use(middleware.RequireTwoFactor(), middleware.RequirePasswordChanged())
+	secured.POST("/register", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), Register)
+	secured.GET("/users", middleware.RequireUserSession(), middleware.RequirePermission("users", "read"), GetUsers)
+	secured.PUT("/users/:id", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), UpdateUser)
+	secured.POST("/users/:id/deactivate", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), DeactivateUser)
+	secured.POST("/users/:id/reactivate", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), ReactivateUser)
+	secured.POST("/users/:id/reset-password", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), ResetUserPassword)
+	secured.POST("/users/:id/reset-token", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), CreatePasswordResetToken)
+	secured.POST("/users/:id/unlock", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), UnlockUser)
+	secured.DELETE("/users/:id/2fa", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), ResetUserTwoFactor)
+	secured.GET("/sessions", middleware.RequireUserSession(), middleware.RequirePermission("users", "read"), GetSessions)
+	secured.DELETE("/sessions/:id", middleware.RequireUserSession(), middleware.RequirePermission("users", "write"), RevokeSession)
+	secured.GET("/login-attempts", middleware.RequireUserSession(), middleware.RequirePermission("users", "read"), GetLoginAttempts)
+	secured.GET("/roles", middleware.RequireUserSession(), middleware.RequirePermission("roles", "read"), GetRoles)
+	secured.GET("/roles/permissions", middleware.RequireUserSession(), middleware.RequirePermission("roles", "read"), GetPermissionCatalog)
+	secured.POST("/roles", middleware.RequireUserSession(), middleware.RequirePermission("roles", "write"), CreateRole)
+	secured.PUT("/roles/:id", middleware.RequireUserSession(), middleware.RequirePermission("roles", "write"), UpdateRole)
+	secured.DELETE("/roles/:id", middleware.RequireUserSession(), middleware.RequirePermission("roles", "write"), DeleteRole)
+	secured.POST("/api-keys", middleware.RequireUserSession(), middleware.RequirePermission("api_keys", "write"), CreateAPIKey)
+	secured.GET("/api-keys", middleware.RequireUserSession(), middleware.RequirePermission("api_keys", "read"), GetAPIKeys)
+	secured.POST("/api-keys/:id/revoke", middleware.RequireUserSession(), middleware.RequirePermission("api_keys", "write"), RevokeAPIKey)
+	secured.POST("/patients", middleware.RequirePermission("patients", "write"), CreatePatient)
+	secured.GET("/patients", middleware.RequirePermission("patients", "read"), GetPatients)
+	secured.GET("/patients/lookup", middleware.RequirePermission("patients", "read"), LookupPatientByDocument)
+	secured.GET("/patients/duplicates", middleware.RequirePermission("patients", "read"), GetDuplicatePatients)
+	secured.GET("/patients/:id", middleware.RequirePermission("patients", "read"), GetPatientByID)
+	secured.PUT("/patients/:id", middleware.RequirePermission("patients", "write"), UpdatePatient)
+	secured.PATCH("/patients/:id", middleware.RequirePermission("patients", "write"), PatchPatient)
+	secured.POST("/patients/:id/deactivate", middleware.RequirePermission("patients", "delete"), DeactivatePatient)
+	secured.DELETE("/patients/:id", middleware.RequirePermission("patients", "delete"), DeletePatient)
+	secured.POST("/patients/:id/restore", middleware.RequirePermission("patients", "delete"), RestorePatient)
+	secured.GET("/patients/:id/duplicates", middleware.RequirePermission("patients", "read"), GetPatientDuplicates)
+	secured.GET("/patients/:id/timeline", middleware.RequirePermission("patients", "read"), middleware.RequirePermission("results", "read"), GetPatientTimeline)
+	secured.GET("/patients/:id/results/cumulative", middleware.RequirePermission("patients", "read"), middleware.RequirePermission("results", "read"), GetCumulativeResults)
+	secured.GET("/patients/:id/results/parameters/:parameterId/series", middleware.RequirePermission("patients", "read"), middleware.RequirePermission("results", "read"), GetParameterSeries)
+	secured.GET("/patients/:id/guardians", middleware.RequirePermission("patients", "read"), GetPatientGuardians)
+	secured.POST("/patients/:id/guardians", middleware.RequirePermission("patients", "write"), AddPatientGuardian)
+	secured.DELETE("/patients/:id/guardians/:guardianId", middleware.RequirePermission("patients", "write"), RemovePatientGuardian)
+	secured.GET("/patients/:id/dependents", middleware.RequirePermission("patients", "read"), GetPatientDependents)
+	secured.POST("/patients/:id/dependents", middleware.RequirePermission("patients", "write"), CreateDependent)
+	secured.GET("/patients/:id/consents", middleware.RequirePermission("patients", "read"), GetPatientConsents)
+	secured.POST("/patients/:id/consents", middleware.RequirePermission("patients", "write"), GrantPatientConsent)
+	secured.POST("/patients/:id/consents/:consentId/revoke", middleware.RequirePermission("patients", "write"), RevokePatientConsent)
+	secured.GET("/patients/:id/exports", middleware.RequirePermission("patients", "export"), GetPatientExports)
+	secured.POST("/patients/:id/exports", middleware.RequirePermission("patients", "export"), CreatePatientExport)
+	secured.GET("/patients/:id/exports/:exportId", middleware.RequirePermission("patients", "export"), GetPatientExportByID)
+	secured.GET("/patients/:id/exports/:exportId/download", middleware.RequirePermission("patients", "export"), DownloadPatientExport)
+	secured.POST("/patients/:id/merge", middleware.RequirePermission("patients", "merge"), MergePatients)
+	secured.GET("/consents/templates", middleware.RequirePermission("consents", "read"), GetConsentTemplates)
+	secured.GET("/consents/templates/:id", middleware.RequirePermission("consents", "read"), GetConsentTemplateByID)
+	secured.POST("/consents/templates", middleware.RequirePermission("consents", "write"), CreateConsentTemplate)
+	secured.POST("/orders", middleware.RequirePermission("orders", "write"), CreateOrder)
+	secured.GET("/orders", middleware.RequirePermission("orders", "read"), GetOrders)
+	secured.GET("/lab/exams/catalog", middleware.RequirePermission("exams", "read"), GetExamCatalog)
+	secured.GET("/lab/exams/:id", middleware.RequirePermission("results", "read"), GetOrderExamDetails)
+	secured.POST("/lab/exams/:id/results", middleware.RequirePermission("results", "write"), SubmitResults)
+	secured.POST("/lab/exams/:id/validate", middleware.RequireUserSession(), middleware.RequirePermission("results", "validate"), ValidateResults)
+	secured.GET("/research/results", middleware.RequirePermission("research", "export"), ExportResearchResults)
 	return r
 }
 
 func seedAuthData(t *testing.T, db *gorm.DB) models.User {
-	role := models.Role{Name: "admin", Description: "admin"}
+	role := models.Role{Name: "admin", Description: "admin", Permissions: models.Permissions{"all": {"*"}}, IsActive: true}
 	if err := db.Create(&role).Error; err != nil {
 		t.Fatalf("create role: %v", err)
 	}
@@ -86,6 +196,10 @@ func seedAuthData(t *testing.T, db *gorm.DB) models.User {
 }
 
 func getToken(t *testing.T, r *gin.Engine, username, password string) string {
+	return login(t, r, username, password).Token
+}
+
+func login(t *testing.T, r *gin.Engine, username, password string) dtos.TokenResponse {
 	body := dtos.LoginRequest{Username: username, Password: password}
 	payload, _ := json.Marshal(body)
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", bytes.NewReader(payload))
@@ -97,9 +211,7 @@ func getToken(t *testing.T, r *gin.Engine, username, password string) string {
 	}
 
 	var parsed struct {
-		Data struct {
-			Token string `json:"token"`
-		} `json:"data"`
+		Data dtos.TokenResponse `json:"data"`
 	}
 	if err := json.Unmarshal(resp.Body.Bytes(), &parsed); err != nil {
 		t.Fatalf("unmarshal login: %v", err)
@@ -107,7 +219,25 @@ func getToken(t *testing.T, r *gin.Engine, username, password string) string {
 	if parsed.Data.Token == "" {
 		t.Fatal("empty token")
 	}
-	return parsed.Data.Token
+	return parsed.Data
+}
+
+func doJSON(r *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
 }
 
 func TestAuthLoginAndRegister(t *testing.T) {
@@ -125,19 +255,27 @@ func TestAuthLoginAndRegister(t *testing.T) {
 		FullName: "User One",
 		RoleID:   1,
 	}
-	payload, _ := json.Marshal(register)
-	req := httptest.NewRequest(http.MethodPost, "/api/v1/register", bytes.NewReader(payload))
-	req.Header.Set("Content-Type", "application/json")
-	resp := httptest.NewRecorder()
-	r.ServeHTTP(resp, req)
-	if resp.Code != http.StatusCreated {
-		t.Fatalf("register failed: %d", resp.Code)
+
+	// El registro ya no es público
+	if resp := doJSON(r, http.MethodPost, "/api/v1/register", "", register); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for anonymous register, got %d", resp.Code)
 	}
 
 	token := getToken(t, r, "admin", "Admin123!")
 	if token == "" {
 		t.Fatal("expected token")
 	}
+
+	if resp := doJSON(r, http.MethodPost, "/api/v1/register", token, register); resp.Code != http.StatusCreated {
+		t.Fatalf("register failed: %d", resp.Code)
+	}
+
+	register.Username = "user2"
+	register.Email = "user2@test.com"
+	register.RoleID = 99
+	if resp := doJSON(r, http.MethodPost, "/api/v1/register", token, register); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown role, got %d", resp.Code)
+	}
 }
 
 func TestPatientsEndpoints(t *testing.T) {
@@ -251,3 +389,2759 @@ func TestOrdersAndLabCatalog(t *testing.T) {
 		t.Fatalf("lab catalog failed: %d", catResp.Code)
 	}
 }
+
+func TestPermissionsEnforced(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+
+	role := models.Role{
+		Name:        "recepcionista",
+		Permissions: models.Permissions{"patients": {"read", "write"}, "orders": {"read", "write"}},
+		IsActive:    true,
+	}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	user := models.User{
+		Username: "recepcion",
+		Email:    "recepcion@test.com",
+		Password: "Recepcion123!",
+		FullName: "Recepcion",
+		RoleID:   role.ID,
+		IsActive: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	token := getToken(t, r, "recepcion", "Recepcion123!")
+
+	listReq := httptest.NewRequest(http.MethodGet, "/api/v1/patients", nil)
+	listReq.Header.Set("Authorization", "Bearer "+token)
+	listResp := httptest.NewRecorder()
+	r.ServeHTTP(listResp, listReq)
+	if listResp.Code != http.StatusOK {
+		t.Fatalf("list patients failed: %d", listResp.Code)
+	}
+
+	validateReq := httptest.NewRequest(http.MethodPost, "/api/v1/lab/exams/1/validate", nil)
+	validateReq.Header.Set("Authorization", "Bearer "+token)
+	validateResp := httptest.NewRecorder()
+	r.ServeHTTP(validateResp, validateReq)
+	if validateResp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 on validate, got %d", validateResp.Code)
+	}
+
+	// Al quitar permisos e invalidar la caché el cambio aplica de inmediato
+	if err := db.Model(&role).Update("is_active", false).Error; err != nil {
+		t.Fatalf("deactivate role: %v", err)
+	}
+	middleware.InvalidateRolePermissions(role.ID)
+
+	listReq = httptest.NewRequest(http.MethodGet, "/api/v1/patients", nil)
+	listReq.Header.Set("Authorization", "Bearer "+token)
+	listResp = httptest.NewRecorder()
+	r.ServeHTTP(listResp, listReq)
+	if listResp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 after deactivating role, got %d", listResp.Code)
+	}
+}
+
+func TestRefreshRotationAndLogout(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+
+	first := login(t, r, "admin", "Admin123!")
+	if first.RefreshToken == "" {
+		t.Fatal("expected refresh token")
+	}
+
+	resp := doJSON(r, http.MethodPost, "/api/v1/auth/refresh", "", dtos.RefreshRequest{RefreshToken: first.RefreshToken})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("refresh failed: %d", resp.Code)
+	}
+	var rotated struct {
+		Data dtos.TokenResponse `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &rotated); err != nil {
+		t.Fatalf("unmarshal refresh: %v", err)
+	}
+	if rotated.Data.RefreshToken == "" || rotated.Data.RefreshToken == first.RefreshToken {
+		t.Fatal("expected a rotated refresh token")
+	}
+
+	// Reutilizar el refresh token viejo revoca toda la familia
+	resp = doJSON(r, http.MethodPost, "/api/v1/auth/refresh", "", dtos.RefreshRequest{RefreshToken: first.RefreshToken})
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 on reuse, got %d", resp.Code)
+	}
+	resp = doJSON(r, http.MethodPost, "/api/v1/auth/refresh", "", dtos.RefreshRequest{RefreshToken: rotated.Data.RefreshToken})
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected family revoked after reuse, got %d", resp.Code)
+	}
+	resp = doJSON(r, http.MethodGet, "/api/v1/patients", rotated.Data.Token, nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected access token revoked after reuse, got %d", resp.Code)
+	}
+
+	// Logout revoca el token de acceso actual
+	second := login(t, r, "admin", "Admin123!")
+	resp = doJSON(r, http.MethodPost, "/api/v1/auth/logout", second.Token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("logout failed: %d", resp.Code)
+	}
+	resp = doJSON(r, http.MethodGet, "/api/v1/patients", second.Token, nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after logout, got %d", resp.Code)
+	}
+	resp = doJSON(r, http.MethodPost, "/api/v1/auth/refresh", "", dtos.RefreshRequest{RefreshToken: second.RefreshToken})
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected refresh token revoked after logout, got %d", resp.Code)
+	}
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	os.Setenv("LOGIN_MAX_FAILED_ATTEMPTS", "3")
+	defer os.Unsetenv("JWT_SECRET")
+	defer os.Unsetenv("LOGIN_MAX_FAILED_ATTEMPTS")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	user := models.User{
+		Username: "bioanalista",
+		Email:    "bio@test.com",
+		Password: "Bio12345!",
+		FullName: "Bio",
+		RoleID:   admin.RoleID,
+		IsActive: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	wrong := dtos.LoginRequest{Username: "bioanalista", Password: "wrong"}
+	for i := 0; i < 2; i++ {
+		if resp := doJSON(r, http.MethodPost, "/api/v1/login", "", wrong); resp.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", resp.Code)
+		}
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/login", "", wrong); resp.Code != http.StatusLocked {
+		t.Fatalf("expected 423 on threshold, got %d", resp.Code)
+	}
+
+	// Con la cuenta bloqueada ni la contraseña correcta funciona
+	right := dtos.LoginRequest{Username: "bioanalista", Password: "Bio12345!"}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/login", "", right); resp.Code != http.StatusLocked {
+		t.Fatalf("expected 423 while locked, got %d", resp.Code)
+	}
+
+	adminToken := getToken(t, r, "admin", "Admin123!")
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/users/%d/unlock", user.ID), adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("unlock failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/login", "", right); resp.Code != http.StatusOK {
+		t.Fatalf("expected login after unlock, got %d", resp.Code)
+	}
+
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.LastLogin == nil || reloaded.FailedLoginAttempts != 0 {
+		t.Fatalf("expected last_login set and attempts reset: %+v", reloaded)
+	}
+
+	// Intentos en paralelo parten del mismo usuario cargado: el contador se incrementa en
+	// SQL, de modo que ninguno se pierde y se alcanza el umbral
+	session := login(t, r, "bioanalista", "Bio12345!")
+	for i := 0; i < 3; i++ {
+		stale := reloaded
+		if err := registerFailedLogin(db, &stale); err != nil {
+			t.Fatalf("register failed login: %v", err)
+		}
+	}
+	var locked models.User
+	db.First(&locked, user.ID)
+	if locked.FailedLoginAttempts != 3 || !locked.IsLocked() {
+		t.Fatalf("expected concurrent failures to lock the account: %+v", locked)
+	}
+	// Con la cuenta bloqueada tampoco se renuevan los tokens
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/refresh", "", dtos.RefreshRequest{RefreshToken: session.RefreshToken}); resp.Code != http.StatusLocked {
+		t.Fatalf("expected 423 refreshing a locked account, got %d", resp.Code)
+	}
+	db.Model(&locked).Updates(map[string]interface{}{"failed_login_attempts": 0, "locked_until": nil})
+
+	// Un usuario inactivo recibe un código distinto
+	db.Model(&reloaded).Update("is_active", false)
+	resp := doJSON(r, http.MethodPost, "/api/v1/login", "", right)
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for inactive user, got %d", resp.Code)
+	}
+	var parsed struct {
+		Errors struct {
+			Code string `json:"code"`
+		} `json:"errors"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &parsed)
+	if parsed.Errors.Code != "USER_INACTIVE" {
+		t.Fatalf("expected USER_INACTIVE, got %q", parsed.Errors.Code)
+	}
+}
+
+func TestUserManagement(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	adminToken := getToken(t, r, "admin", "Admin123!")
+
+	resp := doJSON(r, http.MethodGet, "/api/v1/me", adminToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("me failed: %d", resp.Code)
+	}
+	var me struct {
+		Data models.UserResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &me)
+	if me.Data.ID != admin.ID || me.Data.RoleName != "admin" {
+		t.Fatalf("unexpected profile: %+v", me.Data)
+	}
+
+	user := models.User{
+		Username: "recepcion",
+		Email:    "recepcion@test.com",
+		Password: "Recepcion123!",
+		FullName: "Recepcion",
+		RoleID:   admin.RoleID,
+		IsActive: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	resp = doJSON(r, http.MethodGet, "/api/v1/users?search=recep", adminToken, nil)
+	var list struct {
+		Data []models.UserResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	if resp.Code != http.StatusOK || len(list.Data) != 1 {
+		t.Fatalf("unexpected user list: %d %+v", resp.Code, list.Data)
+	}
+
+	fullName := "Recepcion Principal"
+	resp = doJSON(r, http.MethodPut, fmt.Sprintf("/api/v1/users/%d", user.ID), adminToken, dtos.UpdateUserRequest{FullName: &fullName})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("update user failed: %d", resp.Code)
+	}
+
+	// Desactivar revoca las sesiones activas del usuario
+	userToken := getToken(t, r, "recepcion", "Recepcion123!")
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/users/%d/deactivate", user.ID), adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("deactivate failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", userToken, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after deactivation, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/users/%d/deactivate", admin.ID), adminToken, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 when deactivating self, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/users/%d/reactivate", user.ID), adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("reactivate failed: %d", resp.Code)
+	}
+
+	resp = doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/users/%d/reset-password", user.ID), adminToken, dtos.ResetUserPasswordRequest{NewPassword: "Temporal123!"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("reset password failed: %d", resp.Code)
+	}
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if !reloaded.MustChangePassword || !reloaded.CheckPassword("Temporal123!") {
+		t.Fatal("expected temporary password with forced change")
+	}
+
+	// Con users:write no se puede asignar un rol con más permisos que los propios
+	supervisorRole := models.Role{Name: "supervisor", Permissions: models.Permissions{"users": {"read", "write"}}, IsActive: true}
+	db.Create(&supervisorRole)
+	supervisor := models.User{Username: "supervisor", Email: "supervisor@test.com", Password: "Supervisor123!", FullName: "Supervisor", RoleID: supervisorRole.ID, IsActive: true}
+	db.Create(&supervisor)
+	supervisorToken := getToken(t, r, "supervisor", "Supervisor123!")
+	if resp := doJSON(r, http.MethodPut, fmt.Sprintf("/api/v1/users/%d", supervisor.ID), supervisorToken, dtos.UpdateUserRequest{RoleID: &admin.RoleID}); resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "PERMISSION_NOT_ALLOWED") {
+		t.Fatalf("expected 403 when assigning the admin role, got %d %s", resp.Code, resp.Body.String())
+	}
+	register := dtos.RegisterRequest{Username: "complice", Email: "complice@test.com", Password: "Complice123!", FullName: "Complice", RoleID: admin.RoleID}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/register", supervisorToken, register); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 when registering an admin, got %d %s", resp.Code, resp.Body.String())
+	}
+	register.RoleID = supervisorRole.ID
+	if resp := doJSON(r, http.MethodPost, "/api/v1/register", supervisorToken, register); resp.Code != http.StatusCreated {
+		t.Fatalf("expected supervisor to register a user with its own role, got %d %s", resp.Code, resp.Body.String())
+	}
+	var unchanged models.User
+	db.First(&unchanged, supervisor.ID)
+	if unchanged.RoleID != supervisorRole.ID {
+		t.Fatalf("role should not have changed: %d", unchanged.RoleID)
+	}
+}
+
+func TestRoleManagement(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	token := getToken(t, r, "admin", "Admin123!")
+
+	if resp := doJSON(r, http.MethodGet, "/api/v1/roles/permissions", token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("permission catalog failed: %d", resp.Code)
+	}
+
+	invalid := dtos.CreateRoleRequest{Name: "cajero", Permissions: models.Permissions{"payments": {"approve"}}}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/roles", token, invalid); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown action, got %d", resp.Code)
+	}
+
+	valid := dtos.CreateRoleRequest{Name: "cajero", Permissions: models.Permissions{"payments": {"read", "write"}}}
+	resp := doJSON(r, http.MethodPost, "/api/v1/roles", token, valid)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create role failed: %d", resp.Code)
+	}
+	var created struct {
+		Data models.Role `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+
+	// No se puede quitar el acceso total al único rol administrador
+	demote := dtos.UpdateRoleRequest{Permissions: models.Permissions{"patients": {"read"}}}
+	if resp := doJSON(r, http.MethodPut, fmt.Sprintf("/api/v1/roles/%d", admin.RoleID), token, demote); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 when demoting last admin role, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodDelete, fmt.Sprintf("/api/v1/roles/%d", admin.RoleID), token, nil); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 when deleting role with users, got %d", resp.Code)
+	}
+
+	update := dtos.UpdateRoleRequest{Permissions: models.Permissions{"payments": {"read"}, "patients": {"read"}}}
+	if resp := doJSON(r, http.MethodPut, fmt.Sprintf("/api/v1/roles/%d", created.Data.ID), token, update); resp.Code != http.StatusOK {
+		t.Fatalf("update role failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodDelete, fmt.Sprintf("/api/v1/roles/%d", created.Data.ID), token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("delete role failed: %d", resp.Code)
+	}
+
+	// Con roles:write solo se conceden permisos propios; el acceso total exige tenerlo
+	managerRole := models.Role{Name: "gestor", Permissions: models.Permissions{"roles": {"read", "write"}, "patients": {"read"}}, IsActive: true}
+	db.Create(&managerRole)
+	db.Create(&models.User{Username: "gestor", Email: "gestor@test.com", Password: "Gestor123!", FullName: "Gestor", RoleID: managerRole.ID, IsActive: true})
+	managerToken := getToken(t, r, "gestor", "Gestor123!")
+	for _, permissions := range []models.Permissions{{"all": {"*"}}, {"patients": {"read", "write"}}, {"patients": {"*"}}} {
+		if resp := doJSON(r, http.MethodPost, "/api/v1/roles", managerToken, dtos.CreateRoleRequest{Name: "escalado", Permissions: permissions}); resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "PERMISSION_NOT_ALLOWED") {
+			t.Fatalf("expected 403 creating role with %v, got %d %s", permissions, resp.Code, resp.Body.String())
+		}
+	}
+	if resp := doJSON(r, http.MethodPut, fmt.Sprintf("/api/v1/roles/%d", managerRole.ID), managerToken, dtos.UpdateRoleRequest{Permissions: models.Permissions{"all": {"*"}}}); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 escalating own role, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/roles", managerToken, dtos.CreateRoleRequest{Name: "consulta", Permissions: models.Permissions{"patients": {"read"}}}); resp.Code != http.StatusCreated {
+		t.Fatalf("expected role within own permissions to be created, got %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestTwoFactorLoginFlow(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	fixed := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return fixed }
+	defer func() { timeNow = time.Now }()
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+
+	token := getToken(t, r, "admin", "Admin123!")
+
+	resp := doJSON(r, http.MethodPost, "/api/v1/me/2fa/setup", token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("2fa setup failed: %d", resp.Code)
+	}
+	var setup struct {
+		Data dtos.TwoFactorSetupResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &setup)
+
+	code, _ := utils.TOTPCode(setup.Data.Secret, fixed)
+	resp = doJSON(r, http.MethodPost, "/api/v1/me/2fa/enable", token, dtos.TwoFactorCodeRequest{Code: code})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("2fa enable failed: %d", resp.Code)
+	}
+	var enabled struct {
+		Data dtos.RecoveryCodesResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &enabled)
+	if len(enabled.Data.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(enabled.Data.RecoveryCodes))
+	}
+
+	challenge := func() string {
+		resp := doJSON(r, http.MethodPost, "/api/v1/login", "", dtos.LoginRequest{Username: "admin", Password: "Admin123!"})
+		var parsed struct {
+			Data dtos.TwoFactorChallengeResponse `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		if !parsed.Data.TwoFactorRequired || parsed.Data.ChallengeToken == "" {
+			t.Fatalf("expected 2fa challenge, got %s", resp.Body.String())
+		}
+		return parsed.Data.ChallengeToken
+	}
+
+	// El token de desafío no sirve como token de acceso
+	first := challenge()
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", first, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected challenge token to be rejected, got %d", resp.Code)
+	}
+
+	// El código usado para activar ya no es válido; el del siguiente intervalo sí
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/2fa/verify", "", dtos.VerifyTwoFactorRequest{ChallengeToken: first, Code: code}); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed code to fail, got %d", resp.Code)
+	}
+	fixed = fixed.Add(utils.TOTPPeriod)
+	next, _ := utils.TOTPCode(setup.Data.Secret, fixed)
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/2fa/verify", "", dtos.VerifyTwoFactorRequest{ChallengeToken: first, Code: next}); resp.Code != http.StatusOK {
+		t.Fatalf("2fa verify failed: %d", resp.Code)
+	}
+
+	recovery := enabled.Data.RecoveryCodes[0]
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/2fa/verify", "", dtos.VerifyTwoFactorRequest{ChallengeToken: challenge(), RecoveryCode: recovery}); resp.Code != http.StatusOK {
+		t.Fatalf("recovery code verify failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/2fa/verify", "", dtos.VerifyTwoFactorRequest{ChallengeToken: challenge(), RecoveryCode: recovery}); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected used recovery code to fail, got %d", resp.Code)
+	}
+
+	// Un rol con 2FA obligatorio solo puede enrolarse hasta activarlo
+	role := models.Role{Name: "bioanalista", Permissions: models.Permissions{"patients": {"read"}}, IsActive: true, RequireTwoFactor: true}
+	db.Create(&role)
+	bio := models.User{Username: "bio", Email: "bio@test.com", Password: "Bio12345!", FullName: "Bio", RoleID: role.ID, IsActive: true}
+	db.Create(&bio)
+
+	resp = doJSON(r, http.MethodPost, "/api/v1/login", "", dtos.LoginRequest{Username: "bio", Password: "Bio12345!"})
+	var bioLogin struct {
+		Data dtos.LoginResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &bioLogin)
+	if !bioLogin.Data.TwoFactorSetupRequired {
+		t.Fatal("expected two_factor_setup_required")
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients", bioLogin.Data.Token, nil); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 before enrolling, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/me/2fa/setup", bioLogin.Data.Token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected enrollment to be reachable, got %d", resp.Code)
+	}
+
+	// Un administrador puede restablecer el 2FA de otro usuario
+	adminToken := func() string {
+		fixed = fixed.Add(utils.TOTPPeriod)
+		code, _ := utils.TOTPCode(setup.Data.Secret, fixed)
+		resp := doJSON(r, http.MethodPost, "/api/v1/auth/2fa/verify", "", dtos.VerifyTwoFactorRequest{ChallengeToken: challenge(), Code: code})
+		var parsed struct {
+			Data dtos.LoginResponse `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		return parsed.Data.Token
+	}()
+	db.Model(&models.User{}).Where("id = ?", bio.ID).Update("two_factor_enabled", true)
+	if resp := doJSON(r, http.MethodDelete, fmt.Sprintf("/api/v1/users/%d/2fa", bio.ID), adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("admin 2fa reset failed: %d", resp.Code)
+	}
+	var reloaded models.User
+	db.First(&reloaded, bio.ID)
+	if reloaded.TwoFactorEnabled || reloaded.TOTPSecret != "" {
+		t.Fatalf("expected 2fa cleared: %+v", reloaded)
+	}
+}
+
+func TestPasswordChangeAndResetToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	user := models.User{
+		Username:           "recepcion",
+		Email:              "recepcion@test.com",
+		Password:           "Temporal123!",
+		FullName:           "Recepcion",
+		RoleID:             admin.RoleID,
+		IsActive:           true,
+		MustChangePassword: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	// Con cambio pendiente solo se puede usar el perfil y /me/password
+	token := getToken(t, r, "recepcion", "Temporal123!")
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients", token, nil); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 before changing password, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected /me to be reachable, got %d", resp.Code)
+	}
+
+	change := func(current, next string) *httptest.ResponseRecorder {
+		return doJSON(r, http.MethodPost, "/api/v1/me/password", token, dtos.ChangePasswordRequest{CurrentPassword: current, NewPassword: next})
+	}
+	if resp := change("Temporal123!", "corta"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for weak password, got %d", resp.Code)
+	}
+	if resp := change("Incorrecta1", "Nueva12345"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for wrong current password, got %d", resp.Code)
+	}
+	if resp := change("Temporal123!", "Temporal123!"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for reused password, got %d", resp.Code)
+	}
+
+	resp := change("Temporal123!", "Nueva12345")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("change password failed: %d", resp.Code)
+	}
+	var changed struct {
+		Data dtos.TokenResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &changed)
+
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", token, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected old token to be revoked, got %d", resp.Code)
+	}
+	token = changed.Data.Token
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients", token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected access after changing password, got %d", resp.Code)
+	}
+
+	// La contraseña anterior queda en el historial
+	if resp := change("Nueva12345", "Temporal123!"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for password in history, got %d", resp.Code)
+	}
+
+	// Con una sesión robada no se puede adivinar la contraseña actual sin bloquear la cuenta
+	os.Setenv("LOGIN_MAX_FAILED_ATTEMPTS", "3")
+	defer os.Unsetenv("LOGIN_MAX_FAILED_ATTEMPTS")
+	for attempt := 1; attempt <= 3; attempt++ {
+		expected := http.StatusUnauthorized
+		if attempt == 3 {
+			expected = http.StatusLocked
+		}
+		if resp := change("Adivinada1", "OtraClave12"); resp.Code != expected {
+			t.Fatalf("attempt %d: expected %d, got %d", attempt, expected, resp.Code)
+		}
+	}
+	if resp := change("Nueva12345", "OtraClave12"); resp.Code != http.StatusLocked || !strings.Contains(resp.Body.String(), "ACCOUNT_LOCKED") {
+		t.Fatalf("expected locked account to reject password change, got %d %s", resp.Code, resp.Body.String())
+	}
+	var failures int64
+	db.Model(&models.LoginAttempt{}).Where("user_id = ? AND reason = ?", user.ID, models.LoginReasonInvalidPassword).Count(&failures)
+	if failures != 4 {
+		t.Fatalf("expected failed attempts to be recorded, got %d", failures)
+	}
+
+	adminToken := getToken(t, r, "admin", "Admin123!")
+	resp = doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/users/%d/reset-token", user.ID), adminToken, nil)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("reset token failed: %d", resp.Code)
+	}
+	var issued struct {
+		Data dtos.PasswordResetTokenResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &issued)
+
+	reset := dtos.ResetPasswordRequest{Token: issued.Data.Token, NewPassword: "Restablecida1"}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/password/reset", "", reset); resp.Code != http.StatusOK {
+		t.Fatalf("password reset failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", token, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected sessions revoked after reset, got %d", resp.Code)
+	}
+	reset.NewPassword = "OtraClave12"
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/password/reset", "", reset); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected used reset token to fail, got %d", resp.Code)
+	}
+
+	login(t, r, "recepcion", "Restablecida1")
+}
+
+func TestAPIKeys(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+
+	token := getToken(t, r, "admin", "Admin123!")
+
+	for _, scopes := range [][]string{{"all:*"}, {"reagents:read"}, {"patients"}} {
+		if resp := doJSON(r, http.MethodPost, "/api/v1/api-keys", token, dtos.CreateAPIKeyRequest{Name: "invalida", Scopes: scopes}); resp.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for scopes %v, got %d", scopes, resp.Code)
+		}
+	}
+
+	create := func(request dtos.CreateAPIKeyRequest) dtos.CreateAPIKeyResponse {
+		resp := doJSON(r, http.MethodPost, "/api/v1/api-keys", token, request)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create api key failed: %d %s", resp.Code, resp.Body.String())
+		}
+		var parsed struct {
+			Data dtos.CreateAPIKeyResponse `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		return parsed.Data
+	}
+	kiosk := create(dtos.CreateAPIKeyRequest{Name: "Kiosco de reportes", Scopes: []string{"patients:read"}})
+	restricted := create(dtos.CreateAPIKeyRequest{Name: "Middleware analizador", Scopes: []string{"results:write"}, AllowedIPs: []string{"10.0.0.0/8"}})
+
+	withKey := func(method, path, key string) int {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte("{}")))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if code := withKey(http.MethodGet, "/api/v1/patients", kiosk.Key); code != http.StatusOK {
+		t.Fatalf("expected scoped read to succeed, got %d", code)
+	}
+	if code := withKey(http.MethodPost, "/api/v1/patients", kiosk.Key); code != http.StatusForbidden {
+		t.Fatalf("expected 403 outside scopes, got %d", code)
+	}
+	if code := withKey(http.MethodGet, "/api/v1/me", kiosk.Key); code != http.StatusForbidden {
+		t.Fatalf("expected user-only route to reject api key, got %d", code)
+	}
+	if code := withKey(http.MethodPost, "/api/v1/api-keys", kiosk.Key); code != http.StatusForbidden {
+		t.Fatalf("expected api key to be unable to mint keys, got %d", code)
+	}
+	if code := withKey(http.MethodGet, "/api/v1/patients", "lab_desconocida"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown key, got %d", code)
+	}
+
+	// httptest usa 192.0.2.1 como IP de origen, fuera del rango permitido
+	if code := withKey(http.MethodGet, "/api/v1/patients", restricted.Key); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 from disallowed IP, got %d", code)
+	}
+
+	var stored models.APIKey
+	db.First(&stored, kiosk.ID)
+	if stored.LastUsedAt == nil || stored.LastUsedIP != "192.0.2.1" || stored.KeyHash == kiosk.Key {
+		t.Fatalf("expected hashed key with usage tracking: %+v", stored)
+	}
+
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/api-keys/%d/revoke", kiosk.ID), token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("revoke failed: %d", resp.Code)
+	}
+	if code := withKey(http.MethodGet, "/api/v1/patients", kiosk.Key); code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked key to be rejected, got %d", code)
+	}
+
+	// Las claves no sirven para administrar usuarios ni roles, aunque tengan el scope
+	manager := create(dtos.CreateAPIKeyRequest{Name: "Sincronizador", Scopes: []string{"users:read", "roles:read"}})
+	for _, path := range []string{"/api/v1/users", "/api/v1/roles", "/api/v1/sessions"} {
+		if code := withKey(http.MethodGet, path, manager.Key); code != http.StatusForbidden {
+			t.Fatalf("expected %s to reject api keys, got %d", path, code)
+		}
+	}
+
+	// Un usuario no puede emitir scopes que su rol no concede
+	role := models.Role{Name: "integraciones", Permissions: models.Permissions{"api_keys": {"write"}, "patients": {"read", "write"}}, IsActive: true}
+	db.Create(&role)
+	db.Create(&models.User{Username: "integra", Email: "integra@test.com", Password: "Integra123!", FullName: "Integraciones", RoleID: role.ID, IsActive: true})
+	integraToken := getToken(t, r, "integra", "Integra123!")
+	for _, scopes := range [][]string{{"users:write"}, {"roles:write"}, {"patients:*"}} {
+		resp := doJSON(r, http.MethodPost, "/api/v1/api-keys", integraToken, dtos.CreateAPIKeyRequest{Name: "escalada", Scopes: scopes})
+		if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "SCOPE_NOT_ALLOWED") {
+			t.Fatalf("expected scopes %v to be rejected, got %d %s", scopes, resp.Code, resp.Body.String())
+		}
+	}
+	resp := doJSON(r, http.MethodPost, "/api/v1/api-keys", integraToken, dtos.CreateAPIKeyRequest{Name: "Portal", Scopes: []string{"patients:read", "patients:write"}})
+	var portal struct {
+		Data dtos.CreateAPIKeyResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &portal)
+	if resp.Code != http.StatusCreated || withKey(http.MethodGet, "/api/v1/patients", portal.Data.Key) != http.StatusOK {
+		t.Fatalf("expected allowed scopes to work: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Si el rol del creador pierde un permiso, la clave también lo pierde
+	db.Model(&role).Update("permissions", models.Permissions{"api_keys": {"write"}, "patients": {"write"}})
+	middleware.InvalidateRolePermissions(role.ID)
+	if code := withKey(http.MethodGet, "/api/v1/patients", portal.Data.Key); code != http.StatusForbidden {
+		t.Fatalf("expected scope removed from the owner's role to be denied, got %d", code)
+	}
+}
+
+func TestValidateResultsElectronicSignature(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	category := models.ExamCategory{Name: "Quimica", Code: "QS"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Suero"}
+	db.Create(&sample)
+	examType := models.ExamType{Code: "GLU", Name: "Glicemia", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 5}
+	db.Create(&examType)
+	min, max := 70.0, 110.0
+	parameter := models.ExamParameter{ExamTypeID: examType.ID, ParameterName: "Glucosa", DataType: "numeric", ReferenceMin: &min, ReferenceMax: &max}
+	db.Create(&parameter)
+	patient := models.Patient{DocumentType: "cedula", DocumentNumber: "V11111111", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), Gender: "F", CreatedBy: admin.ID}
+	db.Create(&patient)
+	order := models.Order{PatientID: patient.ID, CreatedBy: admin.ID}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	orderExam := models.OrderExam{OrderID: order.ID, ExamTypeID: examType.ID, Price: 5}
+	db.Create(&orderExam)
+
+	token := getToken(t, r, "admin", "Admin123!")
+	validatePath := fmt.Sprintf("/api/v1/lab/exams/%d/validate", orderExam.ID)
+	resultsPath := fmt.Sprintf("/api/v1/lab/exams/%d/results", orderExam.ID)
+
+	submit := func(value float64) {
+		body := []dtos.UpdateResultRequest{{ParameterID: parameter.ID, ValueNumeric: &value}}
+		if resp := doJSON(r, http.MethodPost, resultsPath, token, body); resp.Code != http.StatusOK {
+			t.Fatalf("submit results failed: %d", resp.Code)
+		}
+	}
+	details := func() dtos.OrderExamDetailResponse {
+		resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/lab/exams/%d", orderExam.ID), token, nil)
+		var parsed struct {
+			Data dtos.OrderExamDetailResponse `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		return parsed.Data
+	}
+
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without results, got %d", resp.Code)
+	}
+
+	submit(95)
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "validado"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without re-authentication, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "incorrecta"}); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for wrong password, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "corregido", Password: "Admin123!"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for correction without prior signature, got %d", resp.Code)
+	}
+
+	resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("validate failed: %d %s", resp.Code, resp.Body.String())
+	}
+	manifest := details()
+	if len(manifest.Signatures) != 1 || !manifest.SignatureValid || manifest.Signatures[0].SignerName != "Admin" || manifest.ValidatedAt == nil {
+		t.Fatalf("unexpected signature manifest: %+v", manifest)
+	}
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 when re-signing unchanged results, got %d", resp.Code)
+	}
+
+	// Una corrección invalida la firma anterior hasta que se firme como "corregido"
+	submit(130)
+	if manifest := details(); manifest.SignatureValid {
+		t.Fatal("expected signature to no longer match corrected values")
+	}
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for wrong meaning, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, validatePath, token, dtos.ValidateResultsRequest{Meaning: "corregido", Password: "Admin123!"}); resp.Code != http.StatusOK {
+		t.Fatalf("correction signature failed: %d", resp.Code)
+	}
+
+	manifest = details()
+	if len(manifest.Signatures) != 2 || !manifest.SignatureValid || manifest.Signatures[0].ResultsHash == manifest.Signatures[1].ResultsHash {
+		t.Fatalf("unexpected manifest after correction: %+v", manifest.Signatures)
+	}
+	var current []models.ExamResult
+	db.Where("order_exam_id = ? AND is_current = ?", orderExam.ID, true).Find(&current)
+	if len(current) != 1 || current[0].Version != 2 || current[0].ValidatedBy == nil {
+		t.Fatalf("expected a single validated current version: %+v", current)
+	}
+}
+
+func TestSessionsAndLoginHistory(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	user := models.User{Username: "recepcion", Email: "recepcion@test.com", Password: "Recepcion123!", FullName: "Recepcion", RoleID: admin.RoleID, IsActive: true}
+	db.Create(&user)
+
+	doJSON(r, http.MethodPost, "/api/v1/login", "", dtos.LoginRequest{Username: "recepcion", Password: "incorrecta"})
+	doJSON(r, http.MethodPost, "/api/v1/login", "", dtos.LoginRequest{Username: "fantasma", Password: "incorrecta"})
+	laptop := login(t, r, "recepcion", "Recepcion123!")
+	phone := login(t, r, "recepcion", "Recepcion123!")
+
+	resp := doJSON(r, http.MethodGet, "/api/v1/me/sessions", laptop.Token, nil)
+	var mine struct {
+		Data []dtos.SessionResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &mine)
+	if resp.Code != http.StatusOK || len(mine.Data) != 2 {
+		t.Fatalf("expected 2 sessions, got %d %+v", resp.Code, mine.Data)
+	}
+	var phoneSession dtos.SessionResponse
+	currentCount := 0
+	for _, session := range mine.Data {
+		if session.Current {
+			currentCount++
+		} else {
+			phoneSession = session
+		}
+	}
+	if currentCount != 1 || phoneSession.ID == 0 {
+		t.Fatalf("expected exactly one current session: %+v", mine.Data)
+	}
+
+	// Revocar la sesión del teléfono invalida su token de acceso y su refresh token
+	if resp := doJSON(r, http.MethodDelete, fmt.Sprintf("/api/v1/me/sessions/%d", phoneSession.ID), laptop.Token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("revoke own session failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", phone.Token, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session token to be rejected, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/refresh", "", dtos.RefreshRequest{RefreshToken: phone.RefreshToken}); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session refresh to fail, got %d", resp.Code)
+	}
+
+	resp = doJSON(r, http.MethodGet, "/api/v1/me/login-history", laptop.Token, nil)
+	var history struct {
+		Data []models.LoginAttempt `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &history)
+	if len(history.Data) != 3 || history.Data[2].Success || history.Data[2].Reason != models.LoginReasonInvalidPassword || history.Data[0].IPAddress == "" {
+		t.Fatalf("unexpected login history: %+v", history.Data)
+	}
+
+	// Vista administrativa
+	adminToken := getToken(t, r, "admin", "Admin123!")
+	resp = doJSON(r, http.MethodGet, "/api/v1/login-attempts?username=fantasma", adminToken, nil)
+	json.Unmarshal(resp.Body.Bytes(), &history)
+	if len(history.Data) != 1 || history.Data[0].UserID != nil || history.Data[0].Reason != models.LoginReasonUnknownUser {
+		t.Fatalf("unexpected unknown user attempts: %+v", history.Data)
+	}
+
+	resp = doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/sessions?user_id=%d", user.ID), adminToken, nil)
+	var sessions struct {
+		Data []dtos.SessionResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &sessions)
+	if len(sessions.Data) != 1 || sessions.Data[0].User == nil || sessions.Data[0].User.Username != "recepcion" {
+		t.Fatalf("unexpected admin session list: %+v", sessions.Data)
+	}
+	if resp := doJSON(r, http.MethodDelete, fmt.Sprintf("/api/v1/sessions/%d", sessions.Data[0].ID), adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("admin revoke failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", laptop.Token, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected admin-revoked session to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestCookieSessionModeWithCSRF(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+
+	// send reproduce al navegador: adjunta las cookies vigentes y, opcionalmente, el encabezado CSRF
+	jar := map[string]*http.Cookie{}
+	send := func(method, path, csrf string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		if body == nil {
+			payload = nil
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range jar {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set(utils.CSRFHeader, csrf)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		for _, cookie := range resp.Result().Cookies() {
+			if cookie.MaxAge < 0 {
+				delete(jar, cookie.Name)
+			} else {
+				jar[cookie.Name] = cookie
+			}
+		}
+		return resp
+	}
+	var parsed struct {
+		Data dtos.TokenResponse `json:"data"`
+	}
+
+	resp := send(http.MethodPost, "/api/v1/login", "", dtos.LoginRequest{Username: "admin", Password: "Admin123!", Mode: utils.AuthModeCookie})
+	json.Unmarshal(resp.Body.Bytes(), &parsed)
+	if resp.Code != http.StatusOK || parsed.Data.Token != "" || parsed.Data.RefreshToken != "" || parsed.Data.CSRFToken == "" {
+		t.Fatalf("unexpected cookie login response: %d %s", resp.Code, resp.Body.String())
+	}
+	access, refresh, csrfCookie := jar[utils.AccessCookieName], jar[utils.RefreshCookieName], jar[utils.CSRFCookieName]
+	if access == nil || !access.HttpOnly || !access.Secure || access.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected access cookie: %+v", access)
+	}
+	if refresh == nil || !refresh.HttpOnly || refresh.Path != "/api/v1/auth" {
+		t.Fatalf("unexpected refresh cookie: %+v", refresh)
+	}
+	if csrfCookie == nil || csrfCookie.HttpOnly || csrfCookie.Value != parsed.Data.CSRFToken {
+		t.Fatalf("unexpected csrf cookie: %+v", csrfCookie)
+	}
+	csrf := parsed.Data.CSRFToken
+
+	// Las lecturas solo necesitan la cookie
+	if resp := send(http.MethodGet, "/api/v1/me", "", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected cookie auth to work, got %d", resp.Code)
+	}
+
+	// Las escrituras sin el encabezado CSRF (o con uno distinto) se rechazan
+	if resp := send(http.MethodPost, "/api/v1/auth/logout", "", nil); resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "CSRF_TOKEN_INVALID") {
+		t.Fatalf("expected CSRF rejection, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := send(http.MethodPost, "/api/v1/auth/refresh", "otro", nil); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected CSRF rejection on refresh, got %d", resp.Code)
+	}
+
+	// El refresh toma el token de la cookie y rota las cookies
+	resp = send(http.MethodPost, "/api/v1/auth/refresh", csrf, nil)
+	json.Unmarshal(resp.Body.Bytes(), &parsed)
+	if resp.Code != http.StatusOK || parsed.Data.Token != "" || parsed.Data.CSRFToken == "" || parsed.Data.CSRFToken == csrf {
+		t.Fatalf("unexpected cookie refresh: %d %s", resp.Code, resp.Body.String())
+	}
+	if jar[utils.AccessCookieName].Value == access.Value || jar[utils.RefreshCookieName].Value == refresh.Value {
+		t.Fatal("expected rotated session cookies")
+	}
+	csrf = parsed.Data.CSRFToken
+	oldAccess := jar[utils.AccessCookieName]
+
+	// El cliente Bearer sigue sin necesitar CSRF aunque también haya cookies
+	bearer := getToken(t, r, "admin", "Admin123!")
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/logout", bearer, nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected bearer logout without CSRF, got %d", resp.Code)
+	}
+
+	resp = send(http.MethodPost, "/api/v1/auth/logout", csrf, nil)
+	if resp.Code != http.StatusOK || len(jar) != 0 {
+		t.Fatalf("expected logout to clear cookies, got %d %v", resp.Code, jar)
+	}
+	jar[utils.AccessCookieName] = oldAccess
+	if resp := send(http.MethodGet, "/api/v1/me", "", nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected logged out cookie to be rejected, got %d", resp.Code)
+	}
+}
+
+// mockOIDCProvider es un proveedor de identidad en proceso: publica el documento de
+// descubrimiento y su JWKS, y en /token valida PKCE antes de firmar el ID token
+type mockOIDCProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu     sync.Mutex
+	grants map[string]mockOIDCGrant
+}
+
+type mockOIDCGrant struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T, clientID string) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	mock := &mockOIDCProvider{key: key, clientID: clientID, grants: map[string]mockOIDCGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 mock.server.URL,
+			"authorization_endpoint": mock.server.URL + "/authorize",
+			"token_endpoint":         mock.server.URL + "/token",
+			"jwks_uri":               mock.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock-key",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mock.mu.Lock()
+		grant, ok := mock.grants[r.PostForm.Get("code")]
+		delete(mock.grants, r.PostForm.Get("code"))
+		mock.mu.Unlock()
+
+		if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("client_id") != mock.clientID ||
+			r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+			utils.PKCEChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss": mock.server.URL,
+			"aud": mock.clientID,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(5 * time.Minute).Unix(),
+		}
+		for name, value := range grant.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "mock-key"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+
+	mock.server = httptest.NewServer(mux)
+	t.Cleanup(mock.server.Close)
+	return mock
+}
+
+// authorize simula que el usuario se autenticó en el proveedor: registra un código para
+// la URL de autorización y retorna el código y el estado con los que vuelve al cliente.
+// El nonce de la solicitud se incluye en el ID token salvo que claims lo sobrescriba.
+func (m *mockOIDCProvider) authorize(t *testing.T, authorizationURL string, claims jwt.MapClaims) (string, string) {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil || !strings.HasPrefix(authorizationURL, m.server.URL+"/authorize?") {
+		t.Fatalf("unexpected authorization url: %s", authorizationURL)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != m.clientID || query.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization request: %v", query)
+	}
+
+	grantClaims := jwt.MapClaims{"nonce": query.Get("nonce")}
+	for name, value := range claims {
+		grantClaims[name] = value
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	m.mu.Lock()
+	m.grants[code] = mockOIDCGrant{challenge: query.Get("code_challenge"), redirectURI: query.Get("redirect_uri"), claims: grantClaims}
+	m.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+
+	bio := models.Role{Name: "bioanalista", Permissions: models.Permissions{"results": {"read"}}, IsActive: true}
+	db.Create(&bio)
+
+	if resp := doJSON(r, http.MethodGet, "/api/v1/auth/oidc/login", "", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected SSO disabled, got %d", resp.Code)
+	}
+
+	mock := newMockOIDCProvider(t, "lab-app")
+	env := map[string]string{
+		"OIDC_ISSUER_URL":   mock.server.URL,
+		"OIDC_CLIENT_ID":    "lab-app",
+		"OIDC_REDIRECT_URL": "http://localhost:5173/sso/callback",
+		"OIDC_ROLE_MAPPING": "lab-bioanalistas=bioanalista,lab-admins=admin",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	start := func(mode string) dtos.OIDCLoginResponse {
+		resp := doJSON(r, http.MethodGet, "/api/v1/auth/oidc/login?mode="+mode, "", nil)
+		var parsed struct {
+			Data dtos.OIDCLoginResponse `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		if resp.Code != http.StatusOK || parsed.Data.AuthorizationURL == "" {
+			t.Fatalf("start oidc login failed: %d %s", resp.Code, resp.Body.String())
+		}
+		return parsed.Data
+	}
+	ssoLogin := func(mode string, claims jwt.MapClaims) (*httptest.ResponseRecorder, dtos.OIDCCallbackRequest) {
+		code, state := mock.authorize(t, start(mode).AuthorizationURL, claims)
+		callback := dtos.OIDCCallbackRequest{Code: code, State: state}
+		return doJSON(r, http.MethodPost, "/api/v1/auth/oidc/callback", "", callback), callback
+	}
+	newStaff := jwt.MapClaims{
+		"sub":                "idp-123",
+		"email":              "Maria.Perez@hospital.org",
+		"email_verified":     true,
+		"name":               "María Pérez",
+		"preferred_username": "mperez",
+		"groups":             []string{"staff", "lab-bioanalistas"},
+	}
+
+	// Sin aprovisionamiento JIT un usuario desconocido no puede entrar
+	resp, _ := ssoLogin(utils.AuthModeBearer, newStaff)
+	if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "SSO_USER_NOT_PROVISIONED") {
+		t.Fatalf("expected not provisioned, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Con JIT se crea el usuario con el rol mapeado desde sus grupos
+	os.Setenv("OIDC_JIT_PROVISIONING", "true")
+	defer os.Unsetenv("OIDC_JIT_PROVISIONING")
+	resp, callback := ssoLogin(utils.AuthModeBearer, newStaff)
+	var logged struct {
+		Data dtos.LoginResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &logged)
+	if resp.Code != http.StatusOK || logged.Data.Token == "" || logged.Data.User.Username != "mperez" || logged.Data.User.RoleName != "bioanalista" {
+		t.Fatalf("expected JIT login, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", logged.Data.Token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected SSO token to work, got %d", resp.Code)
+	}
+	var identity models.UserIdentity
+	if err := db.Where("issuer = ? AND subject = ?", mock.server.URL, "idp-123").First(&identity).Error; err != nil || identity.UserID != logged.Data.User.ID {
+		t.Fatalf("expected linked identity: %v %+v", err, identity)
+	}
+
+	// El estado es de un solo uso
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/oidc/callback", "", callback); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected replayed state to fail, got %d", resp.Code)
+	}
+
+	// Un ID token con otro nonce se rechaza
+	tampered := jwt.MapClaims{"sub": "idp-123", "nonce": "otro"}
+	if resp, _ := ssoLogin(utils.AuthModeBearer, tampered); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected nonce mismatch to fail, got %d", resp.Code)
+	}
+
+	// Al volver a entrar se reconoce por sub y el rol se sincroniza con los grupos
+	promoted := jwt.MapClaims{"sub": "idp-123", "email": "maria.perez@hospital.org", "groups": []string{"lab-admins"}}
+	resp, _ = ssoLogin(utils.AuthModeCookie, promoted)
+	logged.Data = dtos.LoginResponse{}
+	json.Unmarshal(resp.Body.Bytes(), &logged)
+	if resp.Code != http.StatusOK || logged.Data.User.RoleName != "admin" || logged.Data.Token != "" || logged.Data.CSRFToken == "" {
+		t.Fatalf("expected cookie login with synced role, got %d %s", resp.Code, resp.Body.String())
+	}
+	var users int64
+	db.Model(&models.User{}).Where("email = ?", "maria.perez@hospital.org").Count(&users)
+	if users != 1 {
+		t.Fatalf("expected a single provisioned user, got %d", users)
+	}
+
+	// Una cuenta local existente nunca se vincula por correo, aunque esté verificado
+	adminClaims := jwt.MapClaims{"sub": "idp-admin", "email": "admin@test.com", "email_verified": true, "groups": []string{"lab-admins"}}
+	resp, _ = ssoLogin(utils.AuthModeBearer, adminClaims)
+	if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "SSO_LINK_REQUIRED") {
+		t.Fatalf("expected explicit link to be required, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// La vinculación se hace desde la sesión del propio usuario
+	adminToken := getToken(t, r, "admin", "Admin123!")
+	linkSSO := func(token string, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		resp := doJSON(r, http.MethodGet, "/api/v1/me/sso/link", token, nil)
+		var parsed struct {
+			Data dtos.OIDCLoginResponse `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("start oidc link failed: %d %s", resp.Code, resp.Body.String())
+		}
+		code, state := mock.authorize(t, parsed.Data.AuthorizationURL, claims)
+		return doJSON(r, http.MethodPost, "/api/v1/me/sso/link/callback", token, dtos.OIDCCallbackRequest{Code: code, State: state})
+	}
+
+	// Un estado de vinculación no sirve para iniciar sesión
+	linkStart := doJSON(r, http.MethodGet, "/api/v1/me/sso/link", adminToken, nil)
+	var linkState struct {
+		Data dtos.OIDCLoginResponse `json:"data"`
+	}
+	json.Unmarshal(linkStart.Body.Bytes(), &linkState)
+	code, state := mock.authorize(t, linkState.Data.AuthorizationURL, adminClaims)
+	if resp := doJSON(r, http.MethodPost, "/api/v1/auth/oidc/callback", "", dtos.OIDCCallbackRequest{Code: code, State: state}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected link state to be rejected at login, got %d", resp.Code)
+	}
+
+	if resp := linkSSO(adminToken, adminClaims); resp.Code != http.StatusOK {
+		t.Fatalf("expected explicit link, got %d %s", resp.Code, resp.Body.String())
+	}
+	resp, _ = ssoLogin(utils.AuthModeBearer, adminClaims)
+	json.Unmarshal(resp.Body.Bytes(), &logged)
+	if resp.Code != http.StatusOK || logged.Data.User.Username != "admin" {
+		t.Fatalf("expected SSO login after link, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	var attempts []models.LoginAttempt
+	db.Where("reason IN ?", []string{models.LoginReasonOIDC, models.LoginReasonSSONotProvisioned}).Order("id").Find(&attempts)
+	if len(attempts) != 4 || attempts[0].Success || !attempts[1].Success {
+		t.Fatalf("unexpected SSO login attempts: %+v", attempts)
+	}
+
+	// Una identidad ya vinculada no puede pasar a otro usuario
+	resp, _ = ssoLogin(utils.AuthModeBearer, promoted)
+	json.Unmarshal(resp.Body.Bytes(), &logged)
+	staffToken := logged.Data.Token
+	if resp := linkSSO(staffToken, adminClaims); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "SSO_IDENTITY_LINKED") {
+		t.Fatalf("expected identity linked conflict, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Un cambio de rol por SSO revoca las sesiones abiertas
+	demoted := jwt.MapClaims{"sub": "idp-123", "email": "maria.perez@hospital.org", "groups": []string{"lab-bioanalistas"}}
+	if resp, _ := ssoLogin(utils.AuthModeBearer, demoted); resp.Code != http.StatusOK {
+		t.Fatalf("expected demotion login, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/me", staffToken, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected sessions revoked on SSO role change, got %d", resp.Code)
+	}
+
+	// Sin grupos mapeados se rechaza el ingreso en lugar de conservar el rol anterior
+	unmapped := jwt.MapClaims{"sub": "idp-admin", "email": "admin@test.com", "groups": []string{"staff"}}
+	if resp, _ := ssoLogin(utils.AuthModeBearer, unmapped); resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "SSO_ROLE_NOT_MAPPED") {
+		t.Fatalf("expected unmapped groups to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// El proveedor no puede quitar el rol al último administrador
+	lastAdmin := jwt.MapClaims{"sub": "idp-admin", "email": "admin@test.com", "groups": []string{"lab-bioanalistas"}}
+	if resp, _ := ssoLogin(utils.AuthModeBearer, lastAdmin); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "SSO_LAST_ADMIN") {
+		t.Fatalf("expected last admin protection, got %d %s", resp.Code, resp.Body.String())
+	}
+	var adminUser models.User
+	db.Preload("Role").Where("username = ?", "admin").First(&adminUser)
+	if !adminUser.Role.Permissions.IsFullAccess() {
+		t.Fatalf("admin role must be kept, got %s", adminUser.Role.Name)
+	}
+
+	// Una cuenta bloqueada tampoco entra por SSO
+	lockedUntil := time.Now().Add(time.Hour)
+	db.Model(&adminUser).Update("locked_until", &lockedUntil)
+	if resp, _ := ssoLogin(utils.AuthModeBearer, adminClaims); resp.Code != http.StatusLocked {
+		t.Fatalf("expected locked account to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestLoginRehashesLegacyPasswords(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+
+	// Usuario creado antes de argon2id (hash bcrypt)
+	os.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	legacy := models.User{Username: "antiguo", Email: "antiguo@test.com", Password: "Antiguo123!", FullName: "Antiguo", RoleID: admin.RoleID, IsActive: true}
+	db.Create(&legacy)
+	os.Unsetenv("PASSWORD_HASH_ALGORITHM")
+	if !strings.HasPrefix(legacy.PasswordHash, "$2a$") {
+		t.Fatalf("expected bcrypt hash, got %s", legacy.PasswordHash)
+	}
+
+	// Un intento fallido no toca el hash
+	doJSON(r, http.MethodPost, "/api/v1/login", "", dtos.LoginRequest{Username: "antiguo", Password: "incorrecta"})
+	db.First(&legacy, legacy.ID)
+	if !strings.HasPrefix(legacy.PasswordHash, "$2a$") {
+		t.Fatal("failed login must not rehash")
+	}
+
+	login(t, r, "antiguo", "Antiguo123!")
+	db.First(&legacy, legacy.ID)
+	if !strings.HasPrefix(legacy.PasswordHash, "$argon2id$") {
+		t.Fatalf("expected argon2id hash after login, got %s", legacy.PasswordHash)
+	}
+	login(t, r, "antiguo", "Antiguo123!")
+}
+
+func TestPatientUpdateDeactivateAndRestore(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	create := dtos.CreatePatientRequest{
+		DocumentType:   "cedula",
+		DocumentNumber: "V-12345678",
+		FirstName:      "Jose",
+		LastName:       "Perez",
+		DateOfBirth:    time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC),
+		Gender:         "M",
+		Phone:          "0414-5551234",
+	}
+	resp := doJSON(r, http.MethodPost, "/api/v1/patients", token, create)
+	var created struct {
+		Data models.Patient `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create patient failed: %d %s", resp.Code, resp.Body.String())
+	}
+	path := fmt.Sprintf("/api/v1/patients/%d", created.Data.ID)
+
+	// PATCH corrige solo el nombre; un valor inválido se rechaza
+	name := "José"
+	if resp := doJSON(r, http.MethodPatch, path, token, dtos.PatchPatientRequest{FirstName: &name}); resp.Code != http.StatusOK {
+		t.Fatalf("patch failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPatch, path, token, map[string]string{"last_name": ""}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected empty last name to be rejected, got %d", resp.Code)
+	}
+	var patient models.Patient
+	db.First(&patient, created.Data.ID)
+	if patient.FirstName != "José" || patient.LastName != "Perez" || patient.Phone != "0414-5551234" {
+		t.Fatalf("unexpected patched patient: %+v", patient)
+	}
+
+	// PUT reemplaza los datos editables (el teléfono omitido queda vacío)
+	update := dtos.UpdatePatientRequest(create)
+	update.FirstName = "José"
+	update.Phone = ""
+	update.Email = "jose@test.com"
+	if resp := doJSON(r, http.MethodPut, path, token, update); resp.Code != http.StatusOK {
+		t.Fatalf("put failed: %d %s", resp.Code, resp.Body.String())
+	}
+
+	var audit []models.AuditLog
+	db.Where("table_name = ? AND record_id = ?", "patients", created.Data.ID).Order("id").Find(&audit)
+	if len(audit) != 3 || !audit[0].IsInsert() || audit[1].OldValues["first_name"] != "Jose" || audit[1].NewValues["first_name"] != "José" {
+		t.Fatalf("unexpected audit trail: %+v", audit)
+	}
+	if len(audit[2].NewValues) != 2 || audit[2].NewValues["email"] != "jose@test.com" || audit[2].OldValues["phone"] != "0414-5551234" || audit[2].UserID == nil || *audit[2].UserID != admin.ID {
+		t.Fatalf("unexpected PUT audit entry: %+v", audit[2])
+	}
+
+	// Desactivación con motivo: deja de listarse y no admite órdenes
+	if resp := doJSON(r, http.MethodPost, path+"/deactivate", token, map[string]string{}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected reason to be required, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, path+"/deactivate", token, dtos.PatientStatusRequest{Reason: "Paciente fallecido"}); resp.Code != http.StatusOK {
+		t.Fatalf("deactivate failed: %d", resp.Code)
+	}
+	order := dtos.CreateOrderRequest{PatientID: created.Data.ID, Priority: "normal", Exams: []dtos.OrderExamRequest{{ExamTypeID: 1, Price: 10}}}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/orders", token, order); resp.Code != http.StatusConflict {
+		t.Fatalf("expected inactive patient order to be rejected, got %d", resp.Code)
+	}
+
+	listed := func(status string) int {
+		resp := doJSON(r, http.MethodGet, "/api/v1/patients?status="+status, token, nil)
+		var parsed struct {
+			Data []models.Patient `json:"data"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &parsed)
+		return len(parsed.Data)
+	}
+	if listed("active") != 0 || listed("inactive") != 1 || listed("deleted") != 0 {
+		t.Fatal("unexpected listing after deactivation")
+	}
+
+	// Eliminación lógica y restauración
+	if resp := doJSON(r, http.MethodDelete, path, token, dtos.PatientStatusRequest{Reason: "Registro duplicado"}); resp.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, path, token, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted patient to be hidden, got %d", resp.Code)
+	}
+	if listed("deleted") != 1 || listed("inactive") != 0 || listed("all") != 1 {
+		t.Fatal("unexpected listing after deletion")
+	}
+
+	if resp := doJSON(r, http.MethodPost, path+"/restore", token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("restore failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, path+"/restore", token, nil); resp.Code != http.StatusConflict {
+		t.Fatalf("expected second restore to conflict, got %d", resp.Code)
+	}
+	db.First(&patient, created.Data.ID)
+	if !patient.IsActive || patient.DeactivationReason != "" || listed("active") != 1 {
+		t.Fatalf("unexpected restored patient: %+v", patient)
+	}
+
+	db.Where("table_name = ? AND record_id = ?", "patients", created.Data.ID).Order("id").Find(&audit)
+	actions := []string{}
+	for _, entry := range audit {
+		actions = append(actions, entry.Action)
+	}
+	if strings.Join(actions, ",") != "INSERT,UPDATE,UPDATE,DEACTIVATE,DELETE,RESTORE" || audit[4].NewValues["reason"] != "Registro duplicado" {
+		t.Fatalf("unexpected audit actions: %v", actions)
+	}
+}
+
+func TestPatientRecordValidation(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	create := dtos.CreatePatientRequest{
+		DocumentType:          "cedula",
+		DocumentNumber:        "V-20111222",
+		FirstName:             "Ana",
+		LastName:              "Rojas",
+		DateOfBirth:           time.Date(1985, 3, 2, 0, 0, 0, 0, time.UTC),
+		Gender:                "F",
+		Phone:                 "+58 (212) 555-1234",
+		Address:               "Av. Principal, Edif. Sol, piso 3",
+		City:                  "Caracas",
+		State:                 "Distrito Capital",
+		EmergencyContactName:  "Luis Rojas",
+		EmergencyContactPhone: "0412-5557788",
+		Allergies:             "Penicilina",
+	}
+	resp := doJSON(r, http.MethodPost, "/api/v1/patients", token, create)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create patient failed: %d %s", resp.Code, resp.Body.String())
+	}
+	var raw struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &raw)
+	if _, ok := raw.Data["creator"]; ok {
+		t.Fatalf("response should not expose the creator: %v", raw.Data)
+	}
+	if _, ok := raw.Data["orders"]; ok {
+		t.Fatalf("response should not expose orders: %v", raw.Data)
+	}
+	var created struct {
+		Data models.PatientResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if created.Data.Age < 40 || created.Data.Country != models.DefaultPatientCountry || created.Data.EmergencyContactName != "Luis Rojas" || created.Data.Allergies != "Penicilina" {
+		t.Fatalf("unexpected patient response: %+v", created.Data)
+	}
+
+	// Fechas de nacimiento y teléfonos inválidos se rechazan
+	invalid := []func(*dtos.CreatePatientRequest){
+		func(p *dtos.CreatePatientRequest) { p.DateOfBirth = time.Now().AddDate(0, 0, 2) },
+		func(p *dtos.CreatePatientRequest) { p.DateOfBirth = time.Now().AddDate(-140, 0, 0) },
+		func(p *dtos.CreatePatientRequest) { p.Phone = "abc123" },
+		func(p *dtos.CreatePatientRequest) { p.EmergencyContactPhone = "12345" },
+	}
+	for i, mutate := range invalid {
+		input := create
+		input.DocumentNumber = fmt.Sprintf("V-3000000%d", i)
+		mutate(&input)
+		if resp := doJSON(r, http.MethodPost, "/api/v1/patients", token, input); resp.Code != http.StatusBadRequest {
+			t.Fatalf("case %d: expected 400, got %d %s", i, resp.Code, resp.Body.String())
+		}
+	}
+
+	// El cambio de alergias queda auditado
+	path := fmt.Sprintf("/api/v1/patients/%d", created.Data.ID)
+	allergies := "Penicilina, látex"
+	if resp := doJSON(r, http.MethodPatch, path, token, dtos.PatchPatientRequest{Allergies: &allergies}); resp.Code != http.StatusOK {
+		t.Fatalf("patch allergies failed: %d %s", resp.Code, resp.Body.String())
+	}
+	var audit models.AuditLog
+	db.Where("table_name = ? AND record_id = ? AND action = ?", "patients", created.Data.ID, models.AuditActionUpdate).First(&audit)
+	if audit.OldValues["allergies"] != "Penicilina" || audit.NewValues["allergies"] != allergies {
+		t.Fatalf("unexpected allergies audit entry: %+v", audit)
+	}
+}
+
+func TestPatientDocumentUniquenessAndLookup(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	create := dtos.CreatePatientRequest{
+		DocumentType:   "cedula",
+		DocumentNumber: "v-14.555.666",
+		FirstName:      "Carla",
+		LastName:       "Mendez",
+		DateOfBirth:    time.Date(1979, 8, 21, 0, 0, 0, 0, time.UTC),
+		Gender:         "F",
+	}
+	resp := doJSON(r, http.MethodPost, "/api/v1/patients", token, create)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create patient failed: %d %s", resp.Code, resp.Body.String())
+	}
+	var created struct {
+		Data models.PatientResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if created.Data.DocumentNumber != "V-14555666" {
+		t.Fatalf("expected normalized document, got %q", created.Data.DocumentNumber)
+	}
+
+	// El mismo documento en otro formato es un duplicado
+	duplicate := create
+	duplicate.DocumentNumber = "14555666"
+	resp = doJSON(r, http.MethodPost, "/api/v1/patients", token, duplicate)
+	if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "PATIENT_DOCUMENT_EXISTS") {
+		t.Fatalf("expected duplicate document conflict, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// RIF con dígito verificador incorrecto y pasaporte normalizado
+	rif := create
+	rif.DocumentType = "rif"
+	rif.DocumentNumber = "G-20000303-1"
+	if resp := doJSON(r, http.MethodPost, "/api/v1/patients", token, rif); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "INVALID_DOCUMENT") {
+		t.Fatalf("expected invalid RIF, got %d %s", resp.Code, resp.Body.String())
+	}
+	passport := create
+	passport.DocumentType = "pasaporte"
+	passport.DocumentNumber = "ab 998877"
+	resp = doJSON(r, http.MethodPost, "/api/v1/patients", token, passport)
+	var other struct {
+		Data models.PatientResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &other)
+	if resp.Code != http.StatusCreated || other.Data.DocumentNumber != "AB998877" {
+		t.Fatalf("passport create failed: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Cambiar el documento a uno existente también es un conflicto
+	docType, number := "cedula", "V14555666"
+	otherPath := fmt.Sprintf("/api/v1/patients/%d", other.Data.ID)
+	if resp := doJSON(r, http.MethodPatch, otherPath, token, dtos.PatchPatientRequest{DocumentType: &docType, DocumentNumber: &number}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected conflict on patch, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Búsqueda exacta por documento en cualquier formato
+	resp = doJSON(r, http.MethodGet, "/api/v1/patients/lookup?document_type=cedula&document_number=14.555.666", token, nil)
+	var found struct {
+		Data models.PatientResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &found)
+	if resp.Code != http.StatusOK || found.Data.ID != created.Data.ID {
+		t.Fatalf("lookup failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients/lookup?document_type=cedula&document_number=V-14555667", token, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected lookup miss, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients/lookup?document_type=dni&document_number=123", token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid lookup, got %d", resp.Code)
+	}
+
+	// Un paciente eliminado libera el documento; no puede restaurarse mientras otro lo use
+	path := fmt.Sprintf("/api/v1/patients/%d", created.Data.ID)
+	if resp := doJSON(r, http.MethodDelete, path, token, dtos.PatientStatusRequest{Reason: "Registro erróneo"}); resp.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, "/api/v1/patients", token, duplicate); resp.Code != http.StatusCreated {
+		t.Fatalf("expected document to be reusable after delete, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, path+"/restore", token, nil); resp.Code != http.StatusConflict {
+		t.Fatalf("expected restore conflict, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// El índice único también protege contra inserciones directas
+	direct := models.Patient{DocumentType: "cedula", DocumentNumber: "V-14555666", FirstName: "X", LastName: "Y", DateOfBirth: create.DateOfBirth}
+	if err := db.Create(&direct).Error; err == nil {
+		t.Fatal("expected unique index violation")
+	}
+}
+
+func TestPatientDuplicatesAndMerge(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	dob := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	survivor := models.Patient{DocumentType: "cedula", DocumentNumber: "V-15000001", FirstName: "José", LastName: "Pérez", DateOfBirth: dob, Phone: "0414-5551234", CreatedBy: admin.ID}
+	duplicate := models.Patient{DocumentType: "cedula", DocumentNumber: "V-15000010", FirstName: "Jose", LastName: "Perez", DateOfBirth: dob, Phone: "+58 414 555 1234", Email: "jose@test.com", Allergies: "Penicilina", CreatedBy: admin.ID}
+	sameBirthDate := models.Patient{DocumentType: "cedula", DocumentNumber: "V-9876543", FirstName: "Josue", LastName: "Paredes", DateOfBirth: dob, CreatedBy: admin.ID}
+	unrelated := models.Patient{DocumentType: "cedula", DocumentNumber: "V-20000000", FirstName: "Ana", LastName: "Perez", DateOfBirth: time.Date(1990, 2, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	for _, p := range []*models.Patient{&survivor, &duplicate, &sameBirthDate, &unrelated} {
+		if err := db.Create(p).Error; err != nil {
+			t.Fatalf("failed to seed patient: %v", err)
+		}
+	}
+
+	// Solo el par con documento transpuesto, misma fecha, nombre parecido y mismo teléfono
+	resp := doJSON(r, http.MethodGet, "/api/v1/patients/duplicates", token, nil)
+	var pairs struct {
+		Data []dtos.PatientDuplicateCandidate `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &pairs)
+	if resp.Code != http.StatusOK || len(pairs.Data) != 1 {
+		t.Fatalf("unexpected duplicate pairs: %d %s", resp.Code, resp.Body.String())
+	}
+	pair := pairs.Data[0]
+	if pair.Patient.ID != survivor.ID || pair.Duplicate.ID != duplicate.ID || pair.Score != 1 || len(pair.Reasons) != 4 || pair.Reasons[0] != models.DuplicateReasonTransposedDocument {
+		t.Fatalf("unexpected duplicate pair: %+v", pair)
+	}
+
+	resp = doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/duplicates?min_score=0.2", duplicate.ID), token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &pairs)
+	if resp.Code != http.StatusOK || len(pairs.Data) != 2 || pairs.Data[0].Patient.ID != duplicate.ID || pairs.Data[0].Duplicate.ID != survivor.ID || pairs.Data[1].Duplicate.ID != sameBirthDate.ID {
+		t.Fatalf("unexpected patient duplicates: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients/duplicates?min_score=2", token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid min_score, got %d", resp.Code)
+	}
+
+	// Las órdenes, facturas y exportaciones del duplicado pasan al paciente que se conserva
+	order := models.Order{PatientID: duplicate.ID, CreatedBy: admin.ID}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("failed to create order: %v", err)
+	}
+	invoice := models.Invoice{OrderID: order.ID, PatientID: duplicate.ID, InvoiceDate: time.Now(), Subtotal: 10, TotalAmount: 10, CreatedBy: admin.ID}
+	if err := db.Create(&invoice).Error; err != nil {
+		t.Fatalf("failed to create invoice: %v", err)
+	}
+
+	// Ambos tienen como principal al mismo representante y el duplicado otro principal más
+	guardian := models.Patient{DocumentType: "cedula", DocumentNumber: "V-5000000", FirstName: "Rosa", LastName: "Perez", DateOfBirth: time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&guardian)
+	survivorLink := models.PatientGuardian{PatientID: survivor.ID, GuardianPatientID: &guardian.ID, Relationship: models.GuardianRelationshipMother, IsPrimary: true, CreatedBy: admin.ID}
+	repeatedLink := models.PatientGuardian{PatientID: duplicate.ID, GuardianPatientID: &guardian.ID, Relationship: models.GuardianRelationshipMother, IsPrimary: true, CanReceiveResults: true, CreatedBy: admin.ID}
+	contactLink := models.PatientGuardian{PatientID: duplicate.ID, FullName: "Luis Perez", DocumentType: "cedula", DocumentNumber: "V-6000000", Relationship: models.GuardianRelationshipFather, IsPrimary: true, CreatedBy: admin.ID}
+	for _, link := range []*models.PatientGuardian{&survivorLink, &repeatedLink, &contactLink} {
+		if err := db.Create(link).Error; err != nil {
+			t.Fatalf("failed to create guardian link: %v", err)
+		}
+	}
+
+	export := models.PatientExport{PatientID: duplicate.ID, Status: models.ExportStatusFailed, RequestedBy: admin.ID}
+	if err := db.Create(&export).Error; err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+
+	mergePath := fmt.Sprintf("/api/v1/patients/%d/merge", survivor.ID)
+	merge := dtos.MergePatientRequest{DuplicateID: duplicate.ID, Reason: "Registro duplicado"}
+	resp = doJSON(r, http.MethodPost, mergePath, token, merge)
+	var merged struct {
+		Data dtos.MergePatientResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &merged)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("merge failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if len(merged.Data.Moved["orders"]) != 1 || len(merged.Data.Moved["invoices"]) != 1 || len(merged.Data.Moved["exports"]) != 1 || merged.Data.Patient.Email != "jose@test.com" || merged.Data.Patient.Allergies != "Penicilina" || merged.Data.Patient.Phone != "0414-5551234" {
+		t.Fatalf("unexpected merge result: %+v", merged.Data)
+	}
+
+	db.First(&order, order.ID)
+	db.First(&invoice, invoice.ID)
+	if order.PatientID != survivor.ID || invoice.PatientID != survivor.ID {
+		t.Fatalf("references were not moved: order %d invoice %d", order.PatientID, invoice.PatientID)
+	}
+
+	// El representante repetido queda una sola vez y hay un único principal
+	var links []models.PatientGuardian
+	db.Where("patient_id = ?", survivor.ID).Order("id").Find(&links)
+	if len(merged.Data.RemovedGuardianLinks) != 1 || merged.Data.RemovedGuardianLinks[0] != repeatedLink.ID || len(links) != 2 {
+		t.Fatalf("expected repeated guardian link to be removed: %+v %+v", merged.Data.RemovedGuardianLinks, links)
+	}
+	if links[0].ID != survivorLink.ID || !links[0].IsPrimary || !links[0].CanReceiveResults || links[1].ID != contactLink.ID || links[1].IsPrimary {
+		t.Fatalf("unexpected guardian links after merge: %+v", links)
+	}
+
+	var tombstone models.Patient
+	db.Unscoped().First(&tombstone, duplicate.ID)
+	if !tombstone.IsDeleted() || tombstone.IsActive || tombstone.MergedIntoID == nil || *tombstone.MergedIntoID != survivor.ID {
+		t.Fatalf("unexpected tombstone: %+v", tombstone)
+	}
+
+	duplicatePath := fmt.Sprintf("/api/v1/patients/%d", duplicate.ID)
+	resp = doJSON(r, http.MethodGet, duplicatePath, token, nil)
+	if resp.Code != http.StatusNotFound || !strings.Contains(resp.Body.String(), "PATIENT_MERGED") {
+		t.Fatalf("expected merged patient hint, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, duplicatePath+"/restore", token, nil); resp.Code != http.StatusConflict {
+		t.Fatalf("expected merged patient restore to fail, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodPost, mergePath, token, merge); resp.Code != http.StatusConflict {
+		t.Fatalf("expected second merge to fail, got %d", resp.Code)
+	}
+
+	var audit []models.AuditLog
+	db.Where("action = ?", models.AuditActionMerge).Order("id").Find(&audit)
+	if len(audit) != 2 || audit[0].RecordID != survivor.ID || audit[0].OldValues["document_number"] != "V-15000010" || audit[0].NewValues["reason"] != "Registro duplicado" || audit[1].RecordID != duplicate.ID {
+		t.Fatalf("unexpected merge audit: %+v", audit)
+	}
+}
+
+func TestPatientSearchAndPagination(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	now := time.Now()
+	seed := []models.Patient{
+		{DocumentType: "cedula", DocumentNumber: "V-10000001", FirstName: "José Antonio", LastName: "Pérez", DateOfBirth: now.AddDate(-40, 0, -1), Gender: "M", Phone: "+58 (414) 555-1234", Email: "jose@correo.com"},
+		{DocumentType: "cedula", DocumentNumber: "V-10000002", FirstName: "María", LastName: "Ñáñez", DateOfBirth: now.AddDate(-25, 0, -1), Gender: "F", Phone: "0212-5550000"},
+		{DocumentType: "cedula", DocumentNumber: "V-10000003", FirstName: "Ana", LastName: "Pérez", DateOfBirth: now.AddDate(-8, 0, -1), Gender: "F"},
+		{DocumentType: "cedula", DocumentNumber: "V-10000004", FirstName: "Josefina", LastName: "Alvarez", DateOfBirth: now.AddDate(-65, 0, -1), Gender: "F", Email: "JOSEFINA@correo.com"},
+	}
+	for i := range seed {
+		seed[i].CreatedBy = admin.ID
+		if err := db.Create(&seed[i]).Error; err != nil {
+			t.Fatalf("failed to seed patient: %v", err)
+		}
+	}
+
+	type page struct {
+		Data []models.PatientResponse `json:"data"`
+		Meta utils.Pagination         `json:"meta"`
+	}
+	search := func(query string) page {
+		t.Helper()
+		resp := doJSON(r, http.MethodGet, "/api/v1/patients?"+query, token, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("search %q failed: %d %s", query, resp.Code, resp.Body.String())
+		}
+		var result page
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result
+	}
+	ids := func(result page) []uint {
+		out := []uint{}
+		for _, p := range result.Data {
+			out = append(out, p.ID)
+		}
+		return out
+	}
+
+	// Nombre sin acentos ni mayúsculas, en cualquier orden de palabras
+	if got := ids(search("name=jose+PEREZ")); len(got) != 1 || got[0] != seed[0].ID {
+		t.Fatalf("unexpected name search result: %v", got)
+	}
+	if got := ids(search("name=nanez")); len(got) != 1 || got[0] != seed[1].ID {
+		t.Fatalf("unexpected accent-insensitive search result: %v", got)
+	}
+	if got := ids(search("name=jos")); len(got) != 2 {
+		t.Fatalf("expected partial name match for two patients, got %v", got)
+	}
+
+	// Teléfono por dígitos (con o sin el 0 inicial) y correo sin distinguir mayúsculas
+	if got := ids(search("phone=0414-5551234")); len(got) != 1 || got[0] != seed[0].ID {
+		t.Fatalf("unexpected phone search result: %v", got)
+	}
+	if got := ids(search("email=josefina%40")); len(got) != 1 || got[0] != seed[3].ID {
+		t.Fatalf("unexpected email search result: %v", got)
+	}
+
+	// Rango de edad y género
+	if got := ids(search("age_min=18&age_max=60&gender=F")); len(got) != 1 || got[0] != seed[1].ID {
+		t.Fatalf("unexpected age/gender search result: %v", got)
+	}
+	if got := ids(search("age_max=8")); len(got) != 1 || got[0] != seed[2].ID {
+		t.Fatalf("unexpected age_max search result: %v", got)
+	}
+
+	// Paginación con total y orden
+	result := search("sort=-date_of_birth&limit=3&page=1")
+	if result.Meta.Total != 4 || result.Meta.TotalPages != 2 || len(result.Data) != 3 || result.Data[0].ID != seed[2].ID {
+		t.Fatalf("unexpected first page: %+v %v", result.Meta, ids(result))
+	}
+	result = search("sort=-date_of_birth&limit=3&page=2")
+	if len(result.Data) != 1 || result.Data[0].ID != seed[3].ID || result.Meta.Page != 2 {
+		t.Fatalf("unexpected second page: %+v %v", result.Meta, ids(result))
+	}
+	if got := ids(search("sort=name")); len(got) != 4 || got[0] != seed[3].ID || got[1] != seed[2].ID || got[2] != seed[0].ID {
+		t.Fatalf("unexpected name order: %v", got)
+	}
+
+	// Un cambio de nombre actualiza la búsqueda
+	name := "Josué"
+	path := fmt.Sprintf("/api/v1/patients/%d", seed[2].ID)
+	if resp := doJSON(r, http.MethodPatch, path, token, dtos.PatchPatientRequest{FirstName: &name}); resp.Code != http.StatusOK {
+		t.Fatalf("patch failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if got := ids(search("name=josue")); len(got) != 1 || got[0] != seed[2].ID {
+		t.Fatalf("expected renamed patient to be found, got %v", got)
+	}
+
+	for _, query := range []string{"sort=phone", "limit=500", "page=0", "age_min=30&age_max=20", "gender=X"} {
+		if resp := doJSON(r, http.MethodGet, "/api/v1/patients?"+query, token, nil); resp.Code != http.StatusBadRequest {
+			t.Fatalf("expected %q to be rejected, got %d", query, resp.Code)
+		}
+	}
+}
+
+func TestPatientTimeline(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	category := models.ExamCategory{Name: "Quimica", Code: "QS"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Suero"}
+	db.Create(&sample)
+	examType := models.ExamType{Code: "GLU", Name: "Glicemia", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 5}
+	db.Create(&examType)
+	min, max := 70.0, 110.0
+	parameter := models.ExamParameter{ExamTypeID: examType.ID, ParameterName: "Glucosa", UnitOfMeasure: "mg/dL", DataType: "numeric", ReferenceMin: &min, ReferenceMax: &max}
+	db.Create(&parameter)
+	patient := models.Patient{DocumentType: "cedula", DocumentNumber: "V-11111111", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&patient)
+
+	start := time.Now().Add(-48 * time.Hour)
+	collected := start.Add(time.Hour)
+	order := models.Order{PatientID: patient.ID, OrderDate: start, TotalAmount: 5, CreatedBy: admin.ID}
+	db.Create(&order)
+	orderExam := models.OrderExam{OrderID: order.ID, ExamTypeID: examType.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &collected}
+	db.Create(&orderExam)
+	db.Create(&models.Payment{OrderID: order.ID, PaymentDate: start.Add(10 * time.Minute), Amount: 5, PaymentMethod: "efectivo", CreatedBy: admin.ID})
+	db.Create(&models.Invoice{OrderID: order.ID, PatientID: patient.ID, InvoiceDate: start, Subtotal: 5, TotalAmount: 5, CreatedBy: admin.ID})
+
+	value := 150.0
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/results", orderExam.ID), token, []dtos.UpdateResultRequest{{ParameterID: parameter.ID, ValueNumeric: &value}}); resp.Code != http.StatusOK {
+		t.Fatalf("submit results failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/validate", orderExam.ID), token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"}); resp.Code != http.StatusOK {
+		t.Fatalf("validate failed: %d %s", resp.Code, resp.Body.String())
+	}
+
+	type timeline struct {
+		Data []dtos.TimelineEvent `json:"data"`
+		Meta utils.Pagination     `json:"meta"`
+	}
+	fetch := func(query string) timeline {
+		t.Helper()
+		resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/timeline?%s", patient.ID, query), token, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("timeline failed: %d %s", resp.Code, resp.Body.String())
+		}
+		var result timeline
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result
+	}
+
+	// Más recientes primero: validación, factura, muestra, pago y orden
+	result := fetch("")
+	types := []string{}
+	for _, event := range result.Data {
+		types = append(types, event.Type)
+	}
+	expected := []string{dtos.TimelineExamValidated, dtos.TimelineInvoice, dtos.TimelineSampleCollected, dtos.TimelinePayment, dtos.TimelineOrderCreated}
+	if result.Meta.Total != 5 || strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected timeline: %v (%+v)", types, result.Meta)
+	}
+	validated := result.Data[0]
+	if !validated.HasAbnormal || len(validated.Results) != 1 || validated.Results[0].Flags != "H" || validated.Results[0].Unit != "mg/dL" || validated.ExamName != "Glicemia" || validated.OrderNumber != order.OrderNumber {
+		t.Fatalf("unexpected validation event: %+v", validated)
+	}
+
+	// Orden ascendente, filtro por tipo y paginación
+	result = fetch("order=asc&limit=2&page=2")
+	if len(result.Data) != 2 || result.Data[0].Type != dtos.TimelineSampleCollected || result.Meta.TotalPages != 3 {
+		t.Fatalf("unexpected page: %+v", result)
+	}
+	result = fetch("types=payment,invoice")
+	if len(result.Data) != 2 || result.Meta.Total != 2 {
+		t.Fatalf("unexpected filtered timeline: %+v", result)
+	}
+
+	// Una página cuyo desplazamiento desbordaría se rechaza en lugar de fallar
+	if resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/timeline?page=288230376151711745&limit=50", patient.ID), token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for out of range page, got %d", resp.Code)
+	}
+
+	// Sin payments:read (como el bioanalista) no se ven pagos ni facturas
+	bioRole := models.Role{Name: "bioanalista", Permissions: models.Permissions{"orders": {"read"}, "results": {"read", "write", "validate"}, "patients": {"read"}, "exams": {"read"}}, IsActive: true}
+	db.Create(&bioRole)
+	db.Create(&models.User{Username: "bio", Email: "bio@test.com", Password: "Bio12345!", FullName: "Bio", RoleID: bioRole.ID, IsActive: true})
+	token = getToken(t, r, "bio", "Bio12345!")
+	result = fetch("")
+	for _, event := range result.Data {
+		if event.Type == dtos.TimelinePayment || event.Type == dtos.TimelineInvoice {
+			t.Fatalf("unexpected billing event for bioanalista: %+v", event)
+		}
+	}
+	if result.Meta.Total != 3 {
+		t.Fatalf("expected 3 clinical events, got %+v", result.Meta)
+	}
+
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients/999/timeline", token, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown patient, got %d", resp.Code)
+	}
+}
+
+func TestPatientCumulativeResults(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	category := models.ExamCategory{Name: "Quimica", Code: "QS"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Suero"}
+	db.Create(&sample)
+	glucoseExam := models.ExamType{Code: "GLU", Name: "Glicemia", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 5}
+	db.Create(&glucoseExam)
+	lipidExam := models.ExamType{Code: "COL", Name: "Colesterol", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 8}
+	db.Create(&lipidExam)
+	min, max := 70.0, 110.0
+	glucose := models.ExamParameter{ExamTypeID: glucoseExam.ID, ParameterName: "Glucosa", UnitOfMeasure: "mg/dL", DataType: "numeric", ReferenceMin: &min, ReferenceMax: &max}
+	db.Create(&glucose)
+	cholesterol := models.ExamParameter{ExamTypeID: lipidExam.ID, ParameterName: "Colesterol total", UnitOfMeasure: "mg/dL", DataType: "numeric"}
+	db.Create(&cholesterol)
+	patient := models.Patient{DocumentType: "cedula", DocumentNumber: "V-11111111", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&patient)
+
+	// Dos órdenes: la más antigua solo con glicemia y la reciente con ambos exámenes
+	validate := func(orderExamID uint, parameterID uint, value float64) {
+		t.Helper()
+		if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/results", orderExamID), token, []dtos.UpdateResultRequest{{ParameterID: parameterID, ValueNumeric: &value}}); resp.Code != http.StatusOK {
+			t.Fatalf("submit results failed: %d %s", resp.Code, resp.Body.String())
+		}
+		if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/validate", orderExamID), token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"}); resp.Code != http.StatusOK {
+			t.Fatalf("validate failed: %d %s", resp.Code, resp.Body.String())
+		}
+	}
+	older := time.Now().AddDate(0, 0, -30)
+	newer := time.Now().AddDate(0, 0, -1)
+	firstOrder := models.Order{PatientID: patient.ID, OrderDate: older, TotalAmount: 5, CreatedBy: admin.ID}
+	db.Create(&firstOrder)
+	firstGlucose := models.OrderExam{OrderID: firstOrder.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &older}
+	db.Create(&firstGlucose)
+	validate(firstGlucose.ID, glucose.ID, 150)
+
+	secondOrder := models.Order{PatientID: patient.ID, OrderDate: newer, TotalAmount: 13, CreatedBy: admin.ID}
+	db.Create(&secondOrder)
+	secondGlucose := models.OrderExam{OrderID: secondOrder.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &newer}
+	db.Create(&secondGlucose)
+	validate(secondGlucose.ID, glucose.ID, 95)
+	secondLipid := models.OrderExam{OrderID: secondOrder.ID, ExamTypeID: lipidExam.ID, Price: 8, Status: "muestra_tomada", SampleCollectedAt: &newer}
+	db.Create(&secondLipid)
+	validate(secondLipid.ID, cholesterol.ID, 180)
+	// La glicemia repetida en la misma orden no reemplaza a la primera
+	repeatedGlucose := models.OrderExam{OrderID: secondOrder.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &newer}
+	db.Create(&repeatedGlucose)
+	validate(repeatedGlucose.ID, glucose.ID, 101)
+
+	// Un resultado sin validar no aparece en el acumulado
+	pending := models.OrderExam{OrderID: secondOrder.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &newer}
+	db.Create(&pending)
+	pendingValue := 300.0
+	db.Create(&models.ExamResult{OrderExamID: pending.ID, ExamParameterID: glucose.ID, ValueNumeric: &pendingValue, IsCurrent: true, EnteredBy: admin.ID})
+
+	var cumulative struct {
+		Data dtos.CumulativeResultsResponse `json:"data"`
+	}
+	resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/results/cumulative", patient.ID), token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("cumulative failed: %d %s", resp.Code, resp.Body.String())
+	}
+	json.Unmarshal(resp.Body.Bytes(), &cumulative)
+	columns, rows := cumulative.Data.Columns, cumulative.Data.Rows
+	if len(columns) != 3 || columns[0].Date != older.Format("2006-01-02") || columns[0].OrderIDs[0] != firstOrder.ID ||
+		columns[1].Date != newer.Format("2006-01-02") || columns[2].Date != columns[1].Date || columns[2].OrderIDs[0] != secondOrder.ID {
+		t.Fatalf("unexpected columns: %+v", columns)
+	}
+	if len(rows) != 2 || rows[0].Parameter != "Colesterol total" || rows[1].Parameter != "Glucosa" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+	if rows[0].Values[0] != nil || rows[0].Values[1] == nil || rows[0].Values[1].Value != "180.00" || rows[0].Values[2] != nil {
+		t.Fatalf("unexpected cholesterol values: %+v", rows[0].Values)
+	}
+	if rows[1].Values[2] == nil || rows[1].Values[2].OrderExamID != repeatedGlucose.ID || rows[1].Values[2].Value != "101.00" {
+		t.Fatalf("expected repeated glucose in its own column: %+v", rows[1].Values)
+	}
+	if rows[1].Values[0] == nil || rows[1].Values[0].Flags != "H" || rows[1].Values[1] == nil || rows[1].Values[1].IsAbnormal || rows[1].Unit != "mg/dL" || *rows[1].ReferenceMax != 110 {
+		t.Fatalf("unexpected glucose row: %+v", rows[1])
+	}
+
+	// Filtro por examen y por fecha
+	resp = doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/results/cumulative?exam_type_id=%d&from=%s", patient.ID, glucoseExam.ID, newer.Format("2006-01-02")), token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &cumulative)
+	if len(cumulative.Data.Columns) != 2 || len(cumulative.Data.Rows) != 1 || cumulative.Data.Rows[0].Values[0].Value != "95.00" || cumulative.Data.Rows[0].Values[1].Value != "101.00" {
+		t.Fatalf("unexpected filtered cumulative: %+v", cumulative.Data)
+	}
+	if resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/results/cumulative?from=ayer", patient.ID), token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid date, got %d", resp.Code)
+	}
+
+	// Serie del parámetro, de la más antigua a la más reciente
+	var series struct {
+		Data dtos.ParameterSeriesResponse `json:"data"`
+	}
+	resp = doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/results/parameters/%d/series", patient.ID, glucose.ID), token, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("series failed: %d %s", resp.Code, resp.Body.String())
+	}
+	json.Unmarshal(resp.Body.Bytes(), &series)
+	points := series.Data.Points
+	if len(points) != 3 || *points[0].ValueNumeric != 150 || *points[1].ValueNumeric != 95 || series.Data.Unit != "mg/dL" || *series.Data.ReferenceMin != 70 || series.Data.ExamName != "Glicemia" {
+		t.Fatalf("unexpected series: %+v", series.Data)
+	}
+
+	if resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/results/parameters/999/series", patient.ID), token, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown parameter, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, "/api/v1/patients/999/results/cumulative", token, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown patient, got %d", resp.Code)
+	}
+}
+
+func TestPatientGuardians(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	category := models.ExamCategory{Name: "Hematologia", Code: "HEM"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Sangre"}
+	db.Create(&sample)
+	examType := models.ExamType{Code: "HB", Name: "Hemoglobina", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 10}
+	db.Create(&examType)
+	mother := models.Patient{DocumentType: "cedula", DocumentNumber: "V-12345678", FirstName: "Carmen", LastName: "Rojas", DateOfBirth: time.Date(1988, 3, 4, 0, 0, 0, 0, time.UTC), Phone: "0414-5551234", Address: "Av. Bolivar", City: "Valencia", Country: "Venezuela", CreatedBy: admin.ID}
+	db.Create(&mother)
+
+	// Dos hijos registrados bajo el documento de la madre, con secuencia
+	type dependentResponse struct {
+		Data dtos.DependentResponse `json:"data"`
+	}
+	register := func(name string) dtos.DependentResponse {
+		t.Helper()
+		resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/patients/%d/dependents", mother.ID), token, dtos.CreateDependentRequest{
+			FirstName: name, LastName: "Rojas", DateOfBirth: time.Now().AddDate(-4, 0, 0), Relationship: models.GuardianRelationshipMother,
+		})
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("register dependent failed: %d %s", resp.Code, resp.Body.String())
+		}
+		var created dependentResponse
+		json.Unmarshal(resp.Body.Bytes(), &created)
+		return created.Data
+	}
+	first := register("Pedro")
+	second := register("Lucia")
+	if first.Patient.DocumentType != "otro" || first.Patient.DocumentNumber != "V-12345678-01" || second.Patient.DocumentNumber != "V-12345678-02" {
+		t.Fatalf("unexpected dependent documents: %+v %+v", first.Patient, second.Patient)
+	}
+	if first.Patient.City != "Valencia" || !first.Guardian.IsPrimary || first.Guardian.FullName != "Carmen Rojas" || first.Guardian.DocumentNumber != "V-12345678" {
+		t.Fatalf("unexpected dependent: %+v", first)
+	}
+
+	var dependents struct {
+		Data []dtos.DependentResponse `json:"data"`
+	}
+	resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/dependents", mother.ID), token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &dependents)
+	if len(dependents.Data) != 2 || dependents.Data[1].Patient.FirstName != "Lucia" {
+		t.Fatalf("unexpected dependents: %s", resp.Body.String())
+	}
+
+	// Un menor no puede ser representante; un contacto sin registro sí
+	childPath := fmt.Sprintf("/api/v1/patients/%d/guardians", first.Patient.ID)
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/patients/%d/dependents", second.Patient.ID), token, dtos.CreateDependentRequest{FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Now().AddDate(-1, 0, 0), Relationship: "otro"}); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "GUARDIAN_MINOR") {
+		t.Fatalf("expected minor guardian to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, childPath, token, dtos.AddGuardianRequest{GuardianPatientID: &mother.ID, Relationship: "madre"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected duplicate guardian to be rejected, got %d", resp.Code)
+	}
+	noResults := false
+	resp = doJSON(r, http.MethodPost, childPath, token, dtos.AddGuardianRequest{FullName: "Jose Rojas", DocumentType: "cedula", DocumentNumber: "9.876.543", Relationship: "padre", IsPrimary: true, CanReceiveResults: &noResults})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("add contact guardian failed: %d %s", resp.Code, resp.Body.String())
+	}
+	var father struct {
+		Data models.PatientGuardianResponse `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &father)
+	if father.Data.DocumentNumber != "V-9876543" || !father.Data.IsPrimary || father.Data.CanReceiveResults {
+		t.Fatalf("unexpected contact guardian: %+v", father.Data)
+	}
+
+	var guardians struct {
+		Data []models.PatientGuardianResponse `json:"data"`
+	}
+	resp = doJSON(r, http.MethodGet, childPath, token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &guardians)
+	if len(guardians.Data) != 2 || guardians.Data[0].ID != father.Data.ID || guardians.Data[1].IsPrimary {
+		t.Fatalf("unexpected guardians: %+v", guardians.Data)
+	}
+
+	// La orden registra al representante principal (o al indicado) y la factura lo hereda
+	var created struct {
+		Data models.Order `json:"data"`
+	}
+	order := dtos.CreateOrderRequest{PatientID: first.Patient.ID, Priority: "normal", Exams: []dtos.OrderExamRequest{{ExamTypeID: examType.ID, Price: 10}}}
+	resp = doJSON(r, http.MethodPost, "/api/v1/orders", token, order)
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if resp.Code != http.StatusCreated || created.Data.GuardianName != "Jose Rojas" || created.Data.GuardianDocument != "cedula V-9876543" {
+		t.Fatalf("unexpected order guardian: %d %s", resp.Code, resp.Body.String())
+	}
+	order.GuardianID = &first.Guardian.ID
+	resp = doJSON(r, http.MethodPost, "/api/v1/orders", token, order)
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if created.Data.GuardianID == nil || *created.Data.GuardianID != first.Guardian.ID || created.Data.GuardianName != "Carmen Rojas" {
+		t.Fatalf("unexpected explicit order guardian: %s", resp.Body.String())
+	}
+	order.GuardianID = &second.Guardian.ID
+	if resp := doJSON(r, http.MethodPost, "/api/v1/orders", token, order); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected guardian of another patient to be rejected, got %d", resp.Code)
+	}
+	invoice := models.Invoice{OrderID: created.Data.ID, PatientID: first.Patient.ID, InvoiceDate: time.Now(), Subtotal: 10, TotalAmount: 10, CreatedBy: admin.ID}
+	db.Create(&invoice)
+	if invoice.GuardianID == nil || invoice.GuardianName != "Carmen Rojas" || invoice.GuardianDocument != "cedula V-12345678" {
+		t.Fatalf("unexpected invoice guardian: %+v", invoice)
+	}
+
+	// Solo los representantes autorizados aparecen en la entrega de resultados
+	var orderExam models.OrderExam
+	db.Where("order_id = ?", created.Data.ID).First(&orderExam)
+	var detail struct {
+		Data dtos.OrderExamDetailResponse `json:"data"`
+	}
+	resp = doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/lab/exams/%d", orderExam.ID), token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &detail)
+	if len(detail.Data.Guardians) != 1 || detail.Data.Guardians[0].FullName != "Carmen Rojas" || detail.Data.Guardians[0].Phone != "0414-5551234" {
+		t.Fatalf("unexpected result recipients: %+v", detail.Data.Guardians)
+	}
+
+	// Al quitar al principal, el siguiente pasa a serlo; sin representantes el menor no admite órdenes
+	if resp := doJSON(r, http.MethodDelete, fmt.Sprintf("%s/%d", childPath, father.Data.ID), token, nil); resp.Code != http.StatusOK {
+		t.Fatalf("remove guardian failed: %d", resp.Code)
+	}
+	resp = doJSON(r, http.MethodGet, childPath, token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &guardians)
+	if len(guardians.Data) != 1 || !guardians.Data[0].IsPrimary {
+		t.Fatalf("expected remaining guardian to become primary: %+v", guardians.Data)
+	}
+	doJSON(r, http.MethodDelete, fmt.Sprintf("%s/%d", childPath, first.Guardian.ID), token, nil)
+	order.GuardianID = nil
+	if resp := doJSON(r, http.MethodPost, "/api/v1/orders", token, order); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "GUARDIAN_REQUIRED") {
+		t.Fatalf("expected minor without guardian to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// La secuencia sigue al mayor sufijo asignado aunque haya huecos
+	db.Create(&models.Patient{DocumentType: "otro", DocumentNumber: "V-12345678-05", FirstName: "Mario", LastName: "Rojas", DateOfBirth: time.Now().AddDate(-3, 0, 0), CreatedBy: admin.ID})
+	if third := register("Elena"); third.Patient.DocumentNumber != "V-12345678-06" {
+		t.Fatalf("expected next sequence after the highest suffix, got %s", third.Patient.DocumentNumber)
+	}
+}
+
+func TestPatientConsents(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	category := models.ExamCategory{Name: "Serologia", Code: "SER"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Suero"}
+	db.Create(&sample)
+	hiv := models.ExamType{Code: "VIH", Name: "VIH 1 y 2", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 20, RequiredConsent: models.ConsentHIVTest}
+	db.Create(&hiv)
+	glucose := models.ExamType{Code: "GLU", Name: "Glicemia", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 5}
+	db.Create(&glucose)
+	patient := models.Patient{DocumentType: "cedula", DocumentNumber: "V-11111111", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&patient)
+
+	type templateResponse struct {
+		Data models.ConsentTemplate `json:"data"`
+	}
+	publish := func(request dtos.CreateConsentTemplateRequest) models.ConsentTemplate {
+		t.Helper()
+		resp := doJSON(r, http.MethodPost, "/api/v1/consents/templates", token, request)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("publish template failed: %d %s", resp.Code, resp.Body.String())
+		}
+		var created templateResponse
+		json.Unmarshal(resp.Body.Bytes(), &created)
+		return created.Data
+	}
+	hivV1 := publish(dtos.CreateConsentTemplateRequest{Code: "HIV_TEST", Title: "Prueba de VIH", Body: "Autorizo la prueba de VIH"})
+	if hivV1.Code != models.ConsentHIVTest || hivV1.Version != 1 {
+		t.Fatalf("unexpected template: %+v", hivV1)
+	}
+
+	patientPath := fmt.Sprintf("/api/v1/patients/%d/consents", patient.ID)
+	hivOrder := dtos.CreateOrderRequest{PatientID: patient.ID, Priority: "normal", Exams: []dtos.OrderExamRequest{{ExamTypeID: hiv.ID, Price: 20}}}
+	expectOrder := func(order dtos.CreateOrderRequest, code int, missing string) {
+		t.Helper()
+		resp := doJSON(r, http.MethodPost, "/api/v1/orders", token, order)
+		if resp.Code != code || (missing != "" && !strings.Contains(resp.Body.String(), missing)) {
+			t.Fatalf("expected order %d (%s), got %d %s", code, missing, resp.Code, resp.Body.String())
+		}
+	}
+	state := func() dtos.PatientConsentsResponse {
+		t.Helper()
+		var result struct {
+			Data dtos.PatientConsentsResponse `json:"data"`
+		}
+		resp := doJSON(r, http.MethodGet, patientPath, token, nil)
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result.Data
+	}
+
+	// Sin consentimiento el examen de VIH no se puede ordenar; la glicemia sí
+	expectOrder(hivOrder, http.StatusConflict, "CONSENT_REQUIRED")
+	expectOrder(dtos.CreateOrderRequest{PatientID: patient.ID, Priority: "normal", Exams: []dtos.OrderExamRequest{{ExamTypeID: glucose.ID, Price: 5}}}, http.StatusCreated, "")
+	if states := state().States; len(states) != 1 || states[0].Status != models.ConsentStatusMissing {
+		t.Fatalf("unexpected consent state: %+v", states)
+	}
+
+	var granted struct {
+		Data models.PatientConsent `json:"data"`
+	}
+	resp := doJSON(r, http.MethodPost, patientPath, token, dtos.GrantConsentRequest{TemplateID: hivV1.ID})
+	json.Unmarshal(resp.Body.Bytes(), &granted)
+	if resp.Code != http.StatusCreated || granted.Data.SignedBy != "Ana Rojas" || granted.Data.GrantedBy != admin.ID || granted.Data.Version != 1 {
+		t.Fatalf("grant failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, patientPath, token, dtos.GrantConsentRequest{TemplateID: hivV1.ID}); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "CONSENT_ALREADY_GRANTED") {
+		t.Fatalf("expected duplicate grant to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	// El índice único parcial impide el duplicado aunque se salte la verificación
+	if err := db.Create(&models.PatientConsent{PatientID: patient.ID, TemplateID: hivV1.ID, Code: hivV1.Code, Version: 1, GrantedAt: time.Now(), GrantedBy: admin.ID, SignedBy: "Ana Rojas"}).Error; !utils.IsUniqueViolation(err) {
+		t.Fatalf("expected unique index violation, got %v", err)
+	}
+	expectOrder(hivOrder, http.StatusCreated, "")
+
+	// Una versión sin requires_reconsent mantiene válido el consentimiento anterior
+	publish(dtos.CreateConsentTemplateRequest{Code: models.ConsentHIVTest, Title: "Prueba de VIH", Body: "Texto con correcciones menores"})
+	if states := state().States; states[0].Status != models.ConsentStatusGranted || states[0].CurrentVersion != 2 || states[0].MinimumVersion != 1 {
+		t.Fatalf("unexpected state after minor version: %+v", states[0])
+	}
+	hivV3 := publish(dtos.CreateConsentTemplateRequest{Code: models.ConsentHIVTest, Title: "Prueba de VIH", Body: "Nuevo texto", RequiresReconsent: true})
+	if states := state().States; states[0].Status != models.ConsentStatusOutdated || states[0].TemplateID != hivV3.ID {
+		t.Fatalf("expected outdated consent: %+v", states[0])
+	}
+	expectOrder(hivOrder, http.StatusConflict, "hiv_test")
+	if resp := doJSON(r, http.MethodPost, patientPath, token, dtos.GrantConsentRequest{TemplateID: hivV1.ID}); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "CONSENT_TEMPLATE_OUTDATED") {
+		t.Fatalf("expected outdated template to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	resp = doJSON(r, http.MethodPost, patientPath, token, dtos.GrantConsentRequest{TemplateID: hivV3.ID})
+	json.Unmarshal(resp.Body.Bytes(), &granted)
+	expectOrder(hivOrder, http.StatusCreated, "")
+
+	// La revocación queda en el historial y bloquea nuevas órdenes
+	revokePath := fmt.Sprintf("%s/%d/revoke", patientPath, granted.Data.ID)
+	if resp := doJSON(r, http.MethodPost, revokePath, token, dtos.RevokeConsentRequest{Reason: "El paciente retira su autorizacion"}); resp.Code != http.StatusOK {
+		t.Fatalf("revoke failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, revokePath, token, dtos.RevokeConsentRequest{Reason: "Otra vez"}); resp.Code != http.StatusConflict {
+		t.Fatalf("expected second revoke to be rejected, got %d", resp.Code)
+	}
+	current := state()
+	if current.States[0].Status != models.ConsentStatusRevoked || len(current.History) != 2 || current.History[0].RevokedBy == nil || *current.History[0].RevokedBy != admin.ID {
+		t.Fatalf("unexpected state after revoke: %+v", current)
+	}
+	expectOrder(hivOrder, http.StatusConflict, "CONSENT_REQUIRED")
+
+	// Un consentimiento obligatorio para toda orden aplica a cualquier examen
+	dataV1 := publish(dtos.CreateConsentTemplateRequest{Code: models.ConsentDataProcessing, Title: "Tratamiento de datos", Body: "Autorizo el tratamiento de mis datos", RequiredForOrders: true})
+	glucoseOrder := dtos.CreateOrderRequest{PatientID: patient.ID, Priority: "normal", Exams: []dtos.OrderExamRequest{{ExamTypeID: glucose.ID, Price: 5}}}
+	expectOrder(glucoseOrder, http.StatusConflict, models.ConsentDataProcessing)
+	doJSON(r, http.MethodPost, patientPath, token, dtos.GrantConsentRequest{TemplateID: dataV1.ID})
+	expectOrder(glucoseOrder, http.StatusCreated, "")
+
+	// Por un menor firma su representante
+	child := models.Patient{DocumentType: "otro", DocumentNumber: "V-11111111-01", FirstName: "Pedro", LastName: "Rojas", DateOfBirth: time.Now().AddDate(-5, 0, 0), CreatedBy: admin.ID}
+	db.Create(&child)
+	childPath := fmt.Sprintf("/api/v1/patients/%d/consents", child.ID)
+	if resp := doJSON(r, http.MethodPost, childPath, token, dtos.GrantConsentRequest{TemplateID: dataV1.ID}); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "GUARDIAN_REQUIRED") {
+		t.Fatalf("expected guardian to be required, got %d %s", resp.Code, resp.Body.String())
+	}
+	db.Create(&models.PatientGuardian{PatientID: child.ID, GuardianPatientID: &patient.ID, Relationship: models.GuardianRelationshipMother, IsPrimary: true, CreatedBy: admin.ID})
+	resp = doJSON(r, http.MethodPost, childPath, token, dtos.GrantConsentRequest{TemplateID: dataV1.ID})
+	json.Unmarshal(resp.Body.Bytes(), &granted)
+	if resp.Code != http.StatusCreated || granted.Data.SignedBy != "Ana Rojas" || granted.Data.GuardianID == nil {
+		t.Fatalf("unexpected guardian consent: %d %s", resp.Code, resp.Body.String())
+	}
+
+	var versions struct {
+		Data []models.ConsentTemplate `json:"data"`
+	}
+	resp = doJSON(r, http.MethodGet, "/api/v1/consents/templates?code="+models.ConsentHIVTest, token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &versions)
+	if len(versions.Data) != 3 || versions.Data[0].Version != 3 {
+		t.Fatalf("unexpected template versions: %+v", versions.Data)
+	}
+	resp = doJSON(r, http.MethodGet, "/api/v1/consents/templates", token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &versions)
+	if len(versions.Data) != 2 || versions.Data[0].Code != models.ConsentDataProcessing || versions.Data[1].Version != 3 {
+		t.Fatalf("unexpected current templates: %+v", versions.Data)
+	}
+
+	// Al fusionar, el consentimiento vigente repetido del duplicado se elimina de forma
+	// lógica (y se traslada como historial) sin chocar con el índice único
+	duplicate := models.Patient{DocumentType: "pasaporte", DocumentNumber: "P123456", FirstName: "Ana", LastName: "Rojas", DateOfBirth: patient.DateOfBirth, CreatedBy: admin.ID}
+	db.Create(&duplicate)
+	duplicatePath := fmt.Sprintf("/api/v1/patients/%d/consents", duplicate.ID)
+	var repeated, moved struct {
+		Data models.PatientConsent `json:"data"`
+	}
+	json.Unmarshal(doJSON(r, http.MethodPost, duplicatePath, token, dtos.GrantConsentRequest{TemplateID: dataV1.ID}).Body.Bytes(), &repeated)
+	json.Unmarshal(doJSON(r, http.MethodPost, duplicatePath, token, dtos.GrantConsentRequest{TemplateID: hivV3.ID}).Body.Bytes(), &moved)
+	var merged struct {
+		Data dtos.MergePatientResponse `json:"data"`
+	}
+	resp = doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/patients/%d/merge", patient.ID), token, dtos.MergePatientRequest{DuplicateID: duplicate.ID, Reason: "Registro duplicado"})
+	json.Unmarshal(resp.Body.Bytes(), &merged)
+	if resp.Code != http.StatusOK || len(merged.Data.RemovedConsents) != 1 || merged.Data.RemovedConsents[0] != repeated.Data.ID ||
+		len(merged.Data.Moved["consents"]) != 2 || merged.Data.Moved["consents"][1] != moved.Data.ID {
+		t.Fatalf("unexpected merge result: %d %s", resp.Code, resp.Body.String())
+	}
+	if states := state().States; states[0].Status != models.ConsentStatusGranted || states[1].Status != models.ConsentStatusGranted {
+		t.Fatalf("unexpected state after merge: %+v", states)
+	}
+}
+
+func TestPatientExport(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	category := models.ExamCategory{Name: "Quimica", Code: "QS"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Suero"}
+	db.Create(&sample)
+	glucoseExam := models.ExamType{Code: "GLU", Name: "Glicemia", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 5}
+	db.Create(&glucoseExam)
+	min, max := 70.0, 110.0
+	glucose := models.ExamParameter{ExamTypeID: glucoseExam.ID, ParameterName: "Glucosa", UnitOfMeasure: "mg/dL", DataType: "numeric", ReferenceMin: &min, ReferenceMax: &max}
+	db.Create(&glucose)
+	patient := models.Patient{DocumentType: "cedula", DocumentNumber: "V-11111111", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&patient)
+
+	collected := time.Now().AddDate(0, 0, -1)
+	order := models.Order{PatientID: patient.ID, OrderDate: collected, TotalAmount: 5, CreatedBy: admin.ID}
+	db.Create(&order)
+	orderExam := models.OrderExam{OrderID: order.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &collected}
+	db.Create(&orderExam)
+	value := 95.0
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/results", orderExam.ID), token, []dtos.UpdateResultRequest{{ParameterID: glucose.ID, ValueNumeric: &value}}); resp.Code != http.StatusOK {
+		t.Fatalf("submit results failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/validate", orderExam.ID), token, dtos.ValidateResultsRequest{Meaning: "validado", Password: "Admin123!"}); resp.Code != http.StatusOK {
+		t.Fatalf("validate failed: %d %s", resp.Code, resp.Body.String())
+	}
+	// Un resultado cargado pero sin validar no se entrega al paciente
+	draftExam := models.OrderExam{OrderID: order.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "muestra_tomada", SampleCollectedAt: &collected}
+	db.Create(&draftExam)
+	draft := 312.0
+	if resp := doJSON(r, http.MethodPost, fmt.Sprintf("/api/v1/lab/exams/%d/results", draftExam.ID), token, []dtos.UpdateResultRequest{{ParameterID: glucose.ID, ValueNumeric: &draft}}); resp.Code != http.StatusOK {
+		t.Fatalf("submit draft results failed: %d %s", resp.Code, resp.Body.String())
+	}
+	db.Create(&models.Payment{OrderID: order.ID, Amount: 5, PaymentMethod: "efectivo", CreatedBy: admin.ID})
+	phone := "0414-5555555"
+	if resp := doJSON(r, http.MethodPatch, fmt.Sprintf("/api/v1/patients/%d", patient.ID), token, dtos.PatchPatientRequest{Phone: &phone}); resp.Code != http.StatusOK {
+		t.Fatalf("patch patient failed: %d %s", resp.Code, resp.Body.String())
+	}
+
+	exportsPath := fmt.Sprintf("/api/v1/patients/%d/exports", patient.ID)
+	var created struct {
+		Data models.PatientExport `json:"data"`
+	}
+	resp := doJSON(r, http.MethodPost, exportsPath, token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if resp.Code != http.StatusCreated || created.Data.Status != models.ExportStatusCompleted || created.Data.Checksum == "" || created.Data.ExpiresAt == nil {
+		t.Fatalf("export failed: %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp = doJSON(r, http.MethodGet, fmt.Sprintf("%s/%d/download", exportsPath, created.Data.ID), token, nil)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("download failed: %d %s", resp.Code, resp.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid archive: %v", err)
+	}
+	files := map[string]string{}
+	for _, file := range archive.File {
+		rc, _ := file.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[file.Name] = string(content)
+	}
+	var bundle dtos.PatientExportBundle
+	if err := json.Unmarshal([]byte(files["paciente.json"]), &bundle); err != nil {
+		t.Fatalf("invalid bundle: %v", err)
+	}
+	if bundle.Patient.Phone != phone || len(bundle.Orders) != 1 || len(bundle.Orders[0].Payments) != 1 {
+		t.Fatalf("unexpected bundle: %+v", bundle)
+	}
+	if exams := bundle.Orders[0].Exams; len(exams) != 2 || len(exams[1].Results) != 0 || strings.Contains(files["paciente.html"], "312") {
+		t.Fatalf("expected unvalidated results to be left out: %+v", exams)
+	}
+	if results := bundle.Orders[0].Exams[0].Results; len(results) != 1 || results[0].Value != "95.00" || results[0].ReferenceRange != "70 - 110" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	actions := map[string]bool{}
+	for _, entry := range bundle.AccessLog {
+		actions[entry.Action] = true
+	}
+	if !actions[models.AuditActionUpdate] || !actions[models.AuditActionExport] {
+		t.Fatalf("unexpected access log: %+v", bundle.AccessLog)
+	}
+	if !strings.Contains(files["paciente.html"], "Ana Rojas") || !strings.Contains(files["paciente.html"], "Glucosa") {
+		t.Fatalf("unexpected html: %s", files["paciente.html"])
+	}
+	var downloads int64
+	db.Model(&models.AuditLog{}).Where("table_name = ? AND record_id = ? AND action = ?", "patient_exports", created.Data.ID, models.AuditActionDownload).Count(&downloads)
+	if downloads != 1 {
+		t.Fatalf("expected download to be audited, got %d", downloads)
+	}
+
+	// Las exportaciones pendientes o vencidas no se pueden descargar
+	pending := models.PatientExport{PatientID: patient.ID, Status: models.ExportStatusProcessing, RequestedBy: admin.ID}
+	db.Create(&pending)
+	if resp := doJSON(r, http.MethodGet, fmt.Sprintf("%s/%d/download", exportsPath, pending.ID), token, nil); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "EXPORT_NOT_READY") {
+		t.Fatalf("expected pending export to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	expired := time.Now().Add(-time.Hour)
+	db.Model(&models.PatientExport{}).Where("id = ?", created.Data.ID).Update("expires_at", expired)
+	if resp := doJSON(r, http.MethodGet, fmt.Sprintf("%s/%d/download", exportsPath, created.Data.ID), token, nil); resp.Code != http.StatusGone {
+		t.Fatalf("expected expired export to be rejected, got %d %s", resp.Code, resp.Body.String())
+	}
+	var purged models.PatientExport
+	db.First(&purged, created.Data.ID)
+	if purged.Archive != nil {
+		t.Fatal("expected expired archive to be purged")
+	}
+	if resp := doJSON(r, http.MethodGet, fmt.Sprintf("/api/v1/patients/%d/exports/%d", patient.ID+1, created.Data.ID), token, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected export of another patient to be hidden, got %d", resp.Code)
+	}
+
+	// Con historiales grandes la exportación se genera en segundo plano
+	exportSyncOrderLimit = 0
+	defer func() { exportSyncOrderLimit = 50 }()
+	resp = doJSON(r, http.MethodPost, exportsPath, token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("expected async export, got %d %s", resp.Code, resp.Body.String())
+	}
+	var status struct {
+		Data models.PatientExport `json:"data"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := doJSON(r, http.MethodGet, fmt.Sprintf("%s/%d", exportsPath, created.Data.ID), token, nil)
+		json.Unmarshal(resp.Body.Bytes(), &status)
+		if status.Data.Status == models.ExportStatusCompleted || status.Data.Status == models.ExportStatusFailed || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if status.Data.Status != models.ExportStatusCompleted {
+		t.Fatalf("async export did not complete: %+v", status.Data)
+	}
+
+	// Al reiniciar el servidor se retoman las exportaciones interrumpidas
+	StartPatientExportJobs(db)
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		db.Omit("archive").First(&pending, pending.ID)
+		if pending.Status == models.ExportStatusCompleted || pending.Status == models.ExportStatusFailed || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if pending.Status != models.ExportStatusCompleted {
+		t.Fatalf("interrupted export was not resumed: %+v", pending)
+	}
+
+	var list struct {
+		Data []models.PatientExport `json:"data"`
+	}
+	resp = doJSON(r, http.MethodGet, exportsPath, token, nil)
+	json.Unmarshal(resp.Body.Bytes(), &list)
+	if len(list.Data) != 3 {
+		t.Fatalf("expected 3 exports, got %d", len(list.Data))
+	}
+}
+
+func TestResearchResultsExport(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	category := models.ExamCategory{Name: "Quimica", Code: "QS"}
+	db.Create(&category)
+	sample := models.SampleType{Name: "Suero"}
+	db.Create(&sample)
+	glucoseExam := models.ExamType{Code: "GLU", Name: "Glicemia", CategoryID: category.ID, SampleTypeID: sample.ID, BasePrice: 5}
+	db.Create(&glucoseExam)
+	min, max := 70.0, 110.0
+	glucose := models.ExamParameter{ExamTypeID: glucoseExam.ID, ParameterName: "Glucosa", ParameterCode: "GLU", UnitOfMeasure: "mg/dL", DataType: "numeric", ReferenceMin: &min, ReferenceMax: &max}
+	db.Create(&glucose)
+
+	// Tres mujeres de 30 a 39 años y un hombre de 60 a 69
+	collected := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	people := []struct {
+		document, name, gender string
+		birthYear              int
+		value                  float64
+	}{
+		{"V-10000001", "Ana", "F", 1990, 85},
+		{"V-10000002", "Beatriz", "F", 1988, 120},
+		{"V-10000003", "Carla", "F", 1992, 95},
+		{"V-10000004", "Daniel", "M", 1960, 100},
+	}
+	var patients []models.Patient
+	for _, person := range people {
+		patient := models.Patient{DocumentType: "cedula", DocumentNumber: person.document, FirstName: person.name, LastName: "Rojas", Gender: person.gender, Phone: "0414-5555555", DateOfBirth: time.Date(person.birthYear, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+		db.Create(&patient)
+		patients = append(patients, patient)
+		order := models.Order{PatientID: patient.ID, OrderDate: collected, TotalAmount: 5, CreatedBy: admin.ID}
+		db.Create(&order)
+		orderExam := models.OrderExam{OrderID: order.ID, ExamTypeID: glucoseExam.ID, Price: 5, Status: "validado", SampleCollectedAt: &collected}
+		db.Create(&orderExam)
+		value := person.value
+		db.Create(&models.ExamResult{OrderExamID: orderExam.ID, ExamParameterID: glucose.ID, ValueNumeric: &value, EnteredBy: admin.ID, ValidatedBy: &admin.ID, ValidatedAt: &collected})
+	}
+
+	path := "/api/v1/research/results"
+	if resp := doJSON(r, http.MethodGet, path, token, nil); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected export without key to be unavailable, got %d", resp.Code)
+	}
+	os.Setenv("RESEARCH_PSEUDONYM_KEY", "clave-de-investigacion")
+	defer os.Unsetenv("RESEARCH_PSEUDONYM_KEY")
+	os.Setenv("RESEARCH_MIN_K", "2")
+	defer os.Unsetenv("RESEARCH_MIN_K")
+
+	// El hombre queda solo en su combinación de cuasi-identificadores
+	resp := doJSON(r, http.MethodGet, path+"?k=3", token, nil)
+	if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "K_ANONYMITY_NOT_MET") || !strings.Contains(resp.Body.String(), `"age_band":"60-69"`) {
+		t.Fatalf("expected k-anonymity to be enforced, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(r, http.MethodGet, path+"?k=1", token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected k below RESEARCH_MIN_K to be rejected, got %d", resp.Code)
+	}
+	if resp := doJSON(r, http.MethodGet, path+"?exam_type_id=abc", token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid exam_type_id to be rejected, got %d", resp.Code)
+	}
+
+	resp = doJSON(r, http.MethodGet, path+"?k=3&suppress=true", token, nil)
+	if resp.Code != http.StatusOK || resp.Header().Get("X-Suppressed-Rows") != "1" {
+		t.Fatalf("suppressed export failed: %d %s", resp.Code, resp.Body.String())
+	}
+	body := resp.Body.String()
+	for _, identifier := range []string{"Ana", "Rojas", "V-10000001", "0414", "1990"} {
+		if strings.Contains(body, identifier) {
+			t.Fatalf("export contains identifier %q: %s", identifier, body)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "subject,age_band,gender") {
+		t.Fatalf("unexpected csv: %s", body)
+	}
+	expected := utils.Pseudonym([]byte("clave-de-investigacion"), patients[0].ID) + ",30-39,F,,2025-03,GLU,Glicemia,Quimica,GLU,Glucosa,numeric,mg/dL,85,,70,110,,,false,false"
+	if lines[1] != expected {
+		t.Fatalf("unexpected row:\n%s\nexpected:\n%s", lines[1], expected)
+	}
+
+	// NDJSON por semana
+	resp = doJSON(r, http.MethodGet, path+"?format=ndjson&date_precision=week&suppress=true&k=3", token, nil)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("ndjson export failed: %d %s", resp.Code, resp.Body.String())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(resp.Body.String()), "\n") {
+		var row dtos.ResearchResultRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", line, err)
+		}
+		if row.SampleDate != "2025-W11" || row.AgeBand != "30-39" {
+			t.Fatalf("unexpected ndjson row: %+v", row)
+		}
+	}
+
+	// El día exacto solo se publica desplazado y también cuenta para k: cada paciente
+	// queda en su propia fecha
+	if resp := doJSON(r, http.MethodGet, path+"?date_precision=day&suppress=true&k=3", token, nil); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected exact dates without shift to be rejected, got %d", resp.Code)
+	}
+	resp = doJSON(r, http.MethodGet, path+"?date_precision=day&date_shift_days=30&k=3", token, nil)
+	if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), `"age_band":"30-39"`) || !strings.Contains(resp.Body.String(), `"sample_date":"2025-`) {
+		t.Fatalf("expected shifted dates to be part of the classes, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Las exportaciones demasiado grandes se rechazan
+	os.Setenv("RESEARCH_MAX_ROWS", "3")
+	defer os.Unsetenv("RESEARCH_MAX_ROWS")
+	if resp := doJSON(r, http.MethodGet, path+"?k=3&suppress=true", token, nil); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "RESEARCH_TOO_MANY_ROWS") {
+		t.Fatalf("expected row limit, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	var exports int64
+	db.Model(&models.AuditLog{}).Where("table_name = ? AND action = ?", "exam_results", models.AuditActionExport).Count(&exports)
+	if exports != 2 {
+		t.Fatalf("expected 2 audited exports, got %d", exports)
+	}
+}
+
+func TestApplyBuiltinPermissionGrants(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Un rol creado por una versión anterior, sin validar resultados ni ver el catálogo
+	legacy := models.Role{Name: "bioanalista", Permissions: models.Permissions{"orders": {"read"}, "results": {"read", "write"}, "payments": {"read"}}, IsActive: true}
+	db.Create(&legacy)
+
+	if err := migrations.ApplyBuiltinPermissionGrants(db); err != nil {
+		t.Fatalf("apply grants: %v", err)
+	}
+	var updated models.Role
+	db.First(&updated, legacy.ID)
+	for _, scope := range [][2]string{{"results", "validate"}, {"exams", "read"}, {"payments", "read"}} {
+		if !updated.Permissions.Allows(scope[0], scope[1]) {
+			t.Fatalf("expected %s:%s after applying grants, got %v", scope[0], scope[1], updated.Permissions)
+		}
+	}
+
+	// Un permiso que el administrador quita no vuelve en el siguiente despliegue
+	delete(updated.Permissions, "exams")
+	db.Model(&updated).Update("permissions", updated.Permissions)
+	if err := migrations.ApplyBuiltinPermissionGrants(db); err != nil {
+		t.Fatalf("apply grants again: %v", err)
+	}
+	db.First(&updated, legacy.ID)
+	if updated.Permissions.Allows("exams", "read") || len(updated.Permissions["results"]) != 3 {
+		t.Fatalf("expected removed permission to stay removed, got %v", updated.Permissions)
+	}
+	var applied int64
+	db.Model(&models.RolePermissionGrant{}).Count(&applied)
+	if applied != 4 {
+		t.Fatalf("expected every grant to be recorded once, got %d", applied)
+	}
+}
+
+func TestEnforceDefaultAdminPasswordChange(t *testing.T) {
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+
+	// Un admin sembrado por una versión anterior conserva la contraseña por defecto
+	if err := migrations.EnforceDefaultAdminPasswordChange(db); err != nil {
+		t.Fatalf("enforce password change: %v", err)
+	}
+	var reloaded models.User
+	db.First(&reloaded, admin.ID)
+	if !reloaded.MustChangePassword || !reloaded.CheckPassword("Admin123!") {
+		t.Fatalf("expected admin with default password to be forced to change it")
+	}
+
+	// Con una contraseña propia no se vuelve a exigir el cambio
+	db.Model(&reloaded).Updates(map[string]interface{}{"must_change_password": false})
+	reloaded.Password = "Otra-Clave-2024"
+	db.Save(&reloaded)
+	if err := migrations.EnforceDefaultAdminPasswordChange(db); err != nil {
+		t.Fatalf("enforce password change again: %v", err)
+	}
+	var changed models.User
+	db.First(&changed, admin.ID)
+	if changed.MustChangePassword {
+		t.Fatalf("expected admin with a custom password to be left alone")
+	}
+}
+
+func TestBackfillPatientDocuments(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test_secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	db := setupTestDB(t)
+	admin := seedAuthData(t, db)
+	r := setupRouter()
+	token := getToken(t, r, "admin", "Admin123!")
+
+	// Una base anterior al índice, con documentos sin normalizar y un duplicado
+	db.Exec("DROP INDEX idx_patients_document")
+	legacy := models.Patient{DocumentType: "cedula", DocumentNumber: "12.345.678", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&legacy)
+	duplicate := models.Patient{DocumentType: "cedula", DocumentNumber: "v-12345678", FirstName: "Ana", LastName: "Rojas", DateOfBirth: time.Date(1985, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&duplicate)
+	invalid := models.Patient{DocumentType: "rif", DocumentNumber: "J-1", FirstName: "Empresa", LastName: "Vieja", DateOfBirth: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC), CreatedBy: admin.ID}
+	db.Create(&invalid)
+
+	err := migrations.BackfillPatientDocuments(db)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d,%d", legacy.ID, duplicate.ID)) {
+		t.Fatalf("expected duplicates to be reported, got %v", err)
+	}
+	var stored models.Patient
+	db.First(&stored, legacy.ID)
+	if stored.DocumentNumber != "V-12345678" {
+		t.Fatalf("expected legacy document to be normalized, got %q", stored.DocumentNumber)
+	}
+	var untouched models.Patient
+	db.First(&untouched, invalid.ID)
+	if untouched.DocumentNumber != "J-1" {
+		t.Fatalf("expected invalid document to be left as is, got %q", untouched.DocumentNumber)
+	}
+
+	// Resuelto el duplicado, el índice se crea y la búsqueda encuentra el registro antiguo
+	db.Delete(&duplicate)
+	if err := migrations.BackfillPatientDocuments(db); err != nil {
+		t.Fatalf("backfill failed: %v", err)
+	}
+	if err := db.Create(&models.Patient{DocumentType: "cedula", DocumentNumber: "V-12345678", FirstName: "Otra", LastName: "Persona", DateOfBirth: time.Now(), CreatedBy: admin.ID}).Error; !utils.IsUniqueViolation(err) {
+		t.Fatalf("expected the unique index to reject duplicates, got %v", err)
+	}
+	resp := doJSON(r, http.MethodGet, "/api/v1/patients/lookup?document_type=cedula&document_number=12345678", token, nil)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), fmt.Sprintf(`"id":%d`, legacy.ID)) {
+		t.Fatalf("expected lookup to find the legacy patient, got %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// GetJWKS publica las claves públicas con las que otros servicios y el cliente de
+// escritorio pueden verificar nuestros tokens sin conocer ningún secreto.
+// Responde el formato estándar JWKS (RFC 7517), sin el envoltorio de utils.Response.
+func GetJWKS(c *gin.Context) {
+	jwks, err := utils.PublicJWKS()
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Claves de firma no disponibles", nil)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
@@ -15,13 +15,13 @@ import (
 
 // GetOrderExamDetails godoc
 // @Summary      Detalle de un examen específico de una orden
-// @Description  Retorna el examen con sus parámetros y resultados previos (si existen)
+// @Description  Retorna el examen con sus parámetros, resultados previos (si existen), el manifiesto de firmas electrónicas y, si el paciente es menor o dependiente, los representantes autorizados a recibir los resultados
 // @Tags         lab
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
 // @Param        id path int true "ID del examen dentro de la orden"
-// @Success      200 {object} utils.Response{data=models.OrderExam}
+// @Success      200 {object} utils.Response{data=dtos.OrderExamDetailResponse}
 // @Failure      404 {object} utils.Response{errors=string}
 // @Router       /lab/exams/{id} [get]
 func GetOrderExamDetails(c *gin.Context) {
@@ -37,7 +37,17 @@ func GetOrderExamDetails(c *gin.Context) {
 		return
 	}
 
-	utils.Success(c, http.StatusOK, "Examen obtenido exitosamente", orderExam)
+	var signatures []models.ResultSignature
+	db.Where("order_exam_id = ?", orderExam.ID).Order("signed_at, id").Find(&signatures)
+
+	hash := models.ResultsHash(orderExam.ID, orderExam.Results)
+	utils.Success(c, http.StatusOK, "Examen obtenido exitosamente", dtos.OrderExamDetailResponse{
+		OrderExam:      orderExam,
+		Signatures:     signatures,
+		ResultsHash:    hash,
+		SignatureValid: len(signatures) > 0 && signatures[len(signatures)-1].ResultsHash == hash,
+		Guardians:      resultRecipients(db, orderExam.OrderID),
+	})
 }
 
 // UpdateExamStatus godoc
@@ -84,10 +94,16 @@ func UpdateExamStatus(c *gin.Context) {
 
 // ValidateResults godoc
 // @Summary      Validar resultados de un examen
-// @Description  Marca los resultados como validados y finaliza el examen para su impresión
+// @Description  Firma electrónicamente los resultados vigentes: el validador re-ingresa su contraseña (o un código TOTP) y se registra la firma con su significado y el hash de los valores. Use "validado" en la primera firma y "corregido" si los resultados cambiaron después de firmarse
 // @Tags         lab
 // @Param        id path int true "ID del examen de la orden"
-// @Success      200 {object} utils.Response{data=nil}
+// @Param        request body dtos.ValidateResultsRequest true "Significado y re-autenticación"
+// @Success      200 {object} utils.Response{data=models.ResultSignature}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      401 {object} utils.Response{errors=string} "INVALID_SIGNATURE_CREDENTIALS"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string}
+// @Failure      423 {object} utils.Response{errors=string} "ACCOUNT_LOCKED"
 // @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
 // @Accept       json
 // @Produce      json
@@ -95,30 +111,129 @@ func UpdateExamStatus(c *gin.Context) {
 // @Router       /lab/exams/{id}/validate [post]
 func ValidateResults(c *gin.Context) {
 	id := c.Param("id")
+	var input dtos.ValidateResultsRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
 	userID, _ := c.Get("userID")
 	db := config.GetDB()
 
+	var orderExam models.OrderExam
+	if err := db.Preload("Results", "is_current = ?", true).First(&orderExam, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Examen no encontrado", nil)
+		return
+	}
+	if len(orderExam.Results) == 0 {
+		utils.Error(c, http.StatusBadRequest, "El examen no tiene resultados para validar", nil)
+		return
+	}
+
+	// "validado" solo en la primera firma; "corregido" solo si los valores cambiaron desde la última
+	hash := models.ResultsHash(orderExam.ID, orderExam.Results)
+	var last models.ResultSignature
+	signed := db.Where("order_exam_id = ?", orderExam.ID).Order("signed_at DESC, id DESC").First(&last).Error == nil
+	switch {
+	case signed && last.ResultsHash == hash:
+		utils.Error(c, http.StatusConflict, "Los resultados ya fueron firmados y no han cambiado", nil)
+		return
+	case signed && input.Meaning != models.SignatureMeaningCorrected:
+		utils.Error(c, http.StatusConflict, "Los resultados cambiaron después de la firma: use el significado \"corregido\"", nil)
+		return
+	case !signed && input.Meaning != models.SignatureMeaningValidated:
+		utils.Error(c, http.StatusConflict, "La primera firma debe tener el significado \"validado\"", nil)
+		return
+	}
+
+	var signer models.User
+	if err := db.First(&signer, userID.(uint)).Error; err != nil {
+		utils.Error(c, http.StatusUnauthorized, "Usuario no disponible", nil)
+		return
+	}
+	authMethod, ok := reauthenticateSigner(c, db, &signer, input)
+	if !ok {
+		return
+	}
+
 	now := time.Now()
+	signature := models.ResultSignature{
+		OrderExamID:    orderExam.ID,
+		SignedBy:       signer.ID,
+		SignerName:     signer.FullName,
+		SignerUsername: signer.Username,
+		Meaning:        input.Meaning,
+		AuthMethod:     authMethod,
+		ResultsHash:    hash,
+		ResultCount:    len(orderExam.Results),
+		IPAddress:      c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		SignedAt:       now,
+	}
+
 	err := db.Transaction(func(tx *gorm.DB) error {
-		// 1. Marcar el examen de la orden como completado y validado
-		if err := tx.Model(&models.OrderExam{}).Where("id = ?", id).Updates(map[string]interface{}{
+		if err := tx.Create(&signature).Error; err != nil {
+			return err
+		}
+
+		// Marcar el examen de la orden y sus resultados vigentes como validados
+		if err := tx.Model(&models.OrderExam{}).Where("id = ?", orderExam.ID).Updates(map[string]interface{}{
 			"status":       "completado",
 			"validated_at": &now,
-			"validated_by": userID.(uint),
+			"validated_by": signer.ID,
 		}).Error; err != nil {
 			return err
 		}
-
-		// 2. Opcional: Verificar si todos los exámenes de la ORDEN están listos
-		// Si todos están 'completado', marcar la Orden principal como 'completada'
-		return nil
+		return tx.Model(&models.ExamResult{}).
+			Where("order_exam_id = ? AND is_current = ?", orderExam.ID, true).
+			Updates(map[string]interface{}{"validated_at": &now, "validated_by": signer.ID}).Error
 	})
 
 	if err != nil {
 		utils.Error(c, http.StatusInternalServerError, "Error al validar resultados", err.Error())
 		return
 	}
-	utils.Success(c, http.StatusOK, "Resultados validados exitosamente", nil)
+	utils.Success(c, http.StatusOK, "Resultados validados exitosamente", signature)
+}
+
+// reauthenticateSigner verifica la contraseña o el código TOTP del validador. Los
+// intentos fallidos cuentan para el bloqueo de la cuenta igual que en el login.
+func reauthenticateSigner(c *gin.Context, db *gorm.DB, user *models.User, input dtos.ValidateResultsRequest) (string, bool) {
+	if user.IsLocked() {
+		utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+			"code":         "ACCOUNT_LOCKED",
+			"locked_until": user.LockedUntil,
+		})
+		return "", false
+	}
+
+	method := "password"
+	var ok bool
+	if input.Code != "" && user.TwoFactorEnabled {
+		method = "totp"
+		var err error
+		ok, err = consumeTOTPCode(db, user, input.Code)
+		if err != nil {
+			utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+			return "", false
+		}
+	} else {
+		ok = input.Password != "" && user.CheckPassword(input.Password)
+	}
+
+	if !ok {
+		if err := registerFailedLogin(db, user); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+			return "", false
+		}
+		utils.Error(c, http.StatusUnauthorized, "No se pudo verificar la firma", gin.H{"code": "INVALID_SIGNATURE_CREDENTIALS"})
+		return "", false
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		db.Model(user).Update("failed_login_attempts", 0)
+	}
+	return method, true
 }
 
 // GetExamCatalog godoc
@@ -186,7 +301,22 @@ func SubmitResults(c *gin.Context) {
 				ValueNumeric:    res.ValueNumeric,
 				ValueText:       res.ValueText,
 				EnteredBy:       userID.(uint),
+				Version:         1,
+				IsCurrent:       true,
 			}
+
+			// Un nuevo valor reemplaza al vigente como nueva versión; el anterior se conserva
+			var previous models.ExamResult
+			if err := tx.Where("order_exam_id = ? AND exam_parameter_id = ? AND is_current = ?", orderExamID, res.ParameterID, true).
+				Order("version DESC").First(&previous).Error; err == nil {
+				if err := tx.Model(&models.ExamResult{}).
+					Where("order_exam_id = ? AND exam_parameter_id = ?", orderExamID, res.ParameterID).
+					Update("is_current", false).Error; err != nil {
+					return err
+				}
+				result.Version = previous.Version + 1
+			}
+
 			if err := tx.Create(&result).Error; err != nil {
 				return err
 			}
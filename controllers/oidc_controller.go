@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// oidcStateTTL es el tiempo para completar el inicio de sesión en el proveedor
+const oidcStateTTL = 10 * time.Minute
+
+// oidcLoginError es un rechazo del inicio de sesión SSO con su código de error
+type oidcLoginError struct {
+	status  int
+	message string
+	code    string
+}
+
+func (e *oidcLoginError) Error() string {
+	return e.message
+}
+
+var errOIDCStateUsed = errors.New("estado OIDC ya utilizado")
+
+// StartOIDCLogin godoc
+// @Summary      Iniciar sesión con el proveedor de identidad (SSO)
+// @Description  Genera el estado, el nonce y el desafío PKCE y retorna la URL de autorización del proveedor OIDC. El cliente redirige al usuario a esa URL
+// @Tags         auth
+// @Produce      json
+// @Param        mode query string false "Modo de sesión al completar el login (bearer o cookie)"
+// @Success      200 {object} utils.Response{data=dtos.OIDCLoginResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string} "SSO_DISABLED"
+// @Failure      502 {object} utils.Response{errors=string} "SSO_PROVIDER_ERROR"
+// @Router       /auth/oidc/login [get]
+func StartOIDCLogin(c *gin.Context) {
+	provider, err := utils.CurrentOIDCProvider()
+	if err != nil {
+		utils.Error(c, http.StatusNotFound, "Inicio de sesión SSO no configurado", gin.H{"code": "SSO_DISABLED"})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", utils.AuthModeBearer)
+	if mode != utils.AuthModeBearer && mode != utils.AuthModeCookie {
+		utils.Error(c, http.StatusBadRequest, "Modo de sesión inválido", nil)
+		return
+	}
+
+	startOIDCFlow(c, provider, mode, nil)
+}
+
+// StartOIDCLink godoc
+// @Summary      Vincular la cuenta con el proveedor de identidad
+// @Description  Inicia el flujo OIDC para vincular la cuenta del usuario autenticado con su identidad en el proveedor. Las cuentas existentes solo se vinculan de esta forma, nunca por correo
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} utils.Response{data=dtos.OIDCLoginResponse}
+// @Failure      404 {object} utils.Response{errors=string} "SSO_DISABLED"
+// @Failure      502 {object} utils.Response{errors=string} "SSO_PROVIDER_ERROR"
+// @Router       /me/sso/link [get]
+func StartOIDCLink(c *gin.Context) {
+	provider, err := utils.CurrentOIDCProvider()
+	if err != nil {
+		utils.Error(c, http.StatusNotFound, "Inicio de sesión SSO no configurado", gin.H{"code": "SSO_DISABLED"})
+		return
+	}
+
+	userID := c.GetUint("userID")
+	startOIDCFlow(c, provider, "", &userID)
+}
+
+// startOIDCFlow guarda el estado, el nonce y el verificador PKCE y retorna la URL de
+// autorización. Con linkUserID el estado solo sirve para vincular esa cuenta.
+func startOIDCFlow(c *gin.Context, provider *utils.OIDCProvider, mode string, linkUserID *uint) {
+	state, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+	verifier, err := utils.GenerateOpaqueToken(48)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+	nonce, err := utils.GenerateOpaqueToken(16)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+
+	authorizationURL, err := provider.AuthorizationURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		utils.Error(c, http.StatusBadGateway, "No se pudo contactar al proveedor de identidad", gin.H{"code": "SSO_PROVIDER_ERROR"})
+		return
+	}
+
+	db := config.GetDB()
+	request := models.OIDCAuthRequest{
+		StateHash:    utils.HashToken(state),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		Mode:         mode,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}
+	if err := db.Create(&request).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+	db.Where("expires_at < ?", time.Now()).Delete(&models.OIDCAuthRequest{})
+
+	utils.Success(c, http.StatusOK, "Redirija al usuario al proveedor de identidad", dtos.OIDCLoginResponse{
+		AuthorizationURL: authorizationURL,
+		State:            state,
+		ExpiresIn:        int64(oidcStateTTL.Seconds()),
+	})
+}
+
+// OIDCCallback godoc
+// @Summary      Completar el inicio de sesión SSO
+// @Description  Canjea el código de autorización del proveedor OIDC (con PKCE), valida el ID token, reconoce el usuario vinculado o lo crea según la configuración y emite los tokens de sesión
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body dtos.OIDCCallbackRequest true "Código y estado recibidos del proveedor"
+// @Success      200 {object} utils.Response{data=dtos.LoginResponse} "Con doble factor local activo retorna dtos.TwoFactorChallengeResponse"
+// @Failure      400 {object} utils.Response{errors=string} "INVALID_SSO_STATE"
+// @Failure      401 {object} utils.Response{errors=string} "SSO_LOGIN_FAILED"
+// @Failure      403 {object} utils.Response{errors=string} "SSO_USER_NOT_PROVISIONED, SSO_ROLE_NOT_MAPPED o USER_INACTIVE"
+// @Failure      404 {object} utils.Response{errors=string} "SSO_DISABLED"
+// @Failure      409 {object} utils.Response{errors=string} "SSO_USERNAME_CONFLICT, SSO_LINK_REQUIRED o SSO_LAST_ADMIN"
+// @Failure      423 {object} utils.Response{errors=string} "ACCOUNT_LOCKED"
+// @Router       /auth/oidc/callback [post]
+func OIDCCallback(c *gin.Context) {
+	provider, err := utils.CurrentOIDCProvider()
+	if err != nil {
+		utils.Error(c, http.StatusNotFound, "Inicio de sesión SSO no configurado", gin.H{"code": "SSO_DISABLED"})
+		return
+	}
+
+	verified, ok := redeemOIDCState(c, provider, nil)
+	if !ok {
+		return
+	}
+	completeOIDCLogin(c, config.GetDB(), provider.Config, verified.identity, verified.mode)
+}
+
+// LinkOIDCCallback godoc
+// @Summary      Completar la vinculación con el proveedor de identidad
+// @Description  Canjea el código del flujo iniciado con GET /me/sso/link y vincula la identidad del proveedor con la cuenta del usuario autenticado
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.OIDCCallbackRequest true "Código y estado recibidos del proveedor"
+// @Success      200 {object} utils.Response{data=models.UserIdentity}
+// @Failure      400 {object} utils.Response{errors=string} "INVALID_SSO_STATE"
+// @Failure      401 {object} utils.Response{errors=string} "SSO_LOGIN_FAILED"
+// @Failure      404 {object} utils.Response{errors=string} "SSO_DISABLED"
+// @Failure      409 {object} utils.Response{errors=string} "SSO_IDENTITY_LINKED: la identidad ya pertenece a otro usuario"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /me/sso/link/callback [post]
+func LinkOIDCCallback(c *gin.Context) {
+	provider, err := utils.CurrentOIDCProvider()
+	if err != nil {
+		utils.Error(c, http.StatusNotFound, "Inicio de sesión SSO no configurado", gin.H{"code": "SSO_DISABLED"})
+		return
+	}
+
+	userID := c.GetUint("userID")
+	verified, ok := redeemOIDCState(c, provider, &userID)
+	if !ok {
+		return
+	}
+	identity := verified.identity
+
+	var link models.UserIdentity
+	err = config.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(&link).Error
+		switch {
+		case err == nil:
+			if link.UserID != userID {
+				return &oidcLoginError{http.StatusConflict, "La identidad del proveedor ya está vinculada a otro usuario", "SSO_IDENTITY_LINKED"}
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			link = models.UserIdentity{UserID: userID, Issuer: identity.Issuer, Subject: identity.Subject}
+		default:
+			return err
+		}
+		link.Email = identity.Email
+		return tx.Save(&link).Error
+	})
+	if err != nil {
+		var loginErr *oidcLoginError
+		if errors.As(err, &loginErr) {
+			utils.Error(c, loginErr.status, loginErr.message, gin.H{"code": loginErr.code})
+			return
+		}
+		utils.Error(c, http.StatusInternalServerError, "Error al vincular la cuenta", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Cuenta vinculada con el proveedor de identidad", link)
+}
+
+// verifiedOIDCLogin es la identidad validada junto con el modo de sesión solicitado
+type verifiedOIDCLogin struct {
+	identity *utils.OIDCIdentity
+	mode     string
+}
+
+// redeemOIDCState canjea el estado (una sola vez) y el código del proveedor y valida el
+// ID token. Un estado de vinculación solo se acepta para el mismo usuario que lo inició
+// y uno de inicio de sesión nunca sirve para vincular. Si falla responde y retorna false.
+func redeemOIDCState(c *gin.Context, provider *utils.OIDCProvider, linkUserID *uint) (verifiedOIDCLogin, bool) {
+	var input dtos.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return verifiedOIDCLogin{}, false
+	}
+
+	db := config.GetDB()
+	invalidState := func() (verifiedOIDCLogin, bool) {
+		utils.Error(c, http.StatusBadRequest, "Estado de inicio de sesión inválido o expirado", gin.H{"code": "INVALID_SSO_STATE"})
+		return verifiedOIDCLogin{}, false
+	}
+
+	var request models.OIDCAuthRequest
+	if err := db.Where("state_hash = ?", utils.HashToken(input.State)).First(&request).Error; err != nil || !request.IsUsable() {
+		return invalidState()
+	}
+	if (linkUserID == nil) != (request.LinkUserID == nil) || (linkUserID != nil && *linkUserID != *request.LinkUserID) {
+		return invalidState()
+	}
+
+	// Cada estado se canjea una sola vez (evita reutilizar el código y carreras)
+	now := time.Now()
+	result := db.Model(&models.OIDCAuthRequest{}).Where("id = ? AND used_at IS NULL", request.ID).Update("used_at", &now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return invalidState()
+	}
+
+	rawIDToken, err := provider.Exchange(c.Request.Context(), input.Code, request.CodeVerifier)
+	if err == nil {
+		var identity *utils.OIDCIdentity
+		identity, err = provider.VerifyIDToken(c.Request.Context(), rawIDToken, request.Nonce)
+		if err == nil {
+			return verifiedOIDCLogin{identity: identity, mode: request.Mode}, true
+		}
+	}
+	utils.Error(c, http.StatusUnauthorized, "No se pudo validar el inicio de sesión con el proveedor", gin.H{"code": "SSO_LOGIN_FAILED"})
+	return verifiedOIDCLogin{}, false
+}
+
+// completeOIDCLogin resuelve el usuario local de la identidad verificada y emite la sesión
+func completeOIDCLogin(c *gin.Context, db *gorm.DB, cfg utils.OIDCConfig, identity *utils.OIDCIdentity, mode string) {
+	user, err := resolveOIDCUser(db, cfg, identity)
+	if err != nil {
+		var loginErr *oidcLoginError
+		if errors.As(err, &loginErr) {
+			if loginErr.code == "SSO_USER_NOT_PROVISIONED" {
+				recordLoginAttempt(db, c, nil, ssoUsername(identity), false, models.LoginReasonSSONotProvisioned)
+			}
+			utils.Error(c, loginErr.status, loginErr.message, gin.H{"code": loginErr.code})
+			return
+		}
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+
+	// El bloqueo aplicado por intentos fallidos o por un administrador también rige para SSO
+	if user.IsLocked() {
+		recordLoginAttempt(db, c, &user, user.Username, false, models.LoginReasonAccountLocked)
+		utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+			"code":         "ACCOUNT_LOCKED",
+			"locked_until": user.LockedUntil,
+		})
+		return
+	}
+
+	if !user.IsActive {
+		recordLoginAttempt(db, c, &user, user.Username, false, models.LoginReasonUserInactive)
+		utils.Error(c, http.StatusForbidden, "Usuario inactivo", gin.H{"code": "USER_INACTIVE"})
+		return
+	}
+
+	// Si el proveedor no informó multifactor se exige el segundo factor local, si existe
+	if !identity.MFA && user.TwoFactorEnabled {
+		respondTwoFactorChallenge(c, user)
+		return
+	}
+
+	completeLogin(c, db, user, identity.MFA, mode, models.LoginReasonOIDC)
+}
+
+// resolveOIDCUser busca el usuario vinculado a la identidad (emisor + sub). Si no existe
+// y OIDC_JIT_PROVISIONING está activo, crea uno; las cuentas existentes solo se vinculan
+// de forma explícita (GET /me/sso/link). El rol se asigna según OIDC_ROLE_MAPPING.
+func resolveOIDCUser(db *gorm.DB, cfg utils.OIDCConfig, identity *utils.OIDCIdentity) (models.User, error) {
+	var role *models.Role
+	if roleName := cfg.MapRole(identity.Groups); roleName != "" {
+		var mapped models.Role
+		if err := db.Where("name = ? AND is_active = ?", roleName, true).First(&mapped).Error; err == nil {
+			role = &mapped
+		}
+	}
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		err := tx.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(&link).Error
+		switch {
+		case err == nil:
+			if err := tx.First(&user, link.UserID).Error; err != nil {
+				return err
+			}
+			// Los grupos del proveedor son la fuente de verdad del rol
+			if cfg.SyncRoles {
+				if err := syncOIDCRole(tx, &user, role); err != nil {
+					return err
+				}
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := provisionOIDCUser(tx, cfg, identity, role, &user); err != nil {
+				return err
+			}
+			link = models.UserIdentity{UserID: user.ID, Issuer: identity.Issuer, Subject: identity.Subject}
+		default:
+			return err
+		}
+
+		now := time.Now()
+		link.Email = identity.Email
+		link.LastLoginAt = &now
+		return tx.Save(&link).Error
+	})
+	if err != nil {
+		return user, err
+	}
+
+	err = db.Preload("Role").First(&user, user.ID).Error
+	return user, err
+}
+
+// syncOIDCRole aplica al usuario el rol mapeado desde sus grupos con las mismas reglas
+// que UpdateUser: si ningún grupo corresponde a un rol se rechaza el ingreso (no se
+// conserva el rol anterior), no se quita el último administrador y un cambio de rol
+// revoca las sesiones abiertas.
+func syncOIDCRole(tx *gorm.DB, user *models.User, role *models.Role) error {
+	if role == nil {
+		return &oidcLoginError{http.StatusForbidden, "Ningún grupo del usuario corresponde a un rol del sistema", "SSO_ROLE_NOT_MAPPED"}
+	}
+	if user.RoleID == role.ID {
+		return nil
+	}
+
+	if !role.Permissions.IsFullAccess() {
+		admin, err := isActiveAdmin(tx, *user)
+		if err != nil {
+			return err
+		}
+		if admin {
+			remaining, err := countActiveAdmins(tx, 0, user.ID)
+			if err != nil {
+				return err
+			}
+			if remaining == 0 {
+				return &oidcLoginError{http.StatusConflict, "No se puede quitar el último administrador del sistema", "SSO_LAST_ADMIN"}
+			}
+		}
+	}
+
+	if err := tx.Model(user).Update("role_id", role.ID).Error; err != nil {
+		return err
+	}
+	user.RoleID = role.ID
+	return revokeUserSessions(tx, user.ID, "role_changed")
+}
+
+// provisionOIDCUser crea el usuario en su primer ingreso (JIT). Nunca vincula una cuenta
+// existente por correo: eso permitiría al proveedor tomar cuentas locales, incluida la de
+// administrador.
+func provisionOIDCUser(tx *gorm.DB, cfg utils.OIDCConfig, identity *utils.OIDCIdentity, role *models.Role, user *models.User) error {
+	if identity.Email != "" && identity.EmailVerified {
+		var existing int64
+		if err := tx.Model(&models.User{}).Where("LOWER(email) = ?", identity.Email).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return &oidcLoginError{http.StatusConflict, "Ya existe una cuenta con ese correo: vincúlela desde su perfil antes de usar SSO", "SSO_LINK_REQUIRED"}
+		}
+	}
+
+	if !cfg.JITProvisioning || identity.Email == "" {
+		return &oidcLoginError{http.StatusForbidden, "El usuario no está registrado en el sistema", "SSO_USER_NOT_PROVISIONED"}
+	}
+	if role == nil {
+		return &oidcLoginError{http.StatusForbidden, "Ningún grupo del usuario corresponde a un rol del sistema", "SSO_ROLE_NOT_MAPPED"}
+	}
+
+	username := ssoUsername(identity)
+	var taken int64
+	if err := tx.Model(&models.User{}).Unscoped().Where("username = ? OR LOWER(email) = ?", username, identity.Email).Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return &oidcLoginError{http.StatusConflict, "Ya existe un usuario con ese nombre o correo", "SSO_USERNAME_CONFLICT"}
+	}
+
+	// La cuenta solo inicia sesión por SSO: la contraseña local es aleatoria y desconocida
+	password, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+	fullName := identity.Name
+	if fullName == "" {
+		fullName = username
+	}
+	*user = models.User{
+		Username: username,
+		Email:    identity.Email,
+		Password: password,
+		FullName: fullName,
+		RoleID:   role.ID,
+		IsActive: true,
+	}
+	return tx.Create(user).Error
+}
+
+// ssoUsername deriva el nombre de usuario local del claim configurado o del correo
+func ssoUsername(identity *utils.OIDCIdentity) string {
+	username := identity.Username
+	if username == "" {
+		username, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if username == "" {
+		username = "sso-" + identity.Subject
+	}
+	if len(username) > 50 {
+		username = username[:50]
+	}
+	return username
+}
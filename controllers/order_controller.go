@@ -14,13 +14,14 @@ import (
 
 // CreateOrder godoc
 // @Summary      Crear orden de examen
-// @Description  Crea una nueva orden de examen para un paciente específico
+// @Description  Crea una nueva orden de examen para un paciente específico. Si el paciente tiene representante, la orden registra el indicado en guardian_id o el principal; los menores de edad sin representante no admiten órdenes. El paciente debe tener vigentes los consentimientos que exigen los exámenes (required_consent) y los marcados como obligatorios para toda orden
 // @Tags         orders
 // @Accept       json
 // @Produce      json
 // @Param        request body dtos.CreateOrderRequest true "Datos para crear la orden"
 // @Success      201 {object} utils.Response{data=models.Order}
 // @Failure      400 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "PATIENT_INACTIVE, GUARDIAN_REQUIRED, CONSENT_REQUIRED"
 // @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
 // @Router       /orders [post]
 // @Security BearerAuth
@@ -34,6 +35,28 @@ func CreateOrder(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	db := config.GetDB()
 
+	// Solo se admiten órdenes de pacientes activos
+	var patient models.Patient
+	if err := db.First(&patient, input.PatientID).Error; err != nil {
+		utils.Error(c, http.StatusBadRequest, "Paciente no encontrado", nil)
+		return
+	}
+	if !patient.IsActive {
+		utils.Error(c, http.StatusConflict, "El paciente está inactivo", gin.H{"code": "PATIENT_INACTIVE"})
+		return
+	}
+	guardian, ok := responsibleGuardian(c, db, &patient, input.GuardianID)
+	if !ok {
+		return
+	}
+	examTypeIDs := make([]uint, len(input.Exams))
+	for i, exam := range input.Exams {
+		examTypeIDs[i] = exam.ExamTypeID
+	}
+	if !ensureOrderConsents(c, db, patient.ID, examTypeIDs) {
+		return
+	}
+
 	// Iniciamos una Transacción para asegurar que se cree la orden Y sus exámenes
 	tx := db.Begin()
 
@@ -45,6 +68,9 @@ func CreateOrder(c *gin.Context) {
 		CreatedBy:       userID.(uint),
 		Status:          "pendiente",
 	}
+	if guardian != nil {
+		order.SetGuardian(guardian)
+	}
 
 	if err := tx.Create(&order).Error; err != nil {
 		tx.Rollback()
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+var errResetTokenUsed = errors.New("token de restablecimiento ya utilizado")
+
+// ChangePassword godoc
+// @Summary      Cambiar mi contraseña
+// @Description  Requiere la contraseña actual. Revoca todas las sesiones y retorna tokens nuevos para la sesión actual
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.ChangePasswordRequest true "Contraseña actual y nueva"
+// @Success      200 {object} utils.Response{data=dtos.TokenResponse}
+// @Failure      400 {object} utils.Response{errors=string} "WEAK_PASSWORD o PASSWORD_REUSED"
+// @Failure      401 {object} utils.Response{errors=string}
+// @Failure      423 {object} utils.Response{errors=string} "ACCOUNT_LOCKED"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /me/password [post]
+func ChangePassword(c *gin.Context) {
+	var input dtos.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, userID.(uint)).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	// La contraseña actual se adivina igual que en el login: un intento fallido cuenta
+	// para el bloqueo y una cuenta bloqueada no la evalúa
+	if user.IsLocked() {
+		recordLoginAttempt(db, c, &user, user.Username, false, models.LoginReasonAccountLocked)
+		utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+			"code":         "ACCOUNT_LOCKED",
+			"locked_until": user.LockedUntil,
+		})
+		return
+	}
+	if !user.CheckPassword(input.CurrentPassword) {
+		if err := registerFailedLogin(db, &user); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+			return
+		}
+		recordLoginAttempt(db, c, &user, user.Username, false, models.LoginReasonInvalidPassword)
+
+		if user.IsLocked() {
+			utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+				"code":         "ACCOUNT_LOCKED",
+				"locked_until": user.LockedUntil,
+			})
+			return
+		}
+		utils.Error(c, http.StatusUnauthorized, "Contraseña incorrecta", nil)
+		return
+	}
+	if user.FailedLoginAttempts > 0 {
+		db.Model(&user).Update("failed_login_attempts", 0)
+	}
+	if !validateNewPassword(c, db, user, input.NewPassword) {
+		return
+	}
+
+	var tokens dtos.TokenResponse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := setUserPassword(tx, &user, input.NewPassword, false); err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, user.ID, "password_change"); err != nil {
+			return err
+		}
+		if err := revokeAccessToken(tx, c.GetString("jti"), user.ID, c.GetTime("tokenExpiresAt"), "password_change"); err != nil {
+			return err
+		}
+
+		// La sesión actual continúa con tokens nuevos; las demás deben iniciar sesión otra vez
+		issued, _, err := issueTokens(tx, c, user, "", c.GetBool("mfa"))
+		tokens = issued
+		return err
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al cambiar la contraseña", err.Error())
+		return
+	}
+	if err := applyAuthMode(c, &tokens, c.GetString("authMode")); err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al cambiar la contraseña", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Contraseña actualizada exitosamente", tokens)
+}
+
+// CreatePasswordResetToken godoc
+// @Summary      Emitir token de restablecimiento
+// @Description  Genera un token de un solo uso para que el usuario defina su contraseña en /auth/password/reset. Invalida los tokens anteriores
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Success      201 {object} utils.Response{data=dtos.PasswordResetTokenResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id}/reset-token [post]
+func CreatePasswordResetToken(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	raw, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+
+	adminID, _ := c.Get("userID")
+	reset := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().Add(time.Duration(utils.EnvInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute),
+		CreatedBy: adminID.(uint),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&reset).Error
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al emitir el token", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusCreated, "Token de restablecimiento emitido", dtos.PasswordResetTokenResponse{
+		Token:     raw,
+		ExpiresAt: reset.ExpiresAt,
+	})
+}
+
+// ResetPassword godoc
+// @Summary      Restablecer contraseña con token
+// @Description  Canjea un token de restablecimiento emitido por un administrador y define una nueva contraseña. Revoca las sesiones activas y desbloquea la cuenta
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body dtos.ResetPasswordRequest true "Token y nueva contraseña"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      400 {object} utils.Response{errors=string} "INVALID_RESET_TOKEN, WEAK_PASSWORD o PASSWORD_REUSED"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /auth/password/reset [post]
+func ResetPassword(c *gin.Context) {
+	var input dtos.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	db := config.GetDB()
+	invalid := func() {
+		utils.Error(c, http.StatusBadRequest, "Token de restablecimiento inválido o expirado", gin.H{"code": "INVALID_RESET_TOKEN"})
+	}
+
+	var reset models.PasswordResetToken
+	if err := db.Where("token_hash = ?", utils.HashToken(input.Token)).First(&reset).Error; err != nil || !reset.IsUsable() {
+		invalid()
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, reset.UserID).Error; err != nil || !user.IsActive {
+		invalid()
+		return
+	}
+	if !validateNewPassword(c, db, user, input.NewPassword) {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Marcamos el token como usado solo si nadie lo canjeó antes (evita carreras)
+		now := time.Now()
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", &now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errResetTokenUsed
+		}
+
+		if err := setUserPassword(tx, &user, input.NewPassword, false); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"failed_login_attempts": 0,
+			"locked_until":          nil,
+		}).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, "password_reset")
+	})
+	if err == errResetTokenUsed {
+		invalid()
+		return
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al restablecer la contraseña", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Contraseña restablecida exitosamente", nil)
+}
+
+// validateNewPassword aplica la política de contraseñas y el historial de reutilización.
+// Si la contraseña no es aceptable responde 400 y retorna false.
+func validateNewPassword(c *gin.Context, db *gorm.DB, user models.User, password string) bool {
+	policy := utils.LoadPasswordPolicy()
+	if violations := policy.Validate(password); len(violations) > 0 {
+		utils.Error(c, http.StatusBadRequest, "La contraseña no cumple la política de seguridad", gin.H{
+			"code":       "WEAK_PASSWORD",
+			"violations": violations,
+		})
+		return false
+	}
+
+	if user.ID == 0 {
+		return true
+	}
+
+	reused := user.CheckPassword(password)
+	if !reused {
+		var history []models.PasswordHistory
+		db.Where("user_id = ?", user.ID).Order("id DESC").Limit(policy.HistorySize).Find(&history)
+		for _, previous := range history {
+			if previous.Matches(password) {
+				reused = true
+				break
+			}
+		}
+	}
+	if reused {
+		utils.Error(c, http.StatusBadRequest, "No puede reutilizar una contraseña reciente", gin.H{"code": "PASSWORD_REUSED"})
+		return false
+	}
+	return true
+}
+
+// setUserPassword guarda la contraseña actual en el historial y asigna la nueva.
+// mustChange indica si el usuario deberá cambiarla en su próximo inicio de sesión.
+func setUserPassword(tx *gorm.DB, user *models.User, password string, mustChange bool) error {
+	if user.PasswordHash != "" {
+		if err := tx.Create(&models.PasswordHistory{UserID: user.ID, PasswordHash: user.PasswordHash}).Error; err != nil {
+			return err
+		}
+
+		// Conservamos solo las entradas que la política puede consultar
+		keep := tx.Model(&models.PasswordHistory{}).Select("id").
+			Where("user_id = ?", user.ID).Order("id DESC").Limit(utils.LoadPasswordPolicy().HistorySize)
+		if err := tx.Where("user_id = ? AND id NOT IN (?)", user.ID, keep).Delete(&models.PasswordHistory{}).Error; err != nil {
+			return err
+		}
+	}
+
+	// El hook BeforeUpdate en user.go hará el Hash
+	now := time.Now()
+	user.Password = password
+	user.MustChangePassword = mustChange
+	user.PasswordChangedAt = &now
+	return tx.Model(user).Select("password_hash", "must_change_password", "password_changed_at").Updates(user).Error
+}
@@ -1,26 +1,33 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cesarbmathec/medical-exams-backend/config"
 	"github.com/cesarbmathec/medical-exams-backend/dtos"
 	"github.com/cesarbmathec/medical-exams-backend/models"
 	"github.com/cesarbmathec/medical-exams-backend/utils"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
 	_ "github.com/cesarbmathec/medical-exams-backend/docs"
 )
 
 // CreatePatient godoc
 // @Summary      Crear paciente
-// @Description  Crea un nuevo paciente en el sistema
+// @Description  Crea un nuevo paciente en el sistema. El documento se normaliza (cédula V-/E-, RIF con dígito verificador, pasaporte en mayúsculas) y no puede repetirse
 // @Tags         patients
 // @Accept       json
 // @Produce      json
 // @Param        request body dtos.CreatePatientRequest true "Datos para crear el paciente"
-// @Success      201 {object} utils.Response{data=models.Patient}
-// @Failure      400 {object} utils.Response{errors=string}
+// @Success      201 {object} utils.Response{data=models.PatientResponse}
+// @Failure      400 {object} utils.Response{errors=string} "Error de validación o INVALID_DOCUMENT"
+// @Failure      409 {object} utils.Response{errors=string} "PATIENT_DOCUMENT_EXISTS"
 // @Failure      500 {object} utils.Response{errors=string}
 // @Router       /patients [post]
 // @Security BearerAuth
@@ -46,46 +53,204 @@ func CreatePatient(c *gin.Context) {
 		Phone:          input.Phone,
 		Email:          input.Email,
 		BloodType:      input.BloodType,
-		CreatedBy:      userID.(uint), // Asignamos el ID del usuario autenticado
+
+		Address: input.Address,
+		City:    input.City,
+		State:   input.State,
+		Country: input.Country,
+
+		EmergencyContactName:  input.EmergencyContactName,
+		EmergencyContactPhone: input.EmergencyContactPhone,
+
+		Allergies:         input.Allergies,
+		MedicalConditions: input.MedicalConditions,
+
+		IsActive:  true,
+		CreatedBy: userID.(uint), // Asignamos el ID del usuario autenticado
+	}
+	if patient.Country == "" {
+		patient.Country = models.DefaultPatientCountry
 	}
 
 	db := config.GetDB()
-	if err := db.Create(&patient).Error; err != nil {
-		utils.Error(c, http.StatusInternalServerError, "No se pudo crear el paciente", err.Error())
+	if !ensureUniquePatientDocument(c, db, &patient) {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&patient).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, patient.TableName(), patient.ID, models.AuditActionInsert, nil, patientFields(&patient))
+	})
+	if err != nil {
+		patientSaveFailed(c, db, &patient, "No se pudo crear el paciente", err)
 		return
 	}
 
-	utils.Success(c, http.StatusCreated, "Paciente creado exitosamente", patient)
+	utils.Success(c, http.StatusCreated, "Paciente creado exitosamente", patient.ToResponse())
+}
+
+// patientSortColumns son los órdenes admitidos en el listado de pacientes; con "-"
+// delante el orden es descendente
+var patientSortColumns = map[string][]string{
+	"name":            {"last_name", "first_name"},
+	"date_of_birth":   {"date_of_birth"},
+	"document_number": {"document_number"},
+	"created_at":      {"created_at"},
 }
 
 // GetPatients godoc
 // @Summary      Listar pacientes
-// @Description  Obtiene una lista de pacientes, con opción de filtrar por número de documento
+// @Description  Busca pacientes con filtros combinables y paginación. El nombre se compara sin acentos ni mayúsculas ("jose perez" encuentra "José Antonio Pérez") y el teléfono solo por sus dígitos. La paginación se informa en "meta"
 // @Tags         patients
 // @Accept       json
 // @Produce      json
-// @Param        document query string false "Número de documento para filtrar"
-// @Success      200 {array} utils.Response{data=[]models.Patient}
+// @Param        name query string false "Nombre y/o apellido (todas las palabras deben aparecer)"
+// @Param        document query string false "Número de documento (parcial)"
+// @Param        phone query string false "Teléfono (parcial, se ignoran los separadores)"
+// @Param        email query string false "Correo (parcial)"
+// @Param        gender query string false "Género (M, F u O)"
+// @Param        age_min query int false "Edad mínima en años"
+// @Param        age_max query int false "Edad máxima en años"
+// @Param        status query string false "Estado: active (por defecto), inactive, deleted o all"
+// @Param        sort query string false "Orden: name, date_of_birth, document_number o created_at; con - delante es descendente (por defecto name)"
+// @Param        page query int false "Página (desde 1)"
+// @Param        limit query int false "Registros por página (por defecto 20, máximo 100)"
+// @Success      200 {object} utils.PageResponse{data=[]models.PatientResponse}
+// @Failure      400 {object} utils.Response{errors=string}
 // @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
 // @Router       /patients [get]
 // @Security BearerAuth
 func GetPatients(c *gin.Context) {
-	var patients []models.Patient
-	db := config.GetDB()
+	pagination, err := utils.ParsePagination(c, 20, 100)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
 
-	// Filtro por número de documento si viene en el query
-	doc := c.Query("document")
+	db := config.GetDB()
 	query := db.Model(&models.Patient{})
-	if doc != "" {
-		query = query.Where("document_number LIKE ?", "%"+doc+"%")
+	switch c.DefaultQuery("status", "active") {
+	case "active":
+		query = query.Where("is_active = ?", true)
+	case "inactive":
+		query = query.Where("is_active = ?", false)
+	case "deleted":
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	case "all":
+		query = query.Unscoped()
+	default:
+		utils.Error(c, http.StatusBadRequest, "Estado inválido (active, inactive, deleted o all)", nil)
+		return
+	}
+
+	query, err = filterPatients(query, c)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	sortParam := c.DefaultQuery("sort", "name")
+	direction := "ASC"
+	if strings.HasPrefix(sortParam, "-") {
+		sortParam, direction = sortParam[1:], "DESC"
+	}
+	columns, ok := patientSortColumns[sortParam]
+	if !ok {
+		utils.Error(c, http.StatusBadRequest, "Orden inválido (name, date_of_birth, document_number o created_at)", nil)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener pacientes", err.Error())
+		return
 	}
+	pagination.SetTotal(total)
 
-	if err := query.Find(&patients).Error; err != nil {
+	for _, column := range columns {
+		query = query.Order(column + " " + direction)
+	}
+	var patients []models.Patient
+	if err := query.Order("id " + direction).Offset(pagination.Offset()).Limit(pagination.Limit).Find(&patients).Error; err != nil {
 		utils.Error(c, http.StatusInternalServerError, "Error al obtener pacientes", err.Error())
 		return
 	}
 
-	utils.Success(c, http.StatusOK, "Pacientes obtenidos exitosamente", patients)
+	utils.SuccessPage(c, http.StatusOK, "Pacientes obtenidos exitosamente", models.PatientResponses(patients), pagination)
+}
+
+// filterPatients aplica los filtros de búsqueda del listado de pacientes
+func filterPatients(query *gorm.DB, c *gin.Context) (*gorm.DB, error) {
+	for _, word := range strings.Fields(utils.NormalizeName(c.Query("name"))) {
+		query = query.Where("search_name LIKE ?", "%"+word+"%")
+	}
+	if doc := c.Query("document"); doc != "" {
+		query = query.Where("document_number LIKE ?", "%"+doc+"%")
+	}
+	if phone := c.Query("phone"); phone != "" {
+		// Sin el 0 inicial, "0414..." también encuentra "+58 414..."
+		digits := strings.TrimLeft(onlyDigits(phone), "0")
+		if digits == "" {
+			return nil, errors.New("Teléfono inválido")
+		}
+		query = query.Where(digitsOnlySQL("phone")+" LIKE ?", "%"+digits+"%")
+	}
+	if email := strings.TrimSpace(c.Query("email")); email != "" {
+		query = query.Where("LOWER(email) LIKE ?", "%"+strings.ToLower(email)+"%")
+	}
+	if gender := c.Query("gender"); gender != "" {
+		if gender != "M" && gender != "F" && gender != "O" {
+			return nil, errors.New("Género inválido (M, F u O)")
+		}
+		query = query.Where("gender = ?", gender)
+	}
+
+	// Edad cumplida: age_min años implica haber nacido a más tardar hace age_min años,
+	// y age_max años haber nacido después de hace age_max + 1 años
+	today := time.Now().Truncate(24 * time.Hour)
+	ageMin, ageMax := -1, -1
+	for param, target := range map[string]*int{"age_min": &ageMin, "age_max": &ageMax} {
+		if value := c.Query(param); value != "" {
+			age, err := strconv.Atoi(value)
+			if err != nil || age < 0 || age > dtos.MaxPatientAge {
+				return nil, fmt.Errorf("%s debe estar entre 0 y %d", param, dtos.MaxPatientAge)
+			}
+			*target = age
+		}
+	}
+	if ageMin >= 0 && ageMax >= 0 && ageMin > ageMax {
+		return nil, errors.New("age_min no puede ser mayor que age_max")
+	}
+	if ageMin >= 0 {
+		query = query.Where("date_of_birth <= ?", today.AddDate(-ageMin, 0, 0))
+	}
+	if ageMax >= 0 {
+		query = query.Where("date_of_birth > ?", today.AddDate(-ageMax-1, 0, 0))
+	}
+	return query, nil
+}
+
+// digitsOnlySQL retorna una expresión SQL (válida en PostgreSQL y SQLite) que quita
+// del teléfono los separadores admitidos por la validación "phone"
+func digitsOnlySQL(column string) string {
+	expr := column
+	for _, separator := range []string{"+", " ", "-", ".", "(", ")"} {
+		expr = fmt.Sprintf("REPLACE(%s, '%s', '')", expr, separator)
+	}
+	return expr
+}
+
+// onlyDigits retorna solo los dígitos del texto
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
 }
 
 // GetPatientByID godoc
@@ -95,8 +260,8 @@ func GetPatients(c *gin.Context) {
 // @Accept       json
 // @Produce      json
 // @Param        id path int true "ID del paciente"
-// @Success      200 {object} utils.Response{data=models.Patient}
-// @Failure      404 {object} utils.Response{errors=string}
+// @Success      200 {object} utils.Response{data=models.PatientResponse}
+// @Failure      404 {object} utils.Response{errors=string} "No encontrado o PATIENT_MERGED (con merged_into_id)"
 // @Router       /patients/{id} [get]
 // @Security BearerAuth
 func GetPatientByID(c *gin.Context) {
@@ -105,9 +270,432 @@ func GetPatientByID(c *gin.Context) {
 	db := config.GetDB()
 
 	if err := db.First(&patient, id).Error; err != nil {
+		// Un paciente fusionado indica a qué registro consultar
+		if db.Unscoped().Where("merged_into_id IS NOT NULL").First(&patient, id).Error == nil {
+			utils.Error(c, http.StatusNotFound, "El paciente fue fusionado con otro registro", gin.H{
+				"code":           "PATIENT_MERGED",
+				"merged_into_id": *patient.MergedIntoID,
+			})
+			return
+		}
 		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
 		return
 	}
 
-	utils.Success(c, http.StatusOK, "Paciente obtenido exitosamente", patient)
+	utils.Success(c, http.StatusOK, "Paciente obtenido exitosamente", patient.ToResponse())
+}
+
+// LookupPatientByDocument godoc
+// @Summary      Buscar paciente por documento
+// @Description  Busca el paciente con el documento exacto (después de normalizarlo), incluidos los inactivos. Pensado para la recepción
+// @Tags         patients
+// @Produce      json
+// @Param        document_type query string true "Tipo de documento (cedula, pasaporte, rif u otro)"
+// @Param        document_number query string true "Número de documento en cualquier formato aceptado"
+// @Success      200 {object} utils.Response{data=models.PatientResponse}
+// @Failure      400 {object} utils.Response{errors=string} "INVALID_DOCUMENT"
+// @Failure      404 {object} utils.Response{errors=string} "PATIENT_NOT_FOUND"
+// @Router       /patients/lookup [get]
+// @Security BearerAuth
+func LookupPatientByDocument(c *gin.Context) {
+	documentType := c.Query("document_type")
+	number, err := utils.NormalizeDocument(documentType, c.Query("document_number"))
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "Documento de identidad inválido", gin.H{"code": "INVALID_DOCUMENT", "detail": err.Error()})
+		return
+	}
+
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.Where("document_type = ? AND document_number = ?", documentType, number).First(&patient).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", gin.H{"code": "PATIENT_NOT_FOUND"})
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Paciente obtenido exitosamente", patient.ToResponse())
+}
+
+// UpdatePatient godoc
+// @Summary      Actualizar paciente
+// @Description  Reemplaza los datos editables del paciente. El cambio queda registrado en la auditoría
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        request body dtos.UpdatePatientRequest true "Datos completos del paciente"
+// @Success      200 {object} utils.Response{data=models.PatientResponse}
+// @Failure      400 {object} utils.Response{errors=string} "Error de validación o INVALID_DOCUMENT"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "PATIENT_DOCUMENT_EXISTS"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id} [put]
+// @Security BearerAuth
+func UpdatePatient(c *gin.Context) {
+	var input dtos.UpdatePatientRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	if input.Country == "" {
+		input.Country = models.DefaultPatientCountry
+	}
+
+	// Un PUT es un PATCH con todos los campos presentes
+	savePatientChanges(c, dtos.PatchPatientRequest{
+		DocumentType:   &input.DocumentType,
+		DocumentNumber: &input.DocumentNumber,
+		FirstName:      &input.FirstName,
+		LastName:       &input.LastName,
+		DateOfBirth:    &input.DateOfBirth,
+		Gender:         &input.Gender,
+		Phone:          &input.Phone,
+		Email:          &input.Email,
+		BloodType:      &input.BloodType,
+
+		Address: &input.Address,
+		City:    &input.City,
+		State:   &input.State,
+		Country: &input.Country,
+
+		EmergencyContactName:  &input.EmergencyContactName,
+		EmergencyContactPhone: &input.EmergencyContactPhone,
+
+		Allergies:         &input.Allergies,
+		MedicalConditions: &input.MedicalConditions,
+	})
+}
+
+// PatchPatient godoc
+// @Summary      Actualizar parcialmente un paciente
+// @Description  Modifica solo los campos enviados (por ejemplo, corregir un nombre). El cambio queda registrado en la auditoría
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        request body dtos.PatchPatientRequest true "Campos a modificar"
+// @Success      200 {object} utils.Response{data=models.PatientResponse}
+// @Failure      400 {object} utils.Response{errors=string} "Error de validación o INVALID_DOCUMENT"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "PATIENT_DOCUMENT_EXISTS"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id} [patch]
+// @Security BearerAuth
+func PatchPatient(c *gin.Context) {
+	var input dtos.PatchPatientRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+	savePatientChanges(c, input)
+}
+
+// DeactivatePatient godoc
+// @Summary      Desactivar paciente
+// @Description  Marca al paciente como inactivo con un motivo. Un paciente inactivo no admite nuevas órdenes
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        request body dtos.PatientStatusRequest true "Motivo"
+// @Success      200 {object} utils.Response{data=models.PatientResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "El paciente ya está inactivo"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/deactivate [post]
+// @Security BearerAuth
+func DeactivatePatient(c *gin.Context) {
+	var input dtos.PatientStatusRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+	if !patient.IsActive {
+		utils.Error(c, http.StatusConflict, "El paciente ya está inactivo", nil)
+		return
+	}
+
+	now := time.Now()
+	changes := map[string]interface{}{
+		"is_active":           false,
+		"deactivated_at":      &now,
+		"deactivation_reason": input.Reason,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&patient).Updates(changes).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, patient.TableName(), patient.ID, models.AuditActionDeactivate,
+			map[string]interface{}{"is_active": true},
+			map[string]interface{}{"is_active": false, "reason": input.Reason})
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al desactivar el paciente", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Paciente desactivado exitosamente", patient.ToResponse())
+}
+
+// DeletePatient godoc
+// @Summary      Eliminar paciente
+// @Description  Eliminación lógica con motivo: el paciente deja de aparecer en las búsquedas pero conserva su historial y puede restaurarse
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        request body dtos.PatientStatusRequest true "Motivo"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id} [delete]
+// @Security BearerAuth
+func DeletePatient(c *gin.Context) {
+	var input dtos.PatientStatusRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&patient).Updates(map[string]interface{}{
+			"is_active":       false,
+			"deletion_reason": input.Reason,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&patient).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, patient.TableName(), patient.ID, models.AuditActionDelete,
+			map[string]interface{}{"is_active": true},
+			map[string]interface{}{"deleted": true, "reason": input.Reason})
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al eliminar el paciente", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Paciente eliminado exitosamente", nil)
+}
+
+// RestorePatient godoc
+// @Summary      Restaurar paciente
+// @Description  Reactiva un paciente inactivo o eliminado
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Success      200 {object} utils.Response{data=models.PatientResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "El paciente ya está activo, PATIENT_MERGED o PATIENT_DOCUMENT_EXISTS"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/restore [post]
+// @Security BearerAuth
+func RestorePatient(c *gin.Context) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.Unscoped().First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+	if patient.IsActive && !patient.IsDeleted() {
+		utils.Error(c, http.StatusConflict, "El paciente ya está activo", nil)
+		return
+	}
+	if patient.IsMerged() {
+		utils.Error(c, http.StatusConflict, "El paciente fue fusionado con otro registro", gin.H{
+			"code":           "PATIENT_MERGED",
+			"merged_into_id": *patient.MergedIntoID,
+		})
+		return
+	}
+	// Mientras estuvo eliminado pudo registrarse otro paciente con el mismo documento
+	if patient.IsDeleted() && !ensurePatientDocumentAvailable(c, db, &patient) {
+		return
+	}
+
+	previous := map[string]interface{}{"is_active": patient.IsActive, "deleted": patient.IsDeleted()}
+	if patient.DeactivationReason != "" {
+		previous["deactivation_reason"] = patient.DeactivationReason
+	}
+	if patient.DeletionReason != "" {
+		previous["deletion_reason"] = patient.DeletionReason
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&patient).Updates(map[string]interface{}{
+			"is_active":           true,
+			"deleted_at":          nil,
+			"deactivated_at":      nil,
+			"deactivation_reason": "",
+			"deletion_reason":     "",
+		}).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, patient.TableName(), patient.ID, models.AuditActionRestore,
+			previous, map[string]interface{}{"is_active": true, "deleted": false})
+	})
+	if err != nil {
+		patientSaveFailed(c, db, &patient, "Error al restaurar el paciente", err)
+		return
+	}
+
+	db.First(&patient, patient.ID)
+	utils.Success(c, http.StatusOK, "Paciente restaurado exitosamente", patient.ToResponse())
+}
+
+// savePatientChanges aplica los campos enviados, guarda solo los que cambiaron y
+// registra los valores anteriores y nuevos en la auditoría
+func savePatientChanges(c *gin.Context, input dtos.PatchPatientRequest) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	before := patientFields(&patient)
+	assignField(&patient.DocumentType, input.DocumentType)
+	assignField(&patient.DocumentNumber, input.DocumentNumber)
+	assignField(&patient.FirstName, input.FirstName)
+	assignField(&patient.LastName, input.LastName)
+	assignField(&patient.DateOfBirth, input.DateOfBirth)
+	assignField(&patient.Gender, input.Gender)
+	assignField(&patient.Phone, input.Phone)
+	assignField(&patient.Email, input.Email)
+	assignField(&patient.BloodType, input.BloodType)
+	assignField(&patient.Address, input.Address)
+	assignField(&patient.City, input.City)
+	assignField(&patient.State, input.State)
+	assignField(&patient.Country, input.Country)
+	assignField(&patient.EmergencyContactName, input.EmergencyContactName)
+	assignField(&patient.EmergencyContactPhone, input.EmergencyContactPhone)
+	assignField(&patient.Allergies, input.Allergies)
+	assignField(&patient.MedicalConditions, input.MedicalConditions)
+	if (input.DocumentType != nil || input.DocumentNumber != nil) && !ensureUniquePatientDocument(c, db, &patient) {
+		return
+	}
+
+	oldValues, newValues := diffFields(before, patientFields(&patient))
+	if len(newValues) == 0 {
+		utils.Success(c, http.StatusOK, "Sin cambios", patient.ToResponse())
+		return
+	}
+
+	columns := make([]string, 0, len(newValues)+1)
+	for column := range newValues {
+		columns = append(columns, column)
+	}
+	_, firstNameChanged := newValues["first_name"]
+	_, lastNameChanged := newValues["last_name"]
+	if firstNameChanged || lastNameChanged {
+		columns = append(columns, "search_name") // Calculado en Patient.BeforeSave
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&patient).Select(columns).Updates(&patient).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, patient.TableName(), patient.ID, models.AuditActionUpdate, oldValues, newValues)
+	})
+	if err != nil {
+		patientSaveFailed(c, db, &patient, "Error al actualizar el paciente", err)
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Paciente actualizado exitosamente", patient.ToResponse())
+}
+
+// ensureUniquePatientDocument normaliza el documento del paciente y verifica que no
+// pertenezca a otro paciente vigente. Si no es aceptable responde 400 o 409 y retorna false.
+func ensureUniquePatientDocument(c *gin.Context, db *gorm.DB, patient *models.Patient) bool {
+	number, err := utils.NormalizeDocument(patient.DocumentType, patient.DocumentNumber)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "Documento de identidad inválido", gin.H{"code": "INVALID_DOCUMENT", "detail": err.Error()})
+		return false
+	}
+	patient.DocumentNumber = number
+	return ensurePatientDocumentAvailable(c, db, patient)
+}
+
+// ensurePatientDocumentAvailable responde 409 con el paciente existente si otro paciente
+// no eliminado tiene el mismo documento
+func ensurePatientDocumentAvailable(c *gin.Context, db *gorm.DB, patient *models.Patient) bool {
+	var existing models.Patient
+	err := db.Where("document_type = ? AND document_number = ? AND id <> ?", patient.DocumentType, patient.DocumentNumber, patient.ID).
+		First(&existing).Error
+	switch {
+	case err == nil:
+		utils.Error(c, http.StatusConflict, "Ya existe un paciente con ese documento", gin.H{
+			"code":       "PATIENT_DOCUMENT_EXISTS",
+			"patient_id": existing.ID,
+		})
+		return false
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar el documento", err.Error())
+		return false
+	}
+	return true
+}
+
+// patientSaveFailed responde al error al guardar un paciente. La verificación previa del
+// documento no impide que dos solicitudes concurrentes registren el mismo: la segunda
+// viola el índice único y responde 409 como si la verificación la hubiera detectado.
+func patientSaveFailed(c *gin.Context, db *gorm.DB, patient *models.Patient, message string, err error) {
+	if !utils.IsUniqueViolation(err) {
+		utils.Error(c, http.StatusInternalServerError, message, err.Error())
+		return
+	}
+	if ensurePatientDocumentAvailable(c, db, patient) {
+		utils.Error(c, http.StatusConflict, "Ya existe un paciente con ese documento", gin.H{"code": "PATIENT_DOCUMENT_EXISTS"})
+	}
+}
+
+// patientFields retorna los campos editables del paciente por columna, tal como se
+// comparan y registran en la auditoría
+func patientFields(p *models.Patient) map[string]interface{} {
+	return map[string]interface{}{
+		"document_type":   p.DocumentType,
+		"document_number": p.DocumentNumber,
+		"first_name":      p.FirstName,
+		"last_name":       p.LastName,
+		"date_of_birth":   p.DateOfBirth.Format("2006-01-02"),
+		"gender":          p.Gender,
+		"phone":           p.Phone,
+		"email":           p.Email,
+		"blood_type":      p.BloodType,
+
+		"address": p.Address,
+		"city":    p.City,
+		"state":   p.State,
+		"country": p.Country,
+
+		"emergency_contact_name":  p.EmergencyContactName,
+		"emergency_contact_phone": p.EmergencyContactPhone,
+
+		"allergies":          p.Allergies,
+		"medical_conditions": p.MedicalConditions,
+	}
+}
+
+// assignField copia el valor enviado si el campo vino en la petición
+func assignField[T any](field *T, value *T) {
+	if value != nil {
+		*field = *value
+	}
 }
@@ -0,0 +1,499 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// Puntaje mínimo por defecto y límite de candidatos de la búsqueda de duplicados
+const (
+	defaultDuplicateMinScore = 0.5
+	defaultDuplicateLimit    = 50
+	maxDuplicateLimit        = 200
+)
+
+// errMergeStale indica que uno de los pacientes cambió antes de bloquearlo en la fusión
+var errMergeStale = errors.New("paciente fusionado o eliminado durante la fusión")
+
+// patientReferences son las columnas con llave foránea a pacientes que se trasladan al
+// paciente que se conserva al fusionar duplicados
+var patientReferences = []struct {
+	name   string
+	model  interface{}
+	column string
+}{
+	{"orders", &models.Order{}, "patient_id"},
+	{"invoices", &models.Invoice{}, "patient_id"},
+	{"guardians", &models.PatientGuardian{}, "patient_id"},
+	{"dependents", &models.PatientGuardian{}, "guardian_patient_id"},
+	{"consents", &models.PatientConsent{}, "patient_id"},
+	{"exports", &models.PatientExport{}, "patient_id"},
+}
+
+// mergeFillableColumns son los datos de contacto y clínicos que se copian del
+// duplicado cuando el paciente que se conserva no los tiene
+var mergeFillableColumns = []string{
+	"gender", "phone", "email", "blood_type",
+	"address", "city", "state",
+	"emergency_contact_name", "emergency_contact_phone",
+	"allergies", "medical_conditions",
+}
+
+// GetDuplicatePatients godoc
+// @Summary      Buscar pacientes duplicados
+// @Description  Agrupa los pacientes vigentes por fecha de nacimiento, teléfono, correo y dígitos del documento, y retorna los pares con un puntaje de similitud (nombres parecidos sin acentos, documento con dígitos transpuestos, etc.) ordenados de mayor a menor
+// @Tags         patients
+// @Produce      json
+// @Param        min_score query number false "Puntaje mínimo entre 0 y 1 (0.5 por defecto)"
+// @Param        limit query int false "Máximo de pares (50 por defecto, hasta 200)"
+// @Success      200 {object} utils.Response{data=[]dtos.PatientDuplicateCandidate}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/duplicates [get]
+// @Security BearerAuth
+func GetDuplicatePatients(c *gin.Context) {
+	minScore, limit, ok := duplicateSearchParams(c)
+	if !ok {
+		return
+	}
+
+	var patients []models.Patient
+	db := config.GetDB()
+	if err := db.Order("id").Find(&patients).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener pacientes", err.Error())
+		return
+	}
+
+	candidates := findDuplicateCandidates(patients, nil, minScore)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	utils.Success(c, http.StatusOK, "Posibles duplicados obtenidos exitosamente", candidates)
+}
+
+// GetPatientDuplicates godoc
+// @Summary      Buscar duplicados de un paciente
+// @Description  Retorna los pacientes vigentes que podrían ser la misma persona que el paciente indicado, con su puntaje y los motivos
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        min_score query number false "Puntaje mínimo entre 0 y 1 (0.5 por defecto)"
+// @Param        limit query int false "Máximo de candidatos (50 por defecto, hasta 200)"
+// @Success      200 {object} utils.Response{data=[]dtos.PatientDuplicateCandidate}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /patients/{id}/duplicates [get]
+// @Security BearerAuth
+func GetPatientDuplicates(c *gin.Context) {
+	minScore, limit, ok := duplicateSearchParams(c)
+	if !ok {
+		return
+	}
+
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	var patients []models.Patient
+	if err := db.Order("id").Find(&patients).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener pacientes", err.Error())
+		return
+	}
+
+	candidates := findDuplicateCandidates(patients, &patient, minScore)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	utils.Success(c, http.StatusOK, "Posibles duplicados obtenidos exitosamente", candidates)
+}
+
+// MergePatients godoc
+// @Summary      Fusionar pacientes duplicados
+// @Description  Traslada las órdenes, facturas y demás registros del duplicado al paciente de la ruta, completa sus datos vacíos con los del duplicado y deja el duplicado eliminado con un enlace al paciente que se conserva. Todo ocurre en una transacción y queda en la auditoría
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente que se conserva"
+// @Param        request body dtos.MergePatientRequest true "Duplicado y motivo"
+// @Success      200 {object} utils.Response{data=dtos.MergePatientResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "PATIENT_MERGED"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/merge [post]
+// @Security BearerAuth
+func MergePatients(c *gin.Context) {
+	var input dtos.MergePatientRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var survivor models.Patient
+	db := config.GetDB()
+	if err := db.First(&survivor, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+	if survivor.ID == input.DuplicateID {
+		utils.Error(c, http.StatusBadRequest, "Un paciente no puede fusionarse consigo mismo", nil)
+		return
+	}
+
+	// El duplicado puede estar inactivo o eliminado, pero no fusionado
+	var duplicate models.Patient
+	if err := db.Unscoped().First(&duplicate, input.DuplicateID).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente duplicado no encontrado", nil)
+		return
+	}
+	if duplicate.IsMerged() {
+		utils.Error(c, http.StatusConflict, "El paciente duplicado ya fue fusionado", gin.H{
+			"code":           "PATIENT_MERGED",
+			"merged_into_id": *duplicate.MergedIntoID,
+		})
+		return
+	}
+
+	result := dtos.MergePatientResponse{
+		MergedPatientID:      duplicate.ID,
+		Moved:                map[string][]uint{},
+		RemovedGuardianLinks: []uint{},
+		RemovedConsents:      []uint{},
+		FilledFields:         []string{},
+	}
+	var stale *models.Patient
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Se bloquean ambos pacientes (en orden de ID para no provocar interbloqueos) y se
+		// verifican de nuevo: una fusión simultánea en sentido contrario ya pudo dejar a
+		// cualquiera de los dos como lápida
+		var locked []models.Patient
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []uint{survivor.ID, duplicate.ID}).Order("id").Find(&locked).Error; err != nil {
+			return err
+		}
+		for i := range locked {
+			if locked[i].ID == survivor.ID {
+				survivor = locked[i]
+			} else {
+				duplicate = locked[i]
+			}
+		}
+		for _, patient := range []models.Patient{survivor, duplicate} {
+			if patient.IsMerged() || (patient.ID == survivor.ID && patient.IsDeleted()) {
+				stale = &patient
+				return errMergeStale
+			}
+		}
+
+		// Un consentimiento vigente del duplicado que el paciente que se conserva ya tiene
+		// para la misma plantilla violaría idx_patient_consents_active: se conserva el suyo
+		// y el repetido se elimina de forma lógica
+		if err := tx.Model(&models.PatientConsent{}).
+			Where("patient_id = ? AND revoked_at IS NULL", duplicate.ID).
+			Where("template_id IN (?)", tx.Model(&models.PatientConsent{}).Select("template_id").
+				Where("patient_id = ? AND revoked_at IS NULL", survivor.ID)).
+			Pluck("id", &result.RemovedConsents).Error; err != nil {
+			return err
+		}
+		if len(result.RemovedConsents) > 0 {
+			if err := tx.Where("id IN ?", result.RemovedConsents).Delete(&models.PatientConsent{}).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, ref := range patientReferences {
+			var ids []uint
+			if err := tx.Unscoped().Model(ref.model).Where(ref.column+" = ?", duplicate.ID).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			if err := tx.Unscoped().Model(ref.model).Where(ref.column+" = ?", duplicate.ID).Update(ref.column, survivor.ID).Error; err != nil {
+				return err
+			}
+			result.Moved[ref.name] = ids
+		}
+		// Si uno de los dos era representante del otro, el vínculo deja de tener sentido
+		if err := tx.Where("patient_id = ? AND guardian_patient_id = ?", survivor.ID, survivor.ID).
+			Delete(&models.PatientGuardian{}).Error; err != nil {
+			return err
+		}
+		// Los vínculos trasladados pueden repetir un representante o un segundo principal
+		removed, err := reconcileGuardianLinks(tx, survivor.ID, result.Moved["guardians"])
+		if err != nil {
+			return err
+		}
+		result.RemovedGuardianLinks = append(result.RemovedGuardianLinks, removed...)
+		var dependentIDs []uint
+		if ids := result.Moved["dependents"]; len(ids) > 0 {
+			if err := tx.Model(&models.PatientGuardian{}).Where("id IN ?", ids).Distinct().Pluck("patient_id", &dependentIDs).Error; err != nil {
+				return err
+			}
+		}
+		for _, dependentID := range dependentIDs {
+			removed, err := reconcileGuardianLinks(tx, dependentID, result.Moved["dependents"])
+			if err != nil {
+				return err
+			}
+			result.RemovedGuardianLinks = append(result.RemovedGuardianLinks, removed...)
+		}
+
+		survivorFields, duplicateFields := patientFields(&survivor), patientFields(&duplicate)
+		filled := map[string]interface{}{}
+		for _, column := range mergeFillableColumns {
+			if survivorFields[column] == "" && duplicateFields[column] != "" {
+				filled[column] = duplicateFields[column]
+				result.FilledFields = append(result.FilledFields, column)
+			}
+		}
+		if len(filled) > 0 {
+			if err := tx.Model(&survivor).Updates(filled).Error; err != nil {
+				return err
+			}
+		}
+
+		// El duplicado queda como lápida: eliminado y enlazado al paciente que se conserva.
+		// Las lápidas que apuntaban al duplicado pasan a apuntar al que se conserva.
+		now := time.Now()
+		if err := tx.Unscoped().Model(&duplicate).Updates(map[string]interface{}{
+			"is_active":       false,
+			"merged_into_id":  survivor.ID,
+			"merged_at":       &now,
+			"deletion_reason": input.Reason,
+		}).Error; err != nil {
+			return err
+		}
+		if !duplicate.IsDeleted() {
+			if err := tx.Delete(&duplicate).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Model(&models.Patient{}).Where("merged_into_id = ?", duplicate.ID).
+			Update("merged_into_id", survivor.ID).Error; err != nil {
+			return err
+		}
+
+		// La auditoría del paciente que se conserva guarda los datos completos del
+		// duplicado para poder revisar (o deshacer manualmente) la fusión
+		snapshot := duplicateFields
+		snapshot["id"] = duplicate.ID
+		snapshot["is_active"] = duplicate.IsActive
+		snapshot["deleted"] = duplicate.IsDeleted()
+		if err := recordAudit(tx, c, survivor.TableName(), survivor.ID, models.AuditActionMerge, snapshot, map[string]interface{}{
+			"merged_patient_id": duplicate.ID,
+			"moved":             result.Moved,
+			"removed_guardians": result.RemovedGuardianLinks,
+			"removed_consents":  result.RemovedConsents,
+			"filled_fields":     filled,
+			"reason":            input.Reason,
+		}); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, duplicate.TableName(), duplicate.ID, models.AuditActionMerge,
+			map[string]interface{}{"is_active": duplicate.IsActive, "deleted": duplicate.IsDeleted()},
+			map[string]interface{}{"merged_into_id": survivor.ID, "reason": input.Reason})
+	})
+	if errors.Is(err, errMergeStale) {
+		if stale.IsMerged() {
+			utils.Error(c, http.StatusConflict, "El paciente ya fue fusionado", gin.H{
+				"code":           "PATIENT_MERGED",
+				"patient_id":     stale.ID,
+				"merged_into_id": *stale.MergedIntoID,
+			})
+			return
+		}
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al fusionar los pacientes", err.Error())
+		return
+	}
+
+	db.First(&survivor, survivor.ID)
+	result.Patient = survivor.ToResponse()
+	utils.Success(c, http.StatusOK, "Pacientes fusionados exitosamente", result)
+}
+
+// reconcileGuardianLinks depura los representantes de un paciente tras trasladarle los
+// vínculos indicados en moved: si un representante quedó vinculado dos veces se conserva
+// un solo vínculo (con la unión de sus marcas) y, si quedaron dos principales, se
+// degrada el trasladado. Retorna los IDs de los vínculos eliminados.
+func reconcileGuardianLinks(tx *gorm.DB, patientID uint, moved []uint) ([]uint, error) {
+	var links []models.PatientGuardian
+	if err := tx.Where("patient_id = ?", patientID).Order("id").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	wasMoved := map[uint]bool{}
+	for _, id := range moved {
+		wasMoved[id] = true
+	}
+	// Los vínculos que el paciente ya tenía tienen prioridad sobre los trasladados
+	sort.SliceStable(links, func(i, j int) bool {
+		return !wasMoved[links[i].ID] && wasMoved[links[j].ID]
+	})
+
+	original := map[uint][2]bool{}
+	for _, link := range links {
+		original[link.ID] = [2]bool{link.IsPrimary, link.CanReceiveResults}
+	}
+
+	removed := []uint{}
+	kept := []*models.PatientGuardian{}
+	byGuardian := map[string]*models.PatientGuardian{}
+	for i := range links {
+		link := &links[i]
+		key := guardianLinkKey(*link)
+		if existing := byGuardian[key]; key != "" && existing != nil {
+			existing.IsPrimary = existing.IsPrimary || link.IsPrimary
+			existing.CanReceiveResults = existing.CanReceiveResults || link.CanReceiveResults
+			if err := tx.Delete(link).Error; err != nil {
+				return nil, err
+			}
+			removed = append(removed, link.ID)
+			continue
+		}
+		if key != "" {
+			byGuardian[key] = link
+		}
+		kept = append(kept, link)
+	}
+
+	hasPrimary := false
+	for _, link := range kept {
+		if link.IsPrimary && hasPrimary {
+			link.IsPrimary = false
+		}
+		hasPrimary = hasPrimary || link.IsPrimary
+		if original[link.ID] == [2]bool{link.IsPrimary, link.CanReceiveResults} {
+			continue
+		}
+		if err := tx.Model(link).Updates(map[string]interface{}{
+			"is_primary":          link.IsPrimary,
+			"can_receive_results": link.CanReceiveResults,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// guardianLinkKey identifica al representante de un vínculo: el paciente vinculado o el
+// documento del contacto. Vacío si no hay con qué compararlo.
+func guardianLinkKey(link models.PatientGuardian) string {
+	if link.GuardianPatientID != nil {
+		return fmt.Sprintf("patient:%d", *link.GuardianPatientID)
+	}
+	if link.DocumentNumber != "" {
+		return "document:" + link.DocumentType + ":" + link.DocumentNumber
+	}
+	return ""
+}
+
+// duplicateSearchParams lee min_score y limit. Si son inválidos responde 400 y retorna false.
+func duplicateSearchParams(c *gin.Context) (float64, int, bool) {
+	minScore := defaultDuplicateMinScore
+	if value := c.Query("min_score"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			utils.Error(c, http.StatusBadRequest, "min_score debe ser un número entre 0 y 1", nil)
+			return 0, 0, false
+		}
+		minScore = parsed
+	}
+
+	limit := defaultDuplicateLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDuplicateLimit {
+			utils.Error(c, http.StatusBadRequest, "limit debe estar entre 1 y 200", nil)
+			return 0, 0, false
+		}
+		limit = parsed
+	}
+	return minScore, limit, true
+}
+
+// findDuplicateCandidates compara los pacientes que comparten alguna clave de
+// agrupación y retorna los pares con puntaje mayor o igual a minScore, de mayor a menor.
+// Con target solo se consideran los pares que lo incluyen, con target como "patient".
+func findDuplicateCandidates(patients []models.Patient, target *models.Patient, minScore float64) []dtos.PatientDuplicateCandidate {
+	blocks := map[string][]int{}
+	for i := range patients {
+		for _, key := range models.DuplicateBlockKeys(&patients[i]) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+	if target != nil {
+		// Solo interesan los bloques del paciente indicado
+		targetBlocks := map[string][]int{}
+		for _, key := range models.DuplicateBlockKeys(target) {
+			targetBlocks[key] = blocks[key]
+		}
+		blocks = targetBlocks
+	}
+
+	type pair struct{ a, b uint }
+	seen := map[pair]bool{}
+	candidates := []dtos.PatientDuplicateCandidate{}
+	for _, members := range blocks {
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				a, b := &patients[members[x]], &patients[members[y]]
+				if target != nil {
+					if b.ID == target.ID {
+						a, b = b, a
+					}
+					if a.ID != target.ID {
+						continue
+					}
+				}
+				key := pair{a.ID, b.ID}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				score, reasons := models.DuplicateScore(a, b)
+				if score == 0 || score < minScore {
+					continue
+				}
+				candidates = append(candidates, dtos.PatientDuplicateCandidate{
+					Patient:   a.ToResponse(),
+					Duplicate: b.ToResponse(),
+					Score:     score,
+					Reasons:   reasons,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if candidates[i].Patient.ID != candidates[j].Patient.ID {
+			return candidates[i].Patient.ID < candidates[j].Patient.ID
+		}
+		return candidates[i].Duplicate.ID < candidates[j].Duplicate.ID
+	})
+	return candidates
+}
@@ -0,0 +1,581 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// exportSyncOrderLimit es la cantidad máxima de órdenes con la que la exportación se
+// genera dentro de la misma petición. Con historiales más grandes se genera en
+// segundo plano y se consulta su estado hasta que esté lista.
+var exportSyncOrderLimit int64 = 50
+
+// exportPurgeInterval es la frecuencia con la que se borran los archivos vencidos
+const exportPurgeInterval = time.Hour
+
+// StartPatientExportJobs se ejecuta al iniciar el servidor: vuelve a encolar las
+// exportaciones que quedaron pendientes o en proceso cuando el servidor se detuvo y
+// borra periódicamente los archivos vencidos.
+func StartPatientExportJobs(db *gorm.DB) {
+	purgeExpiredExports(db)
+
+	stale := time.Now().Add(-models.ExportStaleAfter)
+	if err := db.Model(&models.PatientExport{}).
+		Where("status = ? AND (started_at IS NULL OR started_at < ?)", models.ExportStatusProcessing, stale).
+		Update("status", models.ExportStatusPending).Error; err != nil {
+		log.Printf("error al reencolar exportaciones: %v", err)
+	}
+
+	var pending []uint
+	if err := db.Model(&models.PatientExport{}).Where("status = ?", models.ExportStatusPending).
+		Order("id").Pluck("id", &pending).Error; err != nil {
+		log.Printf("error al obtener exportaciones pendientes: %v", err)
+	}
+
+	go func() {
+		for _, id := range pending {
+			generatePatientExport(db, id)
+		}
+		ticker := time.NewTicker(exportPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeExpiredExports(db)
+		}
+	}()
+}
+
+// purgeExpiredExports borra el archivo de las exportaciones vencidas. La fila se
+// conserva como constancia de la solicitud.
+func purgeExpiredExports(db *gorm.DB) {
+	err := db.Model(&models.PatientExport{}).
+		Where("expires_at < ? AND archive IS NOT NULL", time.Now()).
+		Update("archive", nil).Error
+	if err != nil {
+		log.Printf("error al borrar exportaciones vencidas: %v", err)
+	}
+}
+
+// GetPatientExports godoc
+// @Summary      Exportaciones del paciente
+// @Description  Lista las exportaciones de datos solicitadas para el paciente, con su estado y vencimiento
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Success      200 {object} utils.Response{data=[]models.PatientExport}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/exports [get]
+// @Security BearerAuth
+func GetPatientExports(c *gin.Context) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	var exports []models.PatientExport
+	if err := db.Omit("archive").Where("patient_id = ?", patient.ID).Order("id DESC").Find(&exports).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener exportaciones", err.Error())
+		return
+	}
+	utils.Success(c, http.StatusOK, "Exportaciones obtenidas exitosamente", exports)
+}
+
+// CreatePatientExport godoc
+// @Summary      Exportar datos del paciente
+// @Description  Genera un archivo ZIP con todos los datos del paciente (datos personales, representantes, consentimientos, órdenes con resultados y pagos, facturas y registro de accesos) en JSON y en un documento HTML legible. Con historiales pequeños el archivo queda listo en la respuesta (201); con historiales grandes se genera en segundo plano (202) y se consulta su estado
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Success      201 {object} utils.Response{data=models.PatientExport}
+// @Success      202 {object} utils.Response{data=models.PatientExport}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/exports [post]
+// @Security BearerAuth
+func CreatePatientExport(c *gin.Context) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	var orderCount int64
+	if err := db.Model(&models.Order{}).Where("patient_id = ?", patient.ID).Count(&orderCount).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al solicitar la exportación", err.Error())
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	export := models.PatientExport{
+		PatientID:   patient.ID,
+		Status:      models.ExportStatusPending,
+		RequestedBy: userID.(uint),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&export).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, patient.TableName(), patient.ID, models.AuditActionExport, nil, map[string]interface{}{
+			"export_id": export.ID,
+		})
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al solicitar la exportación", err.Error())
+		return
+	}
+	purgeExpiredExports(db)
+
+	if orderCount > exportSyncOrderLimit {
+		go generatePatientExport(db, export.ID)
+		utils.Success(c, http.StatusAccepted, "Exportación en proceso", export)
+		return
+	}
+
+	generatePatientExport(db, export.ID)
+	if err := db.Omit("archive").First(&export, export.ID).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener la exportación", err.Error())
+		return
+	}
+	if export.Status == models.ExportStatusFailed {
+		utils.Error(c, http.StatusInternalServerError, "Error al generar la exportación", export.Error)
+		return
+	}
+	utils.Success(c, http.StatusCreated, "Exportación generada exitosamente", export)
+}
+
+// GetPatientExportByID godoc
+// @Summary      Estado de una exportación
+// @Description  Retorna el estado de una exportación del paciente (pendiente, en_proceso, completado o fallido)
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        exportId path int true "ID de la exportación"
+// @Success      200 {object} utils.Response{data=models.PatientExport}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /patients/{id}/exports/{exportId} [get]
+// @Security BearerAuth
+func GetPatientExportByID(c *gin.Context) {
+	var export models.PatientExport
+	if err := config.GetDB().Omit("archive").Where("patient_id = ?", c.Param("id")).
+		First(&export, c.Param("exportId")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Exportación no encontrada", nil)
+		return
+	}
+	utils.Success(c, http.StatusOK, "Exportación obtenida exitosamente", export)
+}
+
+// DownloadPatientExport godoc
+// @Summary      Descargar exportación
+// @Description  Descarga el archivo ZIP de una exportación terminada. El archivo contiene paciente.json y paciente.html y se puede descargar hasta su vencimiento
+// @Tags         patients
+// @Produce      application/zip
+// @Param        id path int true "ID del paciente"
+// @Param        exportId path int true "ID de la exportación"
+// @Success      200 {file} file
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "La exportación aún no está lista o falló"
+// @Failure      410 {object} utils.Response{errors=string} "La exportación venció"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/exports/{exportId}/download [get]
+// @Security BearerAuth
+func DownloadPatientExport(c *gin.Context) {
+	var export models.PatientExport
+	db := config.GetDB()
+	if err := db.Where("patient_id = ?", c.Param("id")).
+		First(&export, c.Param("exportId")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Exportación no encontrada", nil)
+		return
+	}
+	if export.Status != models.ExportStatusCompleted {
+		utils.Error(c, http.StatusConflict, "La exportación no está lista", gin.H{"code": "EXPORT_NOT_READY", "status": export.Status})
+		return
+	}
+	if export.IsExpired() || export.Archive == nil {
+		purgeExpiredExports(db)
+		utils.Error(c, http.StatusGone, "La exportación venció, solicite una nueva", gin.H{"code": "EXPORT_EXPIRED"})
+		return
+	}
+
+	// Cada descarga del historial completo queda en la auditoría antes de entregarlo
+	if err := recordAudit(db, c, export.TableName(), export.ID, models.AuditActionDownload, nil, map[string]interface{}{
+		"file_name": export.FileName,
+		"checksum":  export.Checksum,
+	}); err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al registrar la descarga", err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.FileName))
+	c.Header("X-Checksum-SHA256", export.Checksum)
+	c.Data(http.StatusOK, "application/zip", export.Archive)
+}
+
+// generatePatientExport arma el archivo de una exportación y lo guarda en su fila.
+// Se ejecuta en segundo plano con historiales grandes, por lo que los errores quedan
+// registrados en la exportación en lugar de responderse.
+func generatePatientExport(db *gorm.DB, exportID uint) {
+	var export models.PatientExport
+	if err := db.Omit("archive").First(&export, exportID).Error; err != nil {
+		log.Printf("exportación %d no encontrada: %v", exportID, err)
+		return
+	}
+
+	// Solo una instancia toma la exportación pendiente; si otra ya la tomó no se repite
+	started := time.Now()
+	claim := db.Model(&models.PatientExport{}).Where("id = ? AND status = ?", export.ID, models.ExportStatusPending).
+		Updates(map[string]interface{}{"status": models.ExportStatusProcessing, "started_at": started})
+	if claim.Error != nil {
+		log.Printf("error al tomar la exportación %d: %v", exportID, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		return
+	}
+
+	fail := func(err error) {
+		log.Printf("error al generar la exportación %d: %v", exportID, err)
+		db.Model(&export).Updates(map[string]interface{}{
+			"status": models.ExportStatusFailed,
+			"error":  err.Error(),
+		})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			fail(fmt.Errorf("%v", r))
+		}
+	}()
+
+	bundle, err := buildPatientExport(db, export.PatientID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	archive, err := patientExportArchive(bundle)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	completed := time.Now()
+	sum := sha256.Sum256(archive)
+	if err := db.Model(&export).Updates(map[string]interface{}{
+		"status":       models.ExportStatusCompleted,
+		"completed_at": completed,
+		"expires_at":   completed.Add(models.ExportRetention),
+		"file_name":    fmt.Sprintf("paciente_%d_%s.zip", export.PatientID, completed.Format("20060102150405")),
+		"file_size":    int64(len(archive)),
+		"checksum":     hex.EncodeToString(sum[:]),
+		"archive":      archive,
+	}).Error; err != nil {
+		fail(err)
+	}
+}
+
+// buildPatientExport reúne todos los datos del paciente, incluidos los registros de
+// auditoría sobre su ficha, sus representantes, sus consentimientos y sus exportaciones
+func buildPatientExport(db *gorm.DB, patientID uint) (*dtos.PatientExportBundle, error) {
+	var patient models.Patient
+	err := db.
+		Preload("Orders", func(tx *gorm.DB) *gorm.DB { return tx.Order("order_date, id") }).
+		Preload("Orders.OrderExams.ExamType").
+		Preload("Orders.OrderExams.Results", "is_current = ? AND validated_at IS NOT NULL", true).
+		Preload("Orders.OrderExams.Results.ExamParameter").
+		Preload("Orders.Payments", func(tx *gorm.DB) *gorm.DB { return tx.Order("payment_date, id") }).
+		First(&patient, patientID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &dtos.PatientExportBundle{
+		GeneratedAt: time.Now(),
+		Patient:     patient.ToResponse(),
+		Orders:      []dtos.ExportOrder{},
+		Invoices:    []dtos.ExportInvoice{},
+		AccessLog:   []dtos.ExportAccessEntry{},
+	}
+
+	var guardians []models.PatientGuardian
+	if err := db.Preload("GuardianPatient").Where("patient_id = ?", patient.ID).Order("id").Find(&guardians).Error; err != nil {
+		return nil, err
+	}
+	bundle.Guardians = models.PatientGuardianResponses(guardians)
+
+	if err := db.Where("patient_id = ?", patient.ID).Order("granted_at, id").Find(&bundle.Consents).Error; err != nil {
+		return nil, err
+	}
+
+	for _, order := range patient.Orders {
+		exportOrder := dtos.ExportOrder{
+			OrderNumber:     order.OrderNumber,
+			OrderDate:       order.OrderDate,
+			Status:          order.Status,
+			Priority:        order.Priority,
+			ReferringDoctor: order.ReferringDoctor,
+			Diagnosis:       order.Diagnosis,
+			GuardianName:    order.GuardianName,
+			TotalAmount:     order.TotalAmount,
+			PaidAmount:      order.PaidAmount,
+			Balance:         order.Balance,
+			Exams:           []dtos.ExportExam{},
+			Payments:        []dtos.ExportPayment{},
+		}
+		for _, exam := range order.OrderExams {
+			exportExam := dtos.ExportExam{
+				ExamName:          exam.ExamType.Name,
+				Status:            exam.Status,
+				SampleCollectedAt: exam.SampleCollectedAt,
+				ValidatedAt:       exam.ValidatedAt,
+				Results:           []dtos.ExportResult{},
+			}
+			for _, result := range exam.Results {
+				exportExam.Results = append(exportExam.Results, dtos.ExportResult{
+					Parameter:      result.ExamParameter.ParameterName,
+					Value:          result.GetDisplayValue(),
+					Unit:           result.ExamParameter.UnitOfMeasure,
+					ReferenceRange: referenceRange(result.ExamParameter),
+					Flags:          result.Flags,
+					ValidatedAt:    result.ValidatedAt,
+				})
+			}
+			exportOrder.Exams = append(exportOrder.Exams, exportExam)
+		}
+		for _, payment := range order.Payments {
+			exportOrder.Payments = append(exportOrder.Payments, dtos.ExportPayment{
+				PaymentNumber: payment.PaymentNumber,
+				PaymentDate:   payment.PaymentDate,
+				Amount:        payment.Amount,
+				PaymentMethod: payment.PaymentMethod,
+				Status:        payment.Status,
+			})
+		}
+		bundle.Orders = append(bundle.Orders, exportOrder)
+	}
+
+	var invoices []models.Invoice
+	if err := db.Preload("Order").Where("patient_id = ?", patient.ID).Order("invoice_date, id").Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	for _, invoice := range invoices {
+		bundle.Invoices = append(bundle.Invoices, dtos.ExportInvoice{
+			InvoiceNumber: invoice.InvoiceNumber,
+			InvoiceDate:   invoice.InvoiceDate,
+			OrderNumber:   invoice.Order.OrderNumber,
+			TotalAmount:   invoice.TotalAmount,
+			Status:        invoice.Status,
+			GuardianName:  invoice.GuardianName,
+		})
+	}
+
+	logs, err := patientAccessLog(db, patient.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range logs {
+		access := dtos.ExportAccessEntry{
+			Date:     entry.CreatedAt,
+			Table:    entry.Table,
+			RecordID: entry.RecordID,
+			Action:   entry.Action,
+			Fields:   entry.GetChangedFields(),
+		}
+		if entry.User != nil {
+			access.Username = entry.User.Username
+		}
+		bundle.AccessLog = append(bundle.AccessLog, access)
+	}
+	return bundle, nil
+}
+
+// patientAccessLog retorna los registros de auditoría sobre los datos del paciente
+func patientAccessLog(db *gorm.DB, patientID uint) ([]models.AuditLog, error) {
+	var guardianIDs, consentIDs, exportIDs []uint
+	if err := db.Model(&models.PatientGuardian{}).Unscoped().
+		Where("patient_id = ? OR guardian_patient_id = ?", patientID, patientID).Pluck("id", &guardianIDs).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.PatientConsent{}).Where("patient_id = ?", patientID).Pluck("id", &consentIDs).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.PatientExport{}).Where("patient_id = ?", patientID).Pluck("id", &exportIDs).Error; err != nil {
+		return nil, err
+	}
+
+	query := db.Where("table_name = ? AND record_id = ?", models.Patient{}.TableName(), patientID)
+	records := map[string][]uint{
+		models.PatientGuardian{}.TableName(): guardianIDs,
+		models.PatientConsent{}.TableName():  consentIDs,
+		models.PatientExport{}.TableName():   exportIDs,
+	}
+	for table, ids := range records {
+		if len(ids) > 0 {
+			query = query.Or("table_name = ? AND record_id IN ?", table, ids)
+		}
+	}
+
+	var logs []models.AuditLog
+	err := db.Preload("User").Where(query).Order("created_at, id").Find(&logs).Error
+	return logs, err
+}
+
+// referenceRange describe el rango de referencia de un parámetro
+func referenceRange(param models.ExamParameter) string {
+	switch {
+	case param.ReferenceMin != nil && param.ReferenceMax != nil:
+		return fmt.Sprintf("%g - %g", *param.ReferenceMin, *param.ReferenceMax)
+	case param.ReferenceMin != nil:
+		return fmt.Sprintf(">= %g", *param.ReferenceMin)
+	case param.ReferenceMax != nil:
+		return fmt.Sprintf("<= %g", *param.ReferenceMax)
+	}
+	return param.ReferenceValueText
+}
+
+// patientExportArchive empaqueta la exportación en un ZIP con paciente.json y
+// paciente.html
+func patientExportArchive(bundle *dtos.PatientExportBundle) ([]byte, error) {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var page bytes.Buffer
+	if err := patientExportTemplate.Execute(&page, bundle); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"paciente.json", data},
+		{"paciente.html", page.Bytes()},
+	}
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(file.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// patientExportTemplate es la versión legible de la exportación, imprimible como PDF
+// desde el navegador
+var patientExportTemplate = template.Must(template.New("paciente").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("02/01/2006 15:04") },
+	"datep": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("02/01/2006 15:04")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="es">
+<head>
+<meta charset="utf-8">
+<title>Datos del paciente {{.Patient.FullName}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; font-size: 0.9em; }
+th { background: #f0f0f0; }
+h2 { border-bottom: 2px solid #444; padding-bottom: 4px; }
+</style>
+</head>
+<body>
+<h1>Datos del paciente</h1>
+<p>Generado el {{date .GeneratedAt}}</p>
+
+<h2>Datos personales</h2>
+<table>
+<tr><th>Nombre</th><td>{{.Patient.FullName}}</td></tr>
+<tr><th>Documento</th><td>{{.Patient.DocumentType}} {{.Patient.DocumentNumber}}</td></tr>
+<tr><th>Fecha de nacimiento</th><td>{{.Patient.DateOfBirth.Format "02/01/2006"}} ({{.Patient.Age}} años)</td></tr>
+<tr><th>Sexo</th><td>{{.Patient.Gender}}</td></tr>
+<tr><th>Teléfono</th><td>{{.Patient.Phone}}</td></tr>
+<tr><th>Correo</th><td>{{.Patient.Email}}</td></tr>
+<tr><th>Dirección</th><td>{{.Patient.Address}} {{.Patient.City}} {{.Patient.State}} {{.Patient.Country}}</td></tr>
+<tr><th>Tipo de sangre</th><td>{{.Patient.BloodType}}</td></tr>
+<tr><th>Alergias</th><td>{{.Patient.Allergies}}</td></tr>
+<tr><th>Condiciones médicas</th><td>{{.Patient.MedicalConditions}}</td></tr>
+<tr><th>Contacto de emergencia</th><td>{{.Patient.EmergencyContactName}} {{.Patient.EmergencyContactPhone}}</td></tr>
+</table>
+
+{{if .Guardians}}
+<h2>Representantes</h2>
+<table>
+<tr><th>Nombre</th><th>Documento</th><th>Parentesco</th><th>Principal</th></tr>
+{{range .Guardians}}<tr><td>{{.FullName}}</td><td>{{.DocumentType}} {{.DocumentNumber}}</td><td>{{.Relationship}}</td><td>{{if .IsPrimary}}Sí{{else}}No{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Consents}}
+<h2>Consentimientos</h2>
+<table>
+<tr><th>Consentimiento</th><th>Versión</th><th>Aceptado</th><th>Revocado</th></tr>
+{{range .Consents}}<tr><td>{{.Code}}</td><td>{{.Version}}</td><td>{{date .GrantedAt}}</td><td>{{datep .RevokedAt}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Órdenes</h2>
+{{range .Orders}}
+<h3>Orden {{.OrderNumber}} — {{date .OrderDate}} ({{.Status}})</h3>
+<p>Médico: {{.ReferringDoctor}}. Total: {{printf "%.2f" .TotalAmount}}, pagado: {{printf "%.2f" .PaidAmount}}, saldo: {{printf "%.2f" .Balance}}</p>
+{{range .Exams}}
+<h4>{{.ExamName}} ({{.Status}})</h4>
+{{if .Results}}<table>
+<tr><th>Parámetro</th><th>Valor</th><th>Unidad</th><th>Referencia</th><th>Marca</th></tr>
+{{range .Results}}<tr><td>{{.Parameter}}</td><td>{{.Value}}</td><td>{{.Unit}}</td><td>{{.ReferenceRange}}</td><td>{{.Flags}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+{{if .Payments}}<table>
+<tr><th>Pago</th><th>Fecha</th><th>Monto</th><th>Método</th><th>Estado</th></tr>
+{{range .Payments}}<tr><td>{{.PaymentNumber}}</td><td>{{date .PaymentDate}}</td><td>{{printf "%.2f" .Amount}}</td><td>{{.PaymentMethod}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>{{end}}
+{{else}}
+<p>Sin órdenes registradas.</p>
+{{end}}
+
+{{if .Invoices}}
+<h2>Facturas</h2>
+<table>
+<tr><th>Factura</th><th>Fecha</th><th>Orden</th><th>Total</th><th>Estado</th></tr>
+{{range .Invoices}}<tr><td>{{.InvoiceNumber}}</td><td>{{.InvoiceDate.Format "02/01/2006"}}</td><td>{{.OrderNumber}}</td><td>{{printf "%.2f" .TotalAmount}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Registro de accesos y cambios</h2>
+<table>
+<tr><th>Fecha</th><th>Registro</th><th>Acción</th><th>Usuario</th></tr>
+{{range .AccessLog}}<tr><td>{{date .Date}}</td><td>{{.Table}} #{{.RecordID}}</td><td>{{.Action}}</td><td>{{.Username}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
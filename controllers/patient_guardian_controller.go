@@ -0,0 +1,429 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// GetPatientGuardians godoc
+// @Summary      Representantes del paciente
+// @Description  Lista los representantes (padres, tutores o familiares) del paciente menor o dependiente, primero el principal
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Success      200 {object} utils.Response{data=[]models.PatientGuardianResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/guardians [get]
+// @Security BearerAuth
+func GetPatientGuardians(c *gin.Context) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	var guardians []models.PatientGuardian
+	if err := db.Preload("GuardianPatient").Where("patient_id = ?", patient.ID).
+		Order("is_primary DESC, id").Find(&guardians).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener representantes", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Representantes obtenidos exitosamente", models.PatientGuardianResponses(guardians))
+}
+
+// AddPatientGuardian godoc
+// @Summary      Agregar representante
+// @Description  Vincula al paciente con su representante: otro paciente adulto (guardian_patient_id) o un contacto con nombre y documento. El primer representante queda como principal
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        request body dtos.AddGuardianRequest true "Representante"
+// @Success      201 {object} utils.Response{data=models.PatientGuardianResponse}
+// @Failure      400 {object} utils.Response{errors=string} "GUARDIAN_MINOR, INVALID_GUARDIAN, INVALID_DOCUMENT"
+// @Failure      404 {object} utils.Response{errors=string} "GUARDIAN_NOT_FOUND"
+// @Failure      409 {object} utils.Response{errors=string} "GUARDIAN_EXISTS"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/guardians [post]
+// @Security BearerAuth
+func AddPatientGuardian(c *gin.Context) {
+	var input dtos.AddGuardianRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	guardian := models.PatientGuardian{
+		PatientID:         patient.ID,
+		Relationship:      input.Relationship,
+		IsPrimary:         input.IsPrimary,
+		CanReceiveResults: input.CanReceiveResults == nil || *input.CanReceiveResults,
+		CreatedBy:         userID.(uint),
+	}
+
+	var adult models.Patient
+	if input.GuardianPatientID != nil {
+		if *input.GuardianPatientID == patient.ID {
+			utils.Error(c, http.StatusBadRequest, "Un paciente no puede ser su propio representante", gin.H{"code": "INVALID_GUARDIAN"})
+			return
+		}
+		if err := db.First(&adult, *input.GuardianPatientID).Error; err != nil {
+			utils.Error(c, http.StatusNotFound, "Representante no encontrado", gin.H{"code": "GUARDIAN_NOT_FOUND"})
+			return
+		}
+		if !ensureAdultGuardian(c, &adult) {
+			return
+		}
+		var count int64
+		db.Model(&models.PatientGuardian{}).Where("patient_id = ? AND guardian_patient_id = ?", patient.ID, adult.ID).Count(&count)
+		if count > 0 {
+			utils.Error(c, http.StatusConflict, "El representante ya está vinculado al paciente", gin.H{"code": "GUARDIAN_EXISTS"})
+			return
+		}
+		guardian.GuardianPatientID = &adult.ID
+	} else {
+		guardian.FullName = input.FullName
+		guardian.Phone = input.Phone
+		guardian.Email = input.Email
+		if input.DocumentNumber != "" {
+			normalized, err := utils.NormalizeDocument(input.DocumentType, input.DocumentNumber)
+			if err != nil {
+				utils.Error(c, http.StatusBadRequest, "Documento inválido", gin.H{"code": "INVALID_DOCUMENT", "detail": err.Error()})
+				return
+			}
+			guardian.DocumentType = input.DocumentType
+			guardian.DocumentNumber = normalized
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return addGuardian(tx, c, &guardian)
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo agregar el representante", err.Error())
+		return
+	}
+
+	if guardian.GuardianPatientID != nil {
+		guardian.GuardianPatient = &adult
+	}
+	utils.Success(c, http.StatusCreated, "Representante agregado exitosamente", guardian.ToResponse())
+}
+
+// RemovePatientGuardian godoc
+// @Summary      Quitar representante
+// @Description  Elimina el vínculo con el representante. Si era el principal, el representante más antiguo que quede pasa a serlo
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        guardianId path int true "ID del vínculo con el representante"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/guardians/{guardianId} [delete]
+// @Security BearerAuth
+func RemovePatientGuardian(c *gin.Context) {
+	var guardian models.PatientGuardian
+	db := config.GetDB()
+	if err := db.Where("patient_id = ?", c.Param("id")).First(&guardian, c.Param("guardianId")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Representante no encontrado", nil)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&guardian).Error; err != nil {
+			return err
+		}
+		if guardian.IsPrimary {
+			var next models.PatientGuardian
+			err := tx.Where("patient_id = ?", guardian.PatientID).Order("id").First(&next).Error
+			if err == nil {
+				if err := tx.Model(&next).Update("is_primary", true).Error; err != nil {
+					return err
+				}
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		return recordAudit(tx, c, guardian.TableName(), guardian.ID, models.AuditActionDelete, guardianFields(&guardian), nil)
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo quitar el representante", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Representante eliminado exitosamente", nil)
+}
+
+// GetPatientDependents godoc
+// @Summary      Dependientes del paciente
+// @Description  Lista los pacientes de los que el paciente de la ruta es representante
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del representante"
+// @Success      200 {object} utils.Response{data=[]dtos.DependentResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/dependents [get]
+// @Security BearerAuth
+func GetPatientDependents(c *gin.Context) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	var links []models.PatientGuardian
+	if err := db.Preload("Patient").Where("guardian_patient_id = ?", patient.ID).Order("id").Find(&links).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener dependientes", err.Error())
+		return
+	}
+
+	dependents := []dtos.DependentResponse{}
+	for i := range links {
+		// Los dependientes eliminados no se precargan
+		if links[i].Patient.ID == 0 {
+			continue
+		}
+		links[i].GuardianPatient = &patient
+		dependents = append(dependents, dtos.DependentResponse{
+			Patient:  links[i].Patient.ToResponse(),
+			Guardian: links[i].ToResponse(),
+		})
+	}
+
+	utils.Success(c, http.StatusOK, "Dependientes obtenidos exitosamente", dependents)
+}
+
+// CreateDependent godoc
+// @Summary      Registrar dependiente
+// @Description  Registra a un menor o dependiente sin documento propio bajo el documento del representante de la ruta, con un sufijo de secuencia (V-12345678-01, V-12345678-02...) y tipo "otro", y lo vincula con el representante como principal
+// @Tags         patients
+// @Accept       json
+// @Produce      json
+// @Param        id path int true "ID del representante"
+// @Param        request body dtos.CreateDependentRequest true "Datos del dependiente"
+// @Success      201 {object} utils.Response{data=dtos.DependentResponse}
+// @Failure      400 {object} utils.Response{errors=string} "GUARDIAN_MINOR"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string} "PATIENT_INACTIVE, PATIENT_DOCUMENT_EXISTS"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/dependents [post]
+// @Security BearerAuth
+func CreateDependent(c *gin.Context) {
+	var input dtos.CreateDependentRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var adult models.Patient
+	db := config.GetDB()
+	if err := db.First(&adult, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Representante no encontrado", nil)
+		return
+	}
+	if !adult.IsActive {
+		utils.Error(c, http.StatusConflict, "El representante está inactivo", gin.H{"code": "PATIENT_INACTIVE"})
+		return
+	}
+	if !ensureAdultGuardian(c, &adult) {
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	dependent := models.Patient{
+		DocumentType: utils.DocumentOtro,
+		FirstName:    input.FirstName,
+		LastName:     input.LastName,
+		DateOfBirth:  input.DateOfBirth,
+		Gender:       input.Gender,
+		BloodType:    input.BloodType,
+
+		Address: input.Address,
+		City:    input.City,
+		State:   input.State,
+		Country: input.Country,
+
+		Allergies:         input.Allergies,
+		MedicalConditions: input.MedicalConditions,
+
+		IsActive:  true,
+		CreatedBy: userID.(uint),
+	}
+	if dependent.Address == "" && dependent.City == "" && dependent.State == "" {
+		dependent.Address, dependent.City, dependent.State = adult.Address, adult.City, adult.State
+		if dependent.Country == "" {
+			dependent.Country = adult.Country
+		}
+	}
+	if dependent.Country == "" {
+		dependent.Country = models.DefaultPatientCountry
+	}
+
+	guardian := models.PatientGuardian{
+		GuardianPatientID: &adult.ID,
+		Relationship:      input.Relationship,
+		IsPrimary:         true,
+		CanReceiveResults: input.CanReceiveResults == nil || *input.CanReceiveResults,
+		CreatedBy:         userID.(uint),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// El representante se bloquea para que dos registros simultáneos no tomen el
+		// mismo número de secuencia
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Patient{}, adult.ID).Error; err != nil {
+			return err
+		}
+		sequence, err := nextDependentSequence(tx, adult.DocumentNumber)
+		if err != nil {
+			return err
+		}
+		dependent.DocumentNumber = models.DependentDocument(adult.DocumentNumber, sequence)
+		if err := tx.Create(&dependent).Error; err != nil {
+			return err
+		}
+		if err := recordAudit(tx, c, dependent.TableName(), dependent.ID, models.AuditActionInsert, nil, patientFields(&dependent)); err != nil {
+			return err
+		}
+		guardian.PatientID = dependent.ID
+		return addGuardian(tx, c, &guardian)
+	})
+	if err != nil {
+		patientSaveFailed(c, db, &dependent, "No se pudo registrar el dependiente", err)
+		return
+	}
+
+	guardian.GuardianPatient = &adult
+	utils.Success(c, http.StatusCreated, "Dependiente registrado exitosamente", dtos.DependentResponse{
+		Patient:  dependent.ToResponse(),
+		Guardian: guardian.ToResponse(),
+	})
+}
+
+// nextDependentSequence retorna el siguiente número de secuencia para los dependientes
+// registrados bajo un documento. Cuenta también los eliminados o fusionados, de modo que
+// un documento asignado no se reutilice.
+func nextDependentSequence(tx *gorm.DB, guardianDocument string) (int, error) {
+	var documents []string
+	if err := tx.Unscoped().Model(&models.Patient{}).
+		Where(`document_type = ? AND document_number LIKE ? ESCAPE '\'`, utils.DocumentOtro, utils.EscapeLike(guardianDocument)+"-%").
+		Pluck("document_number", &documents).Error; err != nil {
+		return 0, err
+	}
+
+	last := 0
+	for _, document := range documents {
+		if sequence, err := strconv.Atoi(strings.TrimPrefix(document, guardianDocument+"-")); err == nil && sequence > last {
+			last = sequence
+		}
+	}
+	return last + 1, nil
+}
+
+// addGuardian crea el vínculo con el representante dentro de la transacción. El primer
+// representante del paciente es el principal, y un nuevo principal reemplaza al anterior.
+func addGuardian(tx *gorm.DB, c *gin.Context, guardian *models.PatientGuardian) error {
+	var existing int64
+	if err := tx.Model(&models.PatientGuardian{}).Where("patient_id = ?", guardian.PatientID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing == 0 {
+		guardian.IsPrimary = true
+	} else if guardian.IsPrimary {
+		if err := tx.Model(&models.PatientGuardian{}).Where("patient_id = ?", guardian.PatientID).
+			Update("is_primary", false).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Create(guardian).Error; err != nil {
+		return err
+	}
+	return recordAudit(tx, c, guardian.TableName(), guardian.ID, models.AuditActionInsert, nil, guardianFields(guardian))
+}
+
+// ensureAdultGuardian verifica que el representante sea mayor de edad. Si no lo es
+// responde 400 y retorna false.
+func ensureAdultGuardian(c *gin.Context, adult *models.Patient) bool {
+	if adult.IsMinor() {
+		utils.Error(c, http.StatusBadRequest, "El representante debe ser mayor de edad", gin.H{"code": "GUARDIAN_MINOR"})
+		return false
+	}
+	return true
+}
+
+// responsibleGuardian retorna el representante que actúa por el paciente en una orden o
+// un consentimiento: el indicado o, si no se indica, el principal. Si el representante
+// no corresponde al paciente, o si el paciente es menor y no tiene representante,
+// responde el error y retorna false.
+func responsibleGuardian(c *gin.Context, db *gorm.DB, patient *models.Patient, guardianID *uint) (*models.PatientGuardian, bool) {
+	var guardian models.PatientGuardian
+	query := db.Preload("GuardianPatient").Where("patient_id = ?", patient.ID)
+	if guardianID != nil {
+		if err := query.First(&guardian, *guardianID).Error; err != nil {
+			utils.Error(c, http.StatusBadRequest, "El representante no corresponde al paciente", gin.H{"code": "GUARDIAN_NOT_FOUND"})
+			return nil, false
+		}
+		return &guardian, true
+	}
+	if err := query.Order("is_primary DESC, id").First(&guardian).Error; err != nil {
+		if patient.IsMinor() {
+			utils.Error(c, http.StatusConflict, "El paciente es menor de edad y no tiene representante", gin.H{"code": "GUARDIAN_REQUIRED"})
+			return nil, false
+		}
+		return nil, true
+	}
+	return &guardian, true
+}
+
+// resultRecipients retorna los representantes autorizados a recibir los resultados de
+// la orden, primero el principal
+func resultRecipients(db *gorm.DB, orderID uint) []models.PatientGuardianResponse {
+	var guardians []models.PatientGuardian
+	db.Preload("GuardianPatient").
+		Where("can_receive_results = ? AND patient_id = (SELECT patient_id FROM orders WHERE id = ?)", true, orderID).
+		Order("is_primary DESC, id").Find(&guardians)
+	return models.PatientGuardianResponses(guardians)
+}
+
+// guardianFields retorna los datos del vínculo tal como se registran en la auditoría
+func guardianFields(g *models.PatientGuardian) map[string]interface{} {
+	fields := map[string]interface{}{
+		"patient_id":          g.PatientID,
+		"full_name":           g.FullName,
+		"document_type":       g.DocumentType,
+		"document_number":     g.DocumentNumber,
+		"phone":               g.Phone,
+		"email":               g.Email,
+		"relationship":        g.Relationship,
+		"is_primary":          g.IsPrimary,
+		"can_receive_results": g.CanReceiveResults,
+	}
+	if g.GuardianPatientID != nil {
+		fields["guardian_patient_id"] = *g.GuardianPatientID
+	}
+	return fields
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// GetCumulativeResults godoc
+// @Summary      Acumulado de resultados del paciente
+// @Description  Pivota todos los resultados validados del paciente: una fila por parámetro (con su unidad y rango de referencia) y una columna por día de toma de muestra (YYYY-MM-DD), de la más antigua a la más reciente. Si un parámetro se midió más de una vez el mismo día, cada medición ocupa su propia columna con esa fecha. Las celdas sin resultado son null
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        exam_type_id query int false "Solo los parámetros de este examen"
+// @Param        from query string false "Desde la fecha de la orden (YYYY-MM-DD)"
+// @Param        to query string false "Hasta la fecha de la orden, inclusive (YYYY-MM-DD)"
+// @Success      200 {object} utils.Response{data=dtos.CumulativeResultsResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/results/cumulative [get]
+// @Security BearerAuth
+func GetCumulativeResults(c *gin.Context) {
+	results, ok := loadPatientResults(c, func(query *gorm.DB) *gorm.DB {
+		if examTypeID := c.Query("exam_type_id"); examTypeID != "" {
+			query = query.Where("order_exams.exam_type_id = ?", examTypeID)
+		}
+		return query
+	})
+	if !ok {
+		return
+	}
+
+	// Una columna por día de toma de muestra, recorriendo los resultados en orden
+	// cronológico. Si el mismo parámetro se midió más de una vez ese día (dos exámenes de
+	// la misma orden o dos órdenes), cada medición ocupa su propia columna con la misma
+	// fecha para que ningún resultado reemplace a otro.
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := resultPoint(results[i]), resultPoint(results[j])
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.OrderExamID < b.OrderExamID
+	})
+	points := make([]dtos.ResultPoint, len(results))
+	for i, result := range results {
+		points[i] = resultPoint(result)
+	}
+
+	type columnKey struct {
+		date string
+		slot int
+	}
+	type measurementKey struct {
+		date        string
+		parameterID uint
+	}
+	type columnOrder struct {
+		column  int
+		orderID uint
+	}
+	columnIndex := map[columnKey]int{}
+	columnOrders := map[columnOrder]bool{}
+	columns := []dtos.CumulativeColumn{}
+	measurements := map[measurementKey]int{}
+	resultColumn := make([]int, len(results))
+	for i, point := range points {
+		date := point.Date.Format("2006-01-02")
+		measurement := measurementKey{date, results[i].ExamParameterID}
+		key := columnKey{date, measurements[measurement]}
+		measurements[measurement]++
+
+		column, exists := columnIndex[key]
+		if !exists {
+			column = len(columns)
+			columnIndex[key] = column
+			columns = append(columns, dtos.CumulativeColumn{Date: date, OrderIDs: []uint{}, OrderNumbers: []string{}})
+		}
+		if !columnOrders[columnOrder{column, point.OrderID}] {
+			columnOrders[columnOrder{column, point.OrderID}] = true
+			columns[column].OrderIDs = append(columns[column].OrderIDs, point.OrderID)
+			columns[column].OrderNumbers = append(columns[column].OrderNumbers, point.OrderNumber)
+		}
+		resultColumn[i] = column
+	}
+
+	rowIndex := map[uint]int{}
+	rows := []dtos.CumulativeRow{}
+	displayOrder := map[uint]int{}
+	for i, result := range results {
+		parameter := result.ExamParameter
+		row, exists := rowIndex[parameter.ID]
+		if !exists {
+			row = len(rows)
+			rowIndex[parameter.ID] = row
+			displayOrder[parameter.ID] = parameter.DisplayOrder
+			rows = append(rows, dtos.CumulativeRow{
+				ExamTypeID:      result.OrderExam.ExamTypeID,
+				ExamName:        result.OrderExam.ExamType.Name,
+				ExamParameterID: parameter.ID,
+				Parameter:       parameter.ParameterName,
+				Unit:            parameter.UnitOfMeasure,
+				ReferenceMin:    parameter.ReferenceMin,
+				ReferenceMax:    parameter.ReferenceMax,
+				ReferenceText:   parameter.ReferenceValueText,
+				Values:          make([]*dtos.ResultPoint, len(columns)),
+			})
+		}
+		point := points[i]
+		rows[row].Values[resultColumn[i]] = &point
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].ExamName != rows[j].ExamName {
+			return rows[i].ExamName < rows[j].ExamName
+		}
+		return displayOrder[rows[i].ExamParameterID] < displayOrder[rows[j].ExamParameterID]
+	})
+
+	utils.Success(c, http.StatusOK, "Acumulado de resultados obtenido exitosamente", dtos.CumulativeResultsResponse{
+		Columns: columns,
+		Rows:    rows,
+	})
+}
+
+// GetParameterSeries godoc
+// @Summary      Serie de un parámetro del paciente
+// @Description  Retorna los resultados validados de un parámetro ordenados por fecha de toma de muestra, con la unidad y el rango de referencia, para graficar la tendencia
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        parameterId path int true "ID del parámetro del examen"
+// @Param        from query string false "Desde la fecha de la orden (YYYY-MM-DD)"
+// @Param        to query string false "Hasta la fecha de la orden, inclusive (YYYY-MM-DD)"
+// @Success      200 {object} utils.Response{data=dtos.ParameterSeriesResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/results/parameters/{parameterId}/series [get]
+// @Security BearerAuth
+func GetParameterSeries(c *gin.Context) {
+	var parameter models.ExamParameter
+	db := config.GetDB()
+	if err := db.Preload("ExamType").First(&parameter, c.Param("parameterId")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Parámetro no encontrado", nil)
+		return
+	}
+
+	results, ok := loadPatientResults(c, func(query *gorm.DB) *gorm.DB {
+		return query.Where("exam_results.exam_parameter_id = ?", parameter.ID)
+	})
+	if !ok {
+		return
+	}
+
+	points := make([]dtos.ResultPoint, 0, len(results))
+	for _, result := range results {
+		points = append(points, resultPoint(result))
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Date.Before(points[j].Date) })
+
+	utils.Success(c, http.StatusOK, "Serie obtenida exitosamente", dtos.ParameterSeriesResponse{
+		ExamParameterID: parameter.ID,
+		Parameter:       parameter.ParameterName,
+		Code:            parameter.ParameterCode,
+		ExamName:        parameter.ExamType.Name,
+		DataType:        parameter.DataType,
+		Unit:            parameter.UnitOfMeasure,
+		ReferenceMin:    parameter.ReferenceMin,
+		ReferenceMax:    parameter.ReferenceMax,
+		ReferenceText:   parameter.ReferenceValueText,
+		Points:          points,
+	})
+}
+
+// loadPatientResults carga los resultados vigentes y validados de las órdenes del
+// paciente, aplicando from/to sobre la fecha de la orden y el filtro adicional. Si el
+// paciente no existe o los parámetros son inválidos responde el error y retorna false.
+func loadPatientResults(c *gin.Context, filter func(*gorm.DB) *gorm.DB) ([]models.ExamResult, bool) {
+	var patient models.Patient
+	db := config.GetDB()
+	if err := db.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return nil, false
+	}
+
+	query := db.Model(&models.ExamResult{}).
+		Joins("JOIN order_exams ON order_exams.id = exam_results.order_exam_id AND order_exams.deleted_at IS NULL").
+		Joins("JOIN orders ON orders.id = order_exams.order_id AND orders.deleted_at IS NULL").
+		Where("orders.patient_id = ? AND exam_results.is_current = ? AND exam_results.validated_at IS NOT NULL", patient.ID, true)
+
+	if value := c.Query("from"); value != "" {
+		from, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			utils.Error(c, http.StatusBadRequest, "from debe tener el formato YYYY-MM-DD", nil)
+			return nil, false
+		}
+		query = query.Where("orders.order_date >= ?", from)
+	}
+	if value := c.Query("to"); value != "" {
+		to, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			utils.Error(c, http.StatusBadRequest, "to debe tener el formato YYYY-MM-DD", nil)
+			return nil, false
+		}
+		query = query.Where("orders.order_date < ?", to.AddDate(0, 0, 1))
+	}
+
+	var results []models.ExamResult
+	err := filter(query).
+		Preload("ExamParameter").
+		Preload("OrderExam.ExamType").
+		Preload("OrderExam.Order").
+		Find(&results).Error
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener resultados", err.Error())
+		return nil, false
+	}
+	return results, true
+}
+
+// resultPoint convierte un resultado en un punto fechado con la toma de muestra (o la
+// fecha de la orden si no se registró)
+func resultPoint(result models.ExamResult) dtos.ResultPoint {
+	date := result.OrderExam.Order.OrderDate
+	if result.OrderExam.SampleCollectedAt != nil {
+		date = *result.OrderExam.SampleCollectedAt
+	}
+	return dtos.ResultPoint{
+		Date:         date,
+		OrderID:      result.OrderExam.OrderID,
+		OrderNumber:  result.OrderExam.Order.OrderNumber,
+		OrderExamID:  result.OrderExamID,
+		Value:        result.GetDisplayValue(),
+		ValueNumeric: result.ValueNumeric,
+		Flags:        result.Flags,
+		IsAbnormal:   result.IsAbnormal,
+		IsCritical:   result.IsCritical,
+	}
+}
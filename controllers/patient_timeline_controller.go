@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/middleware"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// GetPatientTimeline godoc
+// @Summary      Línea de tiempo clínica del paciente
+// @Description  Reúne en un solo listado cronológico las órdenes, los cambios de estado de cada examen (toma de muestra, análisis, validación), los resultados validados con sus marcas de anormalidad, las correcciones firmadas y, si el usuario tiene payments:read, los pagos y las facturas. La paginación se informa en "meta"
+// @Tags         patients
+// @Produce      json
+// @Param        id path int true "ID del paciente"
+// @Param        types query string false "Tipos de evento separados por coma (order_created, sample_collected, exam_validated, payment, invoice, ...)"
+// @Param        order query string false "desc (por defecto, más recientes primero) o asc"
+// @Param        page query int false "Página (desde 1)"
+// @Param        limit query int false "Eventos por página (por defecto 50, máximo 200)"
+// @Success      200 {object} utils.PageResponse{data=[]dtos.TimelineEvent}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /patients/{id}/timeline [get]
+// @Security BearerAuth
+func GetPatientTimeline(c *gin.Context) {
+	pagination, err := utils.ParsePagination(c, 50, 200)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	ascending := false
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		ascending = true
+	case "desc":
+	default:
+		utils.Error(c, http.StatusBadRequest, "Orden inválido (asc o desc)", nil)
+		return
+	}
+	types := map[string]bool{}
+	for _, t := range strings.Split(c.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+
+	// Los pagos y las facturas solo se incluyen si el usuario puede ver la facturación
+	billing := middleware.HasPermission(c, "payments", "read")
+
+	var patient models.Patient
+	db := config.GetDB()
+	query := db.
+		Preload("Orders", func(tx *gorm.DB) *gorm.DB { return tx.Order("order_date, id") }).
+		Preload("Orders.OrderExams.ExamType").
+		Preload("Orders.OrderExams.Results", "is_current = ?", true).
+		Preload("Orders.OrderExams.Results.ExamParameter")
+	if billing {
+		query = query.Preload("Orders.Payments")
+	}
+	if err := query.First(&patient, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Paciente no encontrado", nil)
+		return
+	}
+
+	var invoices []models.Invoice
+	if billing {
+		if err := db.Preload("Order").Where("patient_id = ?", patient.ID).Find(&invoices).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al obtener facturas", err.Error())
+			return
+		}
+	}
+
+	orderExamIDs := []uint{}
+	examNames := map[uint]string{}
+	for _, order := range patient.Orders {
+		for _, exam := range order.OrderExams {
+			orderExamIDs = append(orderExamIDs, exam.ID)
+			examNames[exam.ID] = exam.ExamType.Name
+		}
+	}
+	var corrections []models.ResultSignature
+	if len(orderExamIDs) > 0 {
+		if err := db.Where("order_exam_id IN ? AND meaning = ?", orderExamIDs, models.SignatureMeaningCorrected).
+			Find(&corrections).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al obtener firmas", err.Error())
+			return
+		}
+	}
+
+	events := buildTimeline(patient.Orders, invoices, corrections, examNames)
+	if len(types) > 0 {
+		filtered := events[:0]
+		for _, event := range events {
+			if types[event.Type] {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if ascending {
+			return events[i].OccurredAt.Before(events[j].OccurredAt)
+		}
+		return events[i].OccurredAt.After(events[j].OccurredAt)
+	})
+
+	pagination.SetTotal(int64(len(events)))
+	start := min(pagination.Offset(), len(events))
+	end := min(start+pagination.Limit, len(events))
+	utils.SuccessPage(c, http.StatusOK, "Línea de tiempo obtenida exitosamente", events[start:end], pagination)
+}
+
+// buildTimeline genera los eventos de las órdenes (con sus exámenes y pagos), las
+// facturas y las correcciones de resultados, en orden de creación de la orden
+func buildTimeline(orders []models.Order, invoices []models.Invoice, corrections []models.ResultSignature, examNames map[uint]string) []dtos.TimelineEvent {
+	events := []dtos.TimelineEvent{}
+	orderNumbers := map[uint]string{}
+	examOrders := map[uint]uint{}
+
+	for _, order := range orders {
+		orderNumbers[order.ID] = order.OrderNumber
+		base := dtos.TimelineEvent{OrderID: order.ID, OrderNumber: order.OrderNumber}
+
+		created := base
+		created.Type = dtos.TimelineOrderCreated
+		created.OccurredAt = order.OrderDate
+		created.Status = order.Status
+		created.Amount = &order.TotalAmount
+		created.Description = fmt.Sprintf("Orden %s registrada con %d examen(es)", order.OrderNumber, len(order.OrderExams))
+		events = append(events, created)
+
+		if order.CompletedAt != nil {
+			completed := base
+			completed.Type = dtos.TimelineOrderCompleted
+			completed.OccurredAt = *order.CompletedAt
+			completed.Description = fmt.Sprintf("Orden %s completada", order.OrderNumber)
+			events = append(events, completed)
+		}
+		if order.CancelledAt != nil {
+			cancelled := base
+			cancelled.Type = dtos.TimelineOrderCancelled
+			cancelled.OccurredAt = *order.CancelledAt
+			cancelled.Description = fmt.Sprintf("Orden %s cancelada: %s", order.OrderNumber, order.CancellationReason)
+			events = append(events, cancelled)
+		}
+
+		for _, exam := range order.OrderExams {
+			examOrders[exam.ID] = order.ID
+			events = append(events, examTimelineEvents(base, exam)...)
+		}
+
+		for _, payment := range order.Payments {
+			paid := base
+			paid.Type = dtos.TimelinePayment
+			paid.OccurredAt = payment.PaymentDate
+			paid.Status = payment.Status
+			paid.Reference = payment.PaymentNumber
+			paid.Amount = &payment.Amount
+			paid.Description = fmt.Sprintf("Pago %s de %.2f (%s)", payment.PaymentNumber, payment.Amount, payment.PaymentMethod)
+			events = append(events, paid)
+
+			if payment.CancelledAt != nil {
+				cancelled := paid
+				cancelled.Type = dtos.TimelinePaymentCancelled
+				cancelled.OccurredAt = *payment.CancelledAt
+				cancelled.Description = fmt.Sprintf("Pago %s anulado: %s", payment.PaymentNumber, payment.CancellationReason)
+				events = append(events, cancelled)
+			}
+		}
+	}
+
+	for _, invoice := range invoices {
+		issued := dtos.TimelineEvent{
+			Type:        dtos.TimelineInvoice,
+			OccurredAt:  invoice.CreatedAt,
+			OrderID:     invoice.OrderID,
+			OrderNumber: invoice.Order.OrderNumber,
+			Status:      invoice.Status,
+			Reference:   invoice.InvoiceNumber,
+			Amount:      &invoice.TotalAmount,
+			Description: fmt.Sprintf("Factura %s por %.2f", invoice.InvoiceNumber, invoice.TotalAmount),
+		}
+		events = append(events, issued)
+
+		if invoice.CancelledAt != nil {
+			cancelled := issued
+			cancelled.Type = dtos.TimelineInvoiceCancelled
+			cancelled.OccurredAt = *invoice.CancelledAt
+			cancelled.Description = fmt.Sprintf("Factura %s anulada: %s", invoice.InvoiceNumber, invoice.CancellationReason)
+			events = append(events, cancelled)
+		}
+	}
+
+	for _, signature := range corrections {
+		orderID := examOrders[signature.OrderExamID]
+		examID := signature.OrderExamID
+		events = append(events, dtos.TimelineEvent{
+			Type:        dtos.TimelineResultsCorrected,
+			OccurredAt:  signature.SignedAt,
+			OrderID:     orderID,
+			OrderNumber: orderNumbers[orderID],
+			OrderExamID: &examID,
+			ExamName:    examNames[examID],
+			Description: fmt.Sprintf("Resultados de %s corregidos por %s", examNames[examID], signature.SignerName),
+		})
+	}
+	return events
+}
+
+// examTimelineEvents genera los cambios de estado de un examen de la orden. La
+// validación incluye los resultados vigentes con sus marcas de anormalidad.
+func examTimelineEvents(base dtos.TimelineEvent, exam models.OrderExam) []dtos.TimelineEvent {
+	examID := exam.ID
+	base.OrderExamID = &examID
+	base.ExamName = exam.ExamType.Name
+	events := []dtos.TimelineEvent{}
+
+	if exam.SampleCollectedAt != nil {
+		event := base
+		event.Type = dtos.TimelineSampleCollected
+		event.OccurredAt = *exam.SampleCollectedAt
+		event.Description = fmt.Sprintf("Muestra tomada para %s", exam.ExamType.Name)
+		events = append(events, event)
+	}
+	if exam.AnalyzedAt != nil {
+		event := base
+		event.Type = dtos.TimelineExamAnalyzed
+		event.OccurredAt = *exam.AnalyzedAt
+		event.Description = fmt.Sprintf("%s analizado", exam.ExamType.Name)
+		events = append(events, event)
+	}
+	if exam.RejectionReason != "" {
+		event := base
+		event.Type = dtos.TimelineExamRejected
+		event.OccurredAt = exam.UpdatedAt
+		event.Status = exam.Status
+		event.Description = fmt.Sprintf("Muestra de %s rechazada: %s", exam.ExamType.Name, exam.RejectionReason)
+		events = append(events, event)
+	}
+	if exam.ValidatedAt != nil {
+		event := base
+		event.Type = dtos.TimelineExamValidated
+		event.OccurredAt = *exam.ValidatedAt
+		event.Status = exam.Status
+		abnormal := 0
+		for _, result := range exam.Results {
+			if !result.IsValidated() {
+				continue
+			}
+			event.Results = append(event.Results, dtos.TimelineResult{
+				ExamParameterID: result.ExamParameterID,
+				Parameter:       result.ExamParameter.ParameterName,
+				Value:           result.GetDisplayValue(),
+				Unit:            result.ExamParameter.UnitOfMeasure,
+				Flags:           result.Flags,
+				IsAbnormal:      result.IsAbnormal,
+				IsCritical:      result.IsCritical,
+			})
+			if result.IsAbnormal {
+				abnormal++
+				event.HasAbnormal = true
+			}
+			if result.IsCritical {
+				event.HasCritical = true
+			}
+		}
+		event.Description = fmt.Sprintf("%s validado (%d resultado(s), %d fuera de rango)", exam.ExamType.Name, len(event.Results), abnormal)
+		events = append(events, event)
+	}
+	return events
+}
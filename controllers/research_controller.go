@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// Límites de las opciones de la exportación para investigación
+const (
+	researchMaxDateShiftDays = 365
+	researchDefaultMinK      = 5
+	researchDefaultMaxRows   = 100000
+)
+
+// researchDatePrecisions son las precisiones de fecha permitidas. El día exacto solo
+// se publica con fechas desplazadas
+var researchDatePrecisions = map[string]bool{
+	utils.DatePrecisionMonth: true,
+	utils.DatePrecisionWeek:  true,
+	utils.DatePrecisionDay:   true,
+}
+
+// researchAgeBandWidths son los anchos de banda de edad permitidos
+var researchAgeBandWidths = map[int]bool{5: true, 10: true, 20: true}
+
+// researchCSVHeader es el orden de las columnas del CSV, igual a los campos JSON
+var researchCSVHeader = []string{
+	"subject", "age_band", "gender", "state", "sample_date", "exam_code", "exam_name", "category",
+	"parameter_code", "parameter_name", "data_type", "unit", "value_numeric", "value_text",
+	"reference_min", "reference_max", "reference_text", "flags", "is_abnormal", "is_critical",
+}
+
+// ExportResearchResults godoc
+// @Summary      Exportación desidentificada de resultados
+// @Description  Exporta los resultados validados sin datos identificatorios para investigación y estadística: el paciente se reemplaza por un seudónimo (HMAC de su ID con RESEARCH_PSEUDONYM_KEY), la fecha de nacimiento por una banda de edad, la fecha de la muestra por su mes o semana y se omiten nombres, documentos y contactos. Antes de entregar los datos verifica que cada combinación de cuasi-identificadores (banda de edad, sexo, fecha publicada y, opcionalmente, estado) tenga al menos k pacientes. Se limita a RESEARCH_MAX_ROWS filas
+// @Tags         research
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Param        format query string false "csv (por defecto) o ndjson"
+// @Param        exam_type_id query string false "IDs de tipos de examen separados por coma"
+// @Param        parameter_id query string false "IDs de parámetros separados por coma"
+// @Param        from query string false "Fecha de la orden desde (YYYY-MM-DD)"
+// @Param        to query string false "Fecha de la orden hasta, inclusive (YYYY-MM-DD)"
+// @Param        age_band_width query int false "Ancho de las bandas de edad: 5, 10 (por defecto) o 20"
+// @Param        date_shift_days query int false "Desplaza las fechas de cada paciente hasta este número de días (0 por defecto, máximo 365)"
+// @Param        date_precision query string false "month (por defecto), week o day (day exige date_shift_days)"
+// @Param        include_state query bool false "Incluye el estado de residencia como cuasi-identificador"
+// @Param        k query int false "Pacientes mínimos por combinación de cuasi-identificadores (no menor que RESEARCH_MIN_K)"
+// @Param        suppress query bool false "Omite las combinaciones con menos de k pacientes en lugar de rechazar la exportación"
+// @Success      200 {array} dtos.ResearchResultRow
+// @Failure      400 {object} utils.Response{errors=string} "Parámetros inválidos o RESEARCH_TOO_MANY_ROWS"
+// @Failure      409 {object} utils.Response{errors=object} "K_ANONYMITY_NOT_MET: combinaciones (classes) con menos de k pacientes"
+// @Failure      503 {object} utils.Response{errors=string} "La clave de seudonimización no está configurada"
+// @Router       /research/results [get]
+// @Security BearerAuth
+func ExportResearchResults(c *gin.Context) {
+	key := os.Getenv("RESEARCH_PSEUDONYM_KEY")
+	if key == "" {
+		utils.Error(c, http.StatusServiceUnavailable, "La exportación para investigación no está configurada", gin.H{"code": "RESEARCH_KEY_MISSING"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		utils.Error(c, http.StatusBadRequest, "Formato inválido (csv o ndjson)", nil)
+		return
+	}
+	bandWidth, err := strconv.Atoi(c.DefaultQuery("age_band_width", "10"))
+	if err != nil || !researchAgeBandWidths[bandWidth] {
+		utils.Error(c, http.StatusBadRequest, "age_band_width debe ser 5, 10 o 20", nil)
+		return
+	}
+	shiftDays, err := strconv.Atoi(c.DefaultQuery("date_shift_days", "0"))
+	if err != nil || shiftDays < 0 || shiftDays > researchMaxDateShiftDays {
+		utils.Error(c, http.StatusBadRequest, fmt.Sprintf("date_shift_days debe estar entre 0 y %d", researchMaxDateShiftDays), nil)
+		return
+	}
+	precision := c.DefaultQuery("date_precision", utils.DatePrecisionMonth)
+	if !researchDatePrecisions[precision] {
+		utils.Error(c, http.StatusBadRequest, "date_precision debe ser month, week o day", nil)
+		return
+	}
+	// La fecha exacta junto con la edad y el sexo permite reidentificar al paciente
+	if precision == utils.DatePrecisionDay && shiftDays == 0 {
+		utils.Error(c, http.StatusBadRequest, "date_precision=day requiere date_shift_days", nil)
+		return
+	}
+	minK := utils.EnvInt("RESEARCH_MIN_K", researchDefaultMinK)
+	k, err := strconv.Atoi(c.DefaultQuery("k", strconv.Itoa(minK)))
+	if err != nil || k < minK {
+		utils.Error(c, http.StatusBadRequest, fmt.Sprintf("k debe ser al menos %d", minK), nil)
+		return
+	}
+	includeState := c.Query("include_state") == "true"
+	suppress := c.Query("suppress") == "true"
+
+	db := config.GetDB()
+	query := db.Model(&models.ExamResult{}).
+		Joins("JOIN order_exams ON order_exams.id = exam_results.order_exam_id AND order_exams.deleted_at IS NULL").
+		Joins("JOIN orders ON orders.id = order_exams.order_id AND orders.deleted_at IS NULL").
+		Joins("JOIN patients ON patients.id = orders.patient_id AND patients.deleted_at IS NULL").
+		Where("exam_results.is_current = ? AND exam_results.validated_at IS NOT NULL", true)
+
+	filters := map[string]interface{}{}
+	for param, column := range map[string]string{"exam_type_id": "order_exams.exam_type_id", "parameter_id": "exam_results.exam_parameter_id"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		ids, err := parseIDList(value)
+		if err != nil {
+			utils.Error(c, http.StatusBadRequest, fmt.Sprintf("%s debe ser una lista de IDs separados por coma", param), nil)
+			return
+		}
+		query = query.Where(column+" IN ?", ids)
+		filters[param] = value
+	}
+	if value := c.Query("from"); value != "" {
+		from, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			utils.Error(c, http.StatusBadRequest, "from debe tener el formato YYYY-MM-DD", nil)
+			return
+		}
+		query = query.Where("orders.order_date >= ?", from)
+		filters["from"] = value
+	}
+	if value := c.Query("to"); value != "" {
+		to, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			utils.Error(c, http.StatusBadRequest, "to debe tener el formato YYYY-MM-DD", nil)
+			return
+		}
+		query = query.Where("orders.order_date < ?", to.AddDate(0, 0, 1))
+		filters["to"] = value
+	}
+
+	// Todo el resultado se procesa en memoria para verificar k, por lo que se limita
+	maxRows := utils.EnvInt("RESEARCH_MAX_ROWS", researchDefaultMaxRows)
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener resultados", err.Error())
+		return
+	}
+	if total > int64(maxRows) {
+		utils.Error(c, http.StatusBadRequest, fmt.Sprintf("La exportación supera %d filas, acote los filtros", maxRows), gin.H{
+			"code":  "RESEARCH_TOO_MANY_ROWS",
+			"rows":  total,
+			"limit": maxRows,
+		})
+		return
+	}
+
+	var results []models.ExamResult
+	err = query.
+		Preload("ExamParameter").
+		Preload("OrderExam.ExamType.Category").
+		Preload("OrderExam.Order.Patient").
+		Order("exam_results.id").
+		Find(&results).Error
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener resultados", err.Error())
+		return
+	}
+
+	rows := make([]dtos.ResearchResultRow, len(results))
+	for i, result := range results {
+		rows[i] = researchRow(result, []byte(key), bandWidth, shiftDays, precision, includeState)
+	}
+
+	small := smallResearchClasses(rows, k)
+	suppressed := 0
+	if len(small) > 0 {
+		if !suppress {
+			utils.Error(c, http.StatusConflict, fmt.Sprintf("Hay combinaciones de cuasi-identificadores con menos de %d pacientes", k), gin.H{
+				"code":    "K_ANONYMITY_NOT_MET",
+				"classes": small,
+			})
+			return
+		}
+		blocked := map[dtos.ResearchClass]bool{}
+		for _, class := range small {
+			class.Patients = 0
+			blocked[class] = true
+		}
+		kept := rows[:0]
+		for _, row := range rows {
+			if blocked[researchClass(row)] {
+				suppressed++
+				continue
+			}
+			kept = append(kept, row)
+		}
+		rows = kept
+	}
+
+	var body bytes.Buffer
+	contentType := "text/csv; charset=utf-8"
+	if format == "ndjson" {
+		contentType = "application/x-ndjson"
+		encoder := json.NewEncoder(&body)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				utils.Error(c, http.StatusInternalServerError, "Error al generar la exportación", err.Error())
+				return
+			}
+		}
+	} else if err := writeResearchCSV(&body, rows); err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al generar la exportación", err.Error())
+		return
+	}
+
+	// La exportación queda registrada con sus opciones, sin datos de pacientes
+	filters["format"] = format
+	filters["age_band_width"] = bandWidth
+	filters["date_shift_days"] = shiftDays
+	filters["date_precision"] = precision
+	filters["include_state"] = includeState
+	filters["k"] = k
+	filters["rows"] = len(rows)
+	filters["suppressed_rows"] = suppressed
+	if err := recordAudit(db, c, models.ExamResult{}.TableName(), 0, models.AuditActionExport, nil, filters); err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al registrar la exportación", err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="resultados_%s.%s"`, time.Now().Format("20060102150405"), format))
+	c.Header("X-Suppressed-Rows", strconv.Itoa(suppressed))
+	c.Data(http.StatusOK, contentType, body.Bytes())
+}
+
+// researchRow desidentifica un resultado. La edad se calcula a la fecha real de la
+// muestra y luego se desplaza y generaliza la fecha, para que no altere la banda.
+func researchRow(result models.ExamResult, key []byte, bandWidth, shiftDays int, precision string, includeState bool) dtos.ResearchResultRow {
+	exam := result.OrderExam
+	patient := exam.Order.Patient
+	param := result.ExamParameter
+
+	date := exam.Order.OrderDate
+	if exam.SampleCollectedAt != nil {
+		date = *exam.SampleCollectedAt
+	}
+	row := dtos.ResearchResultRow{
+		Subject:       utils.Pseudonym(key, patient.ID),
+		AgeBand:       utils.AgeBand(utils.AgeAt(patient.DateOfBirth, date), bandWidth),
+		Gender:        patient.Gender,
+		SampleDate:    utils.DatePeriod(date.AddDate(0, 0, utils.DateShiftDays(key, patient.ID, shiftDays)), precision),
+		ExamCode:      exam.ExamType.Code,
+		ExamName:      exam.ExamType.Name,
+		Category:      exam.ExamType.Category.Name,
+		ParameterCode: param.ParameterCode,
+		ParameterName: param.ParameterName,
+		DataType:      param.DataType,
+		Unit:          param.UnitOfMeasure,
+		ValueNumeric:  result.ValueNumeric,
+		ReferenceMin:  param.ReferenceMin,
+		ReferenceMax:  param.ReferenceMax,
+		ReferenceText: param.ReferenceValueText,
+		Flags:         result.Flags,
+		IsAbnormal:    result.IsAbnormal,
+		IsCritical:    result.IsCritical,
+	}
+	if result.ValueNumeric == nil {
+		row.ValueText = result.GetDisplayValue()
+	}
+	if includeState {
+		row.State = patient.State
+	}
+	return row
+}
+
+// researchClass retorna la combinación de cuasi-identificadores de una fila, incluida
+// la fecha tal como se publica
+func researchClass(row dtos.ResearchResultRow) dtos.ResearchClass {
+	return dtos.ResearchClass{AgeBand: row.AgeBand, Gender: row.Gender, State: row.State, SampleDate: row.SampleDate}
+}
+
+// smallResearchClasses retorna las combinaciones de cuasi-identificadores con menos de
+// k pacientes distintos, ordenadas
+func smallResearchClasses(rows []dtos.ResearchResultRow, k int) []dtos.ResearchClass {
+	subjects := map[dtos.ResearchClass]map[string]bool{}
+	for _, row := range rows {
+		class := researchClass(row)
+		if subjects[class] == nil {
+			subjects[class] = map[string]bool{}
+		}
+		subjects[class][row.Subject] = true
+	}
+
+	small := []dtos.ResearchClass{}
+	for class, members := range subjects {
+		if len(members) < k {
+			class.Patients = len(members)
+			small = append(small, class)
+		}
+	}
+	sort.Slice(small, func(i, j int) bool {
+		a, b := small[i], small[j]
+		if a.AgeBand != b.AgeBand {
+			return a.AgeBand < b.AgeBand
+		}
+		if a.Gender != b.Gender {
+			return a.Gender < b.Gender
+		}
+		if a.State != b.State {
+			return a.State < b.State
+		}
+		return a.SampleDate < b.SampleDate
+	})
+	return small
+}
+
+// writeResearchCSV escribe las filas con el encabezado researchCSVHeader
+func writeResearchCSV(body *bytes.Buffer, rows []dtos.ResearchResultRow) error {
+	w := csv.NewWriter(body)
+	if err := w.Write(researchCSVHeader); err != nil {
+		return err
+	}
+	optional := func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*value, 'f', -1, 64)
+	}
+	for _, row := range rows {
+		record := []string{
+			row.Subject, row.AgeBand, row.Gender, row.State, row.SampleDate, row.ExamCode, row.ExamName, row.Category,
+			row.ParameterCode, row.ParameterName, row.DataType, row.Unit, optional(row.ValueNumeric), row.ValueText,
+			optional(row.ReferenceMin), optional(row.ReferenceMax), row.ReferenceText, row.Flags,
+			strconv.FormatBool(row.IsAbnormal), strconv.FormatBool(row.IsCritical),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseIDList convierte una lista de IDs separados por coma
+func parseIDList(value string) ([]uint, error) {
+	ids := []uint{}
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/middleware"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// GetPermissionCatalog godoc
+// @Summary      Catálogo de permisos
+// @Description  Lista los recursos y acciones que se pueden asignar a un rol, para construir la matriz de permisos
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} utils.Response{data=[]models.PermissionResource}
+// @Router       /roles/permissions [get]
+func GetPermissionCatalog(c *gin.Context) {
+	utils.Success(c, http.StatusOK, "Catálogo de permisos obtenido exitosamente", models.PermissionCatalog)
+}
+
+// GetRoles godoc
+// @Summary      Listar roles
+// @Description  Obtiene los roles con sus permisos y la cantidad de usuarios asignados
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} utils.Response{data=[]dtos.RoleResponse}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /roles [get]
+func GetRoles(c *gin.Context) {
+	var roles []models.Role
+	db := config.GetDB()
+
+	if err := db.Order("name").Find(&roles).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener roles", err.Error())
+		return
+	}
+
+	response := make([]dtos.RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		var count int64
+		db.Model(&models.User{}).Where("role_id = ?", role.ID).Count(&count)
+		response = append(response, dtos.RoleResponse{Role: role, UserCount: count})
+	}
+	utils.Success(c, http.StatusOK, "Roles obtenidos exitosamente", response)
+}
+
+// GetRoleByID godoc
+// @Summary      Obtener rol por ID
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del rol"
+// @Success      200 {object} utils.Response{data=dtos.RoleResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /roles/{id} [get]
+func GetRoleByID(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	db := config.GetDB()
+
+	if err := db.First(&role, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Rol no encontrado", nil)
+		return
+	}
+
+	var count int64
+	db.Model(&models.User{}).Where("role_id = ?", role.ID).Count(&count)
+	utils.Success(c, http.StatusOK, "Rol obtenido exitosamente", dtos.RoleResponse{Role: role, UserCount: count})
+}
+
+// CreateRole godoc
+// @Summary      Crear rol
+// @Description  Crea un rol con una matriz de permisos validada contra el catálogo
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.CreateRoleRequest true "Datos del rol"
+// @Success      201 {object} utils.Response{data=models.Role}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string} "PERMISSION_NOT_ALLOWED: permisos que el usuario actual no tiene"
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /roles [post]
+func CreateRole(c *gin.Context) {
+	var input dtos.CreateRoleRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	if err := input.Permissions.Validate(); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Permisos inválidos", err.Error())
+		return
+	}
+	db := config.GetDB()
+	if !ensureGrantablePermissions(c, db, input.Permissions) {
+		return
+	}
+
+	role := models.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+		IsActive:    true,
+
+		RequireTwoFactor: input.RequireTwoFactor,
+	}
+
+	if err := db.Create(&role).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo crear el rol (posible duplicado)", err.Error())
+		return
+	}
+
+	// is_active tiene default:true en la columna, por lo que un false se aplica aparte
+	if input.IsActive != nil && !*input.IsActive {
+		if err := db.Model(&role).Update("is_active", false).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "No se pudo crear el rol", err.Error())
+			return
+		}
+	}
+
+	utils.Success(c, http.StatusCreated, "Rol creado exitosamente", role)
+}
+
+// UpdateRole godoc
+// @Summary      Actualizar rol
+// @Description  Actualiza nombre, descripción, estado o permisos de un rol. No permite dejar el sistema sin administradores activos
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del rol"
+// @Param        request body dtos.UpdateRoleRequest true "Campos a actualizar"
+// @Success      200 {object} utils.Response{data=models.Role}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string} "PERMISSION_NOT_ALLOWED: permisos que el usuario actual no tiene"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /roles/{id} [put]
+func UpdateRole(c *gin.Context) {
+	id := c.Param("id")
+	var input dtos.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var role models.Role
+	db := config.GetDB()
+	if err := db.First(&role, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Rol no encontrado", nil)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		updates["name"] = *input.Name
+	}
+	if input.Description != nil {
+		updates["description"] = *input.Description
+	}
+	if input.Permissions != nil {
+		if err := input.Permissions.Validate(); err != nil {
+			utils.Error(c, http.StatusBadRequest, "Permisos inválidos", err.Error())
+			return
+		}
+		if !ensureGrantablePermissions(c, db, input.Permissions) {
+			return
+		}
+		updates["permissions"] = input.Permissions
+	}
+	if input.IsActive != nil {
+		updates["is_active"] = *input.IsActive
+	}
+	if input.RequireTwoFactor != nil {
+		updates["require_two_factor"] = *input.RequireTwoFactor
+	}
+
+	// Si el rol deja de ser administrador, debe quedar otro administrador activo
+	wasAdmin := role.IsActive && role.Permissions.IsFullAccess()
+	staysAdmin := (input.IsActive == nil || *input.IsActive) &&
+		(input.Permissions == nil || input.Permissions.IsFullAccess())
+	if wasAdmin && !staysAdmin {
+		current, err := countActiveAdmins(db, 0, 0)
+		if err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al verificar administradores", err.Error())
+			return
+		}
+		remaining, err := countActiveAdmins(db, role.ID, 0)
+		if err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al verificar administradores", err.Error())
+			return
+		}
+		if current > 0 && remaining == 0 {
+			utils.Error(c, http.StatusConflict, "No se puede quitar el último administrador del sistema", nil)
+			return
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := db.Model(&role).Updates(updates).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "No se pudo actualizar el rol (posible duplicado)", err.Error())
+			return
+		}
+	}
+	middleware.InvalidateRolePermissions(role.ID)
+
+	db.First(&role, role.ID)
+	utils.Success(c, http.StatusOK, "Rol actualizado exitosamente", role)
+}
+
+// DeleteRole godoc
+// @Summary      Eliminar rol
+// @Description  Elimina un rol que no tenga usuarios asignados
+// @Tags         roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del rol"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /roles/{id} [delete]
+func DeleteRole(c *gin.Context) {
+	id := c.Param("id")
+	var role models.Role
+	db := config.GetDB()
+
+	if err := db.First(&role, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Rol no encontrado", nil)
+		return
+	}
+
+	var users int64
+	if err := db.Model(&models.User{}).Where("role_id = ?", role.ID).Count(&users).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar usuarios del rol", err.Error())
+		return
+	}
+	if users > 0 {
+		utils.Error(c, http.StatusConflict, "El rol tiene usuarios asignados", gin.H{"user_count": users})
+		return
+	}
+
+	if err := db.Delete(&role).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "No se pudo eliminar el rol", err.Error())
+		return
+	}
+	middleware.InvalidateRolePermissions(role.ID)
+
+	utils.Success(c, http.StatusOK, "Rol eliminado exitosamente", nil)
+}
+
+// countActiveAdmins cuenta los usuarios activos con acceso total, excluyendo
+// opcionalmente un rol o un usuario para simular su baja
+func countActiveAdmins(db *gorm.DB, excludeRoleID, excludeUserID uint) (int64, error) {
+	var roles []models.Role
+	if err := db.Where("is_active = ?", true).Find(&roles).Error; err != nil {
+		return 0, err
+	}
+
+	roleIDs := make([]uint, 0)
+	for _, role := range roles {
+		if role.ID != excludeRoleID && role.Permissions.IsFullAccess() {
+			roleIDs = append(roleIDs, role.ID)
+		}
+	}
+	if len(roleIDs) == 0 {
+		return 0, nil
+	}
+
+	var count int64
+	query := db.Model(&models.User{}).Where("role_id IN ? AND is_active = ?", roleIDs, true)
+	if excludeUserID != 0 {
+		query = query.Where("id <> ?", excludeUserID)
+	}
+	err := query.Count(&count).Error
+	return count, err
+}
+
+// isActiveAdmin verifica si el usuario es hoy un administrador activo
+func isActiveAdmin(db *gorm.DB, user models.User) (bool, error) {
+	if !user.IsActive {
+		return false, nil
+	}
+	var role models.Role
+	if err := db.First(&role, user.RoleID).Error; err != nil {
+		return false, err
+	}
+	return role.IsActive && role.Permissions.IsFullAccess(), nil
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// GetMySessions godoc
+// @Summary      Mis sesiones activas
+// @Description  Lista las sesiones activas del usuario actual, marcando la sesión desde la que se consulta
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} utils.Response{data=[]dtos.SessionResponse}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /me/sessions [get]
+func GetMySessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var sessions []models.Session
+	if err := activeSessions(config.GetDB()).Where("user_id = ?", userID.(uint)).Find(&sessions).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener las sesiones", err.Error())
+		return
+	}
+	utils.Success(c, http.StatusOK, "Sesiones obtenidas exitosamente", sessionResponses(c, sessions))
+}
+
+// RevokeMySession godoc
+// @Summary      Cerrar una de mis sesiones
+// @Description  Revoca una sesión propia (por ejemplo, un equipo perdido). Sus tokens dejan de ser válidos de inmediato
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID de la sesión"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /me/sessions/{id} [delete]
+func RevokeMySession(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var session models.Session
+	db := config.GetDB()
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID.(uint)).First(&session).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Sesión no encontrada", nil)
+		return
+	}
+	revokeSession(c, db, session, "user_revoked")
+}
+
+// GetMyLoginHistory godoc
+// @Summary      Mi historial de inicio de sesión
+// @Description  Lista los intentos de inicio de sesión (exitosos y fallidos) de la cuenta actual, del más reciente al más antiguo
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit query int false "Máximo de registros (por defecto 50, máximo 200)"
+// @Success      200 {object} utils.Response{data=[]models.LoginAttempt}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /me/login-history [get]
+func GetMyLoginHistory(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var attempts []models.LoginAttempt
+	query := config.GetDB().Where("user_id = ?", userID.(uint)).Order("created_at DESC, id DESC").Limit(historyLimit(c))
+	if err := query.Find(&attempts).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener el historial", err.Error())
+		return
+	}
+	utils.Success(c, http.StatusOK, "Historial obtenido exitosamente", attempts)
+}
+
+// GetSessions godoc
+// @Summary      Listar sesiones de usuarios
+// @Description  Vista administrativa de las sesiones. Por defecto solo las activas; use active=false para incluir revocadas y expiradas
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id query int false "Filtrar por usuario"
+// @Param        active query bool false "Solo sesiones activas (por defecto true)"
+// @Success      200 {object} utils.Response{data=[]dtos.SessionResponse}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /sessions [get]
+func GetSessions(c *gin.Context) {
+	db := config.GetDB()
+	query := db.Preload("User.Role")
+	if c.DefaultQuery("active", "true") != "false" {
+		query = activeSessions(query)
+	} else {
+		query = query.Order("last_seen_at DESC")
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var sessions []models.Session
+	if err := query.Find(&sessions).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener las sesiones", err.Error())
+		return
+	}
+	utils.Success(c, http.StatusOK, "Sesiones obtenidas exitosamente", sessionResponses(c, sessions))
+}
+
+// RevokeSession godoc
+// @Summary      Revocar sesión de un usuario
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID de la sesión"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /sessions/{id} [delete]
+func RevokeSession(c *gin.Context) {
+	var session models.Session
+	db := config.GetDB()
+	if err := db.First(&session, c.Param("id")).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Sesión no encontrada", nil)
+		return
+	}
+	revokeSession(c, db, session, "admin_revoked")
+}
+
+// GetLoginAttempts godoc
+// @Summary      Historial de inicio de sesión
+// @Description  Vista administrativa de los intentos de inicio de sesión de todos los usuarios
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id query int false "Filtrar por usuario"
+// @Param        username query string false "Filtrar por nombre de usuario (incluye usuarios inexistentes)"
+// @Param        success query bool false "Filtrar por resultado"
+// @Param        limit query int false "Máximo de registros (por defecto 50, máximo 200)"
+// @Success      200 {object} utils.Response{data=[]models.LoginAttempt}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /login-attempts [get]
+func GetLoginAttempts(c *gin.Context) {
+	query := config.GetDB().Order("created_at DESC, id DESC").Limit(historyLimit(c))
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", success == "true")
+	}
+
+	var attempts []models.LoginAttempt
+	if err := query.Find(&attempts).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener el historial", err.Error())
+		return
+	}
+	utils.Success(c, http.StatusOK, "Historial obtenido exitosamente", attempts)
+}
+
+// recordLoginAttempt registra un intento de inicio de sesión. Un fallo al guardarlo
+// no debe impedir el login, por lo que el error se ignora.
+func recordLoginAttempt(db *gorm.DB, c *gin.Context, user *models.User, username string, success bool, reason string) {
+	attempt := models.LoginAttempt{
+		Username:  username,
+		Success:   success,
+		Reason:    reason,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+		attempt.Username = user.Username
+	}
+	db.Create(&attempt)
+}
+
+func revokeSession(c *gin.Context, db *gorm.DB, session models.Session, reason string) {
+	if session.RevokedAt == nil {
+		if err := revokeTokenFamily(db, session.FamilyID, reason); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al revocar la sesión", err.Error())
+			return
+		}
+	}
+	utils.Success(c, http.StatusOK, "Sesión revocada exitosamente", nil)
+}
+
+func activeSessions(query *gorm.DB) *gorm.DB {
+	return query.Where("revoked_at IS NULL AND expires_at > ?", time.Now()).Order("last_seen_at DESC")
+}
+
+func sessionResponses(c *gin.Context, sessions []models.Session) []dtos.SessionResponse {
+	current := c.GetString("sessionID")
+	response := make([]dtos.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, dtos.SessionResponse{
+			Session: session,
+			Current: current != "" && session.FamilyID == current,
+		})
+	}
+	return response
+}
+
+func historyLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		return 50
+	}
+	if limit > 200 {
+		return 200
+	}
+	return limit
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errRefreshTokenReused = errors.New("refresh token reutilizado")
+
+// issueTokens emite un token de acceso y un refresh token para el usuario.
+// Si familyID está vacío se inicia una nueva familia y se registra la sesión
+// (nuevo inicio de sesión); si no, se actualiza la actividad de la sesión existente.
+// mfa indica que la sesión se autenticó con segundo factor y se conserva al rotar.
+func issueTokens(tx *gorm.DB, c *gin.Context, user models.User, familyID string, mfa bool) (dtos.TokenResponse, *models.RefreshToken, error) {
+	newSession := familyID == ""
+	if newSession {
+		var err error
+		familyID, err = utils.GenerateOpaqueToken(16)
+		if err != nil {
+			return dtos.TokenResponse{}, nil, err
+		}
+	}
+
+	accessToken, claims, err := utils.IssueAccessToken(utils.Claims{
+		UserID:         user.ID,
+		Username:       user.Username,
+		RoleID:         user.RoleID,
+		MFA:            mfa,
+		PasswordChange: user.MustChangePassword,
+		SessionID:      familyID,
+	})
+	if err != nil {
+		return dtos.TokenResponse{}, nil, err
+	}
+
+	rawRefresh, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return dtos.TokenResponse{}, nil, err
+	}
+
+	now := time.Now()
+	refresh := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(rawRefresh),
+		FamilyID:  familyID,
+		AccessJTI: claims.ID,
+		MFA:       mfa,
+		ExpiresAt: now.Add(utils.RefreshTokenTTL()),
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if err := tx.Create(&refresh).Error; err != nil {
+		return dtos.TokenResponse{}, nil, err
+	}
+
+	if newSession {
+		session := models.Session{
+			UserID:     user.ID,
+			FamilyID:   familyID,
+			MFA:        mfa,
+			IPAddress:  refresh.IPAddress,
+			UserAgent:  refresh.UserAgent,
+			LastSeenAt: now,
+			ExpiresAt:  refresh.ExpiresAt,
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return dtos.TokenResponse{}, nil, err
+		}
+	} else if err := tx.Model(&models.Session{}).Where("family_id = ?", familyID).Updates(map[string]interface{}{
+		"last_seen_at": now,
+		"expires_at":   refresh.ExpiresAt,
+		"ip_address":   refresh.IPAddress,
+		"user_agent":   refresh.UserAgent,
+	}).Error; err != nil {
+		return dtos.TokenResponse{}, nil, err
+	}
+
+	return dtos.TokenResponse{
+		Token:        accessToken,
+		RefreshToken: rawRefresh,
+		ExpiresIn:    int64(utils.AccessTokenTTL().Seconds()),
+	}, &refresh, nil
+}
+
+// applyAuthMode entrega los tokens según el modo de sesión del cliente. En modo cookie
+// se escriben como cookies HttpOnly junto al token CSRF (double-submit) y se quitan del
+// cuerpo para que el JavaScript del navegador nunca los vea.
+func applyAuthMode(c *gin.Context, tokens *dtos.TokenResponse, mode string) error {
+	if mode != utils.AuthModeCookie {
+		return nil
+	}
+
+	csrf, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+	utils.SetAuthCookies(c.Writer, tokens.Token, tokens.RefreshToken, csrf)
+	tokens.Token = ""
+	tokens.RefreshToken = ""
+	tokens.CSRFToken = csrf
+	return nil
+}
+
+// revokeAccessToken agrega el jti a la lista de revocación hasta que expire
+func revokeAccessToken(tx *gorm.DB, jti string, userID uint, expiresAt time.Time, reason string) error {
+	if jti == "" || time.Now().After(expiresAt) {
+		return nil
+	}
+	revoked := models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		Reason:    reason,
+	}
+	return tx.Where(models.RevokedToken{JTI: jti}).FirstOrCreate(&revoked).Error
+}
+
+// revokeTokenFamily revoca la sesión, todos los refresh tokens de la familia y los
+// tokens de acceso emitidos con ellos que aún no han expirado
+func revokeTokenFamily(tx *gorm.DB, familyID, reason string) error {
+	var tokens []models.RefreshToken
+	if err := tx.Where("family_id = ?", familyID).Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", &now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Updates(map[string]interface{}{"revoked_at": &now, "revoke_reason": reason}).Error; err != nil {
+		return err
+	}
+
+	ttl := utils.AccessTokenTTL()
+	for _, token := range tokens {
+		if err := revokeAccessToken(tx, token.AccessJTI, token.UserID, token.CreatedAt.Add(ttl), reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeUserSessions revoca todas las sesiones activas de un usuario
+func revokeUserSessions(tx *gorm.DB, userID uint, reason string) error {
+	var families []string
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Distinct().Pluck("family_id", &families).Error; err != nil {
+		return err
+	}
+
+	for _, familyID := range families {
+		if err := revokeTokenFamily(tx, familyID, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeExpiredRevocations elimina de la lista de revocación los tokens ya expirados
+func purgeExpiredRevocations(tx *gorm.DB) error {
+	return tx.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error
+}
@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// timeNow es el reloj usado para validar códigos TOTP (reemplazable en pruebas)
+var timeNow = time.Now
+
+const recoveryCodeCount = 10
+
+// VerifyTwoFactor godoc
+// @Summary      Completar inicio de sesión con doble factor
+// @Description  Canjea el token de desafío del login y un código TOTP (o de recuperación) por los tokens de sesión
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body dtos.VerifyTwoFactorRequest true "Desafío y código"
+// @Success      200 {object} utils.Response{data=dtos.LoginResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      401 {object} utils.Response{errors=string} "INVALID_TWO_FACTOR_CODE"
+// @Failure      423 {object} utils.Response{errors=string} "ACCOUNT_LOCKED"
+// @Router       /auth/2fa/verify [post]
+func VerifyTwoFactor(c *gin.Context) {
+	var input dtos.VerifyTwoFactorRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	claims, err := utils.ValidateChallengeToken(input.ChallengeToken)
+	if err != nil {
+		utils.Error(c, http.StatusUnauthorized, "Desafío inválido o expirado", nil)
+		return
+	}
+
+	var user models.User
+	db := config.GetDB()
+	if err := db.Preload("Role").First(&user, claims.UserID).Error; err != nil || !user.IsActive || !user.TwoFactorEnabled {
+		utils.Error(c, http.StatusUnauthorized, "Desafío inválido o expirado", nil)
+		return
+	}
+
+	if user.IsLocked() {
+		recordLoginAttempt(db, c, &user, user.Username, false, models.LoginReasonAccountLocked)
+		utils.Error(c, http.StatusLocked, "Cuenta bloqueada temporalmente por intentos fallidos", gin.H{
+			"code":         "ACCOUNT_LOCKED",
+			"locked_until": user.LockedUntil,
+		})
+		return
+	}
+
+	var ok bool
+	if input.Code != "" {
+		ok, err = consumeTOTPCode(db, &user, input.Code)
+	} else {
+		ok, err = consumeRecoveryCode(db, user.ID, input.RecoveryCode)
+	}
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+
+	if !ok {
+		// Los códigos fallidos cuentan para el bloqueo igual que una contraseña incorrecta
+		if err := registerFailedLogin(db, &user); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+			return
+		}
+		recordLoginAttempt(db, c, &user, user.Username, false, models.LoginReasonInvalidTwoFactor)
+		utils.Error(c, http.StatusUnauthorized, "Código de verificación inválido", gin.H{"code": "INVALID_TWO_FACTOR_CODE"})
+		return
+	}
+
+	completeLogin(c, db, user, true, input.Mode, models.LoginReasonTwoFactor)
+}
+
+// SetupTwoFactor godoc
+// @Summary      Iniciar enrolamiento de doble factor
+// @Description  Genera un secreto TOTP y la URI otpauth:// para mostrar como código QR. Se activa con /me/2fa/enable
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} utils.Response{data=dtos.TwoFactorSetupResponse}
+// @Failure      409 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /me/2fa/setup [post]
+func SetupTwoFactor(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, userID.(uint)).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		utils.Error(c, http.StatusConflict, "El doble factor ya está activo", nil)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+	if err := db.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al guardar el secreto", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Escanee el código con su aplicación autenticadora", dtos.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(totpIssuer(), user.Username, secret),
+	})
+}
+
+// EnableTwoFactor godoc
+// @Summary      Activar doble factor
+// @Description  Confirma el secreto con un código TOTP y retorna los códigos de recuperación (solo se muestran una vez)
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.TwoFactorCodeRequest true "Código TOTP"
+// @Success      200 {object} utils.Response{data=dtos.RecoveryCodesResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      409 {object} utils.Response{errors=string}
+// @Router       /me/2fa/enable [post]
+func EnableTwoFactor(c *gin.Context) {
+	var input dtos.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, userID.(uint)).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		utils.Error(c, http.StatusConflict, "El doble factor ya está activo", nil)
+		return
+	}
+	if user.TOTPSecret == "" {
+		utils.Error(c, http.StatusBadRequest, "Debe iniciar el enrolamiento con /me/2fa/setup", nil)
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, input.Code, timeNow())
+	if !ok {
+		utils.Error(c, http.StatusBadRequest, "Código de verificación inválido", gin.H{"code": "INVALID_TWO_FACTOR_CODE"})
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"two_factor_enabled": true,
+			"totp_last_step":     step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al activar el doble factor", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Doble factor activado. Inicie sesión nuevamente y guarde sus códigos de recuperación", dtos.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTwoFactor godoc
+// @Summary      Desactivar doble factor
+// @Description  Requiere la contraseña y un código TOTP vigente. No disponible si el rol exige doble factor
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body dtos.DisableTwoFactorRequest true "Contraseña y código"
+// @Success      200 {object} utils.Response{data=nil}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      401 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string}
+// @Router       /me/2fa/disable [post]
+func DisableTwoFactor(c *gin.Context) {
+	var input dtos.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	var user models.User
+	db := config.GetDB()
+	if err := db.Preload("Role").First(&user, userID.(uint)).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		utils.Error(c, http.StatusBadRequest, "El doble factor no está activo", nil)
+		return
+	}
+	if user.Role.RequireTwoFactor {
+		utils.Error(c, http.StatusForbidden, "Su rol requiere autenticación de dos factores", nil)
+		return
+	}
+
+	if !user.CheckPassword(input.Password) {
+		utils.Error(c, http.StatusUnauthorized, "Contraseña incorrecta", nil)
+		return
+	}
+	ok, err := consumeTOTPCode(db, &user, input.Code)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "error", err.Error())
+		return
+	}
+	if !ok {
+		utils.Error(c, http.StatusUnauthorized, "Código de verificación inválido", gin.H{"code": "INVALID_TWO_FACTOR_CODE"})
+		return
+	}
+
+	if err := clearTwoFactor(db, user.ID); err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al desactivar el doble factor", err.Error())
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Doble factor desactivado exitosamente", nil)
+}
+
+// ResetUserTwoFactor godoc
+// @Summary      Restablecer doble factor de un usuario
+// @Description  Elimina el secreto TOTP y los códigos de recuperación (p. ej. por pérdida del teléfono) y revoca sus sesiones
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id}/2fa [delete]
+func ResetUserTwoFactor(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := clearTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, "two_factor_reset")
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al restablecer el doble factor", err.Error())
+		return
+	}
+
+	db.Preload("Role").First(&user, user.ID)
+	utils.Success(c, http.StatusOK, "Doble factor restablecido exitosamente", user.ToResponse())
+}
+
+// consumeTOTPCode valida el código y registra su intervalo para que no pueda reutilizarse
+func consumeTOTPCode(db *gorm.DB, user *models.User, code string) (bool, error) {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, timeNow())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	user.TOTPLastStep = step
+	return result.RowsAffected == 1, nil
+}
+
+// consumeRecoveryCode marca como usado un código de recuperación válido
+func consumeRecoveryCode(db *gorm.DB, userID uint, code string) (bool, error) {
+	now := time.Now()
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashToken(utils.NormalizeRecoveryCode(code))).
+		Update("used_at", &now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// replaceRecoveryCodes invalida los códigos anteriores y genera un juego nuevo
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		record := models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(utils.NormalizeRecoveryCode(code)),
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// clearTwoFactor elimina el secreto y los códigos de recuperación del usuario
+func clearTwoFactor(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":        "",
+		"two_factor_enabled": false,
+		"totp_last_step":     0,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Laboratorio Clínico"
+}
@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/dtos"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	_ "github.com/cesarbmathec/medical-exams-backend/docs"
+)
+
+// GetMe godoc
+// @Summary      Perfil del usuario actual
+// @Description  Retorna el perfil del usuario autenticado
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /me [get]
+func GetMe(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var user models.User
+	db := config.GetDB()
+
+	if err := db.Preload("Role").First(&user, userID.(uint)).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Perfil obtenido exitosamente", user.ToResponse())
+}
+
+// GetUsers godoc
+// @Summary      Listar usuarios
+// @Description  Obtiene los usuarios del sistema con filtros opcionales
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        role_id query int false "ID del rol"
+// @Param        is_active query bool false "Estado activo"
+// @Param        locked query bool false "Solo usuarios bloqueados"
+// @Param        search query string false "Busca en usuario, nombre o email"
+// @Success      200 {object} utils.Response{data=[]models.UserResponse}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users [get]
+func GetUsers(c *gin.Context) {
+	var users []models.User
+	db := config.GetDB()
+
+	query := db.Preload("Role")
+	if roleID := c.Query("role_id"); roleID != "" {
+		query = query.Where("role_id = ?", roleID)
+	}
+	if isActive := c.Query("is_active"); isActive != "" {
+		query = query.Where("is_active = ?", isActive == "true")
+	}
+	if c.Query("locked") == "true" {
+		query = query.Where("locked_until > ?", time.Now())
+	}
+	if search := c.Query("search"); search != "" {
+		like := "%" + search + "%"
+		query = query.Where("username LIKE ? OR full_name LIKE ? OR email LIKE ?", like, like, like)
+	}
+
+	if err := query.Order("username").Find(&users).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener usuarios", err.Error())
+		return
+	}
+
+	response := make([]models.UserResponse, 0, len(users))
+	for i := range users {
+		response = append(response, users[i].ToResponse())
+	}
+	utils.Success(c, http.StatusOK, "Usuarios obtenidos exitosamente", response)
+}
+
+// GetUserByID godoc
+// @Summary      Obtener usuario por ID
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Router       /users/{id} [get]
+func GetUserByID(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	db := config.GetDB()
+
+	if err := db.Preload("Role").First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	utils.Success(c, http.StatusOK, "Usuario obtenido exitosamente", user.ToResponse())
+}
+
+// UpdateUser godoc
+// @Summary      Actualizar usuario
+// @Description  Actualiza el perfil o el rol de un usuario. Solo se modifican los campos enviados
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Param        request body dtos.UpdateUserRequest true "Campos a actualizar"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      403 {object} utils.Response{errors=string} "PERMISSION_NOT_ALLOWED: el rol concede permisos que el usuario actual no tiene"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id} [put]
+func UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+	var input dtos.UpdateUserRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Email != nil {
+		updates["email"] = *input.Email
+	}
+	if input.FullName != nil {
+		updates["full_name"] = *input.FullName
+	}
+	if input.Phone != nil {
+		updates["phone"] = *input.Phone
+	}
+	if input.RoleID != nil && *input.RoleID != user.RoleID {
+		newRole, err := findActiveRole(db, *input.RoleID)
+		if err != nil {
+			utils.Error(c, http.StatusBadRequest, "Rol inválido o inactivo", nil)
+			return
+		}
+		if !ensureGrantablePermissions(c, db, newRole.Permissions) {
+			return
+		}
+		if !newRole.Permissions.IsFullAccess() && !ensureAnotherAdmin(c, db, user) {
+			return
+		}
+		updates["role_id"] = *input.RoleID
+	}
+
+	if len(updates) > 0 {
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			utils.Error(c, http.StatusInternalServerError, "No se pudo actualizar el usuario (posible duplicado)", err.Error())
+			return
+		}
+	}
+
+	// El rol viaja en el token: si cambia, se cierran las sesiones para que se emita uno nuevo
+	if _, changed := updates["role_id"]; changed {
+		if err := revokeUserSessions(db, user.ID, "role_changed"); err != nil {
+			utils.Error(c, http.StatusInternalServerError, "Error al revocar sesiones", err.Error())
+			return
+		}
+	}
+
+	db.Preload("Role").First(&user, user.ID)
+	utils.Success(c, http.StatusOK, "Usuario actualizado exitosamente", user.ToResponse())
+}
+
+// DeactivateUser godoc
+// @Summary      Desactivar usuario
+// @Description  Impide que el usuario inicie sesión y revoca sus sesiones activas
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      400 {object} utils.Response{errors=string}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id}/deactivate [post]
+func DeactivateUser(c *gin.Context) {
+	setUserActive(c, false)
+}
+
+// ReactivateUser godoc
+// @Summary      Reactivar usuario
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id}/reactivate [post]
+func ReactivateUser(c *gin.Context) {
+	setUserActive(c, true)
+}
+
+func setUserActive(c *gin.Context, active bool) {
+	id := c.Param("id")
+	currentUserID, _ := c.Get("userID")
+
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	if !active && user.ID == currentUserID.(uint) {
+		utils.Error(c, http.StatusBadRequest, "No puede desactivar su propio usuario", nil)
+		return
+	}
+	if !active && !ensureAnotherAdmin(c, db, user) {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("is_active", active).Error; err != nil {
+			return err
+		}
+		if !active {
+			return revokeUserSessions(tx, user.ID, "user_deactivated")
+		}
+		return nil
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al actualizar el usuario", err.Error())
+		return
+	}
+
+	message := "Usuario reactivado exitosamente"
+	if !active {
+		message = "Usuario desactivado exitosamente"
+	}
+	db.Preload("Role").First(&user, user.ID)
+	utils.Success(c, http.StatusOK, message, user.ToResponse())
+}
+
+// ResetUserPassword godoc
+// @Summary      Forzar cambio de contraseña
+// @Description  Asigna una contraseña temporal, obliga a cambiarla en el próximo inicio de sesión y revoca las sesiones activas
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Param        request body dtos.ResetUserPasswordRequest true "Contraseña temporal"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      400 {object} utils.Response{errors=string} "WEAK_PASSWORD o PASSWORD_REUSED"
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id}/reset-password [post]
+func ResetUserPassword(c *gin.Context) {
+	id := c.Param("id")
+	var input dtos.ResetUserPasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, http.StatusBadRequest, "Error de validación", err.Error())
+		return
+	}
+
+	var user models.User
+	db := config.GetDB()
+	if err := db.First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	if !validateNewPassword(c, db, user, input.NewPassword) {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := setUserPassword(tx, &user, input.NewPassword, true); err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, "password_reset")
+	})
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al restablecer la contraseña", err.Error())
+		return
+	}
+
+	db.Preload("Role").First(&user, user.ID)
+	utils.Success(c, http.StatusOK, "Contraseña restablecida exitosamente", user.ToResponse())
+}
+
+// UnlockUser godoc
+// @Summary      Desbloquear usuario
+// @Description  Elimina el bloqueo por intentos fallidos y reinicia el contador del usuario
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path int true "ID del usuario"
+// @Success      200 {object} utils.Response{data=models.UserResponse}
+// @Failure      404 {object} utils.Response{errors=string}
+// @Failure      500 {object} utils.Response{errors=string} "Error interno del servidor"
+// @Router       /users/{id}/unlock [post]
+func UnlockUser(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+	db := config.GetDB()
+
+	if err := db.Preload("Role").First(&user, id).Error; err != nil {
+		utils.Error(c, http.StatusNotFound, "Usuario no encontrado", nil)
+		return
+	}
+
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al desbloquear el usuario", err.Error())
+		return
+	}
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+
+	utils.Success(c, http.StatusOK, "Usuario desbloqueado exitosamente", user.ToResponse())
+}
+
+// ensureAnotherAdmin verifica que, si el usuario es administrador, quede al menos otro
+// administrador activo. Si no, responde 409 y retorna false.
+func ensureAnotherAdmin(c *gin.Context, db *gorm.DB, user models.User) bool {
+	admin, err := isActiveAdmin(db, user)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar administradores", err.Error())
+		return false
+	}
+	if !admin {
+		return true
+	}
+
+	remaining, err := countActiveAdmins(db, 0, user.ID)
+	if err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al verificar administradores", err.Error())
+		return false
+	}
+	if remaining == 0 {
+		utils.Error(c, http.StatusConflict, "No se puede quitar el último administrador del sistema", nil)
+		return false
+	}
+	return true
+}
+
+// ensureGrantablePermissions verifica que el usuario actual tenga todos los permisos que
+// intenta conceder, ya sea al asignar un rol o al definir sus permisos. Conceder acceso
+// total exige tener acceso total. Si no, responde 403 PERMISSION_NOT_ALLOWED.
+func ensureGrantablePermissions(c *gin.Context, db *gorm.DB, permissions models.Permissions) bool {
+	userID, _ := c.Get("userID")
+	var caller models.User
+	if err := db.Preload("Role").First(&caller, userID).Error; err != nil {
+		utils.Error(c, http.StatusInternalServerError, "Error al obtener el usuario", err.Error())
+		return false
+	}
+	granted := caller.Role.Permissions
+	if !caller.Role.IsActive {
+		granted = models.Permissions{}
+	}
+	if denied := permissions.ScopesNotAllowedBy(granted); len(denied) > 0 {
+		utils.Error(c, http.StatusForbidden, "No puede conceder permisos que su rol no tiene", gin.H{"code": "PERMISSION_NOT_ALLOWED", "scopes": denied})
+		return false
+	}
+	return true
+}
+
+// findActiveRole busca un rol activo por ID
+func findActiveRole(db *gorm.DB, roleID uint) (models.Role, error) {
+	var role models.Role
+	err := db.Where("id = ? AND is_active = ?", roleID, true).First(&role).Error
+	return role, err
+}
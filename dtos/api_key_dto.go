@@ -0,0 +1,20 @@
+package dtos
+
+import (
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/models"
+)
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required,min=3,max=100"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"` // Formato recurso:accion, por ejemplo results:write
+	AllowedIPs []string   `json:"allowed_ips"`                     // IPs o rangos CIDR; vacío = cualquiera
+	ExpiresAt  *time.Time `json:"expires_at"`                      // Opcional
+}
+
+// La clave completa solo se muestra en esta respuesta
+type CreateAPIKeyResponse struct {
+	models.APIKeyResponse
+	Key string `json:"key"`
+}
@@ -5,19 +5,97 @@ import "github.com/cesarbmathec/medical-exams-backend/models"
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Mode     string `json:"mode" binding:"omitempty,oneof=bearer cookie"` // "cookie" para sesiones de navegador
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"` // Validada contra la política de contraseñas
 	FullName string `json:"full_name" binding:"required"`
 	RoleID   uint   `json:"role_id" binding:"required"`
 }
 
+// En modo cookie el refresh token se toma de la cookie y el cuerpo puede omitirse
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse agrupa el token de acceso y el refresh token emitidos.
+// En modo cookie los tokens viajan solo en cookies HttpOnly y se retorna el token CSRF.
+type TokenResponse struct {
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"` // Segundos de vigencia del token de acceso
+}
+
 type LoginResponse struct {
-	User  models.UserResponse `json:"user"`
-	Token string              `json:"token"`
+	User models.UserResponse `json:"user"`
+	TokenResponse
+
+	// El rol exige doble factor y el usuario aún no lo activó (solo /me y /me/2fa disponibles)
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+// TwoFactorChallengeResponse se retorna en el login cuando el usuario tiene doble factor activo
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// OIDCLoginResponse contiene la URL del proveedor de identidad a la que debe ir el usuario
+type OIDCLoginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+	ExpiresIn        int64  `json:"expires_in"` // Segundos para completar el inicio de sesión
+}
+
+// OIDCCallbackRequest son los parámetros con los que el proveedor redirige al cliente
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+type VerifyTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode   string `json:"recovery_code" binding:"required_without=Code"`
+	Mode           string `json:"mode" binding:"omitempty,oneof=bearer cookie"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // Para generar el código QR en el cliente
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// Para definir una nueva contraseña con un token de restablecimiento emitido por un administrador
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
 }
 
 type RegisterResponse struct {
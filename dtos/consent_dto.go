@@ -0,0 +1,46 @@
+package dtos
+
+import "github.com/cesarbmathec/medical-exams-backend/models"
+
+// CreateConsentTemplateRequest publica una nueva versión de un consentimiento. La
+// versión se asigna automáticamente a partir de la última del mismo código.
+type CreateConsentTemplateRequest struct {
+	Code              string `json:"code" binding:"required,max=50"`
+	Title             string `json:"title" binding:"required,max=200"`
+	Body              string `json:"body" binding:"required"`
+	RequiredForOrders bool   `json:"required_for_orders"`
+	RequiresReconsent bool   `json:"requires_reconsent"` // Los pacientes deben aceptar de nuevo esta versión
+}
+
+// GrantConsentRequest registra que el paciente aceptó la versión vigente de un
+// consentimiento. Los menores firman a través de su representante (el principal si
+// no se indica).
+type GrantConsentRequest struct {
+	TemplateID uint   `json:"template_id" binding:"required"`
+	GuardianID *uint  `json:"guardian_id"`
+	Notes      string `json:"notes" binding:"max=2000"`
+}
+
+// RevokeConsentRequest revoca un consentimiento con su motivo
+type RevokeConsentRequest struct {
+	Reason string `json:"reason" binding:"required,min=3,max=255"`
+}
+
+// ConsentState es el estado del paciente para la versión vigente de un consentimiento
+type ConsentState struct {
+	Code              string                 `json:"code"`
+	Title             string                 `json:"title"`
+	TemplateID        uint                   `json:"template_id"`     // Versión vigente, la que debe aceptarse
+	CurrentVersion    int                    `json:"current_version"` // Versión vigente
+	MinimumVersion    int                    `json:"minimum_version"` // Versión mínima válida
+	RequiredForOrders bool                   `json:"required_for_orders"`
+	Status            string                 `json:"status"` // granted, revoked, outdated, missing
+	Consent           *models.PatientConsent `json:"consent,omitempty"`
+}
+
+// PatientConsentsResponse es el estado de los consentimientos del paciente y el
+// historial completo de aceptaciones y revocaciones
+type PatientConsentsResponse struct {
+	States  []ConsentState          `json:"states"`
+	History []models.PatientConsent `json:"history"`
+}
@@ -1,11 +1,14 @@
 package dtos
 
+import "github.com/cesarbmathec/medical-exams-backend/models"
+
 // Para crear una orden con múltiples exámenes a la vez
 type CreateOrderRequest struct {
 	PatientID       uint               `json:"patient_id" binding:"required"`
 	Priority        string             `json:"priority" binding:"required,oneof=normal urgente stat"`
 	ReferringDoctor string             `json:"referring_doctor"`
 	Diagnosis       string             `json:"diagnosis"`
+	GuardianID      *uint              `json:"guardian_id"` // Representante del paciente; por defecto el principal
 	Exams           []OrderExamRequest `json:"exams" binding:"required,gt=0"`
 }
 
@@ -20,3 +23,22 @@ type UpdateResultRequest struct {
 	ValueNumeric *float64 `json:"value_numeric"`
 	ValueText    string   `json:"value_text"`
 }
+
+// Para firmar electrónicamente la validación de resultados. El validador se
+// re-autentica con su contraseña o, si tiene doble factor activo, con un código TOTP.
+type ValidateResultsRequest struct {
+	Meaning  string `json:"meaning" binding:"required,oneof=validado corregido"`
+	Password string `json:"password" binding:"required_without=Code"`
+	Code     string `json:"code" binding:"required_without=Password"`
+}
+
+// Detalle de un examen con el manifiesto de firmas de sus resultados
+type OrderExamDetailResponse struct {
+	models.OrderExam
+	Signatures     []models.ResultSignature `json:"signatures"`
+	ResultsHash    string                   `json:"results_hash"`    // Hash de los valores vigentes
+	SignatureValid bool                     `json:"signature_valid"` // La última firma corresponde a los valores vigentes
+
+	// Representantes autorizados a recibir los resultados de un paciente menor o dependiente
+	Guardians []models.PatientGuardianResponse `json:"guardians,omitempty"`
+}
@@ -1,16 +1,180 @@
 package dtos
 
-import "time"
+import (
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/models"
+)
 
 // Definimos lo que esperamos recibir exactamente del cliente
 type CreatePatientRequest struct {
 	DocumentType   string    `json:"document_type" binding:"required,oneof=cedula pasaporte rif otro"`
-	DocumentNumber string    `json:"document_number" binding:"required"`
-	FirstName      string    `json:"first_name" binding:"required"`
-	LastName       string    `json:"last_name" binding:"required"`
-	DateOfBirth    time.Time `json:"date_of_birth" binding:"required"`
+	DocumentNumber string    `json:"document_number" binding:"required,max=50"`
+	FirstName      string    `json:"first_name" binding:"required,max=100"`
+	LastName       string    `json:"last_name" binding:"required,max=100"`
+	DateOfBirth    time.Time `json:"date_of_birth" binding:"required,birthdate"` // No futura, edad máxima MaxPatientAge
 	Gender         string    `json:"gender" binding:"omitempty,oneof=M F O"`
-	Phone          string    `json:"phone"`
-	Email          string    `json:"email" binding:"omitempty,email"`
+	Phone          string    `json:"phone" binding:"omitempty,max=20,phone"`
+	Email          string    `json:"email" binding:"omitempty,max=100,email"`
 	BloodType      string    `json:"blood_type" binding:"omitempty,oneof=A+ A- B+ B- AB+ AB- O+ O-"`
+
+	// Dirección
+	Address string `json:"address" binding:"max=500"`
+	City    string `json:"city" binding:"max=100"`
+	State   string `json:"state" binding:"max=100"`
+	Country string `json:"country" binding:"max=100"` // Venezuela si se omite
+
+	// Contacto de emergencia
+	EmergencyContactName  string `json:"emergency_contact_name" binding:"max=150"`
+	EmergencyContactPhone string `json:"emergency_contact_phone" binding:"omitempty,max=20,phone"`
+
+	// Datos clínicos
+	Allergies         string `json:"allergies" binding:"max=2000"`
+	MedicalConditions string `json:"medical_conditions" binding:"max=2000"`
+}
+
+// UpdatePatientRequest reemplaza todos los datos editables del paciente (PUT)
+type UpdatePatientRequest CreatePatientRequest
+
+// PatchPatientRequest actualiza solo los campos enviados (PATCH).
+// Los campos opcionales pueden vaciarse enviando "".
+type PatchPatientRequest struct {
+	DocumentType   *string    `json:"document_type" binding:"omitnil,oneof=cedula pasaporte rif otro"`
+	DocumentNumber *string    `json:"document_number" binding:"omitnil,min=1,max=50"`
+	FirstName      *string    `json:"first_name" binding:"omitnil,min=1,max=100"`
+	LastName       *string    `json:"last_name" binding:"omitnil,min=1,max=100"`
+	DateOfBirth    *time.Time `json:"date_of_birth" binding:"omitnil,birthdate"`
+	Gender         *string    `json:"gender" binding:"omitnil,omitempty,oneof=M F O"`
+	Phone          *string    `json:"phone" binding:"omitnil,omitempty,max=20,phone"`
+	Email          *string    `json:"email" binding:"omitnil,omitempty,max=100,email"`
+	BloodType      *string    `json:"blood_type" binding:"omitnil,omitempty,oneof=A+ A- B+ B- AB+ AB- O+ O-"`
+
+	Address *string `json:"address" binding:"omitnil,max=500"`
+	City    *string `json:"city" binding:"omitnil,max=100"`
+	State   *string `json:"state" binding:"omitnil,max=100"`
+	Country *string `json:"country" binding:"omitnil,max=100"`
+
+	EmergencyContactName  *string `json:"emergency_contact_name" binding:"omitnil,max=150"`
+	EmergencyContactPhone *string `json:"emergency_contact_phone" binding:"omitnil,omitempty,max=20,phone"`
+
+	Allergies         *string `json:"allergies" binding:"omitnil,max=2000"`
+	MedicalConditions *string `json:"medical_conditions" binding:"omitnil,max=2000"`
+}
+
+// Motivo obligatorio para desactivar o eliminar un paciente
+type PatientStatusRequest struct {
+	Reason string `json:"reason" binding:"required,min=3,max=255"`
+}
+
+// PatientDuplicateCandidate es un par de pacientes que podrían ser la misma persona
+type PatientDuplicateCandidate struct {
+	Patient   models.PatientResponse `json:"patient"`
+	Duplicate models.PatientResponse `json:"duplicate"`
+	Score     float64                `json:"score"`   // De 0 a 1
+	Reasons   []string               `json:"reasons"` // same_document, transposed_document, same_birth_date, similar_name, same_phone, same_email
+}
+
+// MergePatientRequest fusiona el paciente duplicado en el paciente de la ruta, que es
+// el que se conserva
+type MergePatientRequest struct {
+	DuplicateID uint   `json:"duplicate_id" binding:"required"`
+	Reason      string `json:"reason" binding:"required,min=3,max=255"`
+}
+
+// MergePatientResponse resume la fusión: el paciente resultante y los registros que se
+// trasladaron desde el duplicado
+type MergePatientResponse struct {
+	Patient              models.PatientResponse `json:"patient"`
+	MergedPatientID      uint                   `json:"merged_patient_id"`
+	Moved                map[string][]uint      `json:"moved"`                  // IDs trasladados por tabla (orders, invoices, guardians, dependents, consents, exports)
+	RemovedGuardianLinks []uint                 `json:"removed_guardian_links"` // Vínculos con representantes repetidos que se eliminaron
+	RemovedConsents      []uint                 `json:"removed_consents"`       // Consentimientos vigentes del duplicado que el paciente ya tenía
+	FilledFields         []string               `json:"filled_fields"`          // Campos vacíos completados con los datos del duplicado
+}
+
+// Tipos de evento de la línea de tiempo clínica del paciente
+const (
+	TimelineOrderCreated     = "order_created"
+	TimelineOrderCompleted   = "order_completed"
+	TimelineOrderCancelled   = "order_cancelled"
+	TimelineSampleCollected  = "sample_collected"
+	TimelineExamAnalyzed     = "exam_analyzed"
+	TimelineExamRejected     = "exam_rejected"
+	TimelineExamValidated    = "exam_validated"
+	TimelineResultsCorrected = "results_corrected"
+	TimelinePayment          = "payment"
+	TimelinePaymentCancelled = "payment_cancelled"
+	TimelineInvoice          = "invoice"
+	TimelineInvoiceCancelled = "invoice_cancelled"
+)
+
+// TimelineEvent es un evento de la línea de tiempo del paciente. Los campos que no
+// aplican al tipo de evento se omiten.
+type TimelineEvent struct {
+	Type        string           `json:"type"`
+	OccurredAt  time.Time        `json:"occurred_at"`
+	Description string           `json:"description"`
+	OrderID     uint             `json:"order_id"`
+	OrderNumber string           `json:"order_number"`
+	OrderExamID *uint            `json:"order_exam_id,omitempty"`
+	ExamName    string           `json:"exam_name,omitempty"`
+	Status      string           `json:"status,omitempty"`
+	Reference   string           `json:"reference,omitempty"` // Número de pago o de factura
+	Amount      *float64         `json:"amount,omitempty"`
+	HasAbnormal bool             `json:"has_abnormal,omitempty"`
+	HasCritical bool             `json:"has_critical,omitempty"`
+	Results     []TimelineResult `json:"results,omitempty"`
+}
+
+// TimelineResult es un resultado validado dentro de un evento exam_validated
+type TimelineResult struct {
+	ExamParameterID uint   `json:"exam_parameter_id"`
+	Parameter       string `json:"parameter"`
+	Value           string `json:"value"`
+	Unit            string `json:"unit"`
+	Flags           string `json:"flags"` // L, H, C
+	IsAbnormal      bool   `json:"is_abnormal"`
+	IsCritical      bool   `json:"is_critical"`
+}
+
+// AddGuardianRequest vincula un representante al paciente: otro paciente registrado
+// (guardian_patient_id) o un contacto con nombre y documento
+type AddGuardianRequest struct {
+	GuardianPatientID *uint  `json:"guardian_patient_id"`
+	FullName          string `json:"full_name" binding:"required_without=GuardianPatientID,max=150"`
+	DocumentType      string `json:"document_type" binding:"required_with=DocumentNumber,omitempty,oneof=cedula pasaporte rif otro"`
+	DocumentNumber    string `json:"document_number" binding:"max=50"`
+	Phone             string `json:"phone" binding:"omitempty,max=20,phone"`
+	Email             string `json:"email" binding:"omitempty,max=100,email"`
+	Relationship      string `json:"relationship" binding:"required,oneof=madre padre tutor_legal familiar otro"`
+	IsPrimary         bool   `json:"is_primary"`          // El primer representante siempre es el principal
+	CanReceiveResults *bool  `json:"can_receive_results"` // true si se omite
+}
+
+// CreateDependentRequest registra a un menor o dependiente sin documento propio bajo
+// el documento del representante de la ruta. La dirección se toma del representante
+// si se omite.
+type CreateDependentRequest struct {
+	FirstName   string    `json:"first_name" binding:"required,max=100"`
+	LastName    string    `json:"last_name" binding:"required,max=100"`
+	DateOfBirth time.Time `json:"date_of_birth" binding:"required,birthdate"`
+	Gender      string    `json:"gender" binding:"omitempty,oneof=M F O"`
+	BloodType   string    `json:"blood_type" binding:"omitempty,oneof=A+ A- B+ B- AB+ AB- O+ O-"`
+
+	Address string `json:"address" binding:"max=500"`
+	City    string `json:"city" binding:"max=100"`
+	State   string `json:"state" binding:"max=100"`
+	Country string `json:"country" binding:"max=100"`
+
+	Allergies         string `json:"allergies" binding:"max=2000"`
+	MedicalConditions string `json:"medical_conditions" binding:"max=2000"`
+
+	Relationship      string `json:"relationship" binding:"required,oneof=madre padre tutor_legal familiar otro"`
+	CanReceiveResults *bool  `json:"can_receive_results"` // true si se omite
+}
+
+// DependentResponse es un paciente a cargo del representante, con el vínculo
+type DependentResponse struct {
+	Patient  models.PatientResponse         `json:"patient"`
+	Guardian models.PatientGuardianResponse `json:"guardian"`
 }
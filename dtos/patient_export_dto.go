@@ -0,0 +1,83 @@
+package dtos
+
+import (
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/models"
+)
+
+// PatientExportBundle es el contenido de la exportación de datos del paciente. Se
+// entrega como JSON y como documento HTML legible dentro del mismo archivo.
+type PatientExportBundle struct {
+	GeneratedAt time.Time                        `json:"generated_at"`
+	Patient     models.PatientResponse           `json:"patient"`
+	Guardians   []models.PatientGuardianResponse `json:"guardians"`
+	Consents    []models.PatientConsent          `json:"consents"`
+	Orders      []ExportOrder                    `json:"orders"`
+	Invoices    []ExportInvoice                  `json:"invoices"`
+	AccessLog   []ExportAccessEntry              `json:"access_log"`
+}
+
+// ExportOrder es una orden del paciente con sus exámenes, resultados y pagos
+type ExportOrder struct {
+	OrderNumber     string          `json:"order_number"`
+	OrderDate       time.Time       `json:"order_date"`
+	Status          string          `json:"status"`
+	Priority        string          `json:"priority"`
+	ReferringDoctor string          `json:"referring_doctor"`
+	Diagnosis       string          `json:"diagnosis"`
+	GuardianName    string          `json:"guardian_name,omitempty"`
+	TotalAmount     float64         `json:"total_amount"`
+	PaidAmount      float64         `json:"paid_amount"`
+	Balance         float64         `json:"balance"`
+	Exams           []ExportExam    `json:"exams"`
+	Payments        []ExportPayment `json:"payments"`
+}
+
+// ExportExam es un examen de la orden con sus resultados vigentes y validados
+type ExportExam struct {
+	ExamName          string         `json:"exam_name"`
+	Status            string         `json:"status"`
+	SampleCollectedAt *time.Time     `json:"sample_collected_at"`
+	ValidatedAt       *time.Time     `json:"validated_at"`
+	Results           []ExportResult `json:"results"`
+}
+
+// ExportResult es un resultado con su unidad y rango de referencia
+type ExportResult struct {
+	Parameter      string     `json:"parameter"`
+	Value          string     `json:"value"`
+	Unit           string     `json:"unit"`
+	ReferenceRange string     `json:"reference_range"`
+	Flags          string     `json:"flags"`
+	ValidatedAt    *time.Time `json:"validated_at"` // null si aún no fue validado
+}
+
+// ExportPayment es un pago de la orden
+type ExportPayment struct {
+	PaymentNumber string    `json:"payment_number"`
+	PaymentDate   time.Time `json:"payment_date"`
+	Amount        float64   `json:"amount"`
+	PaymentMethod string    `json:"payment_method"`
+	Status        string    `json:"status"`
+}
+
+// ExportInvoice es una factura emitida al paciente
+type ExportInvoice struct {
+	InvoiceNumber string    `json:"invoice_number"`
+	InvoiceDate   time.Time `json:"invoice_date"`
+	OrderNumber   string    `json:"order_number"`
+	TotalAmount   float64   `json:"total_amount"`
+	Status        string    `json:"status"`
+	GuardianName  string    `json:"guardian_name,omitempty"`
+}
+
+// ExportAccessEntry es un registro de la auditoría sobre los datos del paciente
+type ExportAccessEntry struct {
+	Date     time.Time `json:"date"`
+	Table    string    `json:"table"`
+	RecordID uint      `json:"record_id"`
+	Action   string    `json:"action"`
+	Username string    `json:"username"`
+	Fields   []string  `json:"fields,omitempty"` // Campos modificados (sin sus valores)
+}
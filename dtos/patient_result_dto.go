@@ -0,0 +1,62 @@
+package dtos
+
+import "time"
+
+// CumulativeResultsResponse es el acumulado de resultados validados del paciente:
+// cada fila es un parámetro y cada columna un día de toma de muestra, del más antiguo
+// al más reciente
+type CumulativeResultsResponse struct {
+	Columns []CumulativeColumn `json:"columns"`
+	Rows    []CumulativeRow    `json:"rows"`
+}
+
+// CumulativeColumn identifica el día de toma de muestra de una columna y las órdenes
+// de sus resultados. Un día con mediciones repetidas de un parámetro tiene varias
+// columnas con la misma fecha.
+type CumulativeColumn struct {
+	Date         string   `json:"date"` // YYYY-MM-DD
+	OrderIDs     []uint   `json:"order_ids"`
+	OrderNumbers []string `json:"order_numbers"`
+}
+
+// CumulativeRow contiene los valores de un parámetro alineados con las columnas; las
+// columnas en las que no se midió el parámetro quedan en null
+type CumulativeRow struct {
+	ExamTypeID      uint           `json:"exam_type_id"`
+	ExamName        string         `json:"exam_name"`
+	ExamParameterID uint           `json:"exam_parameter_id"`
+	Parameter       string         `json:"parameter"`
+	Unit            string         `json:"unit"`
+	ReferenceMin    *float64       `json:"reference_min"`
+	ReferenceMax    *float64       `json:"reference_max"`
+	ReferenceText   string         `json:"reference_text,omitempty"`
+	Values          []*ResultPoint `json:"values"`
+}
+
+// ResultPoint es un resultado validado en una fecha
+type ResultPoint struct {
+	Date         time.Time `json:"date"`
+	OrderID      uint      `json:"order_id"`
+	OrderNumber  string    `json:"order_number"`
+	OrderExamID  uint      `json:"order_exam_id"`
+	Value        string    `json:"value"`
+	ValueNumeric *float64  `json:"value_numeric"`
+	Flags        string    `json:"flags"` // L, H, C
+	IsAbnormal   bool      `json:"is_abnormal"`
+	IsCritical   bool      `json:"is_critical"`
+}
+
+// ParameterSeriesResponse es la serie temporal de un parámetro, lista para graficar
+// junto con su rango de referencia
+type ParameterSeriesResponse struct {
+	ExamParameterID uint          `json:"exam_parameter_id"`
+	Parameter       string        `json:"parameter"`
+	Code            string        `json:"code"`
+	ExamName        string        `json:"exam_name"`
+	DataType        string        `json:"data_type"`
+	Unit            string        `json:"unit"`
+	ReferenceMin    *float64      `json:"reference_min"`
+	ReferenceMax    *float64      `json:"reference_max"`
+	ReferenceText   string        `json:"reference_text,omitempty"`
+	Points          []ResultPoint `json:"points"`
+}
@@ -0,0 +1,37 @@
+package dtos
+
+// ResearchResultRow es un resultado desidentificado para investigación y estadística.
+// No incluye nombres, documentos ni contactos; el paciente se identifica con un
+// seudónimo y su edad se generaliza a una banda.
+type ResearchResultRow struct {
+	Subject       string   `json:"subject"`  // Seudónimo del paciente
+	AgeBand       string   `json:"age_band"` // Edad a la fecha de la muestra ("30-39", "90+")
+	Gender        string   `json:"gender"`
+	State         string   `json:"state,omitempty"` // Solo con include_state
+	SampleDate    string   `json:"sample_date"`     // Mes (YYYY-MM), semana (YYYY-Www) o día (YYYY-MM-DD, solo desplazada)
+	ExamCode      string   `json:"exam_code"`
+	ExamName      string   `json:"exam_name"`
+	Category      string   `json:"category"`
+	ParameterCode string   `json:"parameter_code"`
+	ParameterName string   `json:"parameter_name"`
+	DataType      string   `json:"data_type"`
+	Unit          string   `json:"unit"`
+	ValueNumeric  *float64 `json:"value_numeric"`
+	ValueText     string   `json:"value_text,omitempty"`
+	ReferenceMin  *float64 `json:"reference_min"`
+	ReferenceMax  *float64 `json:"reference_max"`
+	ReferenceText string   `json:"reference_text,omitempty"`
+	Flags         string   `json:"flags"`
+	IsAbnormal    bool     `json:"is_abnormal"`
+	IsCritical    bool     `json:"is_critical"`
+}
+
+// ResearchClass es una combinación de cuasi-identificadores con menos pacientes que
+// el k exigido
+type ResearchClass struct {
+	AgeBand    string `json:"age_band"`
+	Gender     string `json:"gender"`
+	State      string `json:"state,omitempty"`
+	SampleDate string `json:"sample_date"`
+	Patients   int    `json:"patients"`
+}
@@ -0,0 +1,27 @@
+package dtos
+
+import "github.com/cesarbmathec/medical-exams-backend/models"
+
+type CreateRoleRequest struct {
+	Name        string             `json:"name" binding:"required,min=3,max=50"`
+	Description string             `json:"description"`
+	Permissions models.Permissions `json:"permissions" binding:"required"`
+	IsActive    *bool              `json:"is_active"`
+
+	RequireTwoFactor bool `json:"require_two_factor"`
+}
+
+// Para actualizar un rol (solo se aplican los campos enviados)
+type UpdateRoleRequest struct {
+	Name        *string            `json:"name" binding:"omitempty,min=3,max=50"`
+	Description *string            `json:"description"`
+	Permissions models.Permissions `json:"permissions"`
+	IsActive    *bool              `json:"is_active"`
+
+	RequireTwoFactor *bool `json:"require_two_factor"`
+}
+
+type RoleResponse struct {
+	models.Role
+	UserCount int64 `json:"user_count"`
+}
@@ -0,0 +1,32 @@
+package dtos
+
+import (
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/models"
+)
+
+// Para actualizar el perfil o el rol de un usuario (solo se aplican los campos enviados)
+type UpdateUserRequest struct {
+	Email    *string `json:"email" binding:"omitempty,email"`
+	FullName *string `json:"full_name" binding:"omitempty,min=1"`
+	Phone    *string `json:"phone"`
+	RoleID   *uint   `json:"role_id" binding:"omitempty,gt=0"`
+}
+
+// Para que un administrador asigne una contraseña temporal
+type ResetUserPasswordRequest struct {
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// Token de restablecimiento de un solo uso (solo se muestra al emitirlo)
+type PasswordResetTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Sesión activa con la marca de la sesión desde la que se consulta
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
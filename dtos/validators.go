@@ -0,0 +1,54 @@
+package dtos
+
+import (
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// MaxPatientAge es la edad máxima admitida al registrar la fecha de nacimiento
+const MaxPatientAge = 130
+
+// Validaciones personalizadas usadas en las etiquetas binding de los DTOs:
+//   - phone: teléfono nacional o internacional ("0414-555.12.34", "+58 (212) 555 1234")
+//   - birthdate: fecha de nacimiento no futura y con una edad razonable
+func init() {
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterValidation("phone", validatePhone)
+		engine.RegisterValidation("birthdate", validateBirthdate)
+	}
+}
+
+// IsValidPhone acepta dígitos con un "+" inicial opcional y separadores (espacios,
+// guiones, puntos y paréntesis), con entre 7 y 15 dígitos (E.164)
+func IsValidPhone(phone string) bool {
+	digits := 0
+	for i, r := range phone {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return false
+		}
+	}
+	return digits >= 7 && digits <= 15
+}
+
+// IsValidBirthdate verifica que la fecha no sea futura ni implique más de MaxPatientAge años
+func IsValidBirthdate(date time.Time) bool {
+	now := time.Now()
+	return !date.IsZero() && !date.After(now) && date.After(now.AddDate(-MaxPatientAge-1, 0, 0))
+}
+
+func validatePhone(fl validator.FieldLevel) bool {
+	return IsValidPhone(fl.Field().String())
+}
+
+func validateBirthdate(fl validator.FieldLevel) bool {
+	date, ok := fl.Field().Interface().(time.Time)
+	return ok && IsValidBirthdate(date)
+}
@@ -23,8 +23,10 @@ import (
 	"strings"
 
 	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/controllers"
 	"github.com/cesarbmathec/medical-exams-backend/migrations"
 	"github.com/cesarbmathec/medical-exams-backend/routes"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/joho/godotenv"
 
@@ -38,6 +40,11 @@ func main() {
 		log.Fatal("Error cargando el archivo .env")
 	}
 
+	// Validamos las claves de firma JWT antes de aceptar peticiones
+	if err := utils.LoadJWTKeys(); err != nil {
+		log.Fatal("Configuración JWT inválida: ", err)
+	}
+
 	// Conectamos a la base de datos
 	config.ConnectDatabase()
 	db := config.GetDB()
@@ -45,6 +52,9 @@ func main() {
 	// Ejecutamos Migraciones y Seeding
 	migrations.RunMigrations(db)
 
+	// Reanudamos las exportaciones interrumpidas y purgamos los archivos vencidos
+	controllers.StartPatientExportJobs(db)
+
 	// Usamos el router definido en routes.go
 	r := routes.SetupRouter()
 
@@ -82,7 +92,7 @@ func main() {
 				origin == "wails://wails.127.0.0.1:34115" || origin == "http://localhost:34115" || origin == "http://127.0.0.1:5173"
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With", utils.CSRFHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: allowCredentials,
 	}))
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader es el header con el que los clientes de máquina envían su clave
+const APIKeyHeader = "X-API-Key"
+
+// Tipos de principal autenticado guardados en el contexto ("principal")
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+)
+
+// apiKeyUsageInterval limita la frecuencia con la que se registra el último uso
+const apiKeyUsageInterval = time.Minute
+
+// authenticateAPIKey valida la clave enviada en X-API-Key y registra en el contexto
+// un principal de servicio. Las operaciones se atribuyen al usuario que emitió la clave.
+func authenticateAPIKey(c *gin.Context, rawKey string) {
+	db := config.GetDB()
+
+	var key models.APIKey
+	if err := db.Where("key_hash = ?", utils.HashToken(rawKey)).First(&key).Error; err != nil || !key.IsUsable() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key inválida"})
+		c.Abort()
+		return
+	}
+
+	if !key.AllowsIP(c.ClientIP()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key no permitida desde esta IP"})
+		c.Abort()
+		return
+	}
+
+	var owner models.User
+	if err := db.Select("id", "username", "is_active", "role_id").First(&owner, key.CreatedBy).Error; err != nil || !owner.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key inválida"})
+		c.Abort()
+		return
+	}
+
+	// Los scopes quedan limitados a lo que el rol actual del creador todavía permite
+	role, err := rolePermissions.get(owner.RoleID)
+	if err != nil || !role.isActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key inválida"})
+		c.Abort()
+		return
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageInterval || key.LastUsedIP != c.ClientIP() {
+		db.Model(&key).UpdateColumns(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": c.ClientIP(),
+		})
+	}
+
+	c.Set("principal", PrincipalService)
+	c.Set("apiKeyID", key.ID)
+	c.Set("apiKeyScopes", key.Scopes.RestrictTo(role.permissions))
+	c.Set("userID", owner.ID)
+	c.Set("username", owner.Username)
+	c.Next()
+}
+
+// RequireUserSession restringe una ruta a usuarios autenticados con JWT
+// (perfil, contraseña, doble factor, gestión de API keys)
+func RequireUserSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("principal") != PrincipalUser {
+			utils.Error(c, http.StatusForbidden, "Disponible solo para sesiones de usuario", nil)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
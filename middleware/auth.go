@@ -4,36 +4,100 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/models"
 	"github.com/cesarbmathec/medical-exams-backend/utils"
 	"github.com/gin-gonic/gin"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Se requiere token de autorización"})
-			c.Abort()
+		// Los clientes de máquina se autentican con una API key en lugar de un JWT
+		if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" {
+			authenticateAPIKey(c, apiKey)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Formato de token inválido"})
+		// El encabezado Authorization (cliente de escritorio) tiene prioridad sobre la cookie
+		// de sesión del navegador
+		authMode := utils.AuthModeBearer
+		var tokenString string
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+			if tokenString == authHeader {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Formato de token inválido"})
+				c.Abort()
+				return
+			}
+		} else if cookie, err := c.Cookie(utils.AccessCookieName); err == nil && cookie != "" {
+			tokenString = cookie
+			authMode = utils.AuthModeCookie
+		} else {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Se requiere token de autorización"})
 			c.Abort()
 			return
 		}
+
 		claims, err := utils.ValidateToken(tokenString)
-		if err != nil {
+		if err != nil || claims.ID == "" || claims.ExpiresAt == nil || claims.Purpose != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido"})
 			c.Abort()
 			return
 		}
 
+		// Verificamos que el token no haya sido revocado (logout, reutilización, etc.)
+		var revoked int64
+		if err := config.GetDB().Model(&models.RevokedToken{}).Where("jti = ?", claims.ID).Count(&revoked).Error; err != nil || revoked > 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revocado"})
+			c.Abort()
+			return
+		}
+
+		// Los tokens de una sesión revocada (desde /me/sessions o por un administrador) dejan de valer
+		if claims.SessionID != "" {
+			var active int64
+			if err := config.GetDB().Model(&models.Session{}).
+				Where("family_id = ? AND revoked_at IS NULL", claims.SessionID).
+				Count(&active).Error; err != nil || active == 0 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión revocada"})
+				c.Abort()
+				return
+			}
+		}
+
+		// El navegador adjunta las cookies automáticamente: las operaciones que modifican
+		// estado deben demostrar que vienen del frontend con el token CSRF (double-submit)
+		if authMode == utils.AuthModeCookie && !utils.IsSafeMethod(c.Request.Method) && !utils.ValidCSRF(c.Request) {
+			utils.Error(c, http.StatusForbidden, "Token CSRF inválido", gin.H{"code": "CSRF_TOKEN_INVALID"})
+			c.Abort()
+			return
+		}
+
 		// Guardamos el ID del usuario en el contexto para saber quién opera
+		c.Set("principal", PrincipalUser)
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("roleID", claims.RoleID)
+		c.Set("jti", claims.ID)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("authMode", authMode)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		c.Set("mfa", claims.MFA)
+		c.Set("mustChangePassword", claims.PasswordChange)
+		c.Next()
+	}
+}
+
+// RequirePasswordChanged bloquea las sesiones de usuarios que deben cambiar su
+// contraseña (primer inicio de sesión o contraseña temporal). Las rutas de perfil
+// y /me/password quedan fuera para poder completar el cambio.
+func RequirePasswordChanged() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("mustChangePassword") {
+			utils.Error(c, http.StatusForbidden, "Debe cambiar su contraseña antes de continuar", gin.H{"code": "PASSWORD_CHANGE_REQUIRED"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/config"
+	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type cachedRole struct {
+	permissions      models.Permissions
+	isActive         bool
+	requireTwoFactor bool
+	loadedAt         time.Time
+}
+
+// roleCache guarda en memoria los permisos de cada rol para no consultar
+// la base de datos en cada solicitud
+type roleCache struct {
+	mu    sync.RWMutex
+	roles map[uint]cachedRole
+}
+
+var rolePermissions = &roleCache{roles: make(map[uint]cachedRole)}
+
+// RequirePermission exige que el rol del usuario autenticado (o los scopes de la
+// API key) tenga la acción indicada sobre el recurso. Debe ejecutarse después de AuthMiddleware.
+func RequirePermission(resource, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Las API keys solo pueden usar los scopes que se les asignaron
+		if scopes, ok := c.Get("apiKeyScopes"); ok {
+			if !scopes.(models.Permissions).Allows(resource, action) {
+				utils.Error(c, http.StatusForbidden, "La API key no tiene el scope requerido", gin.H{
+					"resource": resource,
+					"action":   action,
+				})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		roleID, ok := c.Get("roleID")
+		if !ok {
+			utils.Error(c, http.StatusForbidden, "No tiene permisos para realizar esta acción", nil)
+			c.Abort()
+			return
+		}
+
+		role, err := rolePermissions.get(roleID.(uint))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.Error(c, http.StatusForbidden, "No tiene permisos para realizar esta acción", nil)
+			} else {
+				utils.Error(c, http.StatusInternalServerError, "Error al verificar permisos", nil)
+			}
+			c.Abort()
+			return
+		}
+
+		if !role.isActive || !role.permissions.Allows(resource, action) {
+			utils.Error(c, http.StatusForbidden, "No tiene permisos para realizar esta acción", gin.H{
+				"resource": resource,
+				"action":   action,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HasPermission indica si el rol del usuario autenticado (o los scopes de la API key)
+// tiene la acción sobre el recurso. Sirve a los handlers que omiten parte de la respuesta
+// según los permisos; un error al cargar el rol se trata como permiso denegado.
+func HasPermission(c *gin.Context, resource, action string) bool {
+	if scopes, ok := c.Get("apiKeyScopes"); ok {
+		return scopes.(models.Permissions).Allows(resource, action)
+	}
+	roleID, ok := c.Get("roleID")
+	if !ok {
+		return false
+	}
+	role, err := rolePermissions.get(roleID.(uint))
+	return err == nil && role.isActive && role.permissions.Allows(resource, action)
+}
+
+// RequireTwoFactor bloquea las sesiones sin segundo factor de los usuarios cuyo rol
+// lo exige. Las rutas de perfil y enrolamiento quedan fuera para poder activarlo.
+func RequireTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("mfa") {
+			c.Next()
+			return
+		}
+
+		roleID, ok := c.Get("roleID")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		role, err := rolePermissions.get(roleID.(uint))
+		if err == nil && role.requireTwoFactor {
+			utils.Error(c, http.StatusForbidden, "Su rol requiere autenticación de dos factores", gin.H{"code": "TWO_FACTOR_SETUP_REQUIRED"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// InvalidateRolePermissions descarta los permisos en caché de un rol.
+// Debe llamarse cada vez que se modifica o elimina un rol.
+func InvalidateRolePermissions(roleID uint) {
+	rolePermissions.mu.Lock()
+	defer rolePermissions.mu.Unlock()
+	delete(rolePermissions.roles, roleID)
+}
+
+// InvalidateAllRolePermissions vacía la caché de permisos
+func InvalidateAllRolePermissions() {
+	rolePermissions.mu.Lock()
+	defer rolePermissions.mu.Unlock()
+	rolePermissions.roles = make(map[uint]cachedRole)
+}
+
+func (rc *roleCache) get(roleID uint) (cachedRole, error) {
+	ttl := time.Duration(getEnvInt("PERMISSION_CACHE_TTL_SECONDS", 300)) * time.Second
+
+	rc.mu.RLock()
+	entry, ok := rc.roles[roleID]
+	rc.mu.RUnlock()
+	if ok && time.Since(entry.loadedAt) < ttl {
+		return entry, nil
+	}
+
+	var role models.Role
+	if err := config.GetDB().First(&role, roleID).Error; err != nil {
+		return cachedRole{}, err
+	}
+
+	entry = cachedRole{
+		permissions:      role.Permissions,
+		isActive:         role.IsActive,
+		requireTwoFactor: role.RequireTwoFactor,
+		loadedAt:         time.Now(),
+	}
+
+	rc.mu.Lock()
+	rc.roles[roleID] = entry
+	rc.mu.Unlock()
+
+	return entry, nil
+}
@@ -1,11 +1,14 @@
 package migrations
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/cesarbmathec/medical-exams-backend/models"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
 
 	"gorm.io/gorm"
 )
@@ -18,12 +21,17 @@ func RunMigrations(db *gorm.DB) {
 	err := db.AutoMigrate(
 		// Primero las tablas sin dependencias
 		&models.Role{},
+		&models.RolePermissionGrant{},
 		&models.ExamCategory{},
 		&models.SampleType{},
 
 		// Luego las que dependen de las anteriores
 		&models.User{},
 		&models.Patient{},
+		&models.PatientGuardian{},
+		&models.ConsentTemplate{},
+		&models.PatientConsent{},
+		&models.PatientExport{},
 		&models.ExamType{},
 
 		// Luego las que tienen más dependencias
@@ -38,18 +46,156 @@ func RunMigrations(db *gorm.DB) {
 		&models.AuditLog{},
 		&models.Reagent{},
 		&models.Equipment{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.RecoveryCode{},
+		&models.PasswordHistory{},
+		&models.PasswordResetToken{},
+		&models.APIKey{},
+		&models.ResultSignature{},
+		&models.Session{},
+		&models.LoginAttempt{},
+		&models.OIDCAuthRequest{},
+		&models.UserIdentity{},
 	)
 
 	if err != nil {
 		log.Fatal("❌ Migration failed:", err)
 	}
 
+	backfillPatientSearchNames(db)
+	if err := BackfillPatientDocuments(db); err != nil {
+		log.Println("⚠️ ", err)
+	}
+	if err := DeduplicatePatientConsents(db); err != nil {
+		log.Println("⚠️ ", err)
+	}
+	if err := ApplyBuiltinPermissionGrants(db); err != nil {
+		log.Println("⚠️  Could not apply built-in role permissions:", err)
+	}
+	if err := EnforceDefaultAdminPasswordChange(db); err != nil {
+		log.Println("⚠️  Could not check the default admin password:", err)
+	}
+
 	log.Println("✅ Migrations completed successfully!")
 
 	// Crear datos iniciales
 	seedData(db)
 }
 
+// backfillPatientSearchNames calcula el nombre normalizado de búsqueda de los pacientes
+// registrados antes de que existiera la columna search_name
+func backfillPatientSearchNames(db *gorm.DB) {
+	var patients []models.Patient
+	err := db.Unscoped().Select("id", "first_name", "last_name").
+		Where("search_name IS NULL OR search_name = ''").
+		FindInBatches(&patients, 500, func(tx *gorm.DB, batch int) error {
+			for _, patient := range patients {
+				if err := db.Unscoped().Model(&models.Patient{}).Where("id = ?", patient.ID).
+					UpdateColumn("search_name", patient.NormalizedName()).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		log.Println("⚠️  Could not backfill patient search names:", err)
+	}
+}
+
+// BackfillPatientDocuments normaliza los documentos de los pacientes registrados antes
+// de que se guardaran en forma canónica y luego crea el índice único parcial
+// idx_patients_document. Los documentos que no se pueden normalizar se dejan como están
+// y se informan. Si quedan pacientes vigentes con el mismo documento, el índice no se
+// crea y se retorna un error con los grupos, para resolverlos (GET /patients/duplicates
+// y POST /patients/:id/merge) antes del siguiente arranque.
+func BackfillPatientDocuments(db *gorm.DB) error {
+	var patients []models.Patient
+	err := db.Unscoped().Select("id", "document_type", "document_number").
+		FindInBatches(&patients, 500, func(tx *gorm.DB, batch int) error {
+			for _, patient := range patients {
+				number, err := utils.NormalizeDocument(patient.DocumentType, patient.DocumentNumber)
+				if err != nil {
+					log.Printf("⚠️  Patient %d has an invalid document (%s %q): %v", patient.ID, patient.DocumentType, patient.DocumentNumber, err)
+					continue
+				}
+				if number == patient.DocumentNumber {
+					continue
+				}
+				if err := db.Unscoped().Model(&models.Patient{}).Where("id = ?", patient.ID).
+					UpdateColumn("document_number", number).Error; err != nil {
+					log.Printf("⚠️  Could not normalize the document of patient %d (%q → %q): %v", patient.ID, patient.DocumentNumber, number, err)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return fmt.Errorf("could not normalize patient documents: %w", err)
+	}
+
+	var duplicates []struct {
+		DocumentType   string
+		DocumentNumber string
+		IDs            string
+	}
+	err = db.Model(&models.Patient{}).
+		Select("document_type, document_number, " + groupConcatIDs(db) + " AS ids").
+		Group("document_type, document_number").
+		Having("COUNT(*) > 1").
+		Scan(&duplicates).Error
+	if err != nil {
+		return fmt.Errorf("could not check duplicated patient documents: %w", err)
+	}
+	if len(duplicates) > 0 {
+		groups := make([]string, len(duplicates))
+		for i, duplicate := range duplicates {
+			groups[i] = fmt.Sprintf("%s %s (patients %s)", duplicate.DocumentType, duplicate.DocumentNumber, duplicate.IDs)
+		}
+		return fmt.Errorf("index idx_patients_document not created, %d duplicated documents must be merged first: %s",
+			len(duplicates), strings.Join(groups, "; "))
+	}
+
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_patients_document ON patients (document_type, document_number) WHERE deleted_at IS NULL").Error
+}
+
+// DeduplicatePatientConsents elimina (de forma lógica) los consentimientos vigentes
+// repetidos para el mismo paciente y plantilla, conservando el primero, y crea el índice
+// único parcial idx_patient_consents_active que impide registrarlos de nuevo.
+func DeduplicatePatientConsents(db *gorm.DB) error {
+	var duplicates []struct {
+		PatientID  uint
+		TemplateID uint
+		KeepID     uint
+	}
+	err := db.Model(&models.PatientConsent{}).
+		Select("patient_id, template_id, MIN(id) AS keep_id").
+		Where("revoked_at IS NULL").
+		Group("patient_id, template_id").
+		Having("COUNT(*) > 1").
+		Scan(&duplicates).Error
+	if err != nil {
+		return fmt.Errorf("could not check duplicated patient consents: %w", err)
+	}
+	for _, duplicate := range duplicates {
+		result := db.Where("patient_id = ? AND template_id = ? AND revoked_at IS NULL AND id <> ?", duplicate.PatientID, duplicate.TemplateID, duplicate.KeepID).
+			Delete(&models.PatientConsent{})
+		if result.Error != nil {
+			return fmt.Errorf("could not remove duplicated consents of patient %d: %w", duplicate.PatientID, result.Error)
+		}
+		log.Printf("⚠️  Removed %d duplicated consents of patient %d for template %d (kept %d)", result.RowsAffected, duplicate.PatientID, duplicate.TemplateID, duplicate.KeepID)
+	}
+
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_patient_consents_active ON patient_consents (patient_id, template_id) WHERE revoked_at IS NULL AND deleted_at IS NULL").Error
+}
+
+// groupConcatIDs retorna la agregación de IDs separados por coma del motor en uso
+func groupConcatIDs(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "STRING_AGG(CAST(id AS TEXT), ',' ORDER BY id)"
+	}
+	return "GROUP_CONCAT(id)"
+}
+
 // seedData crea los datos iniciales del sistema
 func seedData(db *gorm.DB) {
 	if !shouldSeed() {
@@ -85,8 +231,9 @@ func shouldSeed() bool {
 	return true
 }
 
-func createRoles(db *gorm.DB) {
-	roles := []models.Role{
+// builtinRoles son los roles del sistema con sus permisos base
+func builtinRoles() []models.Role {
+	return []models.Role{
 		{
 			Name:        "admin",
 			Description: "Administrador del sistema",
@@ -98,10 +245,12 @@ func createRoles(db *gorm.DB) {
 			Description: "Bioanalista - Análisis y validación",
 			Permissions: models.Permissions{
 				"orders":   {"read"},
-				"results":  {"read", "write"},
+				"results":  {"read", "write", "validate"},
 				"patients": {"read"},
+				"exams":    {"read"},
 			},
-			IsActive: true,
+			IsActive:         true,
+			RequireTwoFactor: true,
 		},
 		{
 			Name:        "recepcionista",
@@ -110,12 +259,73 @@ func createRoles(db *gorm.DB) {
 				"patients": {"read", "write"},
 				"orders":   {"read", "write"},
 				"payments": {"read", "write"},
+				"exams":    {"read"},
+				"consents": {"read"},
 			},
 			IsActive: true,
 		},
 	}
+}
+
+// builtinPermissionGrants son los permisos que versiones posteriores agregaron a los
+// roles del sistema (los roles nuevos ya los reciben en builtinRoles). Cada uno se aplica
+// una sola vez a los roles existentes; para agregar otro permiso base se suma una entrada
+// con un ID nuevo, sin modificar las anteriores.
+var builtinPermissionGrants = []struct {
+	ID          string
+	Role        string
+	Permissions models.Permissions
+}{
+	{"bioanalista_results_validate", "bioanalista", models.Permissions{"results": {"validate"}}},
+	{"bioanalista_exams_read", "bioanalista", models.Permissions{"exams": {"read"}}},
+	{"recepcionista_exams_read", "recepcionista", models.Permissions{"exams": {"read"}}},
+	{"recepcionista_consents_read", "recepcionista", models.Permissions{"consents": {"read"}}},
+}
 
-	for _, role := range roles {
+// ApplyBuiltinPermissionGrants aplica a los roles del sistema ya existentes los permisos
+// de builtinPermissionGrants que aún no se aplicaron y registra cada uno en
+// role_permission_grants. Se ejecuta en cada migración, también en producción, donde no
+// se ejecuta el seed; un permiso que un administrador quite después no se vuelve a agregar.
+func ApplyBuiltinPermissionGrants(db *gorm.DB) error {
+	for _, grant := range builtinPermissionGrants {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var applied int64
+			if err := tx.Model(&models.RolePermissionGrant{}).Where("id = ?", grant.ID).Count(&applied).Error; err != nil {
+				return err
+			}
+			if applied > 0 {
+				return nil
+			}
+
+			var role models.Role
+			err := tx.Where("name = ?", grant.Role).First(&role).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			if err == nil {
+				if merged, changed := role.Permissions.Merge(grant.Permissions); changed {
+					if err := tx.Model(&role).Update("permissions", merged).Error; err != nil {
+						return err
+					}
+					log.Printf("  ✓ Updated permissions of role: %s (%s)\n", role.Name, grant.ID)
+				}
+			}
+			return tx.Create(&models.RolePermissionGrant{
+				ID:          grant.ID,
+				RoleName:    grant.Role,
+				Permissions: grant.Permissions,
+				AppliedAt:   time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", grant.ID, err)
+		}
+	}
+	return nil
+}
+
+func createRoles(db *gorm.DB) {
+	for _, role := range builtinRoles() {
 		var existingRole models.Role
 		if err := db.Where("name = ?", role.Name).First(&existingRole).Error; err == gorm.ErrRecordNotFound {
 			db.Create(&role)
@@ -124,6 +334,31 @@ func createRoles(db *gorm.DB) {
 	}
 }
 
+// defaultAdminPassword es la contraseña pública con la que el seed crea el usuario admin
+const defaultAdminPassword = "Admin123!"
+
+// EnforceDefaultAdminPasswordChange exige cambiar la contraseña del usuario admin mientras
+// conserve la contraseña por defecto. Cubre las instalaciones sembradas antes de que el
+// seed marcara must_change_password; se ejecuta en cada migración, también en producción.
+func EnforceDefaultAdminPasswordChange(db *gorm.DB) error {
+	var admin models.User
+	err := db.Where("username = ?", "admin").First(&admin).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if admin.MustChangePassword || !admin.CheckPassword(defaultAdminPassword) {
+		return nil
+	}
+	if err := db.Model(&admin).UpdateColumn("must_change_password", true).Error; err != nil {
+		return err
+	}
+	log.Println("  ✓ Admin user still has the default password: must change on next login")
+	return nil
+}
+
 func createAdminUser(db *gorm.DB) {
 	var adminRole models.Role
 	db.Where("name = ?", "admin").First(&adminRole)
@@ -131,16 +366,19 @@ func createAdminUser(db *gorm.DB) {
 	adminUser := models.User{
 		Username: "admin",
 		Email:    "admin@laboratorio.com",
-		Password: "Admin123!",
+		Password: defaultAdminPassword,
 		FullName: "Administrador del Sistema",
 		RoleID:   adminRole.ID,
 		IsActive: true,
+
+		// La contraseña por defecto es pública: se exige cambiarla en el primer inicio de sesión
+		MustChangePassword: true,
 	}
 
 	var existingUser models.User
 	if err := db.Where("username = ?", adminUser.Username).First(&existingUser).Error; err == gorm.ErrRecordNotFound {
 		db.Create(&adminUser)
-		log.Println("  ✓ Created admin user (username: admin, password: Admin123!)")
+		log.Println("  ✓ Created admin user (username: admin, password: Admin123!, must change on first login)")
 	}
 }
 
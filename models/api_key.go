@@ -0,0 +1,200 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIKey es una credencial de larga duración para clientes de máquina (middleware de
+// equipos, kioscos de reportes, integraciones). Solo se guarda el hash de la clave y
+// sus permisos se limitan a los scopes asignados.
+type APIKey struct {
+	BaseModel
+	Name       string      `gorm:"size:100;not null" json:"name"`
+	Prefix     string      `gorm:"size:16;index" json:"prefix"` // Inicio visible de la clave para identificarla
+	KeyHash    string      `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Scopes     Permissions `gorm:"type:jsonb;default:'{}'" json:"scopes"`
+	AllowedIPs string      `gorm:"column:allowed_ips;type:text" json:"-"` // IPs o rangos CIDR separados por coma; vacío = cualquiera
+	ExpiresAt  *time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+	LastUsedIP string      `gorm:"size:45" json:"last_used_ip"`
+	RevokedAt  *time.Time  `json:"revoked_at"`
+	CreatedBy  uint        `gorm:"not null;index" json:"created_by"` // Usuario al que se atribuyen las operaciones
+
+	// Relaciones
+	Creator User `gorm:"foreignKey:CreatedBy" json:"-"`
+}
+
+// TableName especifica el nombre de la tabla
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// IsUsable indica si la clave no está revocada ni expirada
+func (k *APIKey) IsUsable() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// AllowedIPList retorna las IPs o rangos permitidos
+func (k *APIKey) AllowedIPList() []string {
+	if strings.TrimSpace(k.AllowedIPs) == "" {
+		return []string{}
+	}
+	return strings.Split(k.AllowedIPs, ",")
+}
+
+// AllowsIP verifica si la clave puede usarse desde la IP indicada
+func (k *APIKey) AllowsIP(ip string) bool {
+	allowed := k.AllowedIPList()
+	if len(allowed) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes convierte una lista "recurso:accion" en permisos validados contra el
+// catálogo. Las API keys no pueden recibir acceso total ("all").
+func ParseScopes(scopes []string) (Permissions, error) {
+	permissions := Permissions{}
+	for _, scope := range scopes {
+		resource, action, ok := strings.Cut(strings.TrimSpace(scope), ":")
+		if !ok || resource == "" || action == "" {
+			return nil, fmt.Errorf("scope inválido: %s (formato recurso:accion)", scope)
+		}
+		if resource == "all" {
+			return nil, fmt.Errorf("las API keys no admiten el scope %s", scope)
+		}
+		if !containsString(permissions[resource], action) {
+			permissions[resource] = append(permissions[resource], action)
+		}
+	}
+	if err := permissions.Validate(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// ScopesNotAllowedBy retorna los scopes que los permisos indicados no conceden. Un
+// scope "recurso:*" exige todas las acciones del recurso.
+func (p Permissions) ScopesNotAllowedBy(granted Permissions) []string {
+	denied := []string{}
+	for resource, actions := range p {
+		for _, action := range actions {
+			for _, expanded := range expandAction(resource, action) {
+				if !granted.Allows(resource, expanded) {
+					denied = append(denied, resource+":"+action)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(denied)
+	return denied
+}
+
+// RestrictTo retorna los permisos que además concede granted. Se usa para limitar los
+// scopes de una API key a lo que el rol actual de su creador todavía permite.
+func (p Permissions) RestrictTo(granted Permissions) Permissions {
+	restricted := Permissions{}
+	for resource, actions := range p {
+		for _, action := range actions {
+			for _, expanded := range expandAction(resource, action) {
+				if granted.Allows(resource, expanded) && !containsString(restricted[resource], expanded) {
+					restricted[resource] = append(restricted[resource], expanded)
+				}
+			}
+		}
+	}
+	return restricted
+}
+
+// expandAction reemplaza la acción "*" por las acciones del recurso en el catálogo
+func expandAction(resource, action string) []string {
+	if action != "*" {
+		return []string{action}
+	}
+	actions, _ := permissionActions(resource)
+	return actions
+}
+
+// ScopeList retorna los permisos en formato "recurso:accion" ordenados
+func (p Permissions) ScopeList() []string {
+	scopes := []string{}
+	for resource, actions := range p {
+		for _, action := range actions {
+			scopes = append(scopes, resource+":"+action)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// NormalizeAllowedIPs valida una lista de IPs o rangos CIDR y la retorna serializada
+func NormalizeAllowedIPs(entries []string) (string, error) {
+	normalized := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			normalized = append(normalized, network.String())
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return "", fmt.Errorf("IP o rango inválido: %s", entry)
+		}
+		normalized = append(normalized, ip.String())
+	}
+	return strings.Join(normalized, ","), nil
+}
+
+// APIKeyResponse es la representación pública de una API key (sin el hash)
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedBy  uint       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ToResponse convierte APIKey a APIKeyResponse
+func (k *APIKey) ToResponse() APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes.ScopeList(),
+		AllowedIPs: k.AllowedIPList(),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		LastUsedIP: k.LastUsedIP,
+		RevokedAt:  k.RevokedAt,
+		CreatedBy:  k.CreatedBy,
+		CreatedAt:  k.CreatedAt,
+	}
+}
@@ -30,12 +30,24 @@ func (j JSONMap) Value() (driver.Value, error) {
 	return json.Marshal(j)
 }
 
+// Acciones registradas en la auditoría
+const (
+	AuditActionInsert     = "INSERT"
+	AuditActionUpdate     = "UPDATE"
+	AuditActionDelete     = "DELETE"
+	AuditActionDeactivate = "DEACTIVATE"
+	AuditActionRestore    = "RESTORE"
+	AuditActionMerge      = "MERGE"
+	AuditActionExport     = "EXPORT"
+	AuditActionDownload   = "DOWNLOAD"
+)
+
 // AuditLog representa el registro de auditoría del sistema
 type AuditLog struct {
 	ID        uint      `gorm:"primarykey" json:"id"`
 	Table     string    `gorm:"column:table_name;size:100;not null;index:idx_audit_table_record" json:"table_name"`
 	RecordID  uint      `gorm:"not null;index:idx_audit_table_record" json:"record_id"`
-	Action    string    `gorm:"size:10;not null" json:"action"` // INSERT, UPDATE, DELETE
+	Action    string    `gorm:"size:10;not null" json:"action"` // INSERT, UPDATE, DELETE, DEACTIVATE, RESTORE, MERGE, EXPORT
 	OldValues JSONMap   `gorm:"type:jsonb" json:"old_values"`
 	NewValues JSONMap   `gorm:"type:jsonb" json:"new_values"`
 	UserID    *uint     `gorm:"index" json:"user_id"`
@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// Códigos de consentimiento de uso frecuente (las plantillas pueden definir otros)
+const (
+	ConsentDataProcessing  = "data_processing"
+	ConsentHIVTest         = "hiv_test"
+	ConsentShareWithDoctor = "share_with_doctor"
+)
+
+// Estados del consentimiento de un paciente para un código
+const (
+	ConsentStatusGranted  = "granted"
+	ConsentStatusRevoked  = "revoked"
+	ConsentStatusOutdated = "outdated" // Otorgado sobre una versión que ya no es válida
+	ConsentStatusMissing  = "missing"
+)
+
+// ConsentTemplate es una versión del texto de un consentimiento. Las versiones no se
+// modifican: un cambio en el texto se publica como una versión nueva del mismo código.
+type ConsentTemplate struct {
+	BaseModel
+	Code              string `gorm:"size:50;not null;uniqueIndex:idx_consent_templates_code_version" json:"code"`
+	Version           int    `gorm:"not null;uniqueIndex:idx_consent_templates_code_version" json:"version"`
+	Title             string `gorm:"size:200;not null" json:"title"`
+	Body              string `gorm:"type:text;not null" json:"body"`
+	RequiredForOrders bool   `gorm:"default:false" json:"required_for_orders"` // Toda orden lo exige (p. ej. tratamiento de datos)
+	RequiresReconsent bool   `gorm:"default:false" json:"requires_reconsent"`  // Invalida los consentimientos de versiones anteriores
+	CreatedBy         uint   `json:"created_by"`
+}
+
+// TableName especifica el nombre de la tabla
+func (ConsentTemplate) TableName() string {
+	return "consent_templates"
+}
+
+// PatientConsent registra que el paciente (o su representante) aceptó una versión de
+// un consentimiento, y su revocación
+type PatientConsent struct {
+	BaseModel
+	PatientID        uint       `gorm:"not null;index" json:"patient_id"`
+	TemplateID       uint       `gorm:"not null" json:"template_id"`
+	Code             string     `gorm:"size:50;not null;index" json:"code"`
+	Version          int        `gorm:"not null" json:"version"`
+	GrantedAt        time.Time  `gorm:"not null" json:"granted_at"`
+	GrantedBy        uint       `gorm:"not null" json:"granted_by"` // Usuario que registró el consentimiento
+	GuardianID       *uint      `json:"guardian_id,omitempty"`      // Representante que firmó por el paciente
+	SignedBy         string     `gorm:"size:150" json:"signed_by"`  // Nombre de quien aceptó
+	Notes            string     `gorm:"type:text" json:"notes"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy        *uint      `json:"revoked_by,omitempty"`
+	RevocationReason string     `gorm:"size:255" json:"revocation_reason,omitempty"`
+
+	// Relaciones
+	Template *ConsentTemplate `gorm:"foreignKey:TemplateID" json:"template,omitempty"`
+}
+
+// TableName especifica el nombre de la tabla
+func (PatientConsent) TableName() string {
+	return "patient_consents"
+}
+
+// IsRevoked indica si el consentimiento fue revocado
+func (pc *PatientConsent) IsRevoked() bool {
+	return pc.RevokedAt != nil
+}
+
+// Status retorna el estado del consentimiento dada la versión mínima vigente del código
+func (pc *PatientConsent) Status(minVersion int) string {
+	switch {
+	case pc.IsRevoked():
+		return ConsentStatusRevoked
+	case pc.Version < minVersion:
+		return ConsentStatusOutdated
+	default:
+		return ConsentStatusGranted
+	}
+}
+
+// Revoke revoca el consentimiento
+func (pc *PatientConsent) Revoke(userID uint, reason string) {
+	now := time.Now()
+	pc.RevokedAt = &now
+	pc.RevokedBy = &userID
+	pc.RevocationReason = reason
+}
@@ -55,6 +55,7 @@ type ExamType struct {
 	RequiresFasting         bool    `gorm:"default:false" json:"requires_fasting"`
 	FastingHours            int     `json:"fasting_hours"`
 	RequiresAppointment     bool    `gorm:"default:false" json:"requires_appointment"`
+	RequiredConsent         string  `gorm:"size:50" json:"required_consent"` // Código del consentimiento que exige (p. ej. hiv_test)
 	IsActive                bool    `gorm:"default:true" json:"is_active"`
 
 	// Relaciones
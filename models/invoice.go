@@ -27,6 +27,11 @@ type Invoice struct {
 	CancelledAt        *time.Time `json:"cancelled_at"`
 	CancellationReason string     `gorm:"type:text" json:"cancellation_reason"`
 
+	// Representante a cuyo nombre se factura cuando el paciente es menor o dependiente
+	GuardianID       *uint  `json:"guardian_id,omitempty"`
+	GuardianName     string `gorm:"size:150" json:"guardian_name,omitempty"`
+	GuardianDocument string `gorm:"size:75" json:"guardian_document,omitempty"`
+
 	// Relaciones
 	Order           Order   `gorm:"foreignKey:OrderID" json:"order,omitempty"`
 	Patient         Patient `gorm:"foreignKey:PatientID" json:"patient,omitempty"`
@@ -51,6 +56,17 @@ func (i *Invoice) BeforeCreate(tx *gorm.DB) error {
 		i.InvoiceNumber = fmt.Sprintf("INV-%s-%06d", dateStr, count+1)
 	}
 
+	// Se factura al representante registrado en la orden
+	if i.GuardianID == nil && i.OrderID != 0 {
+		var order Order
+		if err := tx.Model(&Order{}).Select("guardian_id", "guardian_name", "guardian_document").Where("id = ?", i.OrderID).Scan(&order).Error; err != nil {
+			return err
+		}
+		i.GuardianID = order.GuardianID
+		i.GuardianName = order.GuardianName
+		i.GuardianDocument = order.GuardianDocument
+	}
+
 	// Calcular totales si no están definidos
 	if i.TotalAmount == 0 {
 		i.calculateTotals()
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// OIDCAuthRequest guarda el estado de un inicio de sesión OIDC en curso: el
+// code_verifier de PKCE y el nonce nunca salen del servidor.
+type OIDCAuthRequest struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	StateHash    string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	CodeVerifier string     `gorm:"size:128;not null" json:"-"`
+	Nonce        string     `gorm:"size:64;not null" json:"-"`
+	Mode         string     `gorm:"size:10" json:"mode"`       // Modo de sesión solicitado (bearer o cookie)
+	LinkUserID   *uint      `gorm:"index" json:"link_user_id"` // Usuario que vincula su cuenta; nulo en un inicio de sesión
+	ExpiresAt    time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt       *time.Time `json:"used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (OIDCAuthRequest) TableName() string {
+	return "oidc_auth_requests"
+}
+
+// IsUsable indica si el estado no fue canjeado ni expiró
+func (r *OIDCAuthRequest) IsUsable() bool {
+	return r.UsedAt == nil && time.Now().Before(r.ExpiresAt)
+}
+
+// UserIdentity vincula un usuario local con su cuenta en un proveedor de identidad
+// externo, identificada por el emisor y el sub del ID token
+type UserIdentity struct {
+	BaseModel
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Issuer      string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject" json:"issuer"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject" json:"subject"`
+	Email       string     `gorm:"size:100" json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+
+	// Relaciones
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// TableName especifica el nombre de la tabla
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -34,6 +35,11 @@ type Order struct {
 	CancelledBy        *uint      `json:"cancelled_by"`
 	CancellationReason string     `gorm:"type:text" json:"cancellation_reason"`
 
+	// Representante del paciente menor o dependiente al momento de la orden
+	GuardianID       *uint  `json:"guardian_id,omitempty"`
+	GuardianName     string `gorm:"size:150" json:"guardian_name,omitempty"`
+	GuardianDocument string `gorm:"size:75" json:"guardian_document,omitempty"`
+
 	// Relaciones
 	Patient    Patient     `gorm:"foreignKey:PatientID" json:"patient,omitempty"`
 	Creator    User        `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
@@ -45,6 +51,15 @@ func (Order) TableName() string {
 	return "orders"
 }
 
+// SetGuardian registra en la orden los datos del representante (debe tener precargado
+// el paciente vinculado, si lo hay)
+func (o *Order) SetGuardian(guardian *PatientGuardian) {
+	documentType, documentNumber := guardian.GuardianDocument()
+	o.GuardianID = &guardian.ID
+	o.GuardianName = guardian.GuardianName()
+	o.GuardianDocument = strings.TrimSpace(documentType + " " + documentNumber)
+}
+
 // BeforeCreate genera el número de orden automáticamente
 func (o *Order) BeforeCreate(tx *gorm.DB) error {
 	if o.OrderNumber == "" {
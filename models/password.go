@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+)
+
+// PasswordHistory guarda los hashes de contraseñas anteriores para impedir su reutilización
+type PasswordHistory struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	PasswordHash string    `gorm:"size:255;not null" json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (PasswordHistory) TableName() string {
+	return "password_histories"
+}
+
+// Matches verifica si la contraseña coincide con este hash anterior
+func (h *PasswordHistory) Matches(password string) bool {
+	return utils.VerifyPassword(h.PasswordHash, password)
+}
+
+// PasswordResetToken es un token de un solo uso emitido por un administrador para
+// que el usuario defina una nueva contraseña. Solo se guarda el hash del token.
+type PasswordResetToken struct {
+	BaseModel
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedBy uint       `json:"created_by"`
+}
+
+// TableName especifica el nombre de la tabla
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// IsUsable indica si el token no se ha usado ni ha expirado
+func (t *PasswordResetToken) IsUsable() bool {
+	return t.UsedAt == nil && time.Now().Before(t.ExpiresAt)
+}
@@ -1,14 +1,23 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+	"gorm.io/gorm"
+)
 
 // Patient representa un paciente del laboratorio
 type Patient struct {
 	BaseModel
+	// El documento es único entre los pacientes no eliminados y se guarda normalizado
+	// (utils.NormalizeDocument). El índice único parcial idx_patients_document lo crea
+	// la migración después de normalizar los registros existentes.
 	DocumentType          string    `gorm:"size:20;not null" json:"document_type" binding:"required,oneof=cedula pasaporte rif otro"`
 	DocumentNumber        string    `gorm:"size:50;not null" json:"document_number" binding:"required"`
 	FirstName             string    `gorm:"size:100;not null" json:"first_name" binding:"required"`
 	LastName              string    `gorm:"size:100;not null" json:"last_name" binding:"required"`
+	SearchName            string    `gorm:"size:255;index" json:"-"` // Nombre completo sin acentos ni mayúsculas (utils.NormalizeName)
 	DateOfBirth           time.Time `gorm:"type:date;not null" json:"date_of_birth" binding:"required"`
 	Gender                string    `gorm:"size:1" json:"gender" binding:"omitempty,oneof=M F O"`
 	Phone                 string    `gorm:"size:20" json:"phone"`
@@ -25,6 +34,15 @@ type Patient struct {
 	IsActive              bool      `gorm:"default:true" json:"is_active"`
 	CreatedBy             uint      `json:"created_by"`
 
+	// Desactivación y eliminación lógica (el detalle queda en la auditoría)
+	DeactivatedAt      *time.Time `json:"deactivated_at,omitempty"`
+	DeactivationReason string     `gorm:"size:255" json:"deactivation_reason,omitempty"`
+	DeletionReason     string     `gorm:"size:255" json:"deletion_reason,omitempty"`
+
+	// Fusión de duplicados: el registro fusionado queda eliminado y apunta al que se conserva
+	MergedIntoID *uint      `gorm:"index" json:"merged_into_id,omitempty"`
+	MergedAt     *time.Time `json:"merged_at,omitempty"`
+
 	// Relaciones
 	Creator User    `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
 	Orders  []Order `gorm:"foreignKey:PatientID" json:"orders,omitempty"`
@@ -35,6 +53,27 @@ func (Patient) TableName() string {
 	return "patients"
 }
 
+// BeforeSave mantiene el nombre normalizado que usan las búsquedas
+func (p *Patient) BeforeSave(tx *gorm.DB) error {
+	p.SearchName = p.NormalizedName()
+	return nil
+}
+
+// NormalizedName retorna el nombre completo sin acentos y en minúsculas
+func (p *Patient) NormalizedName() string {
+	return utils.NormalizeName(p.FirstName + " " + p.LastName)
+}
+
+// IsDeleted indica si el paciente fue eliminado (borrado lógico)
+func (p *Patient) IsDeleted() bool {
+	return p.DeletedAt.Valid
+}
+
+// IsMerged indica si el paciente fue fusionado con otro registro
+func (p *Patient) IsMerged() bool {
+	return p.MergedIntoID != nil
+}
+
 // GetAge calcula la edad del paciente
 func (p *Patient) GetAge() int {
 	now := time.Now()
@@ -45,7 +84,106 @@ func (p *Patient) GetAge() int {
 	return age
 }
 
+// IsMinor indica si el paciente es menor de edad y requiere un representante
+func (p *Patient) IsMinor() bool {
+	return p.GetAge() < AdultAge
+}
+
 // GetFullName retorna el nombre completo
 func (p *Patient) GetFullName() string {
 	return p.FirstName + " " + p.LastName
 }
+
+// DefaultPatientCountry es el país que se asigna cuando no se indica
+const DefaultPatientCountry = "Venezuela"
+
+// PatientResponse es la representación pública del paciente, sin las relaciones
+// (creador y órdenes) del modelo
+type PatientResponse struct {
+	ID             uint      `json:"id"`
+	DocumentType   string    `json:"document_type"`
+	DocumentNumber string    `json:"document_number"`
+	FirstName      string    `json:"first_name"`
+	LastName       string    `json:"last_name"`
+	FullName       string    `json:"full_name"`
+	DateOfBirth    time.Time `json:"date_of_birth"`
+	Age            int       `json:"age"`
+	Gender         string    `json:"gender"`
+	Phone          string    `json:"phone"`
+	Email          string    `json:"email"`
+	BloodType      string    `json:"blood_type"`
+
+	Address string `json:"address"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Country string `json:"country"`
+
+	EmergencyContactName  string `json:"emergency_contact_name"`
+	EmergencyContactPhone string `json:"emergency_contact_phone"`
+
+	Allergies         string `json:"allergies"`
+	MedicalConditions string `json:"medical_conditions"`
+
+	IsActive           bool       `json:"is_active"`
+	DeactivatedAt      *time.Time `json:"deactivated_at,omitempty"`
+	DeactivationReason string     `json:"deactivation_reason,omitempty"`
+	DeletedAt          *time.Time `json:"deleted_at,omitempty"`
+	DeletionReason     string     `json:"deletion_reason,omitempty"`
+	MergedIntoID       *uint      `json:"merged_into_id,omitempty"`
+	MergedAt           *time.Time `json:"merged_at,omitempty"`
+	CreatedBy          uint       `json:"created_by"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// ToResponse convierte Patient a PatientResponse
+func (p *Patient) ToResponse() PatientResponse {
+	response := PatientResponse{
+		ID:             p.ID,
+		DocumentType:   p.DocumentType,
+		DocumentNumber: p.DocumentNumber,
+		FirstName:      p.FirstName,
+		LastName:       p.LastName,
+		FullName:       p.GetFullName(),
+		DateOfBirth:    p.DateOfBirth,
+		Age:            p.GetAge(),
+		Gender:         p.Gender,
+		Phone:          p.Phone,
+		Email:          p.Email,
+		BloodType:      p.BloodType,
+
+		Address: p.Address,
+		City:    p.City,
+		State:   p.State,
+		Country: p.Country,
+
+		EmergencyContactName:  p.EmergencyContactName,
+		EmergencyContactPhone: p.EmergencyContactPhone,
+
+		Allergies:         p.Allergies,
+		MedicalConditions: p.MedicalConditions,
+
+		IsActive:           p.IsActive,
+		DeactivatedAt:      p.DeactivatedAt,
+		DeactivationReason: p.DeactivationReason,
+		DeletionReason:     p.DeletionReason,
+		MergedIntoID:       p.MergedIntoID,
+		MergedAt:           p.MergedAt,
+		CreatedBy:          p.CreatedBy,
+		CreatedAt:          p.CreatedAt,
+		UpdatedAt:          p.UpdatedAt,
+	}
+	if p.DeletedAt.Valid {
+		response.DeletedAt = &p.DeletedAt.Time
+	}
+	return response
+}
+
+// PatientResponses convierte una lista de pacientes
+func PatientResponses(patients []Patient) []PatientResponse {
+	responses := make([]PatientResponse, len(patients))
+	for i := range patients {
+		responses[i] = patients[i].ToResponse()
+	}
+	return responses
+}
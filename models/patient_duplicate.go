@@ -0,0 +1,139 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/cesarbmathec/medical-exams-backend/utils"
+)
+
+// Motivos por los que dos pacientes se consideran posibles duplicados
+const (
+	DuplicateReasonSameDocument       = "same_document"
+	DuplicateReasonTransposedDocument = "transposed_document"
+	DuplicateReasonSameBirthDate      = "same_birth_date"
+	DuplicateReasonSimilarName        = "similar_name"
+	DuplicateReasonSamePhone          = "same_phone"
+	DuplicateReasonSameEmail          = "same_email"
+)
+
+// Peso de cada coincidencia en el puntaje de duplicado (el total se limita a 1)
+const (
+	duplicateWeightSameDocument       = 0.45
+	duplicateWeightTransposedDocument = 0.35
+	duplicateWeightBirthDate          = 0.30
+	duplicateWeightName               = 0.35
+	duplicateWeightPhone              = 0.20
+	duplicateWeightEmail              = 0.20
+)
+
+// minNameSimilarity es la similitud Jaro-Winkler a partir de la cual dos nombres se
+// consideran parecidos ("Jose Perez" y "José Pérez", "Maria" y "Marai")
+const minNameSimilarity = 0.85
+
+// DuplicateScore compara dos pacientes y retorna un puntaje entre 0 y 1 junto con los
+// motivos que lo explican. Un puntaje de 0 indica que no hay coincidencias.
+func DuplicateScore(a, b *Patient) (float64, []string) {
+	score := 0.0
+	reasons := []string{}
+
+	docA, docB := documentDigits(a.DocumentNumber), documentDigits(b.DocumentNumber)
+	switch {
+	case docA != "" && docA == docB:
+		score += duplicateWeightSameDocument
+		reasons = append(reasons, DuplicateReasonSameDocument)
+	case isAdjacentTransposition(docA, docB):
+		score += duplicateWeightTransposedDocument
+		reasons = append(reasons, DuplicateReasonTransposedDocument)
+	}
+
+	if a.DateOfBirth.Format("2006-01-02") == b.DateOfBirth.Format("2006-01-02") {
+		score += duplicateWeightBirthDate
+		reasons = append(reasons, DuplicateReasonSameBirthDate)
+	}
+
+	if similarity := nameSimilarity(a, b); similarity >= minNameSimilarity {
+		score += duplicateWeightName * similarity
+		reasons = append(reasons, DuplicateReasonSimilarName)
+	}
+
+	if phone := phoneKey(a.Phone); phone != "" && phone == phoneKey(b.Phone) {
+		score += duplicateWeightPhone
+		reasons = append(reasons, DuplicateReasonSamePhone)
+	}
+
+	if email := strings.ToLower(strings.TrimSpace(a.Email)); email != "" && email == strings.ToLower(strings.TrimSpace(b.Email)) {
+		score += duplicateWeightEmail
+		reasons = append(reasons, DuplicateReasonSameEmail)
+	}
+
+	return math.Round(math.Min(score, 1)*100) / 100, reasons
+}
+
+// DuplicateBlockKeys retorna las claves de agrupación del paciente: solo se comparan
+// los pacientes que comparten al menos una (fecha de nacimiento, teléfono, correo o los
+// dígitos del documento en cualquier orden, que cubre las transposiciones)
+func DuplicateBlockKeys(p *Patient) []string {
+	keys := []string{"dob:" + p.DateOfBirth.Format("2006-01-02")}
+	if digits := documentDigits(p.DocumentNumber); digits != "" {
+		sorted := []byte(digits)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		keys = append(keys, "doc:"+string(sorted))
+	}
+	if phone := phoneKey(p.Phone); phone != "" {
+		keys = append(keys, "phone:"+phone)
+	}
+	if email := strings.ToLower(strings.TrimSpace(p.Email)); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	return keys
+}
+
+// nameSimilarity compara nombre con nombre y apellido con apellido, sin acentos, y
+// también invertidos. Se toma la menor similitud de las dos partes, de modo que un
+// apellido distinto descarte la coincidencia aunque el nombre sea parecido.
+func nameSimilarity(a, b *Patient) float64 {
+	firstA, lastA := utils.NormalizeName(a.FirstName), utils.NormalizeName(a.LastName)
+	firstB, lastB := utils.NormalizeName(b.FirstName), utils.NormalizeName(b.LastName)
+	return math.Max(
+		math.Min(utils.JaroWinkler(firstA, firstB), utils.JaroWinkler(lastA, lastB)),
+		math.Min(utils.JaroWinkler(firstA, lastB), utils.JaroWinkler(lastA, firstB)),
+	)
+}
+
+// documentDigits retorna solo los dígitos del documento, sin prefijos ni separadores
+func documentDigits(document string) string {
+	var b strings.Builder
+	for _, r := range document {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// phoneKey retorna los últimos 10 dígitos del teléfono, de modo que "0414-5551234" y
+// "+58 414 555 1234" coincidan
+func phoneKey(phone string) string {
+	digits := documentDigits(phone)
+	if len(digits) < 7 {
+		return ""
+	}
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return digits
+}
+
+// isAdjacentTransposition indica si b se obtiene de a intercambiando dos dígitos contiguos
+func isAdjacentTransposition(a, b string) bool {
+	if len(a) != len(b) || len(a) < 2 || a == b {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	return i+1 < len(a) && a[i] == b[i+1] && a[i+1] == b[i] && a[i+2:] == b[i+2:]
+}
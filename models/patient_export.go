@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Estados de la exportación de datos del paciente
+const (
+	ExportStatusPending    = "pendiente"
+	ExportStatusProcessing = "en_proceso"
+	ExportStatusCompleted  = "completado"
+	ExportStatusFailed     = "fallido"
+)
+
+// ExportRetention es el tiempo durante el que se puede descargar una exportación
+const ExportRetention = 7 * 24 * time.Hour
+
+// ExportStaleAfter es el tiempo tras el que una exportación en proceso se considera
+// abandonada (el servidor se detuvo mientras la generaba) y se vuelve a encolar
+const ExportStaleAfter = 30 * time.Minute
+
+// PatientExport es la solicitud de exportación de todos los datos de un paciente
+// (derecho de acceso). El archivo se genera en segundo plano y se guarda en la propia
+// fila hasta que vence; al vencer se borra.
+type PatientExport struct {
+	BaseModel
+	PatientID   uint       `gorm:"not null;index" json:"patient_id"`
+	Status      string     `gorm:"size:20;not null;default:'pendiente'" json:"status"`
+	RequestedBy uint       `gorm:"not null" json:"requested_by"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	FileName    string     `gorm:"size:150" json:"file_name,omitempty"`
+	FileSize    int64      `json:"file_size,omitempty"`
+	Checksum    string     `gorm:"size:64" json:"checksum,omitempty"` // SHA-256 del archivo
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	Archive     []byte     `json:"-"`
+}
+
+// TableName especifica el nombre de la tabla
+func (PatientExport) TableName() string {
+	return "patient_exports"
+}
+
+// IsExpired indica si la exportación ya no se puede descargar
+func (pe *PatientExport) IsExpired() bool {
+	return pe.ExpiresAt != nil && time.Now().After(*pe.ExpiresAt)
+}
@@ -0,0 +1,113 @@
+package models
+
+import "fmt"
+
+// AdultAge es la edad a partir de la cual el paciente no requiere representante
+const AdultAge = 18
+
+// Parentesco del representante con el paciente
+const (
+	GuardianRelationshipMother   = "madre"
+	GuardianRelationshipFather   = "padre"
+	GuardianRelationshipLegal    = "tutor_legal"
+	GuardianRelationshipRelative = "familiar"
+	GuardianRelationshipOther    = "otro"
+)
+
+// PatientGuardian vincula a un paciente menor o dependiente con su representante. El
+// representante puede ser otro paciente (GuardianPatientID) o un contacto que no está
+// registrado como paciente (nombre, documento y teléfono).
+type PatientGuardian struct {
+	BaseModel
+	PatientID         uint   `gorm:"not null;index" json:"patient_id"`
+	GuardianPatientID *uint  `gorm:"index" json:"guardian_patient_id"`
+	FullName          string `gorm:"size:150" json:"full_name"`
+	DocumentType      string `gorm:"size:20" json:"document_type"`
+	DocumentNumber    string `gorm:"size:50" json:"document_number"`
+	Phone             string `gorm:"size:20" json:"phone"`
+	Email             string `gorm:"size:100" json:"email"`
+	Relationship      string `gorm:"size:30;not null" json:"relationship"`
+	IsPrimary         bool   `gorm:"default:false" json:"is_primary"` // Representante por defecto en órdenes y facturas
+	CanReceiveResults bool   `json:"can_receive_results"`             // Autorizado a retirar los resultados
+	CreatedBy         uint   `json:"created_by"`
+
+	// Relaciones
+	Patient         Patient  `gorm:"foreignKey:PatientID" json:"-"`
+	GuardianPatient *Patient `gorm:"foreignKey:GuardianPatientID" json:"-"`
+}
+
+// TableName especifica el nombre de la tabla
+func (PatientGuardian) TableName() string {
+	return "patient_guardians"
+}
+
+// DependentDocument retorna el documento con el que se registra a un dependiente sin
+// documento propio: el del representante con un sufijo de secuencia (V-12345678-01)
+func DependentDocument(guardianDocument string, sequence int) string {
+	return fmt.Sprintf("%s-%02d", guardianDocument, sequence)
+}
+
+// GuardianName retorna el nombre del representante, tomado del paciente vinculado si
+// existe (debe estar precargado)
+func (g *PatientGuardian) GuardianName() string {
+	if g.GuardianPatient != nil {
+		return g.GuardianPatient.GetFullName()
+	}
+	return g.FullName
+}
+
+// GuardianDocument retorna el documento del representante
+func (g *PatientGuardian) GuardianDocument() (string, string) {
+	if g.GuardianPatient != nil {
+		return g.GuardianPatient.DocumentType, g.GuardianPatient.DocumentNumber
+	}
+	return g.DocumentType, g.DocumentNumber
+}
+
+// PatientGuardianResponse es el representante con sus datos resueltos (del paciente
+// vinculado o del contacto)
+type PatientGuardianResponse struct {
+	ID                uint   `json:"id"`
+	PatientID         uint   `json:"patient_id"`
+	GuardianPatientID *uint  `json:"guardian_patient_id,omitempty"`
+	FullName          string `json:"full_name"`
+	DocumentType      string `json:"document_type"`
+	DocumentNumber    string `json:"document_number"`
+	Phone             string `json:"phone"`
+	Email             string `json:"email"`
+	Relationship      string `json:"relationship"`
+	IsPrimary         bool   `json:"is_primary"`
+	CanReceiveResults bool   `json:"can_receive_results"`
+}
+
+// ToResponse convierte PatientGuardian a PatientGuardianResponse
+func (g *PatientGuardian) ToResponse() PatientGuardianResponse {
+	documentType, documentNumber := g.GuardianDocument()
+	response := PatientGuardianResponse{
+		ID:                g.ID,
+		PatientID:         g.PatientID,
+		GuardianPatientID: g.GuardianPatientID,
+		FullName:          g.GuardianName(),
+		DocumentType:      documentType,
+		DocumentNumber:    documentNumber,
+		Phone:             g.Phone,
+		Email:             g.Email,
+		Relationship:      g.Relationship,
+		IsPrimary:         g.IsPrimary,
+		CanReceiveResults: g.CanReceiveResults,
+	}
+	if g.GuardianPatient != nil {
+		response.Phone = g.GuardianPatient.Phone
+		response.Email = g.GuardianPatient.Email
+	}
+	return response
+}
+
+// PatientGuardianResponses convierte una lista de representantes
+func PatientGuardianResponses(guardians []PatientGuardian) []PatientGuardianResponse {
+	responses := make([]PatientGuardianResponse, len(guardians))
+	for i := range guardians {
+		responses[i] = guardians[i].ToResponse()
+	}
+	return responses
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// RefreshToken representa un refresh token emitido a un usuario.
+// Solo se guarda el hash del token. Los tokens rotados comparten FamilyID
+// para poder revocar toda la cadena si se detecta la reutilización de uno viejo.
+type RefreshToken struct {
+	BaseModel
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	FamilyID   string     `gorm:"size:64;not null;index" json:"family_id"`
+	AccessJTI  string     `gorm:"size:64" json:"-"`
+	MFA        bool       `gorm:"default:false" json:"mfa"` // La sesión se inició con segundo factor
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *uint      `json:"replaced_by"`
+	IPAddress  string     `gorm:"size:45" json:"ip_address"`
+	UserAgent  string     `gorm:"type:text" json:"user_agent"`
+
+	// Relaciones
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// TableName especifica el nombre de la tabla
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// IsExpired verifica si el token ya expiró
+func (rt *RefreshToken) IsExpired() bool {
+	return time.Now().After(rt.ExpiresAt)
+}
+
+// IsUsable verifica si el token puede canjearse por uno nuevo
+func (rt *RefreshToken) IsUsable() bool {
+	return rt.UsedAt == nil && rt.RevokedAt == nil && !rt.IsExpired()
+}
+
+// RevokedToken representa un token de acceso revocado antes de su expiración.
+// Se identifica por el claim jti y se puede purgar una vez expirado.
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	JTI       string    `gorm:"size:64;uniqueIndex;not null" json:"jti"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	Reason    string    `gorm:"size:50" json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
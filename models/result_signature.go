@@ -0,0 +1,78 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Significados admitidos para la firma de resultados
+const (
+	SignatureMeaningValidated = "validado"
+	SignatureMeaningCorrected = "corregido"
+)
+
+// ResultSignature registra la firma electrónica de un validador sobre los resultados
+// de un examen: quién firmó, con qué significado, cuándo, cómo se re-autenticó y el
+// hash de los valores firmados. Los registros no se modifican ni se eliminan.
+type ResultSignature struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrderExamID    uint      `gorm:"not null;index" json:"order_exam_id"`
+	SignedBy       uint      `gorm:"not null" json:"signed_by"`
+	SignerName     string    `gorm:"size:150;not null" json:"signer_name"` // Nombre al momento de firmar
+	SignerUsername string    `gorm:"size:50" json:"signer_username"`
+	Meaning        string    `gorm:"size:20;not null" json:"meaning"`      // validado, corregido
+	AuthMethod     string    `gorm:"size:20;not null" json:"auth_method"`  // password, totp
+	ResultsHash    string    `gorm:"size:64;not null" json:"results_hash"` // SHA-256 de los valores firmados
+	ResultCount    int       `gorm:"not null" json:"result_count"`
+	IPAddress      string    `gorm:"size:45" json:"ip_address"`
+	UserAgent      string    `gorm:"type:text" json:"user_agent"`
+	SignedAt       time.Time `gorm:"not null" json:"signed_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (ResultSignature) TableName() string {
+	return "result_signatures"
+}
+
+// signedResultValue es la forma canónica de un resultado dentro del hash firmado
+type signedResultValue struct {
+	ParameterID  uint     `json:"exam_parameter_id"`
+	ValueNumeric *float64 `json:"value_numeric"`
+	ValueText    string   `json:"value_text"`
+	ValueBoolean *bool    `json:"value_boolean"`
+	Version      int      `json:"version"`
+}
+
+// ResultsHash calcula el SHA-256 de los valores vigentes de un examen, ordenados por
+// parámetro, para que cualquier cambio posterior a la firma sea detectable
+func ResultsHash(orderExamID uint, results []ExamResult) string {
+	values := make([]signedResultValue, 0, len(results))
+	for _, result := range results {
+		if !result.IsCurrent {
+			continue
+		}
+		values = append(values, signedResultValue{
+			ParameterID:  result.ExamParameterID,
+			ValueNumeric: result.ValueNumeric,
+			ValueText:    result.ValueText,
+			ValueBoolean: result.ValueBoolean,
+			Version:      result.Version,
+		})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].ParameterID == values[j].ParameterID {
+			return values[i].Version < values[j].Version
+		}
+		return values[i].ParameterID < values[j].ParameterID
+	})
+
+	payload, _ := json.Marshal(struct {
+		OrderExamID uint                `json:"order_exam_id"`
+		Results     []signedResultValue `json:"results"`
+	}{orderExamID, values})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"time"
 )
 
 // Permissions representa los permisos en formato JSON
@@ -33,6 +35,9 @@ type Role struct {
 	Permissions Permissions `gorm:"type:jsonb;default:'{}'" json:"permissions"`
 	IsActive    bool        `gorm:"default:true" json:"is_active"`
 
+	// RequireTwoFactor obliga a los usuarios del rol a usar TOTP para operar
+	RequireTwoFactor bool `gorm:"default:false" json:"require_two_factor"`
+
 	// Relaciones
 	Users []User `gorm:"foreignKey:RoleID" json:"-"`
 }
@@ -41,3 +46,119 @@ type Role struct {
 func (Role) TableName() string {
 	return "roles"
 }
+
+// RolePermissionGrant registra que una migración de permisos de un rol del sistema ya se
+// aplicó, para no volver a conceder un permiso que un administrador quitó después
+type RolePermissionGrant struct {
+	ID          string      `gorm:"primaryKey;size:100" json:"id"`
+	RoleName    string      `gorm:"size:50;not null" json:"role_name"`
+	Permissions Permissions `gorm:"type:jsonb" json:"permissions"`
+	AppliedAt   time.Time   `gorm:"not null" json:"applied_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (RolePermissionGrant) TableName() string {
+	return "role_permission_grants"
+}
+
+// Allows verifica si los permisos conceden la acción sobre el recurso.
+// El recurso "all" aplica a cualquier recurso y la acción "*" a cualquier acción.
+func (p Permissions) Allows(resource, action string) bool {
+	for _, key := range []string{resource, "all"} {
+		for _, granted := range p[key] {
+			if granted == "*" || granted == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsFullAccess verifica si los permisos conceden acceso total ("all": ["*"])
+func (p Permissions) IsFullAccess() bool {
+	for _, granted := range p["all"] {
+		if granted == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge retorna los permisos con las acciones de other que falten e indica si se
+// agregó alguna. No modifica los permisos originales.
+func (p Permissions) Merge(other Permissions) (Permissions, bool) {
+	merged := Permissions{}
+	for resource, actions := range p {
+		merged[resource] = append([]string{}, actions...)
+	}
+	changed := false
+	for resource, actions := range other {
+		for _, action := range actions {
+			if !containsString(merged[resource], action) {
+				merged[resource] = append(merged[resource], action)
+				changed = true
+			}
+		}
+	}
+	return merged, changed
+}
+
+// Validate verifica que todos los recursos y acciones existan en el catálogo
+func (p Permissions) Validate() error {
+	for resource, actions := range p {
+		known, ok := permissionActions(resource)
+		if !ok {
+			return fmt.Errorf("recurso desconocido: %s", resource)
+		}
+		for _, action := range actions {
+			if action == "*" {
+				continue
+			}
+			if !containsString(known, action) {
+				return fmt.Errorf("acción desconocida para %s: %s", resource, action)
+			}
+		}
+	}
+	return nil
+}
+
+// PermissionResource describe un recurso protegido y las acciones que admite
+type PermissionResource struct {
+	Resource    string   `json:"resource"`
+	Description string   `json:"description"`
+	Actions     []string `json:"actions"`
+}
+
+// PermissionCatalog lista los recursos y acciones que se pueden asignar a un rol.
+// El recurso "all" con la acción "*" concede acceso total.
+var PermissionCatalog = []PermissionResource{
+	{Resource: "patients", Description: "Pacientes", Actions: []string{"read", "write", "delete", "merge", "export"}},
+	{Resource: "orders", Description: "Órdenes de exámenes", Actions: []string{"read", "write"}},
+	{Resource: "results", Description: "Resultados de laboratorio", Actions: []string{"read", "write", "validate"}},
+	{Resource: "exams", Description: "Catálogo de exámenes", Actions: []string{"read"}},
+	{Resource: "consents", Description: "Plantillas de consentimiento", Actions: []string{"read", "write"}},
+	{Resource: "payments", Description: "Pagos y facturación", Actions: []string{"read", "write"}},
+	{Resource: "research", Description: "Exportación desidentificada para investigación", Actions: []string{"export"}},
+	{Resource: "users", Description: "Usuarios del sistema", Actions: []string{"read", "write"}},
+	{Resource: "roles", Description: "Roles y permisos", Actions: []string{"read", "write"}},
+	{Resource: "api_keys", Description: "API keys de integraciones", Actions: []string{"read", "write"}},
+	{Resource: "all", Description: "Todos los recursos", Actions: []string{"*"}},
+}
+
+func permissionActions(resource string) ([]string, bool) {
+	for _, entry := range PermissionCatalog {
+		if entry.Resource == resource {
+			return entry.Actions, true
+		}
+	}
+	return nil, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Session representa un inicio de sesión: agrupa la familia de refresh tokens y los
+// tokens de acceso emitidos con ella (claim "sid"). Revocarla invalida todos esos tokens.
+type Session struct {
+	BaseModel
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID     string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	MFA          bool       `gorm:"default:false" json:"mfa"`
+	IPAddress    string     `gorm:"size:45" json:"ip_address"`
+	UserAgent    string     `gorm:"type:text" json:"user_agent"`
+	LastSeenAt   time.Time  `json:"last_seen_at"` // Último inicio o renovación de tokens
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	RevokeReason string     `gorm:"size:50" json:"revoke_reason,omitempty"`
+
+	// Relaciones
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// TableName especifica el nombre de la tabla
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// IsActive indica si la sesión no fue revocada ni expiró
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// Motivos registrados en el historial de inicio de sesión
+const (
+	LoginReasonPassword          = "password"
+	LoginReasonTwoFactor         = "two_factor"
+	LoginReasonUnknownUser       = "unknown_user"
+	LoginReasonInvalidPassword   = "invalid_password"
+	LoginReasonInvalidTwoFactor  = "invalid_two_factor_code"
+	LoginReasonAccountLocked     = "account_locked"
+	LoginReasonUserInactive      = "user_inactive"
+	LoginReasonOIDC              = "oidc"
+	LoginReasonSSONotProvisioned = "sso_not_provisioned"
+)
+
+// LoginAttempt registra cada intento de inicio de sesión, exitoso o fallido
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    *uint     `gorm:"index" json:"user_id"` // Nulo si el usuario no existe
+	Username  string    `gorm:"size:50;index" json:"username"`
+	Success   bool      `gorm:"not null;index" json:"success"`
+	Reason    string    `gorm:"size:50" json:"reason"`
+	IPAddress string    `gorm:"size:45" json:"ip_address"`
+	UserAgent string    `gorm:"type:text" json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
@@ -3,7 +3,7 @@ package models
 import (
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/cesarbmathec/medical-exams-backend/utils"
 	"gorm.io/gorm"
 )
 
@@ -21,6 +21,11 @@ type User struct {
 	LastLogin           *time.Time `json:"last_login,omitempty"`
 	FailedLoginAttempts int        `gorm:"default:0" json:"-"`
 	LockedUntil         *time.Time `json:"-"`
+	MustChangePassword  bool       `gorm:"default:false" json:"must_change_password"`
+	PasswordChangedAt   *time.Time `json:"password_changed_at,omitempty"`
+	TOTPSecret          string     `gorm:"column:totp_secret;size:64" json:"-"`
+	TwoFactorEnabled    bool       `gorm:"default:false" json:"two_factor_enabled"`
+	TOTPLastStep        int64      `gorm:"column:totp_last_step;default:0" json:"-"` // Último intervalo aceptado, evita reutilizar un código
 
 	// Relaciones
 	Role Role `gorm:"foreignKey:RoleID" json:"role,omitempty"`
@@ -34,11 +39,11 @@ func (User) TableName() string {
 // BeforeCreate hook para hashear la contraseña antes de crear
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		hashedPassword, err := utils.HashPassword(u.Password)
 		if err != nil {
 			return err
 		}
-		u.PasswordHash = string(hashedPassword)
+		u.PasswordHash = hashedPassword
 		u.Password = "" // Limpiar el password en texto plano
 	}
 	return nil
@@ -47,20 +52,65 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 // BeforeUpdate hook para hashear la contraseña si se actualiza
 func (u *User) BeforeUpdate(tx *gorm.DB) error {
 	if u.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		hashedPassword, err := utils.HashPassword(u.Password)
 		if err != nil {
 			return err
 		}
-		u.PasswordHash = string(hashedPassword)
+		u.PasswordHash = hashedPassword
 		u.Password = "" // Limpiar el password en texto plano
 	}
 	return nil
 }
 
-// CheckPassword verifica si la contraseña es correcta
+// CheckPassword verifica si la contraseña es correcta (con el algoritmo indicado en el hash)
 func (u *User) CheckPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
-	return err == nil
+	return utils.VerifyPassword(u.PasswordHash, password)
+}
+
+// PasswordNeedsRehash indica si el hash usa un algoritmo o parámetros anteriores
+// a los configurados y debe regenerarse en el próximo login exitoso
+func (u *User) PasswordNeedsRehash() bool {
+	return utils.PasswordNeedsRehash(u.PasswordHash)
+}
+
+// IsLocked verifica si la cuenta está bloqueada temporalmente
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// ApplyLockout bloquea la cuenta cada vez que FailedLoginAttempts (ya incrementado)
+// alcanza un múltiplo del umbral e indica si se bloqueó. La duración del bloqueo se
+// duplica en cada bloqueo consecutivo hasta llegar a maxLock.
+func (u *User) ApplyLockout(threshold int, baseLock, maxLock time.Duration) bool {
+	if threshold <= 0 || u.FailedLoginAttempts == 0 || u.FailedLoginAttempts%threshold != 0 {
+		return false
+	}
+
+	lock := baseLock
+	for i := 1; i < u.FailedLoginAttempts/threshold && lock < maxLock; i++ {
+		lock *= 2
+	}
+	if lock > maxLock {
+		lock = maxLock
+	}
+
+	until := time.Now().Add(lock)
+	u.LockedUntil = &until
+	return true
+}
+
+// RecoveryCode representa un código de recuperación de un solo uso para el segundo factor.
+// Solo se guarda el hash del código.
+type RecoveryCode struct {
+	BaseModel
+	UserID   uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash string     `gorm:"size:64;not null;index" json:"-"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// TableName especifica el nombre de la tabla
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
 }
 
 // UserResponse es la estructura para respuestas sin datos sensibles
@@ -75,6 +125,10 @@ type UserResponse struct {
 	IsActive  bool       `json:"is_active"`
 	LastLogin *time.Time `json:"last_login"`
 	CreatedAt time.Time  `json:"created_at"`
+
+	LockedUntil        *time.Time `json:"locked_until,omitempty"`
+	MustChangePassword bool       `json:"must_change_password"`
+	TwoFactorEnabled   bool       `json:"two_factor_enabled"`
 }
 
 // ToResponse convierte User a UserResponse
@@ -90,5 +144,9 @@ func (u *User) ToResponse() UserResponse {
 		IsActive:  u.IsActive,
 		LastLogin: u.LastLogin,
 		CreatedAt: u.CreatedAt,
+
+		LockedUntil:        u.LockedUntil,
+		MustChangePassword: u.MustChangePassword,
+		TwoFactorEnabled:   u.TwoFactorEnabled,
 	}
 }
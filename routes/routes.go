@@ -21,46 +21,167 @@ func SetupRouter() *gin.Engine {
 	// Swagger
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Claves públicas para verificar los tokens (RFC 7517)
+	r.GET("/.well-known/jwks.json", controllers.GetJWKS)
+
 	// --- RUTAS PÚBLICAS ---
 	api := r.Group("/api/v1")
 	{
 		loginLimiter := middleware.NewRateLimiter()
 		api.POST("/login", loginLimiter.Middleware(), controllers.Login)
-		api.POST("/register", loginLimiter.Middleware(), controllers.Register)
+		api.POST("/auth/refresh", loginLimiter.Middleware(), controllers.RefreshToken)
+		api.POST("/auth/2fa/verify", loginLimiter.Middleware(), controllers.VerifyTwoFactor)
+		api.POST("/auth/password/reset", loginLimiter.Middleware(), controllers.ResetPassword)
+
+		// Inicio de sesión con el proveedor de identidad (OIDC + PKCE)
+		api.GET("/auth/oidc/login", loginLimiter.Middleware(), controllers.StartOIDCLogin)
+		api.POST("/auth/oidc/callback", loginLimiter.Middleware(), controllers.OIDCCallback)
 	}
 
-	// --- RUTAS PROTEGIDAS (Requieren Token) ---
+	// --- RUTAS PROTEGIDAS (Requieren Token o API key) ---
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware())
+
+	// --- CUENTA DEL USUARIO (solo sesiones de usuario, no API keys) ---
+	account := protected.Group("/")
+	account.Use(middleware.RequireUserSession())
 	{
-		// Ejemplo: Perfil del usuario actual
-		protected.GET("/me", func(c *gin.Context) {
-			userID, _ := c.Get("userID")
-			c.JSON(200, gin.H{"user_id": userID, "message": "Acceso concedido"})
-		})
+		account.POST("/auth/logout", controllers.Logout)
+
+		// Perfil del usuario actual
+		account.GET("/me", controllers.GetMe)
+		account.POST("/me/password", controllers.ChangePassword)
+
+		// Sesiones e historial de acceso propios
+		account.GET("/me/sessions", controllers.GetMySessions)
+		account.DELETE("/me/sessions/:id", controllers.RevokeMySession)
+		account.GET("/me/login-history", controllers.GetMyLoginHistory)
+
+		// Vinculación explícita de la cuenta con el proveedor de identidad (SSO)
+		account.GET("/me/sso/link", controllers.StartOIDCLink)
+		account.POST("/me/sso/link/callback", controllers.LinkOIDCCallback)
+
+		// Enrolamiento de doble factor (accesible aunque el rol lo exija y aún no esté activo)
+		twoFactor := account.Group("/me/2fa")
+		{
+			twoFactor.POST("/setup", controllers.SetupTwoFactor)
+			twoFactor.POST("/enable", controllers.EnableTwoFactor)
+			twoFactor.POST("/disable", controllers.DisableTwoFactor)
+		}
+	}
+
+	// --- RUTAS PROTEGIDAS QUE EXIGEN 2FA SI EL ROL LO REQUIERE Y CONTRASEÑA VIGENTE ---
+	secured := protected.Group("/")
+	secured.Use(middleware.RequireTwoFactor(), middleware.RequirePasswordChanged())
+	{
+		// Administración de usuarios, sesiones y roles: solo sesiones de usuario, para que
+		// una API key no pueda modificar cuentas ni permisos
+		admin := secured.Group("/")
+		admin.Use(middleware.RequireUserSession())
+
+		// Alta de usuarios reservada a administradores (alias de POST /users)
+		admin.POST("/register", middleware.RequirePermission("users", "write"), controllers.Register)
+
+		// Usuarios
+		users := admin.Group("/users")
+		{
+			users.POST("/", middleware.RequirePermission("users", "write"), controllers.Register)
+			users.GET("/", middleware.RequirePermission("users", "read"), controllers.GetUsers)
+			users.GET("/:id", middleware.RequirePermission("users", "read"), controllers.GetUserByID)
+			users.PUT("/:id", middleware.RequirePermission("users", "write"), controllers.UpdateUser)
+			users.POST("/:id/deactivate", middleware.RequirePermission("users", "write"), controllers.DeactivateUser)
+			users.POST("/:id/reactivate", middleware.RequirePermission("users", "write"), controllers.ReactivateUser)
+			users.POST("/:id/reset-password", middleware.RequirePermission("users", "write"), controllers.ResetUserPassword)
+			users.POST("/:id/reset-token", middleware.RequirePermission("users", "write"), controllers.CreatePasswordResetToken)
+			users.POST("/:id/unlock", middleware.RequirePermission("users", "write"), controllers.UnlockUser)
+			users.DELETE("/:id/2fa", middleware.RequirePermission("users", "write"), controllers.ResetUserTwoFactor)
+		}
+
+		// Sesiones e historial de acceso de todos los usuarios
+		admin.GET("/sessions", middleware.RequirePermission("users", "read"), controllers.GetSessions)
+		admin.DELETE("/sessions/:id", middleware.RequirePermission("users", "write"), controllers.RevokeSession)
+		admin.GET("/login-attempts", middleware.RequirePermission("users", "read"), controllers.GetLoginAttempts)
+
+		// Roles y permisos
+		roles := admin.Group("/roles")
+		{
+			roles.GET("/", middleware.RequirePermission("roles", "read"), controllers.GetRoles)
+			roles.GET("/permissions", middleware.RequirePermission("roles", "read"), controllers.GetPermissionCatalog)
+			roles.GET("/:id", middleware.RequirePermission("roles", "read"), controllers.GetRoleByID)
+			roles.POST("/", middleware.RequirePermission("roles", "write"), controllers.CreateRole)
+			roles.PUT("/:id", middleware.RequirePermission("roles", "write"), controllers.UpdateRole)
+			roles.DELETE("/:id", middleware.RequirePermission("roles", "write"), controllers.DeleteRole)
+		}
+
+		// API keys para clientes de máquina (una API key no puede emitir otras)
+		apiKeys := secured.Group("/api-keys")
+		apiKeys.Use(middleware.RequireUserSession())
+		{
+			apiKeys.POST("/", middleware.RequirePermission("api_keys", "write"), controllers.CreateAPIKey)
+			apiKeys.GET("/", middleware.RequirePermission("api_keys", "read"), controllers.GetAPIKeys)
+			apiKeys.GET("/:id", middleware.RequirePermission("api_keys", "read"), controllers.GetAPIKeyByID)
+			apiKeys.POST("/:id/revoke", middleware.RequirePermission("api_keys", "write"), controllers.RevokeAPIKey)
+		}
 
 		// RUTAS DE PACIENTES
-		patients := protected.Group("/patients")
+		patients := secured.Group("/patients")
+		{
+			patients.POST("/", middleware.RequirePermission("patients", "write"), controllers.CreatePatient) // Registrar
+			patients.GET("/", middleware.RequirePermission("patients", "read"), controllers.GetPatients)     // Listar/Buscar
+			patients.GET("/lookup", middleware.RequirePermission("patients", "read"), controllers.LookupPatientByDocument)
+			patients.GET("/duplicates", middleware.RequirePermission("patients", "read"), controllers.GetDuplicatePatients)
+			patients.GET("/:id", middleware.RequirePermission("patients", "read"), controllers.GetPatientByID) // Ver detalle
+			patients.PUT("/:id", middleware.RequirePermission("patients", "write"), controllers.UpdatePatient)
+			patients.PATCH("/:id", middleware.RequirePermission("patients", "write"), controllers.PatchPatient)
+			patients.POST("/:id/deactivate", middleware.RequirePermission("patients", "delete"), controllers.DeactivatePatient)
+			patients.DELETE("/:id", middleware.RequirePermission("patients", "delete"), controllers.DeletePatient)
+			patients.POST("/:id/restore", middleware.RequirePermission("patients", "delete"), controllers.RestorePatient)
+			patients.GET("/:id/duplicates", middleware.RequirePermission("patients", "read"), controllers.GetPatientDuplicates)
+			patients.GET("/:id/timeline", middleware.RequirePermission("patients", "read"), middleware.RequirePermission("results", "read"), controllers.GetPatientTimeline)
+			patients.GET("/:id/results/cumulative", middleware.RequirePermission("patients", "read"), middleware.RequirePermission("results", "read"), controllers.GetCumulativeResults)
+			patients.GET("/:id/results/parameters/:parameterId/series", middleware.RequirePermission("patients", "read"), middleware.RequirePermission("results", "read"), controllers.GetParameterSeries)
+			patients.GET("/:id/guardians", middleware.RequirePermission("patients", "read"), controllers.GetPatientGuardians)
+			patients.POST("/:id/guardians", middleware.RequirePermission("patients", "write"), controllers.AddPatientGuardian)
+			patients.DELETE("/:id/guardians/:guardianId", middleware.RequirePermission("patients", "write"), controllers.RemovePatientGuardian)
+			patients.GET("/:id/dependents", middleware.RequirePermission("patients", "read"), controllers.GetPatientDependents)
+			patients.POST("/:id/dependents", middleware.RequirePermission("patients", "write"), controllers.CreateDependent)
+			patients.GET("/:id/consents", middleware.RequirePermission("patients", "read"), controllers.GetPatientConsents)
+			patients.POST("/:id/consents", middleware.RequirePermission("patients", "write"), controllers.GrantPatientConsent)
+			patients.POST("/:id/consents/:consentId/revoke", middleware.RequirePermission("patients", "write"), controllers.RevokePatientConsent)
+			patients.GET("/:id/exports", middleware.RequirePermission("patients", "export"), controllers.GetPatientExports)
+			patients.POST("/:id/exports", middleware.RequirePermission("patients", "export"), controllers.CreatePatientExport)
+			patients.GET("/:id/exports/:exportId", middleware.RequirePermission("patients", "export"), controllers.GetPatientExportByID)
+			patients.GET("/:id/exports/:exportId/download", middleware.RequirePermission("patients", "export"), controllers.DownloadPatientExport)
+			patients.POST("/:id/merge", middleware.RequirePermission("patients", "merge"), controllers.MergePatients)
+		}
+
+		// Plantillas de consentimiento (versionadas)
+		consents := secured.Group("/consents")
 		{
-			patients.POST("/", controllers.CreatePatient)    // Registrar
-			patients.GET("/", controllers.GetPatients)       // Listar/Buscar
-			patients.GET("/:id", controllers.GetPatientByID) // Ver detalle
+			consents.GET("/templates", middleware.RequirePermission("consents", "read"), controllers.GetConsentTemplates)
+			consents.GET("/templates/:id", middleware.RequirePermission("consents", "read"), controllers.GetConsentTemplateByID)
+			consents.POST("/templates", middleware.RequirePermission("consents", "write"), controllers.CreateConsentTemplate)
 		}
 
 		// Órdenes
-		orders := protected.Group("/orders")
+		orders := secured.Group("/orders")
+		{
+			orders.POST("/", middleware.RequirePermission("orders", "write"), controllers.CreateOrder)
+			orders.GET("/", middleware.RequirePermission("orders", "read"), controllers.GetOrders)
+		}
+
+		lab := secured.Group("/lab")
 		{
-			orders.POST("/", controllers.CreateOrder)
-			orders.GET("/", controllers.GetOrders)
+			lab.GET("/exams/:id", middleware.RequirePermission("results", "read"), controllers.GetOrderExamDetails)
+			lab.PATCH("/exams/:id/status", middleware.RequirePermission("results", "write"), controllers.UpdateExamStatus)
+			lab.POST("/exams/:id/validate", middleware.RequireUserSession(), middleware.RequirePermission("results", "validate"), controllers.ValidateResults) // Firma electrónica: requiere re-autenticación del usuario
+			lab.POST("/exams/:id/results", middleware.RequirePermission("results", "write"), controllers.SubmitResults)
+			lab.GET("/exams/catalog", middleware.RequirePermission("exams", "read"), controllers.GetExamCatalog) // Para que los bioanalistas puedan ver el catálogo de exámenes y sus parámetros
 		}
 
-		lab := protected.Group("/lab")
+		research := secured.Group("/research")
 		{
-			lab.GET("/exams/:id", controllers.GetOrderExamDetails)
-			lab.PATCH("/exams/:id/status", controllers.UpdateExamStatus)
-			lab.POST("/exams/:id/validate", controllers.ValidateResults) // Nueva ruta para validar resultados
-			lab.POST("/exams/:id/results", controllers.SubmitResults)
-			lab.GET("/exams/catalog", controllers.GetExamCatalog) // Para que los bioanalistas puedan ver el catálogo de exámenes y sus parámetros
+			research.GET("/results", middleware.RequirePermission("research", "export"), controllers.ExportResearchResults) // Resultados desidentificados
 		}
 	}
 	return r
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Modo de sesión del cliente: Bearer (por defecto, cliente de escritorio Wails) o
+// cookies HttpOnly con protección CSRF (frontend web)
+const (
+	AuthModeBearer = "bearer"
+	AuthModeCookie = "cookie"
+)
+
+// Nombres de las cookies de sesión y del encabezado CSRF
+const (
+	AccessCookieName  = "lab_access"
+	RefreshCookieName = "lab_refresh"
+	CSRFCookieName    = "lab_csrf"
+	CSRFHeader        = "X-CSRF-Token"
+)
+
+// refreshCookiePath limita el envío del refresh token a las rutas que lo canjean
+// (/auth/refresh y /auth/logout)
+const refreshCookiePath = "/api/v1/auth"
+
+// AuthCookieConfig agrupa los atributos de las cookies de sesión
+type AuthCookieConfig struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// LoadAuthCookieConfig lee AUTH_COOKIE_DOMAIN, AUTH_COOKIE_SECURE (true por defecto)
+// y AUTH_COOKIE_SAMESITE (strict, lax o none; lax por defecto)
+func LoadAuthCookieConfig() AuthCookieConfig {
+	cfg := AuthCookieConfig{
+		Domain:   strings.TrimSpace(os.Getenv("AUTH_COOKIE_DOMAIN")),
+		Secure:   EnvBool("AUTH_COOKIE_SECURE", true),
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("AUTH_COOKIE_SAMESITE"))) {
+	case "strict":
+		cfg.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Los navegadores rechazan SameSite=None sin Secure
+		cfg.SameSite = http.SameSiteNoneMode
+		cfg.Secure = true
+	}
+	return cfg
+}
+
+// SetAuthCookies escribe las cookies de sesión. El token de acceso y el refresh token
+// son HttpOnly; el token CSRF queda legible para que el frontend lo copie en X-CSRF-Token.
+func SetAuthCookies(w http.ResponseWriter, accessToken, refreshToken, csrfToken string) {
+	cfg := LoadAuthCookieConfig()
+	refreshTTL := RefreshTokenTTL()
+	http.SetCookie(w, cfg.cookie(AccessCookieName, accessToken, "/", AccessTokenTTL(), true))
+	http.SetCookie(w, cfg.cookie(RefreshCookieName, refreshToken, refreshCookiePath, refreshTTL, true))
+	http.SetCookie(w, cfg.cookie(CSRFCookieName, csrfToken, "/", refreshTTL, false))
+}
+
+// ClearAuthCookies elimina las cookies de sesión del navegador
+func ClearAuthCookies(w http.ResponseWriter) {
+	cfg := LoadAuthCookieConfig()
+	http.SetCookie(w, cfg.cookie(AccessCookieName, "", "/", -1, true))
+	http.SetCookie(w, cfg.cookie(RefreshCookieName, "", refreshCookiePath, -1, true))
+	http.SetCookie(w, cfg.cookie(CSRFCookieName, "", "/", -1, false))
+}
+
+// ValidCSRF verifica el patrón double-submit: el encabezado X-CSRF-Token debe
+// coincidir con la cookie CSRF. Un sitio externo puede hacer que el navegador envíe
+// las cookies, pero no puede leerlas para construir el encabezado.
+func ValidCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+	return header != "" && subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
+
+// IsSafeMethod indica si el método HTTP no modifica estado y no requiere token CSRF
+func IsSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func (cfg AuthCookieConfig) cookie(name, value, path string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	maxAge := int(ttl.Seconds())
+	if ttl < 0 {
+		maxAge = -1
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   cfg.Domain,
+		MaxAge:   maxAge,
+		Secure:   cfg.Secure,
+		HttpOnly: httpOnly,
+		SameSite: cfg.SameSite,
+	}
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// uniqueViolationCode es el SQLSTATE de PostgreSQL para una clave única duplicada
+const uniqueViolationCode = "23505"
+
+// IsUniqueViolation indica si el error de la base de datos corresponde a un índice
+// único violado (PostgreSQL o SQLite). Permite responder 409 cuando dos solicitudes
+// concurrentes pasan la misma verificación previa y la base de datos rechaza la segunda.
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) {
+		return sqlErr.SQLState() == uniqueViolationCode
+	}
+	message := err.Error()
+	return strings.Contains(message, "UNIQUE constraint failed") ||
+		strings.Contains(message, "duplicate key value") ||
+		strings.Contains(message, "SQLSTATE "+uniqueViolationCode)
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "error de base de datos" }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsUniqueViolation(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("record not found"), false},
+		{errors.New("UNIQUE constraint failed: patients.document_type, patients.document_number"), true},
+		{fmt.Errorf("crear: %w", sqlStateError("23505")), true},
+		{sqlStateError("23503"), false},
+	}
+	for _, tc := range cases {
+		if got := IsUniqueViolation(tc.err); got != tc.expected {
+			t.Fatalf("IsUniqueViolation(%v) = %v, expected %v", tc.err, got, tc.expected)
+		}
+	}
+}
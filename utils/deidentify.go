@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Precisión con la que se publican las fechas desidentificadas
+const (
+	DatePrecisionMonth = "month"
+	DatePrecisionWeek  = "week"
+	DatePrecisionDay   = "day"
+)
+
+// TopAgeBand agrupa a todas las personas de esta edad o más en una sola banda
+// ("90+"), porque las edades altas son escasas y facilitan la reidentificación
+const TopAgeBand = 90
+
+// Pseudonym retorna un seudónimo estable para un paciente: el HMAC-SHA256 de su ID con
+// la clave de investigación. Sin la clave no se puede recuperar ni verificar el ID.
+func Pseudonym(key []byte, patientID uint) string {
+	sum := keyedHash(key, "patient", patientID)
+	return hex.EncodeToString(sum[:16])
+}
+
+// DateShiftDays retorna el desplazamiento de fechas de un paciente, entre -maxDays y
+// maxDays. Es el mismo para todas sus fechas, de modo que se conservan los intervalos
+// entre sus muestras.
+func DateShiftDays(key []byte, patientID uint, maxDays int) int {
+	if maxDays <= 0 {
+		return 0
+	}
+	sum := keyedHash(key, "shift", patientID)
+	return int(binary.BigEndian.Uint64(sum[:8])%uint64(2*maxDays+1)) - maxDays
+}
+
+// AgeAt retorna la edad en años cumplidos a una fecha
+func AgeAt(dateOfBirth, at time.Time) int {
+	age := at.Year() - dateOfBirth.Year()
+	if at.Month() < dateOfBirth.Month() || (at.Month() == dateOfBirth.Month() && at.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return max(age, 0)
+}
+
+// AgeBand generaliza una edad a una banda del ancho indicado ("30-39" con ancho 10).
+// Desde TopAgeBand todas las edades forman la banda "90+".
+func AgeBand(age, width int) string {
+	if age >= TopAgeBand {
+		return fmt.Sprintf("%d+", TopAgeBand)
+	}
+	start := age / width * width
+	return fmt.Sprintf("%d-%d", start, min(start+width, TopAgeBand)-1)
+}
+
+// DatePeriod generaliza una fecha al periodo de la precisión indicada: "2025-03" por
+// mes, "2025-W11" por semana ISO o "2025-03-10" por día
+func DatePeriod(date time.Time, precision string) string {
+	switch precision {
+	case DatePrecisionWeek:
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case DatePrecisionDay:
+		return date.Format("2006-01-02")
+	}
+	return date.Format("2006-01")
+}
+
+// keyedHash calcula el HMAC-SHA256 de un ID con un propósito, para que el seudónimo y
+// el desplazamiento de fechas no se puedan deducir uno del otro
+func keyedHash(key []byte, purpose string, id uint) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s:%d", purpose, id)
+	return mac.Sum(nil)
+}
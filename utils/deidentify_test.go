@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPseudonym(t *testing.T) {
+	key := []byte("clave-de-investigacion")
+	first := Pseudonym(key, 42)
+	if len(first) != 32 || first != Pseudonym(key, 42) {
+		t.Fatalf("expected a stable 32 character pseudonym, got %q", first)
+	}
+	if first == Pseudonym(key, 43) || first == Pseudonym([]byte("otra-clave"), 42) {
+		t.Fatal("expected pseudonyms to depend on the ID and the key")
+	}
+}
+
+func TestDateShiftDays(t *testing.T) {
+	key := []byte("clave-de-investigacion")
+	if DateShiftDays(key, 42, 0) != 0 {
+		t.Fatal("expected no shift when disabled")
+	}
+	seen := map[int]bool{}
+	for id := uint(1); id <= 200; id++ {
+		shift := DateShiftDays(key, id, 30)
+		if shift < -30 || shift > 30 {
+			t.Fatalf("shift out of range for %d: %d", id, shift)
+		}
+		if shift != DateShiftDays(key, id, 30) {
+			t.Fatalf("expected a stable shift for %d", id)
+		}
+		seen[shift] = true
+	}
+	if len(seen) < 10 {
+		t.Fatalf("expected shifts to vary between patients, got %d distinct values", len(seen))
+	}
+}
+
+func TestAgeBand(t *testing.T) {
+	dob := time.Date(1985, 6, 15, 0, 0, 0, 0, time.UTC)
+	if age := AgeAt(dob, time.Date(2025, 6, 14, 0, 0, 0, 0, time.UTC)); age != 39 {
+		t.Fatalf("expected age 39 the day before the birthday, got %d", age)
+	}
+	if age := AgeAt(dob, time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)); age != 40 {
+		t.Fatalf("expected age 40 on the birthday, got %d", age)
+	}
+
+	cases := []struct {
+		age, width int
+		expected   string
+	}{
+		{0, 10, "0-9"},
+		{39, 10, "30-39"},
+		{40, 5, "40-44"},
+		{85, 20, "80-89"},
+		{90, 10, "90+"},
+		{103, 5, "90+"},
+	}
+	for _, tc := range cases {
+		if got := AgeBand(tc.age, tc.width); got != tc.expected {
+			t.Fatalf("AgeBand(%d, %d) = %q, expected %q", tc.age, tc.width, got, tc.expected)
+		}
+	}
+}
+
+func TestDatePeriod(t *testing.T) {
+	date := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	cases := map[string]string{
+		DatePrecisionMonth: "2025-03",
+		DatePrecisionWeek:  "2025-W11",
+		DatePrecisionDay:   "2025-03-10",
+	}
+	for precision, expected := range cases {
+		if got := DatePeriod(date, precision); got != expected {
+			t.Fatalf("DatePeriod(%s) = %q, expected %q", precision, got, expected)
+		}
+	}
+	if got := DatePeriod(time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), DatePrecisionWeek); got != "2025-W01" {
+		t.Fatalf("expected ISO week year, got %q", got)
+	}
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Tipos de documento de identidad del paciente
+const (
+	DocumentCedula    = "cedula"
+	DocumentPasaporte = "pasaporte"
+	DocumentRIF       = "rif"
+	DocumentOtro      = "otro"
+)
+
+// rifLetterValues es el valor de la letra del RIF en el cálculo del dígito verificador
+var rifLetterValues = map[rune]int{'V': 1, 'E': 2, 'J': 3, 'P': 4, 'G': 5, 'C': 3}
+
+// rifWeights son los pesos de la letra y de los ocho dígitos del RIF (módulo 11)
+var rifWeights = [9]int{4, 3, 2, 7, 6, 5, 4, 3, 2}
+
+// NormalizeDocument valida el número de documento según su tipo y lo retorna en su
+// forma canónica, que es la que se guarda y se compara:
+//   - cedula: "V-12345678" o "E-12345678" (sin prefijo se asume V; se ignoran puntos y guiones)
+//   - rif: "J-12345678-9", con el dígito verificador comprobado
+//   - pasaporte: letras y dígitos en mayúsculas, sin espacios ni guiones
+//   - otro: el texto sin espacios sobrantes
+func NormalizeDocument(documentType, number string) (string, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return "", errors.New("el número de documento es obligatorio")
+	}
+
+	switch documentType {
+	case DocumentCedula:
+		return normalizeCedula(number)
+	case DocumentRIF:
+		return normalizeRIF(number)
+	case DocumentPasaporte:
+		return normalizePassport(number)
+	case DocumentOtro:
+		return strings.Join(strings.Fields(number), " "), nil
+	}
+	return "", fmt.Errorf("tipo de documento inválido: %s", documentType)
+}
+
+// RIFCheckDigit calcula el dígito verificador de un RIF a partir de la letra y los
+// ocho dígitos del número
+func RIFCheckDigit(letter rune, digits string) (int, error) {
+	value, ok := rifLetterValues[unicode.ToUpper(letter)]
+	if !ok {
+		return 0, fmt.Errorf("letra de RIF inválida: %c", letter)
+	}
+	if len(digits) != 8 || !isDigits(digits) {
+		return 0, errors.New("el RIF debe tener ocho dígitos")
+	}
+
+	sum := value * rifWeights[0]
+	for i, d := range digits {
+		sum += int(d-'0') * rifWeights[i+1]
+	}
+	check := 11 - sum%11
+	if check > 9 {
+		check = 0
+	}
+	return check, nil
+}
+
+func normalizeCedula(number string) (string, error) {
+	letter, digits := splitDocumentPrefix(number)
+	if letter == 0 {
+		letter = 'V'
+	}
+	if letter != 'V' && letter != 'E' {
+		return "", errors.New("la cédula debe comenzar con V o E")
+	}
+	digits = strings.TrimLeft(digits, "0")
+	if len(digits) < 5 || len(digits) > 9 || !isDigits(digits) {
+		return "", errors.New("la cédula debe tener entre 5 y 9 dígitos")
+	}
+	return fmt.Sprintf("%c-%s", letter, digits), nil
+}
+
+func normalizeRIF(number string) (string, error) {
+	letter, digits := splitDocumentPrefix(number)
+	if _, ok := rifLetterValues[letter]; !ok {
+		return "", errors.New("el RIF debe comenzar con V, E, J, P, G o C")
+	}
+	if len(digits) < 2 || len(digits) > 9 || !isDigits(digits) {
+		return "", errors.New("el RIF debe tener hasta ocho dígitos y el dígito verificador")
+	}
+
+	// El último dígito es el verificador; el número se completa con ceros a la izquierda
+	body := fmt.Sprintf("%08s", digits[:len(digits)-1])
+	check, err := RIFCheckDigit(letter, body)
+	if err != nil {
+		return "", err
+	}
+	if int(digits[len(digits)-1]-'0') != check {
+		return "", errors.New("el dígito verificador del RIF no es válido")
+	}
+	return fmt.Sprintf("%c-%s-%d", letter, body, check), nil
+}
+
+func normalizePassport(number string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(number) {
+		switch {
+		case r == ' ' || r == '-':
+		case r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		default:
+			return "", errors.New("el pasaporte solo admite letras y dígitos")
+		}
+	}
+	passport := b.String()
+	if len(passport) < 5 || len(passport) > 20 {
+		return "", errors.New("el pasaporte debe tener entre 5 y 20 caracteres")
+	}
+	return passport, nil
+}
+
+// splitDocumentPrefix separa la letra inicial (si existe) de los dígitos, descartando
+// espacios, puntos y guiones
+func splitDocumentPrefix(number string) (rune, string) {
+	var letter rune
+	var b strings.Builder
+	for i, r := range strings.ToUpper(number) {
+		switch {
+		case i == 0 && unicode.IsLetter(r):
+			letter = r
+		case r == ' ' || r == '.' || r == '-':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return letter, b.String()
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,57 @@
+package utils
+
+import "testing"
+
+func TestNormalizeDocument(t *testing.T) {
+	valid := []struct {
+		docType, number, expected string
+	}{
+		{DocumentCedula, "12345678", "V-12345678"},
+		{DocumentCedula, "v-12.345.678", "V-12345678"},
+		{DocumentCedula, "E 81234567", "E-81234567"},
+		{DocumentCedula, "V-012345678", "V-12345678"},
+		{DocumentRIF, "G-20000303-0", "G-20000303-0"},
+		{DocumentRIF, "g200003030", "G-20000303-0"},
+		{DocumentRIF, "J-1-8", "J-00000001-8"},
+		{DocumentPasaporte, " ab-123 456 ", "AB123456"},
+		{DocumentOtro, "  Partida   1234 ", "Partida 1234"},
+	}
+	for _, tc := range valid {
+		got, err := NormalizeDocument(tc.docType, tc.number)
+		if err != nil || got != tc.expected {
+			t.Fatalf("NormalizeDocument(%q, %q) = %q, %v; expected %q", tc.docType, tc.number, got, err, tc.expected)
+		}
+	}
+
+	invalid := []struct {
+		docType, number string
+	}{
+		{DocumentCedula, "J-12345678"},
+		{DocumentCedula, "V-12A45678"},
+		{DocumentCedula, "1234"},
+		{DocumentRIF, "G-20000303-1"},
+		{DocumentRIF, "X-20000303-0"},
+		{DocumentRIF, "20000303"},
+		{DocumentPasaporte, "AB#123456"},
+		{DocumentPasaporte, "A12"},
+		{"dni", "12345678"},
+		{DocumentOtro, "   "},
+	}
+	for _, tc := range invalid {
+		if got, err := NormalizeDocument(tc.docType, tc.number); err == nil {
+			t.Fatalf("NormalizeDocument(%q, %q) = %q; expected error", tc.docType, tc.number, got)
+		}
+	}
+}
+
+func TestRIFCheckDigit(t *testing.T) {
+	if check, err := RIFCheckDigit('G', "20000303"); err != nil || check != 0 {
+		t.Fatalf("unexpected check digit: %d %v", check, err)
+	}
+	if check, err := RIFCheckDigit('j', "00000001"); err != nil || check != 8 {
+		t.Fatalf("unexpected check digit: %d %v", check, err)
+	}
+	if _, err := RIFCheckDigit('G', "2000030"); err == nil {
+		t.Fatal("expected error for short RIF")
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"errors"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,45 +13,127 @@ type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	RoleID   uint   `json:"role_id"`
+	MFA      bool   `json:"mfa,omitempty"`     // La sesión se autenticó con segundo factor
+	Purpose  string `json:"purpose,omitempty"` // Vacío en tokens de acceso; "2fa" en desafíos de login
+
+	// El usuario debe cambiar su contraseña antes de operar
+	PasswordChange bool `json:"pwd_change,omitempty"`
+
+	// Sesión (familia de refresh tokens) a la que pertenece el token de acceso
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// PurposeTwoFactor identifica los tokens de desafío emitidos entre la contraseña y el TOTP
+const PurposeTwoFactor = "2fa"
+
+// AccessTokenTTL retorna la duración de los tokens de acceso (JWT_ACCESS_TTL_MINUTES)
+func AccessTokenTTL() time.Duration {
+	return time.Duration(EnvInt("JWT_ACCESS_TTL_MINUTES", 15)) * time.Minute
+}
+
+// RefreshTokenTTL retorna la duración de los refresh tokens (JWT_REFRESH_TTL_HOURS)
+func RefreshTokenTTL() time.Duration {
+	return time.Duration(EnvInt("JWT_REFRESH_TTL_HOURS", 168)) * time.Hour
+}
+
 func GenerateToken(userID uint, username string, roleID uint) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET no configurado")
+	token, _, err := IssueAccessToken(Claims{UserID: userID, Username: username, RoleID: roleID})
+	return token, err
+}
+
+// IssueAccessToken firma un token de acceso de corta duración con un jti único
+// a partir de los datos del usuario y retorna los claims emitidos para poder registrarlos
+func IssueAccessToken(subject Claims) (string, *Claims, error) {
+	claims := &subject
+	claims.Purpose = ""
+	signed, err := signClaims(claims, AccessTokenTTL())
+	if err != nil {
+		return "", nil, err
 	}
+	return signed, claims, nil
+}
 
+// IssueChallengeToken firma un token de desafío de corta duración que solo sirve
+// para completar el segundo paso del login
+func IssueChallengeToken(userID uint, username string, roleID uint) (string, time.Duration, error) {
+	ttl := time.Duration(EnvInt("TWO_FACTOR_CHALLENGE_TTL_MINUTES", 5)) * time.Minute
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
 		RoleID:   roleID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+		Purpose:  PurposeTwoFactor,
+	}
+	signed, err := signClaims(claims, ttl)
+	return signed, ttl, err
+}
+
+// ValidateChallengeToken valida un token de desafío de segundo factor
+func ValidateChallengeToken(tokenString string) (*Claims, error) {
+	claims, err := ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeTwoFactor {
+		return nil, errors.New("token de desafío inválido")
+	}
+	return claims, nil
+}
+
+func signClaims(claims *Claims, ttl time.Duration) (string, error) {
+	keys, err := currentJWTKeys()
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := GenerateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtSecret))
+	method, key, kid := keys.signingMethod()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString(key)
 }
 
+// ValidateToken verifica la firma con la clave indicada por el kid del token
+// (o con JWT_SECRET si no tiene kid y se admite HS256) y retorna sus claims
 func ValidateToken(tokenString string) (*Claims, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, errors.New("JWT_SECRET no configurado")
+	keys, err := currentJWTKeys()
+	if err != nil {
+		return nil, err
 	}
 
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("método de firma inválido")
-		}
-		return []byte(jwtSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.verificationKey,
+		jwt.WithValidMethods([]string{JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmEdDSA}))
 
 	if err != nil || !token.Valid {
 		return nil, err
 	}
 	return claims, nil
 }
+
+// EnvInt lee una variable de entorno entera positiva, con valor por defecto
+func EnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return fallback
+	}
+	return parsed
+}
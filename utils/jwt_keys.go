@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algoritmos de firma soportados (JWT_ALGORITHM)
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// JWK es una clave pública en formato JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS es el conjunto de claves públicas publicado en /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwtKey es una clave de verificación identificada por su kid. Solo la clave de
+// firma activa tiene la parte privada.
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+	jwk     JWK
+}
+
+// jwtKeySet agrupa la configuración de firma vigente
+type jwtKeySet struct {
+	config    string
+	algorithm string
+	secret    []byte             // HS256: firma en modo simétrico y verificación de tokens sin kid
+	signer    *jwtKey            // Clave privada activa (modos asimétricos)
+	verifiers map[string]*jwtKey // Claves públicas aceptadas, por kid
+	// Modos asimétricos: hasta cuándo se aceptan tokens HS256 sin kid (migración desde HS256)
+	hmacFallbackUntil time.Time
+}
+
+var jwtKeys struct {
+	mu  sync.Mutex
+	set *jwtKeySet
+}
+
+// LoadJWTKeys carga y valida las claves configuradas. Se llama al iniciar el servidor
+// para fallar temprano ante una configuración inválida.
+func LoadJWTKeys() error {
+	_, err := currentJWTKeys()
+	return err
+}
+
+// PublicJWKS retorna las claves públicas de verificación vigentes.
+// En modo HS256 la lista está vacía: el secreto compartido nunca se publica.
+func PublicJWKS() (JWKS, error) {
+	keys, err := currentJWTKeys()
+	if err != nil {
+		return JWKS{}, err
+	}
+
+	jwks := JWKS{Keys: []JWK{}}
+	if keys.signer != nil {
+		jwks.Keys = append(jwks.Keys, keys.signer.jwk)
+	}
+	kids := make([]string, 0, len(keys.verifiers))
+	for kid := range keys.verifiers {
+		if keys.signer == nil || kid != keys.signer.kid {
+			kids = append(kids, kid)
+		}
+	}
+	sort.Strings(kids)
+	for _, kid := range kids {
+		jwks.Keys = append(jwks.Keys, keys.verifiers[kid].jwk)
+	}
+	return jwks, nil
+}
+
+// currentJWTKeys retorna las claves en caché y las recarga solo si cambió la
+// configuración del entorno (JWT_ALGORITHM, JWT_SECRET, JWT_PRIVATE_KEY_FILE,
+// JWT_PUBLIC_KEY_FILES, JWT_HS256_FALLBACK_UNTIL)
+func currentJWTKeys() (*jwtKeySet, error) {
+	algorithm := strings.TrimSpace(os.Getenv("JWT_ALGORITHM"))
+	if algorithm == "" {
+		algorithm = JWTAlgorithmHS256
+	}
+	secret := os.Getenv("JWT_SECRET")
+	privateFile := strings.TrimSpace(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+	publicFiles := strings.TrimSpace(os.Getenv("JWT_PUBLIC_KEY_FILES"))
+	fallbackUntil := strings.TrimSpace(os.Getenv("JWT_HS256_FALLBACK_UNTIL"))
+	config := strings.Join([]string{algorithm, secret, privateFile, publicFiles, fallbackUntil}, "\x00")
+
+	jwtKeys.mu.Lock()
+	defer jwtKeys.mu.Unlock()
+	if jwtKeys.set != nil && jwtKeys.set.config == config {
+		return jwtKeys.set, nil
+	}
+
+	set := &jwtKeySet{
+		config:    config,
+		algorithm: algorithm,
+		verifiers: make(map[string]*jwtKey),
+	}
+	if secret != "" {
+		set.secret = []byte(secret)
+	}
+
+	switch algorithm {
+	case JWTAlgorithmHS256:
+		if set.secret == nil {
+			return nil, errors.New("JWT_SECRET no configurado")
+		}
+	case JWTAlgorithmRS256, JWTAlgorithmEdDSA:
+		if privateFile == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE requerido con JWT_ALGORITHM=%s", algorithm)
+		}
+		signer, err := loadJWTKeyFile(privateFile)
+		if err != nil {
+			return nil, err
+		}
+		if signer.private == nil {
+			return nil, fmt.Errorf("%s no contiene una clave privada", privateFile)
+		}
+		if signer.method.Alg() != algorithm {
+			return nil, fmt.Errorf("la clave de %s no corresponde a %s", privateFile, algorithm)
+		}
+		set.signer = signer
+		set.verifiers[signer.kid] = signer
+
+		// Transición desde HS256: los tokens sin kid se aceptan solo hasta la fecha indicada
+		if fallbackUntil != "" {
+			until, err := time.Parse(time.RFC3339, fallbackUntil)
+			if err != nil {
+				return nil, fmt.Errorf("JWT_HS256_FALLBACK_UNTIL debe tener formato RFC 3339: %w", err)
+			}
+			if set.secret == nil {
+				return nil, errors.New("JWT_HS256_FALLBACK_UNTIL requiere JWT_SECRET")
+			}
+			set.hmacFallbackUntil = until
+		}
+	default:
+		return nil, fmt.Errorf("JWT_ALGORITHM no soportado: %s", algorithm)
+	}
+
+	// Claves anteriores que se siguen aceptando durante una rotación
+	for _, path := range strings.Split(publicFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := loadJWTKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key.private = nil
+		if _, exists := set.verifiers[key.kid]; !exists {
+			set.verifiers[key.kid] = key
+		}
+	}
+
+	jwtKeys.set = set
+	return set, nil
+}
+
+// signingMethod retorna el método y la clave con los que se firman los tokens nuevos
+func (s *jwtKeySet) signingMethod() (jwt.SigningMethod, interface{}, string) {
+	if s.signer != nil {
+		return s.signer.method, s.signer.private, s.signer.kid
+	}
+	return jwt.SigningMethodHS256, s.secret, ""
+}
+
+// verificationKey elige la clave según el kid del token. Los tokens sin kid solo
+// se aceptan firmados con HS256 y JWT_SECRET, en modo simétrico o, en los modos
+// asimétricos, hasta JWT_HS256_FALLBACK_UNTIL.
+func (s *jwtKeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok && s.acceptsHMAC() {
+			return s.secret, nil
+		}
+		return nil, errors.New("método de firma inválido")
+	}
+
+	key, ok := s.verifiers[kid]
+	if !ok {
+		return nil, errors.New("clave de firma desconocida")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("método de firma inválido")
+	}
+	return key.public, nil
+}
+
+// acceptsHMAC indica si se aceptan tokens HS256 firmados con JWT_SECRET
+func (s *jwtKeySet) acceptsHMAC() bool {
+	if s.secret == nil {
+		return false
+	}
+	if s.algorithm == JWTAlgorithmHS256 {
+		return true
+	}
+	return time.Now().Before(s.hmacFallbackUntil)
+}
+
+// loadJWTKeyFile lee una clave PEM (privada PKCS#8/PKCS#1 o pública PKIX)
+func loadJWTKeyFile(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer la clave %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s no es un archivo PEM válido", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("tipo PEM no soportado: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("clave inválida en %s: %w", path, err)
+	}
+
+	key := &jwtKey{}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = signer
+		parsed = signer.Public()
+	}
+
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("la clave RSA de %s debe tener al menos 2048 bits", path)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.public = public
+		key.jwk = JWK{
+			Kty: "RSA",
+			Alg: JWTAlgorithmRS256,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.public = public
+		key.jwk = JWK{
+			Kty: "OKP",
+			Alg: JWTAlgorithmEdDSA,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}
+	default:
+		return nil, fmt.Errorf("tipo de clave no soportado en %s", path)
+	}
+
+	key.kid = jwkThumbprint(key.jwk)
+	key.jwk.Kid = key.kid
+	key.jwk.Use = "sig"
+	return key, nil
+}
+
+// jwkThumbprint calcula el identificador de la clave según RFC 7638
+// (SHA-256 de los miembros obligatorios en orden lexicográfico)
+func jwkThumbprint(jwk JWK) string {
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	encoded, _ := json.Marshal(members)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeKeyFiles(t *testing.T, name string, private interface{}, public interface{}) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("marshal private: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("marshal public: %v", err)
+	}
+
+	privPath := filepath.Join(dir, name+".pem")
+	pubPath := filepath.Join(dir, name+".pub.pem")
+	os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600)
+	os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644)
+	return privPath, pubPath
+}
+
+func setJWTEnv(t *testing.T, values map[string]string) {
+	t.Helper()
+	for _, key := range []string{"JWT_ALGORITHM", "JWT_SECRET", "JWT_PRIVATE_KEY_FILE", "JWT_PUBLIC_KEY_FILES", "JWT_HS256_FALLBACK_UNTIL"} {
+		os.Unsetenv(key)
+	}
+	for key, value := range values {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		for key := range values {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatalf("parse header: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestAsymmetricSigningAndRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa: %v", err)
+	}
+	oldPriv, oldPub := writeKeyFiles(t, "old", rsaKey, &rsaKey.PublicKey)
+
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	newPriv, _ := writeKeyFiles(t, "new", edPrivate, edPublic)
+
+	// Firma con RS256: el token lleva el kid de la clave
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "RS256", "JWT_PRIVATE_KEY_FILE": oldPriv})
+	oldToken, err := GenerateToken(1, "tester", 2)
+	if err != nil {
+		t.Fatalf("sign RS256: %v", err)
+	}
+	oldKID := tokenKID(t, oldToken)
+	if oldKID == "" {
+		t.Fatal("expected kid header")
+	}
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Fatalf("validate RS256: %v", err)
+	}
+
+	// Rotación: nueva clave EdDSA activa y la anterior solo para verificar
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": newPriv, "JWT_PUBLIC_KEY_FILES": oldPub})
+	newToken, err := GenerateToken(1, "tester", 2)
+	if err != nil {
+		t.Fatalf("sign EdDSA: %v", err)
+	}
+	if tokenKID(t, newToken) == oldKID {
+		t.Fatal("expected a different kid after rotation")
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := ValidateToken(token); err != nil {
+			t.Fatalf("validate after rotation: %v", err)
+		}
+	}
+
+	jwks, err := PublicJWKS()
+	if err != nil || len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 public keys, got %+v (%v)", jwks.Keys, err)
+	}
+	if jwks.Keys[0].Kty != "OKP" || jwks.Keys[1].Kid != oldKID {
+		t.Fatalf("unexpected jwks order: %+v", jwks.Keys)
+	}
+
+	// Retirada la clave anterior, sus tokens dejan de ser válidos
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": newPriv})
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Fatal("expected retired key to be rejected")
+	}
+}
+
+func TestHS256FallbackDuringMigration(t *testing.T) {
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	priv, _ := writeKeyFiles(t, "ed", edPrivate, edPublic)
+
+	setJWTEnv(t, map[string]string{"JWT_SECRET": "test_secret"})
+	legacy, err := GenerateToken(1, "tester", 2)
+	if err != nil {
+		t.Fatalf("sign HS256: %v", err)
+	}
+	if tokenKID(t, legacy) != "" {
+		t.Fatal("HS256 tokens should not carry a kid")
+	}
+	if jwks, _ := PublicJWKS(); len(jwks.Keys) != 0 {
+		t.Fatal("HS256 mode must not publish keys")
+	}
+
+	// Con solo JWT_SECRET definido el secreto ya no firma tokens aceptados
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": priv, "JWT_SECRET": "test_secret"})
+	if _, err := ValidateToken(legacy); err == nil {
+		t.Fatal("expected HS256 token to be rejected without a transition deadline")
+	}
+
+	// Durante la transición se siguen aceptando los tokens HS256 emitidos antes del cambio
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": priv, "JWT_SECRET": "test_secret", "JWT_HS256_FALLBACK_UNTIL": future})
+	if _, err := ValidateToken(legacy); err != nil {
+		t.Fatalf("expected HS256 fallback, got %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": priv, "JWT_SECRET": "test_secret", "JWT_HS256_FALLBACK_UNTIL": past})
+	if _, err := ValidateToken(legacy); err == nil {
+		t.Fatal("expected HS256 token to be rejected after the transition deadline")
+	}
+
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": priv, "JWT_HS256_FALLBACK_UNTIL": future})
+	if err := LoadJWTKeys(); err == nil {
+		t.Fatal("expected the transition deadline to require JWT_SECRET")
+	}
+	setJWTEnv(t, map[string]string{"JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": priv, "JWT_SECRET": "test_secret", "JWT_HS256_FALLBACK_UNTIL": "mañana"})
+	if err := LoadJWTKeys(); err == nil {
+		t.Fatal("expected an invalid transition deadline to be rejected")
+	}
+}
@@ -0,0 +1,465 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrOIDCDisabled indica que el inicio de sesión con OIDC no está configurado
+var ErrOIDCDisabled = errors.New("inicio de sesión OIDC no configurado")
+
+// oidcKeysMinRefresh limita la recarga de claves del proveedor ante un kid desconocido
+const oidcKeysMinRefresh = time.Minute
+
+// OIDCRoleMapping asigna un grupo del proveedor de identidad a un rol local
+type OIDCRoleMapping struct {
+	Group string
+	Role  string
+}
+
+// OIDCConfig es la configuración del proveedor de identidad (variables OIDC_*)
+type OIDCConfig struct {
+	IssuerURL       string
+	ClientID        string
+	ClientSecret    string // Vacío para clientes públicos (solo PKCE)
+	RedirectURL     string
+	Scopes          []string
+	UsernameClaim   string
+	GroupsClaim     string
+	RoleMappings    []OIDCRoleMapping // En orden de prioridad
+	DefaultRole     string            // Rol para usuarios sin grupo mapeado (vacío = ninguno)
+	JITProvisioning bool              // Crear usuarios locales en su primer inicio de sesión
+	SyncRoles       bool              // Actualizar el rol local en cada inicio de sesión
+}
+
+// LoadOIDCConfig lee la configuración OIDC del entorno. Retorna false si falta
+// OIDC_ISSUER_URL, OIDC_CLIENT_ID u OIDC_REDIRECT_URL.
+func LoadOIDCConfig() (OIDCConfig, bool) {
+	cfg := OIDCConfig{
+		IssuerURL:       strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL")), "/"),
+		ClientID:        strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		ClientSecret:    os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:     strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL")),
+		Scopes:          strings.Fields(os.Getenv("OIDC_SCOPES")),
+		UsernameClaim:   strings.TrimSpace(os.Getenv("OIDC_USERNAME_CLAIM")),
+		GroupsClaim:     strings.TrimSpace(os.Getenv("OIDC_GROUPS_CLAIM")),
+		DefaultRole:     strings.TrimSpace(os.Getenv("OIDC_DEFAULT_ROLE")),
+		JITProvisioning: EnvBool("OIDC_JIT_PROVISIONING", false),
+		SyncRoles:       EnvBool("OIDC_SYNC_ROLES", true),
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	// OIDC_ROLE_MAPPING=grupo-idp=rol,otro-grupo=otro-rol
+	for _, entry := range strings.Split(os.Getenv("OIDC_ROLE_MAPPING"), ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && strings.TrimSpace(group) != "" && strings.TrimSpace(role) != "" {
+			cfg.RoleMappings = append(cfg.RoleMappings, OIDCRoleMapping{
+				Group: strings.TrimSpace(group),
+				Role:  strings.TrimSpace(role),
+			})
+		}
+	}
+
+	enabled := cfg.IssuerURL != "" && cfg.ClientID != "" && cfg.RedirectURL != ""
+	return cfg, enabled
+}
+
+// MapRole retorna el rol local del primer mapeo cuyo grupo tenga el usuario,
+// o el rol por defecto si ninguno coincide
+func (cfg OIDCConfig) MapRole(groups []string) string {
+	for _, mapping := range cfg.RoleMappings {
+		for _, group := range groups {
+			if group == mapping.Group {
+				return mapping.Role
+			}
+		}
+	}
+	return cfg.DefaultRole
+}
+
+// OIDCIdentity son los datos del usuario extraídos de un ID token verificado
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+	Groups        []string
+	MFA           bool // El proveedor informó autenticación multifactor (claim amr)
+}
+
+// oidcDiscovery es el subconjunto usado del documento de descubrimiento
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider es el cliente del proveedor de identidad. El documento de
+// descubrimiento y las claves públicas se obtienen bajo demanda y quedan en caché.
+type OIDCProvider struct {
+	Config OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+var oidcProviders struct {
+	mu       sync.Mutex
+	config   string
+	provider *OIDCProvider
+}
+
+// CurrentOIDCProvider retorna el proveedor configurado. Se recrea si cambió la
+// configuración del entorno.
+func CurrentOIDCProvider() (*OIDCProvider, error) {
+	cfg, enabled := LoadOIDCConfig()
+	if !enabled {
+		return nil, ErrOIDCDisabled
+	}
+	key := strings.Join([]string{cfg.IssuerURL, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL}, "\x00")
+
+	oidcProviders.mu.Lock()
+	defer oidcProviders.mu.Unlock()
+	if oidcProviders.provider == nil || oidcProviders.config != key {
+		oidcProviders.provider = &OIDCProvider{client: &http.Client{Timeout: 10 * time.Second}}
+		oidcProviders.config = key
+	}
+	// El resto de la configuración (mapeo de roles, JIT) se relee en cada uso
+	oidcProviders.provider.Config = cfg
+	return oidcProviders.provider, nil
+}
+
+// PKCEChallenge calcula el code_challenge S256 de un code_verifier (RFC 7636)
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthorizationURL construye la URL del proveedor a la que se redirige al usuario
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.Config.ClientID)
+	params.Set("redirect_uri", p.Config.RedirectURL)
+	params.Set("scope", strings.Join(p.Config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", PKCEChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange canjea el código de autorización por los tokens del proveedor y
+// retorna el ID token sin verificar
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.Config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &tokens)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("el proveedor rechazó el código (%d): %s %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("el proveedor no retornó id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// VerifyIDToken valida la firma, el emisor, la audiencia, la expiración y el nonce
+// del ID token y retorna la identidad del usuario
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCIdentity, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ID token inválido: %w", err)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || tokenNonce != nonce {
+		return nil, errors.New("ID token inválido: nonce no coincide")
+	}
+	// Con varias audiencias el token debe estar dirigido a este cliente (azp)
+	if aud, ok := claims["aud"].([]interface{}); ok && len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.Config.ClientID {
+			return nil, errors.New("ID token inválido: azp no coincide")
+		}
+	}
+
+	identity := &OIDCIdentity{
+		Issuer:        stringClaim(claims, "iss"),
+		Subject:       stringClaim(claims, "sub"),
+		Email:         strings.ToLower(stringClaim(claims, "email")),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, "name"),
+		Username:      stringClaim(claims, p.Config.UsernameClaim),
+		Groups:        stringListClaim(claims, p.Config.GroupsClaim),
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("ID token inválido: sin sub")
+	}
+	for _, method := range stringListClaim(claims, "amr") {
+		switch method {
+		case "mfa", "otp", "hwk", "swk", "sms", "fido":
+			identity.MFA = true
+		}
+	}
+	return identity, nil
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Config.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovery oidcDiscovery
+	status, err := p.doJSON(req, &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("descubrimiento OIDC falló (%d)", status)
+	}
+	// El emisor publicado debe ser exactamente el configurado (OpenID Connect Discovery §4.3)
+	if strings.TrimRight(discovery.Issuer, "/") != p.Config.IssuerURL {
+		return nil, fmt.Errorf("el emisor %s no coincide con OIDC_ISSUER_URL", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("documento de descubrimiento OIDC incompleto")
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// verificationKey busca la clave pública por kid y recarga el JWKS del proveedor
+// si no la conoce (rotación de claves)
+func (p *OIDCProvider) verificationKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcKeysMinRefresh {
+		return nil, errors.New("clave de firma del proveedor desconocida")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	status, err := p.doJSON(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("no se pudo obtener el JWKS del proveedor (%d)", status)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if use := jwk["use"]; use != "" && use != "sig" {
+			continue
+		}
+		if key, err := parseJWK(jwk); err == nil {
+			keys[jwk["kid"]] = key
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("clave de firma del proveedor desconocida")
+}
+
+// lookupKey retorna la clave del kid; sin kid solo se acepta si el proveedor publica una única clave
+func (p *OIDCProvider) lookupKey(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *OIDCProvider) doJSON(req *http.Request, out interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error al contactar al proveedor OIDC: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, out); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("respuesta inválida del proveedor OIDC: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// parseJWK convierte una clave pública JWK (RSA, EC P-256 u OKP Ed25519)
+func parseJWK(jwk map[string]string) (interface{}, error) {
+	decode := func(field string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk[field], "="))
+	}
+
+	switch jwk["kty"] {
+	case "RSA":
+		n, err := decode("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk["crv"] != "P-256" {
+			return nil, fmt.Errorf("curva no soportada: %s", jwk["crv"])
+		}
+		x, err := decode("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y")
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode("x")
+		if err != nil || jwk["crv"] != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("clave OKP inválida")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("tipo de clave no soportado: %s", jwk["kty"])
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return strings.TrimSpace(value)
+}
+
+func boolClaim(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	}
+	return false
+}
+
+// stringListClaim admite claims de lista o de texto separado por espacios o comas
+func stringListClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if text, ok := item.(string); ok && text != "" {
+				list = append(list, text)
+			}
+		}
+		return list
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pagination describe la página retornada por un listado paginado. Se envía en el
+// campo "meta" de la respuesta para que "data" siga siendo la lista de registros.
+type Pagination struct {
+	Page       int   `json:"page"`
+	Limit      int   `json:"limit"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// ParsePagination lee los parámetros page (desde 1) y limit (hasta maxLimit). La
+// página se limita para que el desplazamiento ((page-1)*limit) no desborde.
+func ParsePagination(c *gin.Context, defaultLimit, maxLimit int) (Pagination, error) {
+	p := Pagination{Page: 1, Limit: defaultLimit}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return p, fmt.Errorf("limit debe estar entre 1 y %d", maxLimit)
+		}
+		p.Limit = limit
+	}
+	if value := c.Query("page"); value != "" {
+		maxPage := math.MaxInt32/p.Limit + 1
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 || page > maxPage {
+			return p, fmt.Errorf("page debe estar entre 1 y %d", maxPage)
+		}
+		p.Page = page
+	}
+	return p, nil
+}
+
+// Offset retorna la cantidad de registros que se saltan para llegar a la página
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// SetTotal registra el total de registros y calcula el total de páginas
+func (p *Pagination) SetTotal(total int64) {
+	p.Total = total
+	p.TotalPages = int((total + int64(p.Limit) - 1) / int64(p.Limit))
+}
+
+// PageResponse es la respuesta de un listado paginado
+type PageResponse struct {
+	Response
+	Meta Pagination `json:"meta"`
+}
+
+// SuccessPage envía una respuesta exitosa con la lista de registros y la paginación
+func SuccessPage(c *gin.Context, code int, message string, data interface{}, pagination Pagination) {
+	c.JSON(code, PageResponse{
+		Response: Response{
+			Status:  "success",
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+		Meta: pagination,
+	})
+}
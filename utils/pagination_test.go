@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParsePagination(t *testing.T) {
+	parse := func(query string) (Pagination, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/?"+query, nil)
+		return ParsePagination(c, 20, 100)
+	}
+
+	p, err := parse("page=3&limit=50")
+	if err != nil || p.Offset() != 100 {
+		t.Fatalf("unexpected pagination: %+v %v", p, err)
+	}
+	for _, query := range []string{"page=0", "limit=101", "page=abc", "page=288230376151711745&limit=50", "page=9223372036854775807"} {
+		if _, err := parse(query); err == nil {
+			t.Fatalf("expected %q to be rejected", query)
+		}
+	}
+	// La última página admitida mantiene el desplazamiento positivo
+	p, err = parse("page=42949673&limit=50")
+	if err != nil || p.Offset() < 0 {
+		t.Fatalf("unexpected last page: %+v %v", p, err)
+	}
+}
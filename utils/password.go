@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"unicode"
+)
+
+// MaxPasswordLength es el límite de bcrypt (los bytes adicionales se ignorarían). Se
+// mantiene con argon2id para que las contraseñas sigan siendo válidas con cualquier algoritmo.
+const MaxPasswordLength = 72
+
+// PasswordPolicy define las reglas que debe cumplir una contraseña nueva
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	HistorySize   int // Contraseñas anteriores que no pueden reutilizarse
+}
+
+// LoadPasswordPolicy lee la política desde el entorno: PASSWORD_MIN_LENGTH,
+// PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT,
+// PASSWORD_REQUIRE_SYMBOL y PASSWORD_HISTORY_SIZE
+func LoadPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     EnvInt("PASSWORD_MIN_LENGTH", 8),
+		RequireUpper:  EnvBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:  EnvBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:  EnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: EnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		HistorySize:   EnvInt("PASSWORD_HISTORY_SIZE", 5),
+	}
+}
+
+// Validate retorna la lista de reglas que la contraseña no cumple (vacía si es válida)
+func (p PasswordPolicy) Validate(password string) []string {
+	var violations []string
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("Debe tener al menos %d caracteres", p.MinLength))
+	}
+	if len(password) > MaxPasswordLength {
+		violations = append(violations, fmt.Sprintf("No debe superar %d bytes", MaxPasswordLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		violations = append(violations, "Debe contener una letra mayúscula")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "Debe contener una letra minúscula")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "Debe contener un número")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "Debe contener un símbolo")
+	}
+	return violations
+}
+
+// EnvBool lee una variable de entorno booleana, con valor por defecto
+func EnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
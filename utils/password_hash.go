@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algoritmos de hash de contraseñas soportados (PASSWORD_HASH_ALGORITHM)
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// PasswordHasher genera y verifica hashes de contraseñas. Cada hash lleva el prefijo
+// de su algoritmo ("$argon2id$", "$2a$") para poder verificarlo aunque cambie la
+// configuración.
+type PasswordHasher interface {
+	// Identifies indica si el hash codificado fue generado por este algoritmo
+	Identifies(encoded string) bool
+	Hash(password string) (string, error)
+	Verify(encoded, password string) bool
+	// NeedsRehash indica si el hash se generó con parámetros distintos a los actuales
+	NeedsRehash(encoded string) bool
+}
+
+var passwordHashers = struct {
+	mu        sync.RWMutex
+	factories map[string]func() PasswordHasher
+}{
+	factories: map[string]func() PasswordHasher{
+		PasswordHashArgon2id: func() PasswordHasher { return loadArgon2idHasher() },
+		PasswordHashBcrypt:   func() PasswordHasher { return bcryptHasher{cost: EnvInt("BCRYPT_COST", bcrypt.DefaultCost)} },
+	},
+}
+
+// RegisterPasswordHasher agrega o reemplaza un algoritmo de hash. La fábrica se
+// invoca en cada uso para que tome la configuración vigente del entorno.
+func RegisterPasswordHasher(name string, factory func() PasswordHasher) {
+	passwordHashers.mu.Lock()
+	defer passwordHashers.mu.Unlock()
+	passwordHashers.factories[name] = factory
+}
+
+// CurrentPasswordHasher retorna el algoritmo configurado en PASSWORD_HASH_ALGORITHM
+// (argon2id por defecto)
+func CurrentPasswordHasher() PasswordHasher {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("PASSWORD_HASH_ALGORITHM")))
+	passwordHashers.mu.RLock()
+	factory, ok := passwordHashers.factories[name]
+	if !ok {
+		factory = passwordHashers.factories[PasswordHashArgon2id]
+	}
+	passwordHashers.mu.RUnlock()
+	return factory()
+}
+
+// HashPassword genera el hash de la contraseña con el algoritmo actual
+func HashPassword(password string) (string, error) {
+	return CurrentPasswordHasher().Hash(password)
+}
+
+// VerifyPassword compara la contraseña con un hash de cualquier algoritmo registrado
+func VerifyPassword(encoded, password string) bool {
+	hasher := passwordHasherFor(encoded)
+	return hasher != nil && hasher.Verify(encoded, password)
+}
+
+// PasswordNeedsRehash indica si el hash debe regenerarse porque usa otro algoritmo
+// o parámetros distintos a los configurados
+func PasswordNeedsRehash(encoded string) bool {
+	current := CurrentPasswordHasher()
+	return !current.Identifies(encoded) || current.NeedsRehash(encoded)
+}
+
+func passwordHasherFor(encoded string) PasswordHasher {
+	passwordHashers.mu.RLock()
+	defer passwordHashers.mu.RUnlock()
+	for _, factory := range passwordHashers.factories {
+		if hasher := factory(); hasher.Identifies(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// bcryptHasher es el algoritmo original del sistema; se mantiene para verificar los
+// hashes existentes hasta que se regeneren
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hashed), err
+}
+
+func (h bcryptHasher) Verify(encoded, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// Argon2idParams son los parámetros ajustables de argon2id
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// argon2idHasher codifica los hashes en formato PHC:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// loadArgon2idHasher lee ARGON2_MEMORY_KB, ARGON2_ITERATIONS y ARGON2_PARALLELISM.
+// Los valores por defecto son el mínimo recomendado por OWASP (19 MiB, 2 iteraciones).
+func loadArgon2idHasher() argon2idHasher {
+	parallelism := EnvInt("ARGON2_PARALLELISM", 1)
+	if parallelism > 255 {
+		parallelism = 255
+	}
+	return argon2idHasher{params: Argon2idParams{
+		Memory:      uint32(EnvInt("ARGON2_MEMORY_KB", 19456)),
+		Iterations:  uint32(EnvInt("ARGON2_ITERATIONS", 2)),
+		Parallelism: uint8(parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}}
+}
+
+func (h argon2idHasher) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h argon2idHasher) Verify(encoded, password string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1
+}
+
+func (h argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	return err != nil ||
+		params.Memory != h.params.Memory ||
+		params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism ||
+		uint32(len(salt)) != h.params.SaltLength ||
+		uint32(len(key)) != h.params.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("hash argon2id inválido")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("versión de argon2id no soportada")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("parámetros de argon2id inválidos")
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("parámetros de argon2id inválidos")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("sal de argon2id inválida")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("hash de argon2id inválido")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	hashed, err := HashPassword("Clave123!")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if !strings.HasPrefix(hashed, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fatalf("unexpected encoding: %s", hashed)
+	}
+	if !VerifyPassword(hashed, "Clave123!") || VerifyPassword(hashed, "Clave124!") {
+		t.Fatal("argon2id verification mismatch")
+	}
+	if PasswordNeedsRehash(hashed) {
+		t.Fatal("fresh hash should not need rehash")
+	}
+
+	// Al subir el costo los hashes anteriores se marcan para regenerar, pero siguen siendo válidos
+	os.Setenv("ARGON2_ITERATIONS", "3")
+	defer os.Unsetenv("ARGON2_ITERATIONS")
+	if !PasswordNeedsRehash(hashed) || !VerifyPassword(hashed, "Clave123!") {
+		t.Fatal("expected old parameters to verify and need rehash")
+	}
+}
+
+func TestBcryptHashesRemainValid(t *testing.T) {
+	os.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	os.Setenv("BCRYPT_COST", "4")
+	hashed, err := HashPassword("Clave123!")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if !strings.HasPrefix(hashed, "$2a$04$") || PasswordNeedsRehash(hashed) {
+		t.Fatalf("unexpected bcrypt hash: %s", hashed)
+	}
+	os.Setenv("BCRYPT_COST", "5")
+	if !PasswordNeedsRehash(hashed) {
+		t.Fatal("expected cost change to require rehash")
+	}
+	os.Unsetenv("PASSWORD_HASH_ALGORITHM")
+	os.Unsetenv("BCRYPT_COST")
+
+	// Con argon2id como algoritmo actual el hash bcrypt verifica y debe migrarse
+	if !VerifyPassword(hashed, "Clave123!") || !PasswordNeedsRehash(hashed) {
+		t.Fatal("expected bcrypt hash to verify and need migration")
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedHashes(t *testing.T) {
+	for _, encoded := range []string{"", "texto-plano", "$argon2id$v=19$m=0,t=2,p=1$c2FsdA$aGFzaA", "$argon2id$v=18$m=19456,t=2,p=1$c2FsdA$aGFzaA"} {
+		if VerifyPassword(encoded, "texto-plano") {
+			t.Fatalf("expected %q to be rejected", encoded)
+		}
+		if !PasswordNeedsRehash(encoded) {
+			t.Fatalf("expected %q to need rehash", encoded)
+		}
+	}
+}
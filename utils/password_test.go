@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	if violations := policy.Validate("Clave123!"); len(violations) != 0 {
+		t.Fatalf("expected valid password, got %v", violations)
+	}
+
+	violations := policy.Validate("corta")
+	if len(violations) != 4 {
+		t.Fatalf("expected 4 violations (length, upper, digit, symbol), got %v", violations)
+	}
+
+	if violations := policy.Validate(strings.Repeat("Aa1!", 20)); len(violations) != 1 {
+		t.Fatalf("expected max length violation, got %v", violations)
+	}
+}
+
+func TestLoadPasswordPolicyFromEnv(t *testing.T) {
+	os.Setenv("PASSWORD_MIN_LENGTH", "12")
+	os.Setenv("PASSWORD_REQUIRE_SYMBOL", "true")
+	defer os.Unsetenv("PASSWORD_MIN_LENGTH")
+	defer os.Unsetenv("PASSWORD_REQUIRE_SYMBOL")
+
+	policy := LoadPasswordPolicy()
+	if policy.MinLength != 12 || !policy.RequireSymbol || policy.HistorySize != 5 {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+}
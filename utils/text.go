@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// accentFolding reemplaza las letras acentuadas del español (y otras comunes en
+// nombres) por su forma sin acento
+var accentFolding = strings.NewReplacer(
+	"á", "a", "à", "a", "ä", "a", "â", "a", "ã", "a",
+	"é", "e", "è", "e", "ë", "e", "ê", "e",
+	"í", "i", "ì", "i", "ï", "i", "î", "i",
+	"ó", "o", "ò", "o", "ö", "o", "ô", "o", "õ", "o",
+	"ú", "u", "ù", "u", "ü", "u", "û", "u",
+	"ñ", "n", "ç", "c",
+)
+
+// FoldAccents convierte el texto a minúsculas y elimina los acentos
+// ("José Muñoz" → "jose munoz")
+func FoldAccents(s string) string {
+	return accentFolding.Replace(strings.ToLower(s))
+}
+
+// NormalizeName prepara un nombre para compararlo: sin acentos, en minúsculas, solo
+// letras y dígitos y con un espacio entre palabras
+func NormalizeName(name string) string {
+	words := strings.FieldsFunc(FoldAccents(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// likeEscaper escapa los comodines de LIKE con la barra invertida
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// EscapeLike escapa los comodines de un texto para compararlo literalmente en un
+// patrón LIKE con ESCAPE '\'
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// JaroWinkler retorna la similitud entre dos textos, de 0 (distintos) a 1 (iguales).
+// Favorece los textos con el mismo prefijo, lo que la hace adecuada para nombres.
+func JaroWinkler(a, b string) float64 {
+	s1, s2 := []rune(a), []rune(b)
+	if len(s1) == 0 && len(s2) == 0 {
+		return 1
+	}
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
+
+	window := max(len(s1), len(s2))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matched1 := make([]bool, len(s1))
+	matched2 := make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		for j := max(0, i-window); j < min(len(s2), i+window+1); j++ {
+			if !matched2[j] && s1[i] == s2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, k := 0, 0
+	for i := range s1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[k] {
+			k++
+		}
+		if s1[i] != s2[k] {
+			transpositions++
+		}
+		k++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s1), len(s2)) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
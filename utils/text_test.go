@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	if got := NormalizeName("  José  Ángel Muñoz-Pérez "); got != "jose angel munoz perez" {
+		t.Fatalf("unexpected normalized name: %q", got)
+	}
+	if got := FoldAccents("MARÍA"); got != "maria" {
+		t.Fatalf("unexpected folded text: %q", got)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := EscapeLike(`V-1_2%3\`); got != `V-1\_2\%3\\` {
+		t.Fatalf("unexpected escaped pattern: %q", got)
+	}
+}
+
+func TestJaroWinkler(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dixon", "dicksonx", 0.813},
+		{"", "", 1},
+		{"abc", "", 0},
+		{"abc", "xyz", 0},
+	}
+	for _, tc := range cases {
+		if got := JaroWinkler(tc.a, tc.b); math.Abs(got-tc.expected) > 0.001 {
+			t.Fatalf("JaroWinkler(%q, %q) = %.3f, expected %.3f", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Vectores de prueba del RFC 6238 (SHA1), truncados a 6 dígitos
+func TestTOTPCodeRFCVectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("at %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateTOTPWithFixedClock(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret error: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	code, _ := TOTPCode(secret, now.Add(-TOTPPeriod))
+	if _, ok := ValidateTOTP(secret, code, now); !ok {
+		t.Fatal("expected previous step to be accepted")
+	}
+
+	code, _ = TOTPCode(secret, now.Add(-3*TOTPPeriod))
+	if _, ok := ValidateTOTP(secret, code, now); ok {
+		t.Fatal("expected old code to be rejected")
+	}
+
+	uri := TOTPProvisioningURI("Laboratorio", "admin", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Laboratorio:admin?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected provisioning uri: %s", uri)
+	}
+}